@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dawnzzz/hamble-tcp-server/hamble"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/dirlock"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/backendqueue"
+	"github.com/dawnzzz/lmq/lmqd/backup"
+	"github.com/dawnzzz/lmq/lmqd/channel"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+	lmqbackup 备份、恢复topic/channel中的消息
+	export: 离线导出，直接读取lmqd数据目录中的磁盘队列（需要lmqd已经停止）
+	import: 在线导入，通知lmqd将备份文件中的消息发布到topic中（备份文件需要放在lmqd配置的备份目录backup_path中，-file为其中的相对路径）
+*/
+
+const importTimeout = 60 * time.Second
+
+var (
+	mode           string
+	configFilename string
+	topicName      string
+	channelName    string
+	filename       string
+	lmqdAddress    string
+)
+
+func init() {
+	flag.StringVar(&mode, "mode", "export", "export or import")
+	flag.StringVar(&configFilename, "f", config.DefaultLmqdFilename, "LMQ Daemon yaml config file, used by export")
+	flag.StringVar(&topicName, "topic", "", "topic name")
+	flag.StringVar(&channelName, "channel", "", "channel name, only export the topic if empty")
+	flag.StringVar(&filename, "file", "", "backup file, a path relative to backup_path of lmqd when import")
+	flag.StringVar(&lmqdAddress, "lmqd", "127.0.0.1:6200", "LMQ Daemon tcp address, used by import")
+	flag.Parse()
+}
+
+func main() {
+	if topicName == "" || filename == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch mode {
+	case "export":
+		err = exportOffline()
+	case "import":
+		err = importOnline()
+	default:
+		err = fmt.Errorf("unknown mode %s", mode)
+	}
+
+	if err != nil {
+		fmt.Printf("%s failed, err: %s\n", mode, err.Error())
+		os.Exit(1)
+	}
+}
+
+// exportOffline 离线导出磁盘队列中的消息
+func exportOffline() error {
+	err := config.LoadLmqdConfig(configFilename, nil)
+	if err != nil {
+		return err
+	}
+	lmqdConfig := config.GetLmqdConfig()
+
+	// 对数据目录加锁，保证lmqd没有在运行
+	dataRootPath := lmqdConfig.DataRootPath
+	dirLock := dirlock.NewDirLock(dataRootPath)
+	if err = dirLock.TryLock(); err != nil {
+		return fmt.Errorf("please stop lmqd first: %w", err)
+	}
+	defer dirLock.Unlock()
+
+	queueNames := []string{topicName}
+	if channelName != "" {
+		// channel的优先级队列、ordered模式的spill队列中也有还未投递的消息
+		queueNames = channel.BackendQueueNames(topicName, channelName)
+	}
+	minMsgSize := message.MinPersistLength(lmqdConfig.MinMessageSize)
+	maxMsgSize := message.MaxPersistLength(lmqdConfig.MaxMessageSize)
+
+	header := backup.NewFileHeader(topicName, channelName)
+	count, err := backup.ExportToFile(filename, header, func(fn func(msg iface.IMessage) error) error {
+		for _, queueName := range queueNames {
+			err := backendqueue.ScanDiskQueue(queueName, dataRootPath, minMsgSize, maxMsgSize, func(data []byte) error {
+				msg, err := message.ConvertBytesToMessage(data)
+				if err != nil {
+					return err
+				}
+
+				return fn(msg)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("export %d messages to %s\n", count, filename)
+	return nil
+}
+
+type importRecvHandler struct {
+	hamble.BaseHandler
+	responseChan chan *protocol.ResponseBody
+}
+
+func (h *importRecvHandler) Handle(request serveriface.IRequest) {
+	resp := &protocol.ResponseBody{}
+	_ = json.Unmarshal(request.GetData(), resp)
+	h.responseChan <- resp
+}
+
+// importOnline 通知lmqd导入备份文件
+func importOnline() error {
+	host, portStr, err := net.SplitHostPort(lmqdAddress)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	client, err := hamble.NewClient("tcp", host, port)
+	if err != nil {
+		return err
+	}
+	handler := &importRecvHandler{responseChan: make(chan *protocol.ResponseBody, 1)}
+	client.RegisterHandler(protocol.ImportTopicID, handler)
+	go client.Start()
+	defer client.Stop()
+
+	data, _ := json.Marshal(protocol.RequestBody{
+		TopicName: topicName,
+		FilePath:  filename,
+	})
+	err = client.GetConnection().SendBufMsg(protocol.ImportTopicID, data)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-handler.responseChan:
+		if resp.IsError {
+			return errors.New(resp.StatusMsg)
+		}
+		fmt.Printf("import %s into topic %s, result: %v\n", filename, topicName, resp.Data)
+	case <-time.After(importTimeout):
+		return errors.New("wait for lmqd response timeout")
+	}
+
+	return nil
+}
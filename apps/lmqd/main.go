@@ -20,25 +20,34 @@ const banner = `
 powered by https://github.com/dawnzzz/lmq
 `
 
-var configFilename string
+var (
+	configFilename string
+	printConfig    bool
+	configFlags    func() map[string]string
+)
 
 func init() {
 	flag.StringVar(&configFilename, "f", config.DefaultLmqdFilename, "LMQ Daemon yaml config file")
+	flag.BoolVar(&printConfig, "print-config", false, "print the effective config and exit")
+	// 每一个配置项都可以通过命令行参数设置，例如--tcp-port=6200
+	configFlags = config.BindFlags(flag.CommandLine, config.GetLmqdConfig())
 	flag.Parse()
-	_, err := os.Stat(configFilename)
-	if err != nil && !os.IsNotExist(err) {
-		panic(err)
-	} else if err != nil {
-		configFilename = config.DefaultLmqdFilename
-
-		_, err = os.Stat(configFilename)
-		if err != nil {
-			panic(err)
-		}
+	// 命令行中指定的配置文件必须存在，默认的配置文件可以不存在
+	var err error
+	configFilename, err = config.ResolveConfigFilename(flag.CommandLine, "f")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	err = config.LoadConfigFile(configFilename, &config.GlobalLmqdConfig)
+	err = config.LoadLmqdConfig(configFilename, configFlags())
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if printConfig {
+		config.PrintConfig(os.Stdout, config.GetLmqdConfig())
+		os.Exit(0)
 	}
 }
 
@@ -46,9 +55,10 @@ func main() {
 	// 加载配置信息
 	fmt.Print(banner)
 
-	_, err := os.Stat(config.GlobalLmqdConfig.DataRootPath)
+	dataRootPath := config.GetLmqdConfig().DataRootPath
+	_, err := os.Stat(dataRootPath)
 	if os.IsNotExist(err) {
-		innerErr := os.MkdirAll(config.GlobalLmqdConfig.DataRootPath, 0600)
+		innerErr := os.MkdirAll(dataRootPath, 0600)
 		if innerErr != nil {
 			panic(err)
 		}
@@ -20,23 +20,35 @@ const banner = `
 powered by https://github.com/dawnzzz/lmq
 `
 
+var (
+	configFilename string
+	printConfig    bool
+	configFlags    func() map[string]string
+)
+
 func init() {
 	// 加载配置信息
-	var configFilename string
-	configFilename = *flag.String("f", config.DefaultLmqLookupFilename, "LMQ Daemon yaml config file")
-	_, err := os.Stat(configFilename)
-	if err != nil && !os.IsNotExist(err) {
-		panic(err)
-	} else if err != nil {
-		configFilename = config.DefaultLmqLookupFilename
-		_, err = os.Stat(configFilename)
-		if err != nil {
-			panic(err)
-		}
+	flag.StringVar(&configFilename, "f", config.DefaultLmqLookupFilename, "LMQ Lookup yaml config file")
+	flag.BoolVar(&printConfig, "print-config", false, "print the effective config and exit")
+	// 每一个配置项都可以通过命令行参数设置，例如--tcp-port=6300
+	configFlags = config.BindFlags(flag.CommandLine, config.GlobalLmqLookupConfig)
+	flag.Parse()
+	// 命令行中指定的配置文件必须存在，默认的配置文件可以不存在
+	var err error
+	configFilename, err = config.ResolveConfigFilename(flag.CommandLine, "f")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	err = config.LoadConfigFile(configFilename, &config.GlobalLmqdConfig)
+	err = config.LoadLmqLookupConfig(configFilename, configFlags())
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if printConfig {
+		config.PrintConfig(os.Stdout, config.GlobalLmqLookupConfig)
+		os.Exit(0)
 	}
 }
 
@@ -45,5 +57,11 @@ func main() {
 
 	lookup := lmqlookup.NewLmqLookUp()
 
+	// 加载持久化的registration
+	err := lookup.LoadRegistrations()
+	if err != nil {
+		panic(err)
+	}
+
 	lookup.Main()
 }
@@ -1,17 +1,36 @@
 package config
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
-var GlobalLmqdConfig *LmqdConfig
+// lmqdConfig 正在使用的lmqd配置，重新加载时整体替换，不能修改获取到的配置
+var lmqdConfig atomic.Pointer[LmqdConfig]
+
+// GetLmqdConfig 获取正在使用的lmqd配置
+func GetLmqdConfig() *LmqdConfig {
+	return lmqdConfig.Load()
+}
+
+// SetLmqdConfig 替换正在使用的lmqd配置
+func SetLmqdConfig(c *LmqdConfig) {
+	lmqdConfig.Store(c)
+}
 
 type LmqdConfig struct {
 	TcpHost string `mapstructure:"tcp_host"`
 	TcpPort int    `mapstructure:"tcp_port"`
 
+	NodeID           int64  `mapstructure:"node_id"`           // 节点ID，取值范围[0, 1024)，为负数时使用持久化在元数据中的节点ID（首次启动时随机生成）
+	BroadcastAddress string `mapstructure:"broadcast_address"` // 向lookup公布的地址，客户端使用这个地址连接lmqd，为空时使用hostname
+	HttpPort         int    `mapstructure:"http_port"`         // 向lookup公布的http端口，为0时表示没有
+
 	MinMessageSize int32 `mapstructure:"min_message_size"` // 消息的最小长度
 	MaxMessageSize int32 `mapstructure:"max_message_size"` // 消息的最大长度
 
 	DataRootPath string        `mapstructure:"data_root_path"` // 用于保存持久化数据得根目录
+	BackupPath   string        `mapstructure:"backup_path"`    // 通过lmqd导出、导入topic/channel时备份文件所在的目录，为空时不允许
 	SyncEvery    int64         `mapstructure:"sync_every"`     // 磁盘队列进行多少次读写操作时进行一次同步
 	SyncTimeout  time.Duration `mapstructure:"sync_timeout"`   // 队列文件最长多长时间进行一次同步
 
@@ -30,18 +49,44 @@ type LmqdConfig struct {
 	MessageTimeout    time.Duration `mapstructure:"message_timeout"`
 	ScanQueueInterval time.Duration `mapstructure:"scan_queue_interval"`
 
+	RetentionWindow time.Duration `mapstructure:"retention_window"` // topic消息的默认保留时长，用于重放channel，为0时不保留
+
+	DedupWindow  time.Duration `mapstructure:"dedup_window"`   // topic默认的去重窗口，窗口内相同dedup key的消息只会接受一次，为0时不去重
+	DedupMaxKeys int           `mapstructure:"dedup_max_keys"` // 每个topic在去重窗口内最多记录的dedup key数量
+
+	EphemeralGracePeriod time.Duration `mapstructure:"ephemeral_grace_period"` // 临时topic/channel没有消费者之后等待多久删除
+
+	ClientHeartbeatTimeout time.Duration `mapstructure:"client_heartbeat_timeout"` // 客户端心跳超时时间，failover模式下活跃的客户端超时之后由备用客户端接替，为0时不检查
+
 	HeartBeatInterval time.Duration `mapstructure:"heart_beat_interval"` // 向lmq lookup发送心跳的时间间隔
 	LookupAddresses   []string      `mapstructure:"lookup_addresses"`    // lmq lookup的地址，可配置多个lmq lookup
+
+	ReplicationFactor  int           `mapstructure:"replication_factor"`  // 每一条消息复制到多少个其他的lmqd节点中，为0时不复制
+	ReplicationAcks    int           `mapstructure:"replication_acks"`    // 发布消息时需要等待多少个副本确认
+	ReplicationTimeout time.Duration `mapstructure:"replication_timeout"` // 等待副本确认的超时时间
+
+	LogLevel string `mapstructure:"log_level"` // 日志级别：debug、info、warn、error
 }
 
 func init() {
-	GlobalLmqdConfig = &LmqdConfig{
-		TcpHost:        "0.0.0.0",
-		TcpPort:        6200,
+	SetLmqdConfig(defaultLmqdConfig())
+}
+
+// defaultLmqdConfig lmqd的默认配置
+func defaultLmqdConfig() *LmqdConfig {
+	return &LmqdConfig{
+		TcpHost: "0.0.0.0",
+		TcpPort: 6200,
+
+		NodeID:           -1,
+		BroadcastAddress: "",
+		HttpPort:         0,
+
 		MinMessageSize: 0,
 		MaxMessageSize: 1024768,
 
 		DataRootPath: "data",
+		BackupPath:   "backup",
 		SyncEvery:    10,
 		SyncTimeout:  10 * time.Second,
 
@@ -59,7 +104,22 @@ func init() {
 		MessageTimeout:    5 * time.Second,
 		ScanQueueInterval: 100 * time.Millisecond,
 
+		RetentionWindow: 0,
+
+		DedupWindow:  0,
+		DedupMaxKeys: 100000,
+
+		EphemeralGracePeriod: 10 * time.Second,
+
+		ClientHeartbeatTimeout: 0,
+
 		HeartBeatInterval: 60 * time.Second,
 		LookupAddresses:   []string{},
+
+		ReplicationFactor:  0,
+		ReplicationAcks:    1,
+		ReplicationTimeout: 3 * time.Second,
+
+		LogLevel: "info",
 	}
 }
@@ -6,6 +6,11 @@ type LmqLookupConfig struct {
 	TcpHost string `mapstructure:"tcp_host"`
 	TcpPort int    `mapstructure:"tcp_port"`
 
+	HttpHost string `mapstructure:"http_host"`
+	HttpPort int    `mapstructure:"http_port"` // http服务器端口，为0时不开启http服务器
+
+	DataRootPath string `mapstructure:"data_root_path"` // 用于保存持久化数据的根目录
+
 	TcpServerWorkerPoolSize   int `mapstructure:"tcp_server_worker_pool_size"`    // TCP服务器Worker数量
 	TcpServerMaxWorkerTaskLen int `mapstructure:"tcp_server_max_worker_task_len"` // TCP服务器 Worker任务队列长度
 	TcpServerMaxMsgChanLen    int `mapstructure:"tcp_server_max_msg_chan_len"`    // 连接发送队列的缓冲区长度
@@ -13,15 +18,28 @@ type LmqLookupConfig struct {
 
 	InactiveProducerTimeout time.Duration `mapstructure:"inactive_producer_timeout"`
 	TombstoneLifetime       time.Duration `mapstructure:"tombstone_lifetime"`
+
+	ClusterAddress string   `mapstructure:"cluster_address"` // 本节点在lookup集群中的地址（其他lookup可以访问的tcp地址）
+	ClusterPeers   []string `mapstructure:"cluster_peers"`   // 集群中其他lookup的地址，为空时不开启集群模式
 }
 
 var GlobalLmqLookupConfig *LmqLookupConfig
 
 func init() {
-	GlobalLmqLookupConfig = &LmqLookupConfig{
+	GlobalLmqLookupConfig = defaultLmqLookupConfig()
+}
+
+// defaultLmqLookupConfig lmq lookup的默认配置
+func defaultLmqLookupConfig() *LmqLookupConfig {
+	return &LmqLookupConfig{
 		TcpHost: "0.0.0.0",
 		TcpPort: 6300,
 
+		HttpHost: "0.0.0.0",
+		HttpPort: 0,
+
+		DataRootPath: "lookup_data",
+
 		TcpServerWorkerPoolSize:   10,
 		TcpServerMaxWorkerTaskLen: 2048,
 		TcpServerMaxMsgChanLen:    2048,
@@ -29,5 +47,23 @@ func init() {
 
 		InactiveProducerTimeout: 300 * time.Second,
 		TombstoneLifetime:       45 * time.Second,
+
+		ClusterAddress: "",
+		ClusterPeers:   []string{},
+	}
+}
+
+// LoadLmqLookupConfig 加载并检查lmq lookup的配置
+func LoadLmqLookupConfig(filename string, flags map[string]string) error {
+	newConfig := defaultLmqLookupConfig()
+	if err := LoadConfig(filename, LmqLookupEnvPrefix, flags, newConfig); err != nil {
+		return err
 	}
+	if err := newConfig.Validate(); err != nil {
+		return err
+	}
+
+	GlobalLmqLookupConfig = newConfig
+
+	return nil
 }
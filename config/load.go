@@ -1,23 +1,149 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"flag"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+	配置的加载顺序（优先级从低到高）：默认值、yaml配置文件、环境变量、命令行参数
+	环境变量的名字为 前缀_配置项（大写），例如LMQD_TCP_PORT、LMQLOOKUP_CLUSTER_PEERS，列表使用逗号分隔
+	命令行参数的名字为配置项中的_替换为-，例如--tcp-port、--lookup-addresses
+*/
 
 const (
 	DefaultLmqdFilename      = "lmqd.yaml"
 	DefaultLmqLookupFilename = "lookup.yaml"
+
+	LmqdEnvPrefix      = "LMQD"
+	LmqLookupEnvPrefix = "LMQLOOKUP"
 )
 
-func LoadConfigFile(filename string, v interface{}) error {
-	viper.SetConfigType("yaml")
-	viper.SetConfigFile(filename)
-	err := viper.ReadInConfig()
-	if err != nil {
-		return err
+// LoadConfig 将配置加载到v中，v中原有的值作为默认值，filename为空时不读取配置文件，flags为命令行中设置的配置项
+func LoadConfig(filename, envPrefix string, flags map[string]string, v interface{}) error {
+	vp := viper.New()
+	vp.SetConfigType("yaml")
+	if filename != "" {
+		vp.SetConfigFile(filename)
+		if err := vp.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	// 环境变量
+	vp.SetEnvPrefix(envPrefix)
+	for _, key := range configKeys(v) {
+		if err := vp.BindEnv(key); err != nil {
+			return err
+		}
+	}
+
+	// 命令行参数
+	for key, value := range flags {
+		vp.Set(key, value)
+	}
+
+	return vp.Unmarshal(v)
+}
+
+// BindFlags 为配置中的每一项注册一个命令行参数，返回的函数在解析命令行之后获取设置过的配置项
+func BindFlags(fs *flag.FlagSet, v interface{}) func() map[string]string {
+	values := make(map[string]*string)
+	for _, key := range configKeys(v) {
+		values[key] = fs.String(strings.ReplaceAll(key, "_", "-"), "", fmt.Sprintf("override %s in config file", key))
+	}
+
+	return func() map[string]string {
+		flags := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			key := strings.ReplaceAll(f.Name, "-", "_")
+			if value, ok := values[key]; ok {
+				flags[key] = *value
+			}
+		})
+
+		return flags
 	}
+}
+
+// ResolveConfigFilename 获取命令行参数name指定的配置文件，命令行中指定的文件必须存在，
+// 没有指定并且默认的配置文件不存在时返回空字符串，只使用默认值、环境变量和命令行参数
+func ResolveConfigFilename(fs *flag.FlagSet, name string) (string, error) {
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			explicit = true
+		}
+	})
+
+	filename := fs.Lookup(name).Value.String()
+	_, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		if explicit {
+			return "", fmt.Errorf("config file %s does not exist", filename)
+		}
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// PrintConfig 按照yaml的格式输出生效的配置
+func PrintConfig(w io.Writer, v interface{}) {
+	value := structValue(v)
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+
+		switch field := value.Field(i).Interface().(type) {
+		case time.Duration:
+			_, _ = fmt.Fprintf(w, "%s: %s\n", key, field)
+		case string:
+			_, _ = fmt.Fprintf(w, "%s: %q\n", key, field)
+		case []string:
+			if len(field) == 0 {
+				_, _ = fmt.Fprintf(w, "%s: []\n", key)
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "%s:\n", key)
+			for _, item := range field {
+				_, _ = fmt.Fprintf(w, "  - %q\n", item)
+			}
+		default:
+			_, _ = fmt.Fprintf(w, "%s: %v\n", key, field)
+		}
+	}
+}
+
+// configKeys 配置结构体中所有的配置项
+func configKeys(v interface{}) []string {
+	t := structValue(v).Type()
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("mapstructure"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
 
-	if err = viper.Unmarshal(&v); err != nil {
-		return err
+// structValue 获取指针指向的配置结构体
+func structValue(v interface{}) reflect.Value {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		value = value.Elem()
 	}
 
-	return nil
+	return value
 }
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+)
+
+/*
+	重新加载lmqd的配置文件
+	从默认配置开始重新读取配置文件、环境变量和启动时的命令行参数，检查通过之后与正在使用的配置逐项比较，整体替换正在使用的配置
+	需要重启才能生效的配置保持原来的值，在结果中列出
+	队列相关的配置（mem_queue_size、sync_every等）在创建队列时使用，已经存在的队列不会变化，所以也需要重启才能生效
+*/
+
+var (
+	lmqdConfigFilename string            // lmqd启动时加载的配置文件，为空时没有配置文件
+	lmqdConfigFlags    map[string]string // lmqd启动时命令行中设置的配置项
+	reloadLock         sync.Mutex
+)
+
+// restartRequiredFields 修改之后需要重启lmqd才能生效的配置
+var restartRequiredFields = map[string]struct{}{
+	"tcp_host":                       {},
+	"tcp_port":                       {},
+	"node_id":                        {},
+	"broadcast_address":              {},
+	"http_port":                      {},
+	"min_message_size":               {},
+	"max_message_size":               {},
+	"data_root_path":                 {},
+	"tcp_server_worker_pool_size":    {},
+	"tcp_server_max_worker_task_len": {},
+	"tcp_server_max_msg_chan_len":    {},
+	"tcp_server_max_conn":            {},
+	"replication_factor":             {},
+	"mem_queue_size":                 {},
+	"sync_every":                     {},
+	"sync_timeout":                   {},
+	"max_bytes_per_file":             {},
+	"dedup_max_keys":                 {},
+}
+
+// ReloadResult 重新加载配置的结果
+type ReloadResult struct {
+	Changed         []string `json:"changed"`          // 发生变化并且已经生效的配置
+	RestartRequired []string `json:"restart_required"` // 发生变化但需要重启才能生效的配置，仍然使用原来的值
+}
+
+// LoadLmqdConfig 加载并检查lmqd的配置，记录文件名和命令行参数用于重新加载
+func LoadLmqdConfig(filename string, flags map[string]string) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	newConfig := defaultLmqdConfig()
+	if err := LoadConfig(filename, LmqdEnvPrefix, flags, newConfig); err != nil {
+		return err
+	}
+	if err := newConfig.Validate(); err != nil {
+		return err
+	}
+
+	SetLmqdConfig(newConfig)
+	lmqdConfigFilename = filename
+	lmqdConfigFlags = flags
+
+	return nil
+}
+
+// ReloadLmqdConfig 重新加载lmqd的配置文件
+func ReloadLmqdConfig() (*ReloadResult, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	newConfig := defaultLmqdConfig()
+	if err := LoadConfig(lmqdConfigFilename, LmqdEnvPrefix, lmqdConfigFlags, newConfig); err != nil {
+		return nil, err
+	}
+	if err := newConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	result := &ReloadResult{Changed: []string{}, RestartRequired: []string{}}
+	oldValue := reflect.ValueOf(GetLmqdConfig()).Elem()
+	newValue := reflect.ValueOf(newConfig).Elem()
+	for i := 0; i < newValue.NumField(); i++ {
+		if fieldEqual(oldValue.Field(i), newValue.Field(i)) {
+			continue
+		}
+
+		name := newValue.Type().Field(i).Tag.Get("mapstructure")
+		if _, ok := restartRequiredFields[name]; ok {
+			// 保持原来的值
+			newValue.Field(i).Set(oldValue.Field(i))
+			result.RestartRequired = append(result.RestartRequired, name)
+			continue
+		}
+		result.Changed = append(result.Changed, name)
+	}
+
+	SetLmqdConfig(newConfig)
+
+	return result, nil
+}
+
+// fieldEqual 比较配置项是否相同，空的列表和nil相同
+func fieldEqual(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"github.com/dawnzzz/lmq/logger"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// validator 收集所有不合法的配置项，启动时一次性报告
+type validator struct {
+	errs []string
+}
+
+func (v *validator) check(ok bool, key string, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) checkPort(port int, key string, allowZero bool) {
+	min := 1
+	if allowZero {
+		min = 0
+	}
+	v.check(port >= min && port <= 65535, key, "port %d out of range [%d, 65535]", port, min)
+}
+
+func (v *validator) checkAddresses(addresses []string, key string) {
+	for _, address := range addresses {
+		_, portStr, err := net.SplitHostPort(address)
+		if err == nil {
+			var port int
+			port, err = strconv.Atoi(portStr)
+			if err == nil && (port <= 0 || port > 65535) {
+				err = fmt.Errorf("port %d out of range", port)
+			}
+		}
+		v.check(err == nil, key, "address(%s) is invalid: %v", address, err)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid config:\n\t%s", strings.Join(v.errs, "\n\t"))
+}
+
+// Validate 检查lmqd的配置，返回所有不合法的配置项
+func (c *LmqdConfig) Validate() error {
+	v := &validator{}
+
+	v.checkPort(c.TcpPort, "tcp_port", false)
+	v.check(c.NodeID < 1024, "node_id", "%d must be less than 1024", c.NodeID)
+	v.checkPort(c.HttpPort, "http_port", true)
+
+	v.check(c.MinMessageSize >= 0, "min_message_size", "%d must not be negative", c.MinMessageSize)
+	v.check(c.MaxMessageSize > 0, "max_message_size", "%d must be positive", c.MaxMessageSize)
+	v.check(c.MinMessageSize <= c.MaxMessageSize, "min_message_size", "%d is greater than max_message_size %d", c.MinMessageSize, c.MaxMessageSize)
+
+	v.check(c.DataRootPath != "", "data_root_path", "must not be empty")
+	v.check(c.SyncEvery > 0, "sync_every", "%d must be positive", c.SyncEvery)
+	v.check(c.SyncTimeout > 0, "sync_timeout", "%s must be positive", c.SyncTimeout)
+	v.check(c.MaxBytesPerFile > 0, "max_bytes_per_file", "%d must be positive", c.MaxBytesPerFile)
+	v.check(c.MemQueueSize >= 0, "mem_queue_size", "%d must not be negative", c.MemQueueSize)
+
+	v.check(c.TcpServerWorkerPoolSize > 0, "tcp_server_worker_pool_size", "%d must be positive", c.TcpServerWorkerPoolSize)
+	v.check(c.TcpServerMaxWorkerTaskLen > 0, "tcp_server_max_worker_task_len", "%d must be positive", c.TcpServerMaxWorkerTaskLen)
+	v.check(c.TcpServerMaxMsgChanLen > 0, "tcp_server_max_msg_chan_len", "%d must be positive", c.TcpServerMaxMsgChanLen)
+	v.check(c.TcpServerMaxConn > 0, "tcp_server_max_conn", "%d must be positive", c.TcpServerMaxConn)
+
+	v.check(c.MessageTimeout > 0, "message_timeout", "%s must be positive", c.MessageTimeout)
+	v.check(c.ScanQueueInterval > 0, "scan_queue_interval", "%s must be positive", c.ScanQueueInterval)
+	v.check(c.RetentionWindow >= 0, "retention_window", "%s must not be negative", c.RetentionWindow)
+	v.check(c.DedupWindow >= 0, "dedup_window", "%s must not be negative", c.DedupWindow)
+	v.check(c.DedupMaxKeys > 0, "dedup_max_keys", "%d must be positive", c.DedupMaxKeys)
+	v.check(c.EphemeralGracePeriod >= 0, "ephemeral_grace_period", "%s must not be negative", c.EphemeralGracePeriod)
+	v.check(c.ClientHeartbeatTimeout >= 0, "client_heartbeat_timeout", "%s must not be negative", c.ClientHeartbeatTimeout)
+
+	v.check(c.HeartBeatInterval > 0, "heart_beat_interval", "%s must be positive", c.HeartBeatInterval)
+	v.checkAddresses(c.LookupAddresses, "lookup_addresses")
+
+	v.check(c.ReplicationFactor >= 0, "replication_factor", "%d must not be negative", c.ReplicationFactor)
+	v.check(c.ReplicationAcks >= 0, "replication_acks", "%d must not be negative", c.ReplicationAcks)
+	v.check(c.ReplicationTimeout > 0, "replication_timeout", "%s must be positive", c.ReplicationTimeout)
+
+	v.check(logger.IsValidLevel(c.LogLevel), "log_level", "%q is not one of debug, info, warn, error", c.LogLevel)
+
+	return v.err()
+}
+
+// Validate 检查lmq lookup的配置，返回所有不合法的配置项
+func (c *LmqLookupConfig) Validate() error {
+	v := &validator{}
+
+	v.checkPort(c.TcpPort, "tcp_port", false)
+	v.checkPort(c.HttpPort, "http_port", true)
+
+	v.check(c.DataRootPath != "", "data_root_path", "must not be empty")
+
+	v.check(c.TcpServerWorkerPoolSize > 0, "tcp_server_worker_pool_size", "%d must be positive", c.TcpServerWorkerPoolSize)
+	v.check(c.TcpServerMaxWorkerTaskLen > 0, "tcp_server_max_worker_task_len", "%d must be positive", c.TcpServerMaxWorkerTaskLen)
+	v.check(c.TcpServerMaxMsgChanLen > 0, "tcp_server_max_msg_chan_len", "%d must be positive", c.TcpServerMaxMsgChanLen)
+	v.check(c.TcpServerMaxConn > 0, "tcp_server_max_conn", "%d must be positive", c.TcpServerMaxConn)
+
+	v.check(c.InactiveProducerTimeout > 0, "inactive_producer_timeout", "%s must be positive", c.InactiveProducerTimeout)
+	v.check(c.TombstoneLifetime > 0, "tombstone_lifetime", "%s must be positive", c.TombstoneLifetime)
+
+	if c.ClusterAddress != "" {
+		v.checkAddresses([]string{c.ClusterAddress}, "cluster_address")
+	}
+	v.checkAddresses(c.ClusterPeers, "cluster_peers")
+
+	return v.err()
+}
@@ -2,11 +2,15 @@ module github.com/dawnzzz/lmq
 
 go 1.19
 
-require github.com/sirupsen/logrus v1.9.0
+require (
+	github.com/bwmarrin/snowflake v0.3.0
+	github.com/dawnzzz/hamble-tcp-server v0.0.0-20230424123034-e2683c3355d5
+	github.com/sirupsen/logrus v1.9.0
+	github.com/spf13/viper v1.15.0
+	golang.org/x/sys v0.7.0
+)
 
 require (
-	github.com/bwmarrin/snowflake v0.3.0 // indirect
-	github.com/dawnzzz/hamble-tcp-server v0.0.0-20230424123034-e2683c3355d5 // indirect
 	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
@@ -16,9 +20,7 @@ require (
 	github.com/spf13/cast v1.5.0 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/spf13/viper v1.15.0 // indirect
 	github.com/subosito/gotenv v1.4.2 // indirect
-	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -6,23 +6,40 @@ import (
 )
 
 type IChannel interface {
-	Pause() error    // 暂停channel
-	UnPause() error  // 恢复channel
-	Empty() error    // 清空channel
-	Close() error    // 关闭channel
-	Delete() error   // 关闭并删除channel
-	IsPausing() bool // 返回是否处于暂停状态
-	IsExiting() bool // 是否退出
+	Pause() error      // 暂停channel
+	UnPause() error    // 恢复channel
+	Empty() error      // 清空channel
+	Close() error      // 关闭channel
+	Delete() error     // 关闭并删除channel
+	IsPausing() bool   // 返回是否处于暂停状态
+	IsExiting() bool   // 是否退出
+	IsEphemeral() bool // 是否是临时channel
+
+	GetCreatedAt() time.Time                    // 获取创建时间
+	SetCreatedAt(createdAt time.Time)           // 设置创建时间，用于从元数据中恢复
+	GetSettings() ChannelSettings               // 获取需要持久化的配置
+	SetSettings(settings ChannelSettings) error // 设置需要持久化的配置，已经有多个客户端订阅时不能切换到exclusive模式
+	SetTopicOverrides(overrides *Overrides)     // topic的overrides变化时调用
+	GetOptions() *Options                       // 获取生效的配置
 
 	GetMemoryMsgChan() chan IMessage
+	GetClientMsgChans(clientID uint64) (chan IMessage, <-chan []byte) // 客户端从分发流水线的最后一个阶段读取消息
+	NotifyReady()                                                     // 客户端可以接收更多消息或者取走分发给自己的消息时调用
+	IsActiveClient(clientID uint64) bool                              // 客户端是否可以接收消息，failover模式下备用的客户端返回false
+	IsOrdered() bool                                                  // 是否按照ordering key顺序投递消息
 	GetBackendQueue() backendqueue.BackendQueue
+	Depth() int64            // 还未投递的消息数量
+	InFlightCount() int      // 已经投递但还未确认的消息数量
+	GetStats() *ChannelStats // 获取统计信息
 
 	AddClient(clientID uint64, client IConsumer) error // 添加一个订阅的用户
 	RemoveClient(clientID uint64)                      // 移除一个订阅的用户
 
-	GetName() string                   // 获取一个channel的name
-	GetTopicName() string              // 获取channel得topic name
-	PutMessage(message IMessage) error // 向channel发布一个消息
+	GetName() string                              // 获取一个channel的name
+	GetTopicName() string                         // 获取channel得topic name
+	AcceptMessage(message IMessage) bool          // 按照过滤条件和采样率决定是否接收消息，不接收的消息计入统计
+	PutMessage(message IMessage) error            // 向channel发布一个消息
+	Export(fn func(message IMessage) error) error // 导出channel中还未投递的消息
 	FinishMessage(clientID uint64, messageID MessageID) error
 	RequeueMessage(clientID uint64, messageID MessageID) error
 	StartInFlightTimeout(message IMessage, clientID uint64, timeout time.Duration) error
@@ -32,6 +49,12 @@ type IConsumer interface {
 	Pause()
 	UnPause()
 	Close() error
-	Empty()
+	Empty(inFlight int64) // channel清空时调用，丢弃这个客户端在channel中的inFlight个in-flight消息
 	TimeoutMessage()
+	RemoveChannel(channel IChannel) // channel退出时调用，不再从这个channel中接收消息
+	RecvCapacity() int64            // 还可以接收的消息数量（RDY减去in-flight），暂停时为0
+	GetInFlightCount() int64        // in-flight消息数量
+	GetWeight() int                 // 订阅时指定的权重
+	Wakeup()                        // channel的分发方式变化时调用，唤醒客户端的message pump
+	IsAlive() bool                  // 心跳是否超时
 }
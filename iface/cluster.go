@@ -0,0 +1,47 @@
+package iface
+
+// ClusterCommandOp lmq lookup集群中对registration db的修改操作
+type ClusterCommandOp string
+
+const (
+	CreateTopicOp    = ClusterCommandOp("create_topic")
+	DeleteTopicOp    = ClusterCommandOp("delete_topic")
+	CreateChannelOp  = ClusterCommandOp("create_channel")
+	DeleteChannelOp  = ClusterCommandOp("delete_channel")
+	TombstoneTopicOp = ClusterCommandOp("tombstone_topic")
+
+	UnTombstoneTopicOp = ClusterCommandOp("untombstone_topic")
+
+	CreatePartitionedTopicOp = ClusterCommandOp("create_partitioned_topic")
+)
+
+// ClusterCommand 需要在lmq lookup集群中复制的修改操作
+type ClusterCommand struct {
+	Op           ClusterCommandOp `json:"op"`
+	TopicName    string           `json:"topic_name,omitempty"`
+	ChannelName  string           `json:"channel_name,omitempty"`
+	Hostname     string           `json:"hostname,omitempty"`
+	TcpPort      int              `json:"tcp_port,omitempty"`
+	TombstonedAt int64            `json:"tombstoned_at,omitempty"` // tombstone的时间，由收到命令的节点设置，保证所有节点一致
+
+	Partitions []*PartitionPlacement `json:"partitions,omitempty"` // 分区topic中每个分区所在的lmqd
+}
+
+// ILookupCluster lmq lookup集群，保证所有lookup中管理命令的修改顺序一致
+type ILookupCluster interface {
+	Start()
+	Stop()
+	Propose(cmd *ClusterCommand) error // 提交一个修改操作，等待集群中多数节点确认并应用之后返回
+	IsLeader() bool                    // 本节点是否是leader
+	GetLeader() string                 // 获取leader的地址
+}
+
+// IRegistrationStore 持久化通过管理命令创建的registration和tombstone
+type IRegistrationStore interface {
+	Load(db IRegistrationDB) error                        // 加载持久化的数据到registration db中
+	Append(cmd *ClusterCommand, index, term uint64) error // 记录一条已经应用的修改操作，index和term为对应的raft日志，单机模式下为0
+	AppliedIndex() (uint64, uint64)                       // 已经持久化的最后一条raft日志的index和term
+	Snapshot() ([]byte, uint64, uint64, error)            // 生成快照，返回快照包含的最后一条raft日志的index和term
+	Restore(db IRegistrationDB, data []byte) error        // 使用其他节点的快照替换当前状态
+	Close() error
+}
@@ -1,19 +1,50 @@
 package iface
 
-import serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/config"
+	"time"
+)
 
 type ILmqDaemon interface {
 	Exit() // 退出lmqd
 	Main()
 
-	GetTopics() []ITopic                               // 获取所有的topic
-	GetTopic(name string) (ITopic, error)              // 根据名字获取一个topic，如果没有就新增一个
-	GetExistingTopic(topicName string) (ITopic, error) // 根据名字获取一个存在的topic
-	DeleteExistingTopic(topicName string) error        // 删除一个存在的topic
+	GetTopics() []ITopic                                                      // 获取所有的topic
+	GetTopic(name string) (ITopic, error)                                     // 根据名字获取一个topic，如果没有就新增一个
+	GetTopicWithSettings(name string, settings TopicSettings) (ITopic, error) // 根据名字获取一个topic，如果没有就按照settings新增一个
+	GetExistingTopic(topicName string) (ITopic, error)                        // 根据名字获取一个存在的topic
+	DeleteExistingTopic(topicName string) error                               // 删除一个存在的topic
+	WatchTopics(fn func(topic ITopic)) uint64                                 // 监听新建的topic，返回监听的ID
+	UnWatchTopics(id uint64)                                                  // 取消监听新建的topic
 
 	GenerateClientID(conn serveriface.IConnection) uint64 // 生成一个clientID
+	GetNodeID() int64                                     // 获取节点ID
+	GetGUIDFactory() IGUIDFactory                         // 获取message id生成器
+	GetStartTime() time.Time                              // 获取启动时间
+
+	GetLookupManager() ILookupManager           // 获取lookup manager
+	GetReplicationManager() IReplicationManager // 获取副本管理器
+
+	Drain()                       // 开始下线lmqd
+	CancelDrain()                 // 取消下线
+	IsDraining() bool             // 是否正在下线
+	GetDrainStatus() *DrainStatus // 获取下线的进度
+	GetDrainTargets() []string    // 下线期间可以接受发布请求的其他节点
 
 	Notify(v interface{}, persist bool) // 通知lmqd进行持久化，通知lookup
 	LoadMetaData() error                // 加载元数据信息
 	PersistMetaData() error             // 持久化元数据信息
+
+	Reload() (*config.ReloadResult, error) // 重新加载配置文件
+}
+
+// DrainStatus lmqd下线的进度
+type DrainStatus struct {
+	Draining    bool  `json:"draining"`
+	ReadyToStop bool  `json:"ready_to_stop"` // 所有的消息都已经投递并确认，可以停止lmqd了
+	StartedAt   int64 `json:"started_at,omitempty"`
+	Topics      int   `json:"topics"`
+	Depth       int64 `json:"depth"`     // topic和channel中还未投递的消息数量
+	InFlight    int64 `json:"in_flight"` // 已经投递但还未确认的消息数量
 }
@@ -1,6 +1,7 @@
 package iface
 
 type ILmqLookup interface {
-	Stop() // 退出lmq lookup
+	Stop()                    // 退出lmq lookup
+	LoadRegistrations() error // 加载持久化的registration
 	Main()
 }
@@ -6,4 +6,8 @@ type ILookupManager interface {
 	Close()
 	GetNotifyChan() chan interface{}
 	GetLookupTopicChannels(topicName string) []string
+	GetLookupNodes() (self string, nodes []string, err error) // 获取所有存活的lmqd节点地址，以及本节点的地址
+	TombstoneTopics(topicNames []string)                      // 在所有的lookup中tombstone本节点的topic
+	UnTombstoneTopics(topicNames []string)                    // 在所有的lookup中取消本节点topic的tombstone
+	UpdateLookupAddresses(addresses []string)                 // 更新lookup的地址，连接新增的lookup，断开已经删除的lookup
 }
@@ -14,6 +14,16 @@ type ILmqdInfo interface {
 	SetHostName(hostname string)
 	GetTcpPort() int
 	SetTcpPort(tcpPort int)
+	GetNodeID() int64
+	SetNodeID(nodeID int64)
+	GetVersion() string
+	SetVersion(version string)
+	GetHttpPort() int
+	SetHttpPort(httpPort int)
+	GetBroadcastAddress() string
+	SetBroadcastAddress(broadcastAddress string)
+	GetStartTime() time.Time
+	SetStartTime(startTime time.Time)
 	Equals(anotherInfo ILmqdInfo) bool
 }
 
@@ -23,6 +33,8 @@ type ILmqdProducer interface {
 	SetLmqdInfo(peerInfo ILmqdInfo)
 	String() string
 	Tombstone()
+	TombstoneAt(tombstonedAt time.Time)
+	UnTombstone()
 	IsTombstoned(lifetime time.Duration) bool
 }
 
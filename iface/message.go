@@ -1,7 +1,13 @@
 package iface
 
 const (
-	MsgIDLength = 8
+	MsgIDLength         = 8
+	MsgHeadersMaxLength = 4096 // 消息头部序列化后的最大长度
+
+	OrderingKeyHeader = "ordering_key" // 消息头部中的ordering key，ordered模式的channel中相同key的消息按顺序投递
+	PriorityHeader    = "priority"     // 消息头部中的优先级，优先级高的消息先投递
+	DedupKeyHeader    = "dedup_key"    // 消息头部中的dedup key，topic在去重窗口内丢弃相同key的消息
+	RouteHopsHeader   = "route_hops"   // 消息头部中的转发次数，由topic的路由规则设置
 )
 
 type MessageID [MsgIDLength]byte
@@ -10,20 +16,28 @@ func (msgID MessageID) Bytes() []byte {
 	return msgID[:]
 }
 
+// IGUIDFactory message id 生成器
+type IGUIDFactory interface {
+	NewMessageID() MessageID
+}
+
 type IMessage interface {
-	GetID() MessageID             // 获取message id
-	GetData() []byte              // 获取消息的内容
-	GetDataLength() int32         // 获取消息数据部分的长度
-	GetLength() int32             // 获取消息持久化总长度（包括ID 时间戳等）
-	GetTimestamp() int64          // 获取消息时间戳
-	SetTimestamp(timestamp int64) // 设置时间戳
-	GetAttempts() uint16          // 获取尝试次数
-	SetAttempts(attempts uint16)  // 设置尝试次数
-	AddAttempts(delta uint16)     //	增加尝试次数
-	GetPriority() int64           // 优先级
-	SetPriority(pri int64)        // 设置优先级
-	GetClientID() uint64          // 获取客户端ID
-	SetClientID(clientID uint64)  // 设置客户端ID
-	GetIndex() int                // index为在优先队列中的位置
+	GetID() MessageID                     // 获取message id
+	GetData() []byte                      // 获取消息的内容
+	GetDataLength() int32                 // 获取消息数据部分的长度
+	GetLength() int32                     // 获取消息持久化总长度（包括ID 时间戳等）
+	GetTimestamp() int64                  // 获取消息时间戳
+	SetTimestamp(timestamp int64)         // 设置时间戳
+	GetAttempts() uint16                  // 获取尝试次数
+	SetAttempts(attempts uint16)          // 设置尝试次数
+	AddAttempts(delta uint16)             //	增加尝试次数
+	GetHeaders() map[string]string        // 获取消息头部
+	SetHeaders(headers map[string]string) // 设置消息头部
+	GetHeader(key string) string          // 获取消息头部中的一项
+	GetPriority() int64                   // 优先级
+	SetPriority(pri int64)                // 设置优先级
+	GetClientID() uint64                  // 获取客户端ID
+	SetClientID(clientID uint64)          // 设置客户端ID
+	GetIndex() int                        // index为在优先队列中的位置
 	SetIndex(index int)
 }
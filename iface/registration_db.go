@@ -1,5 +1,7 @@
 package iface
 
+import "time"
+
 type Category uint8
 
 const (
@@ -18,6 +20,12 @@ type IRegistration interface {
 	IsMatch(category Category, key string, subKey string) bool
 }
 
+// PartitionPlacement 分区topic中一个分区所在的lmqd
+type PartitionPlacement struct {
+	Hostname string `json:"hostname"`
+	TcpPort  int    `json:"tcp_port"`
+}
+
 type IRegistrations interface {
 	Filter(category Category, key string, subKey string) IRegistrations
 	Keys() []string
@@ -35,4 +43,37 @@ type IRegistrationDB interface {
 	FindProducers(category Category, key string, subKey string) IProducers
 	LookupRegistrations(id string) IRegistrations
 	RemoveProducerFromAllRegistrations(id string)
+	TombstoneProducers(topicName string, hostname string, tcpPort int, tombstonedAt time.Time)
+	UnTombstoneProducers(topicName string, hostname string, tcpPort int)
+	SetPartitions(topicName string, placements []*PartitionPlacement) // 记录分区topic中每个分区所在的lmqd
+	GetPartitions(topicName string) []*PartitionPlacement             // 查询分区topic的分区，不是分区topic时返回nil
+	RemovePartitions(topicName string)
+	CheckInactiveProducers(inactivityTimeout time.Duration) // 检查不活跃的lmqd，产生inactive事件
+
+	Watch(topicName string) (uint64, <-chan *TopologyEvent)    // 订阅topic的拓扑变化，topic name为空时订阅整个集群
+	Unwatch(id uint64)                                         // 取消订阅
+	EventsSince(topicName string, seq uint64) []*TopologyEvent // 查询序号大于seq的历史事件
+}
+
+// TopologyEventType 拓扑变化事件的类型
+type TopologyEventType string
+
+const (
+	ProducerRegisterEvent    = TopologyEventType("register")    // lmqd注册了topic/channel
+	ProducerUnregisterEvent  = TopologyEventType("unregister")  // lmqd取消注册topic/channel，或者断开连接
+	ProducerTombstoneEvent   = TopologyEventType("tombstone")   // lmqd在topic下被标记为tombstone
+	ProducerUnTombstoneEvent = TopologyEventType("untombstone") // lmqd在topic下被取消tombstone
+	ProducerInactiveEvent    = TopologyEventType("inactive")    // lmqd长时间没有发送心跳
+)
+
+// TopologyEvent 拓扑变化事件，topic name为空时表示lmqd加入或者离开集群
+type TopologyEvent struct {
+	Seq           uint64            `json:"seq"` // 事件序号，单调递增
+	Type          TopologyEventType `json:"type"`
+	TopicName     string            `json:"topic_name,omitempty"`
+	ChannelName   string            `json:"channel_name,omitempty"`
+	RemoteAddress string            `json:"remote_address"`
+	Hostname      string            `json:"hostname"`
+	TcpPort       int               `json:"tcp_port"`
+	Timestamp     int64             `json:"timestamp"`
 }
@@ -0,0 +1,19 @@
+package iface
+
+// IReplicationManager 负责将topic中的消息复制到其他lmqd节点，以及在主节点宕机之后接管副本
+type IReplicationManager interface {
+	Start()
+	Close()
+
+	// 主节点
+	Replicate(topicName string, msg IMessage) error                 // 将消息复制到副本节点，等待足够数量的副本确认
+	Track(topicName string, msgID MessageID, channelNames []string) // 记录消息被投递到了哪些channel中
+	Finish(topicName, channelName string, msgID MessageID)          // 某个channel完成了消息，所有channel都完成之后通知副本节点删除
+	Abort(topicName string, msgID MessageID)                        // 消息复制之后发布失败，通知副本节点删除
+	Drop(topicName, channelName string)                             // 消息被清空，channelName为空时表示topic中还没有投递到channel的消息
+
+	// 副本节点
+	StoreReplica(primary string, replicas []string, topicName string, msg IMessage) // 保存主节点复制过来的消息
+	FinishReplica(primary string, topicName string, msgIDs []MessageID)             // 删除主节点已经完成的消息
+	TakeOver(primary string) (int, error)                                           // 接管主节点，将副本中的消息发布到本节点的topic中
+}
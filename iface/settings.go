@@ -0,0 +1,86 @@
+package iface
+
+import "time"
+
+// Overrides topic/channel级别的配置，覆盖lmqd的全局配置，为nil的字段使用全局配置
+type Overrides struct {
+	MemQueueSize    *int           `json:"mem_queue_size,omitempty"`
+	MinMessageSize  *int32         `json:"min_message_size,omitempty"`
+	MaxMessageSize  *int32         `json:"max_message_size,omitempty"`
+	SyncEvery       *int64         `json:"sync_every,omitempty"`
+	SyncTimeout     *time.Duration `json:"sync_timeout,omitempty"`
+	MaxBytesPerFile *int64         `json:"max_bytes_per_file,omitempty"`
+	MessageTimeout  *time.Duration `json:"message_timeout,omitempty"`
+}
+
+// Options topic/channel生效的配置，按照channel、topic、lmqd全局配置的顺序取值
+type Options struct {
+	MemQueueSize    int           `json:"mem_queue_size"`
+	MinMessageSize  int32         `json:"min_message_size"`
+	MaxMessageSize  int32         `json:"max_message_size"`
+	SyncEvery       int64         `json:"sync_every"`
+	SyncTimeout     time.Duration `json:"sync_timeout"`
+	MaxBytesPerFile int64         `json:"max_bytes_per_file"`
+	MessageTimeout  time.Duration `json:"message_timeout"`
+}
+
+// DeadLetter 死信配置，尝试次数超过MaxAttempts的消息会被投递到TopicName中
+type DeadLetter struct {
+	TopicName   string `json:"topic_name"`
+	MaxAttempts uint16 `json:"max_attempts"`
+}
+
+// RouteRule topic的路由规则，满足过滤条件的消息会被转发到所有的目的topic中
+type RouteRule struct {
+	Name          string            `json:"name,omitempty"`
+	Destinations  []string          `json:"destinations"`
+	Filter        []*FilterRule     `json:"filter,omitempty"`         // 为空时转发所有消息
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`    // 转发时设置的头部
+	RemoveHeaders []string          `json:"remove_headers,omitempty"` // 转发时删除的头部
+}
+
+// TopicSettings topic中需要持久化到元数据中的配置
+type TopicSettings struct {
+	Overrides   *Overrides    `json:"overrides,omitempty"`
+	Retention   time.Duration `json:"retention,omitempty"`    // 消息保留时长，为0时使用lmqd的全局配置，小于0时不保留
+	DedupWindow time.Duration `json:"dedup_window,omitempty"` // 去重窗口，为0时使用lmqd的全局配置，小于0时不去重
+	Routes      []*RouteRule  `json:"routes,omitempty"`       // 路由规则，将消息转发到其他topic中
+}
+
+const (
+	FilterOpEq     = "eq"     // 头部的值等于Values[0]
+	FilterOpPrefix = "prefix" // 头部的值以Values[0]开头
+	FilterOpIn     = "in"     // 头部的值是Values中的一个
+)
+
+// FilterRule channel按照消息头部过滤消息的规则
+type FilterRule struct {
+	Header string   `json:"header"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+const (
+	DispatchPolicyRoundRobin    = "round_robin"     // 按照客户端的顺序轮流分发
+	DispatchPolicyWeighted      = "weighted"        // 按照客户端的权重分发
+	DispatchPolicyLeastInFlight = "least_in_flight" // 分发给in-flight消息最少的客户端
+)
+
+const (
+	SubscriptionModeShared    = "shared"    // 所有客户端共同消费
+	SubscriptionModeExclusive = "exclusive" // 只允许一个客户端订阅
+	SubscriptionModeFailover  = "failover"  // 只有一个活跃的客户端接收消息，其他客户端作为备用
+)
+
+// ChannelSettings channel中需要持久化到元数据中的配置
+type ChannelSettings struct {
+	Overrides  *Overrides    `json:"overrides,omitempty"`
+	DeadLetter *DeadLetter   `json:"dead_letter,omitempty"`
+	Ordered    bool          `json:"ordered,omitempty"`     // 相同ordering key的消息按照发布的顺序逐个投递
+	Priority   bool          `json:"priority,omitempty"`    // 已经开启优先级队列
+	Filter     []*FilterRule `json:"filter,omitempty"`      // 消息需要满足所有的规则才会放入channel中
+	SampleRate int           `json:"sample_rate,omitempty"` // 采样率（1-100），channel只接收这个百分比的消息，为0时接收所有消息
+
+	DispatchPolicy   string `json:"dispatch_policy,omitempty"`   // 消息在客户端之间的分发策略，为空时所有客户端竞争读取
+	SubscriptionMode string `json:"subscription_mode,omitempty"` // 订阅模式，为空时为shared模式
+}
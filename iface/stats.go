@@ -0,0 +1,31 @@
+package iface
+
+// ChannelStats channel的统计信息
+type ChannelStats struct {
+	Name          string     `json:"name"`
+	Paused        bool       `json:"paused"`
+	Depth         int64      `json:"depth"`                   // 还未投递的消息数量
+	InFlightCount int        `json:"in_flight_count"`         // 已经投递但还未确认的消息数量
+	ClientCount   int        `json:"client_count"`            // 订阅的客户端数量
+	ActiveClient  uint64     `json:"active_client,omitempty"` // failover模式下活跃的客户端ID
+	MessageCount  uint64     `json:"message_count"`           // 放入channel的消息数量
+	RequeueCount  uint64     `json:"requeue_count"`           // 重新入队的消息数量
+	TimeoutCount  uint64     `json:"timeout_count"`           // 超时的消息数量
+	FilteredCount uint64     `json:"filtered_count"`          // 不满足过滤条件被跳过的消息数量
+	SampledCount  uint64     `json:"sampled_count"`           // 没有被采样而跳过的消息数量
+	DroppedCount  uint64     `json:"dropped_count"`           // 临时channel内存队列满了之后丢弃的消息数量
+	DeadCount     uint64     `json:"dead_count"`              // 尝试次数超过上限被投递到死信topic的消息数量
+	Overrides     *Overrides `json:"overrides,omitempty"`     // channel级别的配置
+}
+
+// TopicStats topic的统计信息
+type TopicStats struct {
+	Name         string          `json:"name"`
+	Paused       bool            `json:"paused"`
+	Depth        int64           `json:"depth"`               // 还未分发到channel的消息数量
+	MessageCount uint64          `json:"message_count"`       // 发布的消息数量
+	MessageBytes uint64          `json:"message_bytes"`       // 发布的消息数据总长度
+	DroppedCount uint64          `json:"dropped_count"`       // 临时topic内存队列满了之后丢弃的消息数量
+	Overrides    *Overrides      `json:"overrides,omitempty"` // topic级别的配置
+	Channels     []*ChannelStats `json:"channels"`
+}
@@ -1,21 +1,39 @@
 package iface
 
+import "time"
+
 type ITopic interface {
-	Start()          // 开启topic
-	Pause() error    // 暂停topic
-	UnPause() error  // 恢复topic
-	Empty() error    // 清空topic
-	Close() error    // 关闭topic
-	Delete() error   // 关闭并删除topic
-	IsPausing() bool // 返回是否处于暂停状态
-	IsExiting() bool // 返回是否处于关闭状态
+	Start()            // 开启topic
+	Pause() error      // 暂停topic
+	UnPause() error    // 恢复topic
+	Empty() error      // 清空topic
+	Close() error      // 关闭topic
+	Delete() error     // 关闭并删除topic
+	IsPausing() bool   // 返回是否处于暂停状态
+	IsExiting() bool   // 返回是否处于关闭状态
+	IsEphemeral() bool // 返回是否是临时topic
+
+	GetCreatedAt() time.Time            // 获取创建时间
+	SetCreatedAt(createdAt time.Time)   // 设置创建时间，用于从元数据中恢复
+	GetSettings() TopicSettings         // 获取需要持久化的配置
+	SetSettings(settings TopicSettings) // 设置需要持久化的配置
+	GetOptions() *Options               // 获取生效的配置
 
 	GenerateGUID() MessageID // 生成一个messageID
 
-	GetName() string                                  // 获取一个topic的name
-	GetChannelNames() []string                        // 获取所有channel的name
-	GetChannel(name string) (IChannel, error)         // 获取一个channel，如果没有就新建一个
-	GetExistingChannel(name string) (IChannel, error) // 根据名字获取一个已存在的channel
-	DeleteExistingChannel(name string) error          // 删除一个存在的channel
-	PutMessage(message IMessage) error                // 向topic发布一个消息
+	GetName() string                                                                // 获取一个topic的name
+	Depth() int64                                                                   // 还未分发到channel的消息数量
+	GetStats() *TopicStats                                                          // 获取统计信息，包括所有channel的统计信息
+	GetChannelNames() []string                                                      // 获取所有channel的name
+	GetChannel(name string) (IChannel, error)                                       // 获取一个channel，如果没有就新建一个
+	GetChannelWithSettings(name string, settings ChannelSettings) (IChannel, error) // 获取一个channel，如果没有就按照settings新建一个
+	GetExistingChannel(name string) (IChannel, error)                               // 根据名字获取一个已存在的channel
+	DeleteExistingChannel(name string) error                                        // 删除一个存在的channel
+	PutMessage(message IMessage) error                                              // 向topic发布一个消息
+	AcceptDedupKey(message IMessage) (bool, error)                                  // 记录消息的dedup key，去重窗口内已经发布过相同key的消息时返回false
+	ForgetDedupKey(message IMessage)                                                // 消息发布失败时删除dedup key，允许生产者重试
+	CommitDedupKey(message IMessage)                                                // 消息发布成功之后确认dedup key
+	Export(fn func(message IMessage) error) error                                   // 导出topic中还未投递的消息
+
+	ReplayChannel(channelName string, fromTimestamp int64, fromID MessageID) (int, error) // 从某个时间点或者消息ID开始重放保留的消息到channel中
 }
@@ -1,45 +1,123 @@
 package dirlock
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 )
 
 const lockFilename = "dir.lock"
 
+var ErrDirLocked = errors.New("dir is locked by another process")
+
+// DirLock 使用操作系统的建议锁（advisory lock）为文件夹加锁，进程退出（包括崩溃、kill -9）时锁会被自动释放
 type DirLock struct {
 	dirPath  string // 需要加锁的路径
 	lockFile *os.File
 }
 
+// Owner 持有锁的进程信息，记录在lock file中
+type Owner struct {
+	PID      int
+	Hostname string
+}
+
+func (owner Owner) String() string {
+	return fmt.Sprintf("pid: %d, hostname: %s", owner.PID, owner.Hostname)
+}
+
 func NewDirLock(dirPath string) *DirLock {
 	return &DirLock{
 		dirPath: dirPath,
 	}
 }
 
-// TryLock 尝试为dirPath上锁，如果加锁失败则返回false
-func (l *DirLock) TryLock() bool {
-	lockFile, err := os.OpenFile(path.Join(l.dirPath, lockFilename), os.O_CREATE|os.O_EXCL, 0600)
+// TryLock 尝试为dirPath上锁，如果加锁失败则返回错误，错误中包含了持有锁的进程的PID和hostname
+func (l *DirLock) TryLock() error {
+	lockFile, err := os.OpenFile(path.Join(l.dirPath, lockFilename), os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
-		return false // lock file已经存在，上锁失败
+		return err
+	}
+
+	err = lockFd(lockFile)
+	if err != nil {
+		// 加锁失败，读取持有锁的进程信息
+		owner, readErr := readOwner(lockFile)
+		_ = lockFile.Close()
+		if readErr != nil {
+			return fmt.Errorf("%w: %s", ErrDirLocked, err.Error())
+		}
+
+		return fmt.Errorf("%w (%s)", ErrDirLocked, owner)
+	}
+
+	// 加锁成功，记录本进程的PID和hostname
+	err = writeOwner(lockFile)
+	if err != nil {
+		_ = unlockFd(lockFile)
+		_ = lockFile.Close()
+		return err
 	}
 
 	l.lockFile = lockFile
-	return true // 加锁成功
+	return nil // 加锁成功
 }
 
 // Unlock 为dirPath解锁，如果解锁失败则返回false
+// lock file不会被删除，删除会导致其他进程可能对已经删除的文件加锁
 func (l *DirLock) Unlock() bool {
 	if l.lockFile == nil {
 		return false
 	}
 
+	_ = l.lockFile.Truncate(0)
+	err := unlockFd(l.lockFile)
 	_ = l.lockFile.Close()
-	err := os.Remove(path.Join(l.lockFile.Name()))
+	l.lockFile = nil
+
+	return err == nil
+}
+
+// 将本进程的PID和hostname写入lock file，格式为：pid\nhostname\n
+func writeOwner(f *os.File) error {
+	hostname, _ := os.Hostname()
+
+	err := f.Truncate(0)
 	if err != nil {
-		return false
+		return err
+	}
+
+	_, err = f.WriteAt([]byte(fmt.Sprintf("%d\n%s\n", os.Getpid(), hostname)), 0)
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// 从lock file中读取持有锁的进程信息
+func readOwner(f *os.File) (Owner, error) {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1024))
+	if err != nil {
+		return Owner{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		return Owner{}, errors.New("lock file content is invalid")
+	}
+
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return Owner{}, err
 	}
 
-	return true
+	return Owner{
+		PID:      pid,
+		Hostname: lines[1],
+	}, nil
 }
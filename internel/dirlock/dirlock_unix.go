@@ -0,0 +1,17 @@
+//go:build !windows
+
+package dirlock
+
+import (
+	"os"
+	"syscall"
+)
+
+// 使用flock加非阻塞的排他锁，文件描述符关闭或者进程退出时自动释放
+func lockFd(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func unlockFd(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
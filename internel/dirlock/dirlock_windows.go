@@ -0,0 +1,22 @@
+//go:build windows
+
+package dirlock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// windows下的字节范围锁会阻止其他进程读取被锁定的范围，因此锁定文件内容之外的一个字节，保证其他进程可以读取持有锁的进程信息
+const lockOffset = 1 << 30
+
+func lockFd(f *os.File) error {
+	overlapped := &windows.Overlapped{Offset: lockOffset}
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+}
+
+func unlockFd(f *os.File) error {
+	overlapped := &windows.Overlapped{Offset: lockOffset}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, overlapped)
+}
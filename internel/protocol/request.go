@@ -4,18 +4,55 @@ import (
 	"encoding/json"
 	iface2 "github.com/dawnzzz/hamble-tcp-server/iface"
 	"github.com/dawnzzz/lmq/iface"
+	"time"
 )
 
 type RequestBody struct {
-	TopicName   string          `json:"topic_name,omitempty"`
-	ChannelName string          `json:"channel_name,omitempty"`
-	MessageData []byte          `json:"message_data,omitempty"`
-	Count       int64           `json:"count,omitempty"`
-	MessageID   iface.MessageID `json:"message_id,omitempty"`
+	TopicName   string            `json:"topic_name,omitempty"`
+	ChannelName string            `json:"channel_name,omitempty"`
+	MessageData []byte            `json:"message_data,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Priority    int               `json:"priority,omitempty"` // 消息的优先级，大于0时优先投递，小于0时最后投递
+	Count       int64             `json:"count,omitempty"`
+	MessageID   iface.MessageID   `json:"message_id,omitempty"`
 
 	RemoteAddress string `json:"remote_address,omitempty"`
 	Hostname      string `json:",omitempty"`
 	TcpPort       int    `json:"tcp_port,omitempty"`
+
+	NodeID           int64  `json:"node_id,omitempty"`           // lmqd的节点ID
+	Version          string `json:"version,omitempty"`           // lmqd的版本
+	HttpPort         int    `json:"http_port,omitempty"`         // lmqd的http端口
+	BroadcastAddress string `json:"broadcast_address,omitempty"` // lmqd对客户端公布的地址
+	StartTime        int64  `json:"start_time,omitempty"`        // lmqd的启动时间（单位纳秒）
+
+	Partitions int  `json:"partitions,omitempty"` // 分区topic的分区数量
+	Ordered    bool `json:"ordered,omitempty"`    // 创建channel时开启ordered模式
+
+	Filter     []*iface.FilterRule `json:"filter,omitempty"`      // 创建channel时设置过滤条件
+	SampleRate *int                `json:"sample_rate,omitempty"` // 创建channel时设置采样率
+
+	DispatchPolicy *string `json:"dispatch_policy,omitempty"` // 创建channel时设置分发策略，为空字符串时取消分发策略
+	Weight         int     `json:"weight,omitempty"`          // 订阅时指定的权重，用于weighted分发策略
+
+	SubscriptionMode *string `json:"subscription_mode,omitempty"` // 创建channel时设置订阅模式
+
+	DeadLetter *iface.DeadLetter `json:"dead_letter,omitempty"` // 创建channel时设置死信配置
+
+	FilePath string `json:"file_path,omitempty"` // 导出/导入的文件路径（lmqd备份目录backup_path中的相对路径）
+
+	Retention time.Duration `json:"retention,omitempty"` // topic消息的保留时长
+	Timestamp int64         `json:"timestamp,omitempty"` // 重放channel的起始时间戳（单位纳秒）
+
+	DedupWindow time.Duration `json:"dedup_window,omitempty"` // topic的去重窗口
+
+	Routes []*iface.RouteRule `json:"routes,omitempty"` // topic的路由规则
+
+	Overrides *iface.Overrides `json:"overrides,omitempty"` // topic/channel级别的配置，覆盖lmqd的全局配置
+
+	Primary    string            `json:"primary,omitempty"`     // 复制消息的主节点地址
+	Replicas   []string          `json:"replicas,omitempty"`    // 保存消息的副本节点地址
+	MessageIDs []iface.MessageID `json:"message_ids,omitempty"` // 主节点已经完成的消息ID
 }
 
 func GetRequestBody(request iface2.IRequest) (*RequestBody, error) {
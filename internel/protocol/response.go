@@ -14,12 +14,30 @@ var bufPool = sync.Pool{
 	},
 }
 
+// LookupResponseVersion LOOKUP响应的版本
+// 版本1（没有version字段）：producers中的每一项都是空对象
+// 版本2：producers中的每一项都是完整的Node，topics和tombstones只包含查询的topic
+const LookupResponseVersion = 2
+
+// Node lmqd节点的信息，NODES和LOOKUP的响应中都会返回tombstones和topics
 type Node struct {
-	RemoteAddress string   `json:"remote_address"`
-	Hostname      string   `json:"hostname"`
-	TCPPort       int      `json:"tcp_port"`
-	Tombstones    []bool   `json:"tombstones"`
-	Topics        []string `json:"topics"`
+	RemoteAddress    string   `json:"remote_address"`
+	Hostname         string   `json:"hostname"`
+	BroadcastAddress string   `json:"broadcast_address"` // 客户端应该使用这个地址连接lmqd
+	TCPPort          int      `json:"tcp_port"`
+	HttpPort         int      `json:"http_port,omitempty"`
+	NodeID           int64    `json:"node_id"`
+	Version          string   `json:"version"`
+	StartTime        int64    `json:"start_time"`
+	Tombstones       []bool   `json:"tombstones"`
+	Topics           []string `json:"topics"`
+}
+
+// Partition 分区topic中的一个分区
+type Partition struct {
+	Partition int    `json:"partition"`
+	TopicName string `json:"topic_name"` // 分区对应的topic，生产者和消费者直接使用这个topic
+	Node      *Node  `json:"node"`       // 分区所在的lmqd
 }
 
 type ResponseBody struct {
@@ -43,13 +61,7 @@ func MakeStatusResponse(taskID uint32, err error) []byte {
 		responseBody.StatusMsg = "OK"
 	}
 
-	buffer := bufPool.Get().(*bytes.Buffer)
-	defer bufPool.Put(buffer)
-	buffer.Reset()
-
-	_ = json.NewEncoder(buffer).Encode(&responseBody)
-
-	return buffer.Bytes()
+	return encodeResponse(responseBody)
 }
 
 func MakeMessageResponse(taskID uint32, msg iface.IMessage) []byte {
@@ -59,13 +71,7 @@ func MakeMessageResponse(taskID uint32, msg iface.IMessage) []byte {
 		Message:   msg,
 	}
 
-	buffer := bufPool.Get().(*bytes.Buffer)
-	defer bufPool.Put(buffer)
-	buffer.Reset()
-
-	_ = json.NewEncoder(buffer).Encode(&responseBody)
-
-	return buffer.Bytes()
+	return encodeResponse(responseBody)
 }
 
 func MakeDataResponse(taskID uint32, data interface{}) []byte {
@@ -75,13 +81,7 @@ func MakeDataResponse(taskID uint32, data interface{}) []byte {
 		Data:      data,
 	}
 
-	buffer := bufPool.Get().(*bytes.Buffer)
-	defer bufPool.Put(buffer)
-	buffer.Reset()
-
-	_ = json.NewEncoder(buffer).Encode(&responseBody)
-
-	return buffer.Bytes()
+	return encodeResponse(responseBody)
 }
 
 func MakeNodesResponse(taskID uint32, nodes []*Node) []byte {
@@ -91,11 +91,19 @@ func MakeNodesResponse(taskID uint32, nodes []*Node) []byte {
 		Nodes:     nodes,
 	}
 
+	return encodeResponse(responseBody)
+}
+
+// encodeResponse 序列化响应，buf放回缓冲池之后会被复用，而响应可能还在异步发送，所以返回复制出来的数据
+func encodeResponse(responseBody *ResponseBody) []byte {
 	buffer := bufPool.Get().(*bytes.Buffer)
 	defer bufPool.Put(buffer)
 	buffer.Reset()
 
-	_ = json.NewEncoder(buffer).Encode(&responseBody)
+	_ = json.NewEncoder(buffer).Encode(responseBody)
+
+	data := make([]byte, buffer.Len())
+	copy(data, buffer.Bytes())
 
-	return buffer.Bytes()
+	return data
 }
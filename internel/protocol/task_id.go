@@ -28,4 +28,44 @@ const (
 	ChannelsID
 	TombstoneTopicID
 	NodesID
+
+	ExportTopicID
+	ExportChannelID
+	ImportTopicID
+
+	SetTopicRetentionID
+	ReplayChannelID
+
+	ReplicateID
+	ReplicaFinID
+	TakeOverID
+
+	RequestVoteID
+	AppendEntriesID
+	ProposeID
+	ClusterStatusID
+
+	WatchID
+	UnWatchID
+
+	DrainID
+	DrainStatusID
+
+	UnTombstoneTopicID
+
+	CreatePartitionedTopicID
+
+	SetTopicDedupID
+
+	StatsID
+
+	SetTopicRoutesID
+
+	SetOverridesID
+
+	ReloadID
+
+	InstallSnapshotID
+
+	CancelDrainID
 )
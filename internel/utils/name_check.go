@@ -1,13 +1,23 @@
 package utils
 
-import "regexp"
+import (
+	"path"
+	"regexp"
+	"strings"
+)
 
 const (
 	TopicOrChannelNameMinLen = 0
 	TopicOrChannelNameMaxLen = 60
+
+	EphemeralSuffix    = "#tmp" // 临时topic/channel名字的后缀
+	PartitionSeparator = "#p"   // 分区topic的名字为topic#pN，保留给分区使用，普通的topic不会与之冲突
 )
 
-var validTopicChannelNameRegex = regexp.MustCompile(`^[.0-9a-zA-Z-_]+(#tmp)?$`)
+var (
+	validTopicChannelNameRegex = regexp.MustCompile(`^[.0-9a-zA-Z-_]+(` + PartitionSeparator + `[0-9]+)?(` + EphemeralSuffix + `)?$`)
+	partitionNameRegex         = regexp.MustCompile(PartitionSeparator + `[0-9]+(` + EphemeralSuffix + `)?$`)
+)
 
 // TopicOrChannelNameIsValid 检查topic或者channel的名字是否合法
 func TopicOrChannelNameIsValid(name string) bool {
@@ -16,6 +26,41 @@ func TopicOrChannelNameIsValid(name string) bool {
 		return false
 	}
 
-	// name只能包含数字、字母、.、-、_或者以#temp结尾
+	// name只能包含数字、字母、.、-、_，分区topic带有#pN，可以以#tmp结尾
 	return validTopicChannelNameRegex.MatchString(name)
 }
+
+// IsPartitionName 是否是分区topic中某个分区对应的topic
+func IsPartitionName(name string) bool {
+	return partitionNameRegex.MatchString(name)
+}
+
+// IsEphemeralName 名字以#tmp结尾的topic/channel是临时的
+func IsEphemeralName(name string) bool {
+	return strings.HasSuffix(name, EphemeralSuffix)
+}
+
+// IsTopicPattern topic的名字中是否带有通配符
+func IsTopicPattern(name string) bool {
+	return strings.ContainsAny(name, "*?")
+}
+
+// TopicPatternIsValid 检查topic通配符是否合法，*匹配任意个字符，?匹配一个字符
+func TopicPatternIsValid(pattern string) bool {
+	if !TopicOrChannelNameIsValid(strings.NewReplacer("*", "a", "?", "a").Replace(pattern)) {
+		return false
+	}
+
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// MatchTopicPattern topic的名字是否匹配通配符，分区对应的topic只匹配带有#p的通配符
+func MatchTopicPattern(pattern, name string) bool {
+	if IsPartitionName(name) && !strings.Contains(pattern, PartitionSeparator) {
+		return false
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,70 @@
+package utils
+
+import "testing"
+
+func TestEphemeralName(t *testing.T) {
+	cases := []struct {
+		name      string
+		valid     bool
+		ephemeral bool
+	}{
+		{"test", true, false},
+		{"test#tmp", true, true},
+		{"test#temp", false, false},
+		{"#tmp", false, true},
+		{"te#tmpst", false, false},
+	}
+
+	for _, c := range cases {
+		if valid := TopicOrChannelNameIsValid(c.name); valid != c.valid {
+			t.Errorf("TopicOrChannelNameIsValid(%q) = %v, want %v", c.name, valid, c.valid)
+		}
+		if ephemeral := IsEphemeralName(c.name); ephemeral != c.ephemeral {
+			t.Errorf("IsEphemeralName(%q) = %v, want %v", c.name, ephemeral, c.ephemeral)
+		}
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	cases := []struct {
+		name      string
+		valid     bool
+		partition bool
+	}{
+		{"orders.1", true, false},
+		{"orders#p1", true, true},
+		{"orders#p12#tmp", true, true},
+		{"orders#p", false, false},
+		{"orders#p1#p2", false, true},
+		{"#p1", false, true},
+	}
+
+	for _, c := range cases {
+		if valid := TopicOrChannelNameIsValid(c.name); valid != c.valid {
+			t.Errorf("TopicOrChannelNameIsValid(%q) = %v, want %v", c.name, valid, c.valid)
+		}
+		if partition := IsPartitionName(c.name); partition != c.partition {
+			t.Errorf("IsPartitionName(%q) = %v, want %v", c.name, partition, c.partition)
+		}
+	}
+}
+
+func TestMatchTopicPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"orders*", "orders.1", true},
+		{"orders*", "orders#p1", false},
+		{"*", "orders#p1#tmp", false},
+		{"orders#p*", "orders#p1", true},
+		{"orders?", "orders1", true},
+	}
+
+	for _, c := range cases {
+		if match := MatchTopicPattern(c.pattern, c.name); match != c.match {
+			t.Errorf("MatchTopicPattern(%q, %q) = %v, want %v", c.pattern, c.name, match, c.match)
+		}
+	}
+}
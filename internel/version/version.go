@@ -0,0 +1,4 @@
+package version
+
+// Version lmq的版本号，lmqd在identity时上报给lookup
+const Version = "0.1.0"
@@ -6,4 +6,6 @@ type BackendQueue interface {
 	Close() error
 	Delete() error
 	Empty() error
+	Scan(fn func([]byte) error) error // 按顺序遍历队列中还未读取的数据，不会消费数据
+	Depth() int64                     // 队列中还未读取的消息数量
 }
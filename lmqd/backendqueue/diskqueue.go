@@ -12,6 +12,7 @@ import (
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,6 +39,9 @@ type DiskBackendQueue struct {
 	nextReadPos       int64 // 下一次要读取的位置
 	nextReadFileIndex int64 // 下一次尧都区的文件号
 
+	depth        atomic.Int64 // 队列中还未读取的消息数量
+	depthUnknown bool         // 旧版本的元数据中没有记录depth，需要遍历队列计算
+
 	readFile  *os.File
 	writeFile *os.File
 	reader    *bufio.Reader
@@ -49,6 +53,8 @@ type DiskBackendQueue struct {
 	writeResponseChan chan error
 	emptyChan         chan struct{}
 	emptyResponseChan chan error
+	scanChan          chan func([]byte) error
+	scanResponseChan  chan error
 	exitChan          chan struct{}
 	exitSyncChan      chan struct{}
 }
@@ -68,6 +74,8 @@ func NewDiskBackendQueue(name string, dataPath string, maxBytesPerFile int64,
 		writeResponseChan: make(chan error),
 		emptyChan:         make(chan struct{}),
 		emptyResponseChan: make(chan error),
+		scanChan:          make(chan func([]byte) error),
+		scanResponseChan:  make(chan error),
 		exitChan:          make(chan struct{}),
 		exitSyncChan:      make(chan struct{}),
 		syncEvery:         syncEvery,
@@ -79,6 +87,9 @@ func NewDiskBackendQueue(name string, dataPath string, maxBytesPerFile int64,
 	if err != nil && !os.IsNotExist(err) {
 		logger.Errorf("DiskQueue(%s) failed to retrieveMetaData - %s", queue.name, err.Error())
 	}
+	if err == nil && queue.depthUnknown {
+		queue.recountDepth()
+	}
 
 	// 开启一个协程，进行ioLoop
 	go queue.ioLoop()
@@ -98,6 +109,11 @@ func (queue *DiskBackendQueue) Put(data []byte) error {
 	return <-queue.writeResponseChan
 }
 
+// Depth 队列中还未读取的消息数量
+func (queue *DiskBackendQueue) Depth() int64 {
+	return queue.depth.Load()
+}
+
 func (queue *DiskBackendQueue) ReadChan() <-chan []byte {
 	return queue.readChan
 }
@@ -157,6 +173,41 @@ func (queue *DiskBackendQueue) Empty() error {
 	return <-queue.emptyResponseChan
 }
 
+// Scan 按顺序遍历队列中还未读取的数据，遍历期间会阻塞队列的读写
+func (queue *DiskBackendQueue) Scan(fn func([]byte) error) error {
+	queue.RLock()
+	defer queue.RUnlock()
+
+	if queue.isExiting {
+		return errors.New("exiting")
+	}
+
+	queue.scanChan <- fn
+
+	return <-queue.scanResponseChan
+}
+
+// ScanDiskQueue 在不启动磁盘队列的情况下，遍历磁盘队列中还未读取的数据，用于离线导出
+func ScanDiskQueue(name string, dataPath string, minMsgSize int32, maxMsgSize int32, fn func([]byte) error) error {
+	queue := &DiskBackendQueue{
+		name:       name,
+		dataPath:   dataPath,
+		minMsgSize: minMsgSize,
+		maxMsgSize: maxMsgSize,
+	}
+
+	err := queue.retrieveMetaData()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 没有元数据，说明队列为空
+			return nil
+		}
+		return err
+	}
+
+	return queue.scanAll(fn)
+}
+
 // retrieveMetaData 检索元数据
 func (queue *DiskBackendQueue) retrieveMetaData() error {
 	// 首先获取元数据文件名，并且读取文件
@@ -168,10 +219,21 @@ func (queue *DiskBackendQueue) retrieveMetaData() error {
 	defer f.Close()
 
 	// 读取元数据文件内容
-	_, err = fmt.Fscanf(f, "%d,%d\n%d,%d\n", &queue.readFileIndex, &queue.readFilePos, &queue.writeFileIndex, &queue.writeFilePos)
+	data, err := io.ReadAll(f)
 	if err != nil {
 		return err
 	}
+	var depth int64
+	_, err = fmt.Sscanf(string(data), "%d\n%d,%d\n%d,%d\n", &depth, &queue.readFileIndex, &queue.readFilePos, &queue.writeFileIndex, &queue.writeFilePos)
+	if err != nil {
+		// 旧版本的元数据中没有depth
+		_, err = fmt.Sscanf(string(data), "%d,%d\n%d,%d\n", &queue.readFileIndex, &queue.readFilePos, &queue.writeFileIndex, &queue.writeFilePos)
+		if err != nil {
+			return err
+		}
+		queue.depthUnknown = true
+	}
+	queue.depth.Store(depth)
 
 	queue.nextReadFileIndex = queue.readFileIndex
 	queue.nextReadPos = queue.readFilePos
@@ -198,6 +260,20 @@ func (queue *DiskBackendQueue) retrieveMetaData() error {
 	return nil
 }
 
+// recountDepth 遍历队列，重新计算depth
+func (queue *DiskBackendQueue) recountDepth() {
+	var depth int64
+	err := queue.scanAll(func([]byte) error {
+		depth++
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("DiskQueue(%s) failed to count depth - %s", queue.name, err.Error())
+	}
+	queue.depth.Store(depth)
+	queue.depthUnknown = false
+}
+
 func (queue *DiskBackendQueue) persistMetaData() error {
 	fileName := queue.metaDataFileName()
 	tmpFileName := fmt.Sprintf("%s.%d.tmp.data", fileName, rand.Int())
@@ -208,7 +284,7 @@ func (queue *DiskBackendQueue) persistMetaData() error {
 		return err
 	}
 
-	_, err = fmt.Fprintf(f, "%d,%d\n%d,%d\n", queue.readFileIndex, queue.readFilePos, queue.writeFileIndex, queue.writeFilePos)
+	_, err = fmt.Fprintf(f, "%d\n%d,%d\n%d,%d\n", queue.depth.Load(), queue.readFileIndex, queue.readFilePos, queue.writeFileIndex, queue.writeFilePos)
 	if err != nil {
 		_ = f.Close()
 		return err
@@ -256,6 +332,13 @@ func (queue *DiskBackendQueue) ioLoop() {
 			readChan = queue.readChan
 		} else {
 			readChan = nil
+
+			// 已经读取完所有的消息，修正depth（读取错误跳过文件时depth会不准确）
+			if depth := queue.depth.Load(); depth != 0 {
+				logger.Warnf("DiskQueue(%s) is empty but depth is %d, reset it to 0", queue.name, depth)
+				queue.depth.Store(0)
+				queue.needSync = true
+			}
 		}
 
 		select {
@@ -268,6 +351,8 @@ func (queue *DiskBackendQueue) ioLoop() {
 		case <-queue.emptyChan: // 有清空请求
 			queue.emptyResponseChan <- queue.deleteAllFiles()
 			count = 0
+		case fn := <-queue.scanChan: // 有遍历请求
+			queue.scanResponseChan <- queue.scanAll(fn)
 		case <-syncTicker.C:
 			if count == 0 {
 				// 期间没有进行读写操作，跳过同步
@@ -359,6 +444,81 @@ func (queue *DiskBackendQueue) readOne() ([]byte, error) {
 	return readBuf, nil
 }
 
+// scanAll 从当前读取位置开始，遍历到当前写入位置，不改变队列的读写状态
+func (queue *DiskBackendQueue) scanAll(fn func([]byte) error) error {
+	for index := queue.readFileIndex; index <= queue.writeFileIndex; index++ {
+		var startPos, endPos int64
+		if index == queue.readFileIndex {
+			startPos = queue.readFilePos
+		}
+
+		f, err := os.OpenFile(queue.fileName(index), os.O_RDONLY, 0600)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// 文件还没有写入过数据
+				continue
+			}
+			return err
+		}
+
+		if index == queue.writeFileIndex {
+			endPos = queue.writeFilePos
+		} else {
+			stat, err := f.Stat()
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+			endPos = stat.Size()
+		}
+
+		err = queue.scanFile(f, startPos, endPos, fn)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanFile 遍历一个文件中[startPos, endPos)范围内的数据
+func (queue *DiskBackendQueue) scanFile(f *os.File, startPos, endPos int64, fn func([]byte) error) error {
+	if startPos > 0 {
+		_, err := f.Seek(startPos, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	reader := bufio.NewReader(f)
+	for pos := startPos; pos < endPos; {
+		var msgSize int32
+		err := binary.Read(reader, binary.BigEndian, &msgSize)
+		if err != nil {
+			return err
+		}
+
+		if msgSize < queue.minMsgSize || msgSize > queue.maxMsgSize {
+			return fmt.Errorf("invalid message read size (%d)", msgSize)
+		}
+
+		data := make([]byte, msgSize)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return err
+		}
+		pos += int64(4 + msgSize)
+
+		err = fn(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (queue *DiskBackendQueue) writeOne(data []byte) error {
 	var err error
 
@@ -430,6 +590,7 @@ func (queue *DiskBackendQueue) writeOne(data []byte) error {
 	}
 
 	queue.writeFilePos += totalBytes
+	queue.depth.Add(1)
 
 	return nil
 }
@@ -494,6 +655,8 @@ func (queue *DiskBackendQueue) handleReadError() {
 }
 
 func (queue *DiskBackendQueue) moveForward() {
+	queue.depth.Add(-1)
+
 	oldReadFileIndex := queue.readFileIndex
 	queue.readFileIndex = queue.nextReadFileIndex
 	queue.readFilePos = queue.nextReadPos
@@ -512,6 +675,7 @@ func (queue *DiskBackendQueue) moveForward() {
 
 func (queue *DiskBackendQueue) deleteAllFiles() error {
 	err := queue.skipToNextRWFile()
+	queue.depth.Store(0)
 
 	// 删除元数据
 	innerErr := os.Remove(queue.metaDataFileName())
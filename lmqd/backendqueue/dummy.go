@@ -1,14 +1,21 @@
 package backendqueue
 
+import "sync/atomic"
+
+// DummyBackendQueue 临时topic/channel使用的backend queue，不保存任何消息，放入的消息直接丢弃
 type DummyBackendQueue struct {
 	readChan chan []byte
+	dropped  *atomic.Uint64 // 丢弃的消息数量，为nil时不统计
 }
 
-func NewDummyBackendQueue() BackendQueue {
-	return &DummyBackendQueue{readChan: make(chan []byte)}
+func NewDummyBackendQueue(dropped *atomic.Uint64) BackendQueue {
+	return &DummyBackendQueue{readChan: make(chan []byte), dropped: dropped}
 }
 
 func (queue *DummyBackendQueue) Put(bytes []byte) error {
+	if queue.dropped != nil {
+		queue.dropped.Add(1)
+	}
 	return nil
 }
 
@@ -27,3 +34,11 @@ func (queue *DummyBackendQueue) Delete() error {
 func (queue *DummyBackendQueue) Empty() error {
 	return nil
 }
+
+func (queue *DummyBackendQueue) Scan(fn func([]byte) error) error {
+	return nil
+}
+
+func (queue *DummyBackendQueue) Depth() int64 {
+	return 0
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	topic/channel备份文件，每一行为一个json对象：
+	第一行为文件头部FileHeader，之后每一行为一条消息（包括ID、时间戳、尝试次数、头部以及数据）
+*/
+
+const FileVersion = 1
+
+var (
+	ErrFileVersionInvalid = errors.New("backup file version is not supported")
+	ErrBackupDisabled     = errors.New("backup is disabled, backup_path is not configured")
+	ErrFilePathInvalid    = errors.New("file path should be a relative path inside backup_path")
+)
+
+// ResolvePath 将请求中的文件路径解析为备份目录中的路径，只允许备份目录中的相对路径
+func ResolvePath(backupPath, filePath string) (string, error) {
+	if backupPath == "" {
+		return "", ErrBackupDisabled
+	}
+
+	cleaned := filepath.Clean(filePath)
+	if filePath == "" || filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." ||
+		strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", ErrFilePathInvalid
+	}
+
+	path := filepath.Join(backupPath, cleaned)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Renew 使用新的ID生成导入的消息，只保留头部、时间戳和尝试次数，避免与topic中已有的消息ID冲突
+func Renew(msg iface.IMessage, id iface.MessageID) iface.IMessage {
+	renewed := message.NewMessage(id, msg.GetData())
+	renewed.SetTimestamp(msg.GetTimestamp())
+	renewed.SetAttempts(msg.GetAttempts())
+	renewed.SetHeaders(msg.GetHeaders())
+
+	return renewed
+}
+
+// FileHeader 备份文件的头部
+type FileHeader struct {
+	Version     int    `json:"version"`
+	TopicName   string `json:"topic_name"`
+	ChannelName string `json:"channel_name,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+func NewFileHeader(topicName, channelName string) FileHeader {
+	return FileHeader{
+		Version:     FileVersion,
+		TopicName:   topicName,
+		ChannelName: channelName,
+		CreatedAt:   time.Now().UnixNano(),
+	}
+}
+
+// Writer 写入备份文件
+type Writer struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func NewWriter(w io.Writer, header FileHeader) (*Writer, error) {
+	writer := bufio.NewWriter(w)
+	backupWriter := &Writer{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+
+	// 写入头部
+	err := backupWriter.encoder.Encode(&header)
+	if err != nil {
+		return nil, err
+	}
+
+	return backupWriter, nil
+}
+
+// Write 写入一条消息
+func (w *Writer) Write(msg iface.IMessage) error {
+	err := w.encoder.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	w.count++
+	return nil
+}
+
+// Flush 将缓冲区中的数据写入
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
+}
+
+// Count 已经写入的消息数量
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// Reader 读取备份文件
+type Reader struct {
+	decoder *json.Decoder
+	header  FileHeader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		decoder: json.NewDecoder(bufio.NewReader(r)),
+	}
+
+	// 读取头部，并检查版本
+	err := reader.decoder.Decode(&reader.header)
+	if err != nil {
+		return nil, err
+	}
+
+	if reader.header.Version != FileVersion {
+		return nil, ErrFileVersionInvalid
+	}
+
+	return reader, nil
+}
+
+// Header 获取文件头部
+func (r *Reader) Header() FileHeader {
+	return r.header
+}
+
+// Read 读取一条消息，读取完毕时返回io.EOF
+func (r *Reader) Read() (iface.IMessage, error) {
+	msg := &message.Message{}
+	err := r.decoder.Decode(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// ExportToFile 将export遍历到的消息写入备份文件，返回写入的消息数量
+// 先写入临时文件，全部写入成功后再重命名，防止留下不完整的备份文件
+func ExportToFile(filename string, header FileHeader, export func(fn func(msg iface.IMessage) error) error) (int, error) {
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", filename, rand.Int())
+	f, err := os.OpenFile(tmpFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := NewWriter(f, header)
+	if err == nil {
+		err = export(writer.Write)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	_ = f.Close()
+
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return 0, err
+	}
+
+	return writer.Count(), os.Rename(tmpFilename, filename)
+}
+
+// ImportFromFile 读取备份文件，对每一条消息调用fn，返回文件头部以及导入的消息数量
+func ImportFromFile(filename string, fn func(msg iface.IMessage) error) (FileHeader, int, error) {
+	f, err := os.OpenFile(filename, os.O_RDONLY, 0600)
+	if err != nil {
+		return FileHeader{}, 0, err
+	}
+	defer f.Close()
+
+	reader, err := NewReader(f)
+	if err != nil {
+		return FileHeader{}, 0, err
+	}
+
+	count := 0
+	for {
+		msg, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return reader.Header(), count, err
+		}
+
+		err = fn(msg)
+		if err != nil {
+			return reader.Header(), count, err
+		}
+		count++
+	}
+
+	return reader.Header(), count, nil
+}
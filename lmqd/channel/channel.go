@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/utils"
 	"github.com/dawnzzz/lmq/lmqd/backendqueue"
 	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/lmqd/options"
 	"github.com/dawnzzz/lmq/logger"
 	"github.com/dawnzzz/lmq/pkg/e"
-	"strings"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,18 +23,34 @@ type Channel struct {
 	lmqd        iface.ILmqDaemon
 	topicName   string       // topic名称
 	name        string       // channel名称
-	isTemporary bool         // 标记是否是临时的topic
+	isTemporary bool         // 标记是否是临时的channel，临时channel只保存在内存中，不持久化
 	isExiting   atomic.Bool  // 是否退出
 	exitLock    sync.RWMutex // 发送消息与退出的互斥
 	isPausing   atomic.Bool  // 是否已经暂停
+	createdAt   atomic.Int64 // 创建时间
+
+	settings       iface.ChannelSettings // 需要持久化的配置
+	topicOverrides *iface.Overrides      // topic的overrides，由settingsLock保护
+	settingsLock   sync.RWMutex
+	options        atomic.Pointer[iface.Options] // 生效的配置
 
 	memoryMsgChan chan iface.IMessage       // 内存chan
+	putLock       sync.RWMutex              // 向内存chan放入消息与导出的互斥，导出时内存chan中的消息保持原来的顺序
 	backendQueue  backendqueue.BackendQueue // backend队列
 
+	ordered  atomic.Pointer[orderedDispatcher]  // ordered模式下按照ordering key顺序投递消息，为nil时不保证顺序
+	priority atomic.Pointer[priorityDispatcher] // 收到带有优先级的消息之后开启优先级队列，为nil时没有开启
+	dispatch atomic.Pointer[consumerDispatcher] // 按照分发策略将消息分发给客户端，为nil时客户端竞争读取
+
 	deleteCallback func(topic iface.IChannel)
 	deleter        sync.Once
+	expiryTimer    *time.Timer // 临时channel没有客户端之后，等待宽限期之后删除
 
-	clients map[uint64]iface.IConsumer
+	clients      map[uint64]iface.IConsumer
+	clientOrder  []uint64      // 客户端订阅的顺序，failover模式下第一个客户端为活跃的客户端
+	exclusive    bool          // 是否是exclusive订阅模式，由锁保护
+	failover     atomic.Bool   // 是否是failover订阅模式
+	activeClient atomic.Uint64 // failover模式下活跃的客户端ID
 
 	inFlightMessages         map[iface.MessageID]iface.IMessage // 在给客户端发送过程中的message
 	inFlightMessagesPriQueue *inFlightPriQueue                  // 在给客户端发送过程中的message，优先队列
@@ -41,53 +59,83 @@ type Channel struct {
 	messageCount atomic.Uint64 // 消息数量
 	requeueCount atomic.Uint64 // 重新入队的消息数量
 	timeoutCount atomic.Uint64 // 超时消息的数量
+
+	filteredCount atomic.Uint64 // 不满足过滤条件被跳过的消息数量
+	sampledCount  atomic.Uint64 // 没有被采样而跳过的消息数量
+	droppedCount  atomic.Uint64 // 临时channel内存队列满了之后丢弃的消息数量
+	deadCount     atomic.Uint64 // 投递到死信topic的消息数量
 }
 
-func NewChannel(lmqd iface.ILmqDaemon, topicName, name string, deleteCallback func(topic iface.IChannel)) iface.IChannel {
+func NewChannel(lmqd iface.ILmqDaemon, topicName, name string, topicOverrides *iface.Overrides, settings iface.ChannelSettings, deleteCallback func(topic iface.IChannel)) iface.IChannel {
 	channel := &Channel{
 		lmqd:      lmqd,
 		topicName: topicName,
 		name:      name,
 
-		memoryMsgChan: make(chan iface.IMessage, config.GlobalLmqdConfig.MemQueueSize),
+		settings:       settings,
+		topicOverrides: topicOverrides,
 
 		clients: map[uint64]iface.IConsumer{},
 
 		deleteCallback: deleteCallback,
 	}
+	channel.createdAt.Store(time.Now().UnixNano())
 
-	if config.GlobalLmqdConfig.MemQueueSize > 0 {
-		if strings.HasSuffix(channel.name, "#temp") {
-			// 临时队列
-			channel.isTemporary = true
-			channel.backendQueue = backendqueue.NewDummyBackendQueue()
-		}
+	// 队列按照创建时生效的配置初始化
+	channel.updateOptions()
+	opts := channel.GetOptions()
+	channel.memoryMsgChan = make(chan iface.IMessage, opts.MemQueueSize)
 
-		channel.memoryMsgChan = make(chan iface.IMessage, config.GlobalLmqdConfig.MemQueueSize)
-	}
-
-	if channel.backendQueue == nil {
-		backendQueueName := fmt.Sprintf("%s[%s]", topicName, name)
-		minMsgSize := config.GlobalLmqdConfig.MinMessageSize + iface.MsgIDLength + 8 + 2
-		maxMsgSize := config.GlobalLmqdConfig.MaxMessageSize + iface.MsgIDLength + 8 + 2
+	if utils.IsEphemeralName(channel.name) {
+		// 临时channel只使用内存队列，内存队列满了之后丢弃消息
+		channel.isTemporary = true
+		channel.backendQueue = backendqueue.NewDummyBackendQueue(&channel.droppedCount)
+	} else {
+		backendQueueName := BackendQueueName(topicName, name)
+		lmqdConfig := config.GetLmqdConfig()
+		minMsgSize := message.MinPersistLength(lmqdConfig.MinMessageSize)
+		maxMsgSize := message.MaxPersistLength(lmqdConfig.MaxMessageSize)
 		channel.backendQueue = backendqueue.NewDiskBackendQueue(backendQueueName,
-			config.GlobalLmqdConfig.DataRootPath, config.GlobalLmqdConfig.MaxBytesPerFile, minMsgSize, maxMsgSize,
-			config.GlobalLmqdConfig.SyncEvery, config.GlobalLmqdConfig.SyncTimeout,
+			lmqdConfig.DataRootPath, opts.MaxBytesPerFile, minMsgSize, maxMsgSize,
+			opts.SyncEvery, opts.SyncTimeout,
 		)
 	}
 
 	// 初始化优先队列
 	channel.initPQ()
 
+	// ordered模式、优先级队列、分发策略等配置，还没有客户端订阅，不会失败
+	_ = channel.SetSettings(settings)
+
 	go channel.queueScanWorker()
 
+	// 临时channel一直没有客户端订阅时也会被删除
+	channel.Lock()
+	channel.scheduleExpiry()
+	channel.Unlock()
+
 	channel.lmqd.Notify(channel, !channel.isTemporary)
 
 	return channel
 }
 
+// BackendQueueName channel对应的磁盘队列名称
+func BackendQueueName(topicName, name string) string {
+	return fmt.Sprintf("%s[%s]", topicName, name)
+}
+
+// BackendQueueNames channel的所有磁盘队列名称，按照投递的顺序：high lane、spill队列、channel的磁盘队列、low lane
+func BackendQueueNames(topicName, name string) []string {
+	return []string{
+		PriorityBackendQueueName(topicName, name, highPriorityLane),
+		OrderedSpillQueueName(topicName, name),
+		BackendQueueName(topicName, name),
+		PriorityBackendQueueName(topicName, name, lowPriorityLane),
+	}
+}
+
 func (channel *Channel) initPQ() {
-	priQueueSize := config.GlobalLmqdConfig.MemQueueSize / 10
+	priQueueSize := channel.GetOptions().MemQueueSize / 10
 
 	channel.inFlightMessagesLock.Lock()
 	channel.inFlightMessages = map[iface.MessageID]iface.IMessage{}
@@ -127,9 +175,31 @@ func (channel *Channel) Empty() error {
 	channel.Lock()
 	defer channel.Unlock()
 
-	channel.initPQ() // 清空优先队列
-	for _, c := range channel.clients {
-		c.Empty()
+	// 清空优先队列，客户端丢弃在这个channel中的in-flight消息
+	channel.inFlightMessagesLock.Lock()
+	inFlight := make(map[uint64]int64, len(channel.clients))
+	for _, msg := range channel.inFlightMessages {
+		inFlight[msg.GetClientID()]++
+	}
+	channel.inFlightMessagesLock.Unlock()
+	channel.initPQ()
+	for id, c := range channel.clients {
+		c.Empty(inFlight[id])
+	}
+
+	// 清空ordered模式下等待投递的消息
+	if d := channel.ordered.Load(); d != nil {
+		d.empty()
+	}
+
+	// 清空优先级队列
+	if d := channel.priority.Load(); d != nil {
+		_ = d.empty()
+	}
+
+	// 清空已经取出等待分发给客户端的消息
+	if d := channel.dispatch.Load(); d != nil {
+		d.empty()
 	}
 
 	// 清空内存队列中的数据
@@ -142,6 +212,9 @@ func (channel *Channel) Empty() error {
 	}
 
 finish:
+	// 清空的消息不会再完成，通知副本管理器
+	channel.lmqd.GetReplicationManager().Drop(channel.topicName, channel.name)
+
 	// 清空backend queue
 	return channel.backendQueue.Empty()
 }
@@ -163,16 +236,27 @@ func (channel *Channel) exit(deleted bool) error {
 		return e.ErrChannelIsExiting
 	}
 
-	// 关闭客户端
+	// 客户端不再从这个channel中接收消息
 	channel.Lock()
 	for _, c := range channel.clients {
-		_ = c.Close()
+		c.RemoveChannel(channel)
 	}
 	channel.Unlock()
 
+	// 停止分发，还没有被客户端读取的消息重新入队
+	if d := channel.dispatch.Load(); d != nil {
+		d.stop()
+	}
+
 	if deleted {
 		// 如果删除channel，则关闭之前先清空channel
 		_ = channel.Empty()
+		if d := channel.ordered.Load(); d != nil {
+			_ = d.delete()
+		}
+		if d := channel.priority.Load(); d != nil {
+			_ = d.delete()
+		}
 		// 接着删除disk queue
 		err := channel.backendQueue.Delete()
 
@@ -182,6 +266,13 @@ func (channel *Channel) exit(deleted bool) error {
 	}
 
 	// 如果只是关闭，将memory chan中的数据持久化到磁盘中
+	// ordered模式下等待投递的消息更早，先持久化
+	if d := channel.ordered.Load(); d != nil {
+		_ = d.close()
+	}
+	if d := channel.priority.Load(); d != nil {
+		_ = d.close()
+	}
 	_ = channel.persistMemoryChan()
 	return channel.backendQueue.Close()
 }
@@ -217,6 +308,142 @@ func (channel *Channel) IsExiting() bool {
 	return channel.isExiting.Load()
 }
 
+func (channel *Channel) IsEphemeral() bool {
+	return channel.isTemporary
+}
+
+func (channel *Channel) GetCreatedAt() time.Time {
+	return time.Unix(0, channel.createdAt.Load())
+}
+
+func (channel *Channel) SetCreatedAt(createdAt time.Time) {
+	channel.createdAt.Store(createdAt.UnixNano())
+}
+
+func (channel *Channel) GetSettings() iface.ChannelSettings {
+	channel.settingsLock.RLock()
+	defer channel.settingsLock.RUnlock()
+
+	return channel.settings
+}
+
+func (channel *Channel) SetSettings(settings iface.ChannelSettings) error {
+	channel.settingsLock.Lock()
+	defer channel.settingsLock.Unlock()
+
+	// 先切换订阅模式，失败时不修改其他配置
+	if err := channel.setSubscriptionMode(settings.SubscriptionMode); err != nil {
+		return err
+	}
+
+	channel.settings = settings
+	channel.updateOptions()
+
+	// 开启ordered模式，开启之后不能关闭，已经开启优先级队列的channel不能开启ordered模式
+	if settings.Ordered && channel.ordered.Load() == nil && channel.priority.Load() == nil {
+		channel.ordered.Store(newOrderedDispatcher(channel))
+		channel.wakeupClients()
+	}
+
+	// 恢复优先级队列
+	if settings.Priority && channel.priority.Load() == nil && channel.ordered.Load() == nil {
+		channel.priority.Store(newPriorityDispatcher(channel))
+		channel.wakeupClients()
+	}
+
+	channel.setDispatchPolicy(settings.DispatchPolicy)
+
+	return nil
+}
+
+// SetTopicOverrides topic的overrides变化之后更新生效的配置
+func (channel *Channel) SetTopicOverrides(overrides *iface.Overrides) {
+	channel.settingsLock.Lock()
+	defer channel.settingsLock.Unlock()
+
+	channel.topicOverrides = overrides
+	channel.updateOptions()
+}
+
+// GetOptions 获取生效的配置
+func (channel *Channel) GetOptions() *iface.Options {
+	return channel.options.Load()
+}
+
+// updateOptions 按照channel、topic的overrides重新计算生效的配置，调用时需要持有settingsLock
+func (channel *Channel) updateOptions() {
+	channel.options.Store(options.Resolve(channel.topicOverrides, channel.settings.Overrides))
+}
+
+// setDispatchPolicy 设置消息在客户端之间的分发策略，为空时停止dispatcher，由客户端竞争读取
+func (channel *Channel) setDispatchPolicy(policy string) {
+	d := channel.dispatch.Load()
+	if d != nil && policy != "" {
+		d.setPolicy(policy)
+		return
+	}
+	if d == nil && policy == "" {
+		return
+	}
+
+	channel.RLock()
+	clients := make(map[uint64]iface.IConsumer, len(channel.clients))
+	for id, c := range channel.clients {
+		clients[id] = c
+	}
+	channel.RUnlock()
+
+	if d != nil {
+		channel.dispatch.Store(nil)
+		d.stop()
+	} else {
+		d = newConsumerDispatcher(channel, policy)
+		for id, c := range clients {
+			d.addConsumer(id, c)
+		}
+		channel.dispatch.Store(d)
+	}
+
+	// 分发方式变化之后客户端需要从新的chan中读取消息
+	channel.wakeupClients()
+}
+
+// enablePriority 收到第一个带有优先级的消息时开启优先级队列，开启之后不能关闭
+func (channel *Channel) enablePriority() *priorityDispatcher {
+	channel.settingsLock.Lock()
+	defer channel.settingsLock.Unlock()
+
+	if d := channel.priority.Load(); d != nil {
+		return d
+	}
+
+	d := newPriorityDispatcher(channel)
+	channel.priority.Store(d)
+	channel.settings.Priority = true
+	channel.wakeupClients()
+
+	// 持久化元数据，重启之后恢复优先级队列
+	go func() {
+		if err := channel.lmqd.PersistMetaData(); err != nil {
+			logger.Errorf("topic(%s) channel(%s) persist metadata failed when enable priority, err: %s", channel.topicName, channel.name, err.Error())
+		}
+	}()
+
+	return d
+}
+
+// IsOrdered 是否按照ordering key顺序投递消息
+func (channel *Channel) IsOrdered() bool {
+	return channel.ordered.Load() != nil
+}
+
+// NotifyReady 客户端可以接收更多消息时通知dispatcher
+func (channel *Channel) NotifyReady() {
+	if d := channel.dispatch.Load(); d != nil {
+		d.notify()
+	}
+}
+
 func (channel *Channel) GetName() string {
 	return channel.name
 }
@@ -225,8 +452,29 @@ func (channel *Channel) GetTopicName() string {
 	return channel.topicName
 }
 
+// AcceptMessage 按照过滤条件和采样率决定是否接收消息，topic在分发消息之前调用
+func (channel *Channel) AcceptMessage(msg iface.IMessage) bool {
+	channel.settingsLock.RLock()
+	filter, sampleRate := channel.settings.Filter, channel.settings.SampleRate
+	channel.settingsLock.RUnlock()
+
+	// 不满足过滤条件
+	if !message.MatchFilter(filter, msg) {
+		channel.filteredCount.Add(1)
+		return false
+	}
+
+	// 没有被采样
+	if sampleRate > 0 && sampleRate < 100 && rand.Intn(100) >= sampleRate {
+		channel.sampledCount.Add(1)
+		return false
+	}
+
+	return true
+}
+
 // PutMessage 投递一个消息
-func (channel *Channel) PutMessage(message iface.IMessage) error {
+func (channel *Channel) PutMessage(msg iface.IMessage) error {
 	channel.exitLock.RLock()
 	defer channel.exitLock.RUnlock()
 
@@ -235,13 +483,13 @@ func (channel *Channel) PutMessage(message iface.IMessage) error {
 		return e.ErrChannelIsExiting
 	}
 
-	if message.GetDataLength() < config.GlobalLmqdConfig.MinMessageSize || message.GetDataLength() > config.GlobalLmqdConfig.MaxMessageSize {
+	if opts := channel.GetOptions(); msg.GetDataLength() < opts.MinMessageSize || msg.GetDataLength() > opts.MaxMessageSize {
 		// 消息长度不合法
 		return e.ErrMessageLengthInvalid
 	}
 
 	// 消息发送到管道中
-	err := channel.put(message)
+	err := channel.put(msg)
 	if err != nil {
 		return err
 	}
@@ -252,27 +500,92 @@ func (channel *Channel) PutMessage(message iface.IMessage) error {
 }
 
 func (channel *Channel) put(msg iface.IMessage) error {
+	// 按照优先级放入对应的队列，ordered模式下不区分优先级
+	if d := channel.priority.Load(); d != nil {
+		return d.put(msg)
+	}
+	if message.Priority(msg) != 0 && !channel.IsOrdered() {
+		return channel.enablePriority().put(msg)
+	}
+
+	return channel.putNormal(msg)
+}
+
+// putNormal 将消息放入channel的内存队列或者磁盘队列中
+func (channel *Channel) putNormal(msg iface.IMessage) error {
+	// ordered模式下磁盘队列中还有消息时，新的消息也放入磁盘队列，保证按照发布的顺序读取
+	if channel.IsOrdered() && channel.backendQueue.Depth() > 0 {
+		return channel.putBackend(msg)
+	}
+
+	channel.putLock.RLock()
 	select {
 	case channel.memoryMsgChan <- msg:
+		channel.putLock.RUnlock()
 	default:
+		channel.putLock.RUnlock()
 		// 内存chan已经满了，放入backend queue中
-		// 转为[]byte
-		data, err := message.ConvertMessageToBytes(msg)
-		if err != nil {
-			logger.Errorf("topic(%s) channel(%s) convert message to bytes err when PutMessage: %s", channel.topicName, channel.name, err.Error())
-			return err
-		}
-		// 送入backend queue
-		err = channel.backendQueue.Put(data)
-		if err != nil {
-			logger.Errorf("topic(%s) channel(%s) convert message to bytes err when put msg into backend queue: %s", channel.topicName, channel.name, err.Error())
-			return err
-		}
+		return channel.putBackend(msg)
 	}
 
 	return nil
 }
 
+// putBackend 将消息放入backend queue中
+func (channel *Channel) putBackend(msg iface.IMessage) error {
+	// 转为[]byte
+	data, err := message.ConvertMessageToBytes(msg)
+	if err != nil {
+		logger.Errorf("topic(%s) channel(%s) convert message to bytes err when PutMessage: %s", channel.topicName, channel.name, err.Error())
+		return err
+	}
+	// 送入backend queue
+	err = channel.backendQueue.Put(data)
+	if err != nil {
+		logger.Errorf("topic(%s) channel(%s) convert message to bytes err when put msg into backend queue: %s", channel.topicName, channel.name, err.Error())
+		return err
+	}
+
+	if channel.isTemporary {
+		// 临时channel的backend queue直接丢弃消息
+		channel.dropMessage(msg)
+	}
+
+	return nil
+}
+
+// dropMessage 消息被丢弃，通知副本管理器该channel不会再完成这个消息
+func (channel *Channel) dropMessage(msg iface.IMessage) {
+	channel.lmqd.GetReplicationManager().Finish(channel.topicName, channel.name, msg.GetID())
+}
+
+// requeue 将消息重新入队，ordered模式下交给dispatcher在相同key的其他消息之前重新投递
+func (channel *Channel) requeue(msg iface.IMessage) error {
+	if d := channel.ordered.Load(); d != nil {
+		return d.sendEvent(&orderedEvent{msg: msg})
+	}
+
+	return channel.put(msg)
+}
+
+// Export 按投递的顺序导出channel中还未投递的消息（分发流水线中已经取出的消息、优先级队列、内存队列+磁盘队列），
+// 不会消费这些消息，in-flight中的消息不会被导出
+func (channel *Channel) Export(fn func(msg iface.IMessage) error) error {
+	channel.exitLock.RLock()
+	defer channel.exitLock.RUnlock()
+
+	if channel.isExiting.Load() {
+		return e.ErrChannelIsExiting
+	}
+
+	// 从分发流水线的最后一个阶段开始导出
+	if d := channel.dispatch.Load(); d != nil {
+		return d.export(fn)
+	}
+
+	return channel.exportSource(fn)
+}
+
 // GetMemoryMsgChan 获取memoryMsgChan
 func (channel *Channel) GetMemoryMsgChan() chan iface.IMessage {
 	return channel.memoryMsgChan
@@ -283,7 +596,55 @@ func (channel *Channel) GetBackendQueue() backendqueue.BackendQueue {
 	return channel.backendQueue
 }
 
-// AddClient 为通道添加一个订阅的用户
+// Depth 还未投递的消息数量（内存和磁盘，还包括ordered模式下等待投递的消息、优先级队列中的消息以及等待分发给客户端的消息）
+func (channel *Channel) Depth() int64 {
+	depth := int64(len(channel.memoryMsgChan)) + channel.backendQueue.Depth()
+	if d := channel.ordered.Load(); d != nil {
+		depth += d.depth()
+	}
+	if d := channel.priority.Load(); d != nil {
+		depth += d.depth()
+	}
+	if d := channel.dispatch.Load(); d != nil {
+		depth += d.depth()
+	}
+
+	return depth
+}
+
+// InFlightCount 已经投递但还未确认的消息数量
+func (channel *Channel) InFlightCount() int {
+	channel.inFlightMessagesLock.Lock()
+	defer channel.inFlightMessagesLock.Unlock()
+
+	return len(channel.inFlightMessages)
+}
+
+// GetStats 获取channel的统计信息
+func (channel *Channel) GetStats() *iface.ChannelStats {
+	channel.RLock()
+	clientCount := len(channel.clients)
+	channel.RUnlock()
+
+	return &iface.ChannelStats{
+		Name:          channel.name,
+		Paused:        channel.IsPausing(),
+		Depth:         channel.Depth(),
+		InFlightCount: channel.InFlightCount(),
+		ClientCount:   clientCount,
+		ActiveClient:  channel.activeClient.Load(),
+		MessageCount:  channel.messageCount.Load(),
+		RequeueCount:  channel.requeueCount.Load(),
+		TimeoutCount:  channel.timeoutCount.Load(),
+		FilteredCount: channel.filteredCount.Load(),
+		SampledCount:  channel.sampledCount.Load(),
+		DroppedCount:  channel.droppedCount.Load(),
+		DeadCount:     channel.deadCount.Load(),
+		Overrides:     channel.GetSettings().Overrides,
+	}
+}
+
+// AddClient 为通道添加一个订阅的用户，exclusive模式下已经有其他用户订阅时返回错误
 func (channel *Channel) AddClient(clientID uint64, client iface.IConsumer) error {
 	channel.exitLock.RLock()
 	defer channel.exitLock.RUnlock()
@@ -292,21 +653,28 @@ func (channel *Channel) AddClient(clientID uint64, client iface.IConsumer) error
 		return e.ErrChannelIsExiting
 	}
 
-	channel.RLock()
-	_, ok := channel.clients[clientID]
-	channel.RUnlock()
-	if ok {
+	channel.Lock()
+	if _, ok := channel.clients[clientID]; ok {
+		channel.Unlock()
 		return nil
 	}
-
-	channel.Lock()
+	if channel.exclusive && len(channel.clients) > 0 {
+		channel.Unlock()
+		return e.ErrChannelIsExclusive
+	}
 	channel.clients[clientID] = client
+	channel.clientOrder = append(channel.clientOrder, clientID)
+	channel.updateActiveClient()
 	channel.Unlock()
 
+	if d := channel.dispatch.Load(); d != nil {
+		d.addConsumer(clientID, client)
+	}
+
 	return nil
 }
 
-// RemoveClient 为channel移除一个用户
+// RemoveClient 为channel移除一个用户，failover模式下移除活跃的用户时由下一个备用用户接替
 func (channel *Channel) RemoveClient(clientID uint64) {
 	channel.exitLock.RLock()
 	defer channel.exitLock.RUnlock()
@@ -315,20 +683,56 @@ func (channel *Channel) RemoveClient(clientID uint64) {
 		return
 	}
 
-	channel.RLock()
-	_, ok := channel.clients[clientID]
-	channel.RUnlock()
-	if !ok {
+	channel.Lock()
+	if _, ok := channel.clients[clientID]; !ok {
+		channel.Unlock()
 		return
 	}
-
-	channel.Lock()
 	delete(channel.clients, clientID)
+	for i, id := range channel.clientOrder {
+		if id == clientID {
+			channel.clientOrder = append(channel.clientOrder[:i], channel.clientOrder[i+1:]...)
+			break
+		}
+	}
+	activeChanged := channel.updateActiveClient()
+	if len(channel.clients) == 0 {
+		channel.scheduleExpiry()
+	}
 	channel.Unlock()
 
-	if len(channel.clients) == 0 && channel.isTemporary {
-		go channel.deleter.Do(func() { channel.deleteCallback(channel) })
+	if d := channel.dispatch.Load(); d != nil {
+		d.removeConsumer(clientID)
+	}
+
+	if activeChanged {
+		channel.wakeupActiveClient()
+	}
+}
+
+// scheduleExpiry 临时channel没有客户端时，等待宽限期之后删除，调用时需要持有锁
+func (channel *Channel) scheduleExpiry() {
+	if !channel.isTemporary {
+		return
 	}
+
+	if channel.expiryTimer != nil {
+		channel.expiryTimer.Stop()
+	}
+	channel.expiryTimer = time.AfterFunc(config.GetLmqdConfig().EphemeralGracePeriod, channel.expire)
+}
+
+// expire 宽限期结束之后仍然没有客户端，删除临时channel
+func (channel *Channel) expire() {
+	channel.RLock()
+	clientCount := len(channel.clients)
+	channel.RUnlock()
+
+	if clientCount > 0 || channel.isExiting.Load() {
+		return
+	}
+
+	channel.deleter.Do(func() { channel.deleteCallback(channel) })
 }
 
 // FinishMessage 结束消息的投递
@@ -342,6 +746,14 @@ func (channel *Channel) FinishMessage(clientID uint64, messageID iface.MessageID
 	// 将消息从inflight优先队列中删除
 	channel.removeFromInFlightPriQueue(message)
 
+	// 通知副本管理器，所有channel都完成之后副本节点会删除这个消息
+	channel.lmqd.GetReplicationManager().Finish(channel.topicName, channel.name, messageID)
+
+	// ordered模式下投递相同key的下一个消息
+	if d := channel.ordered.Load(); d != nil {
+		_ = d.sendEvent(&orderedEvent{msg: message, finished: true})
+	}
+
 	return nil
 }
 
@@ -363,7 +775,13 @@ func (channel *Channel) RequeueMessage(clientID uint64, messageID iface.MessageI
 		return e.ErrChannelIsExiting
 	}
 
-	err = channel.put(message)
+	// 尝试次数达到上限的消息投递到死信topic中
+	if channel.deadLetter(message) {
+		channel.exitLock.RUnlock()
+		return nil
+	}
+
+	err = channel.requeue(message)
 	channel.exitLock.RUnlock()
 	return err
 }
@@ -408,6 +826,7 @@ func (channel *Channel) popInFlightMessage(clientID uint64, messageID iface.Mess
 	}
 
 	if msg.GetClientID() != clientID {
+		channel.inFlightMessagesLock.Unlock()
 		return nil, e.ErrClientNotOwnTheMessage
 	}
 
@@ -431,12 +850,21 @@ func (channel *Channel) removeFromInFlightPriQueue(message iface.IMessage) {
 
 // 扫描队列，处理超时消息
 func (channel *Channel) queueScanWorker() {
-	ticker := time.NewTicker(config.GlobalLmqdConfig.ScanQueueInterval)
+	interval := config.GetLmqdConfig().ScanQueueInterval
+	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
 			// 处理 in-flight 的超时消息
 			go channel.processInFlightQueue()
+			// 检查failover模式下活跃的客户端是否心跳超时
+			go channel.checkFailover()
+		}
+
+		// 配置重新加载之后使用新的扫描间隔
+		if newInterval := config.GetLmqdConfig().ScanQueueInterval; newInterval != interval {
+			interval = newInterval
+			ticker.Reset(interval)
 		}
 
 		if channel.isExiting.Load() {
@@ -478,7 +906,12 @@ func (channel *Channel) processInFlightQueue() {
 		}
 		channel.RUnlock()
 
+		if channel.deadLetter(msg) {
+			logger.Infof("message id = %v timeout, attempts exceed, now put into dead letter topic", msg.GetID())
+			continue
+		}
+
 		logger.Infof("message id = %v timeout, now requeue", msg.GetID())
-		_ = channel.put(msg)
+		_ = channel.requeue(msg)
 	}
 }
@@ -0,0 +1,58 @@
+package channel
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+)
+
+/*
+	channel的死信配置
+	消息超时或者被客户端重新入队时，尝试次数达到MaxAttempts的消息不再重新入队，而是作为新的消息发布到死信topic中
+	投递到死信topic之后，这个消息在原来的channel中视为已经完成
+*/
+
+// ValidateDeadLetter 检查死信配置是否合法，死信topic不能是channel所在的topic
+func ValidateDeadLetter(topicName string, deadLetter *iface.DeadLetter) error {
+	if deadLetter == nil {
+		return nil
+	}
+
+	if deadLetter.MaxAttempts == 0 || deadLetter.TopicName == topicName || !utils.TopicOrChannelNameIsValid(deadLetter.TopicName) {
+		return e.ErrDeadLetterInValid
+	}
+
+	return nil
+}
+
+// deadLetter 尝试次数达到上限时将消息发布到死信topic中，返回消息是否已经投递到死信topic
+func (channel *Channel) deadLetter(msg iface.IMessage) bool {
+	deadLetter := channel.GetSettings().DeadLetter
+	if deadLetter == nil || msg.GetAttempts() < deadLetter.MaxAttempts {
+		return false
+	}
+
+	t, err := channel.lmqd.GetTopic(deadLetter.TopicName)
+	if err != nil {
+		logger.Errorf("topic(%s) channel(%s) get dead letter topic(%s) failed, err: %s", channel.topicName, channel.name, deadLetter.TopicName, err.Error())
+		return false
+	}
+
+	dead := message.NewMessage(t.GenerateGUID(), msg.GetData())
+	dead.SetHeaders(msg.GetHeaders())
+	if err = t.PutMessage(dead); err != nil {
+		logger.Errorf("topic(%s) channel(%s) put message into dead letter topic(%s) failed, err: %s", channel.topicName, channel.name, deadLetter.TopicName, err.Error())
+		return false
+	}
+	channel.deadCount.Add(1)
+
+	// 消息在这个channel中已经完成
+	channel.lmqd.GetReplicationManager().Finish(channel.topicName, channel.name, msg.GetID())
+	if d := channel.ordered.Load(); d != nil {
+		_ = d.sendEvent(&orderedEvent{msg: msg, finished: true})
+	}
+
+	return true
+}
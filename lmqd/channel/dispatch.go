@@ -0,0 +1,384 @@
+package channel
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	按照分发策略将消息分发给channel中的客户端
+	没有设置分发策略时，所有客户端在自己的message pump中竞争读取channel的队列
+	设置分发策略之后，由dispatcher作为流水线的分发阶段统一读取上一个阶段，按照策略选出一个可以接收消息的客户端（考虑客户端的RDY），
+	再放入这个客户端的chan中，客户端只从自己的chan中读取消息
+	客户端的RDY、in-flight、订阅关系变化以及客户端从chan中取走消息时都会通知dispatcher，没有可以接收消息的客户端时等待通知
+	round_robin：按照客户端ID的顺序轮流分发
+	weighted：按照订阅时指定的权重平滑加权轮询
+	least_in_flight：分发给in-flight消息最少的客户端
+*/
+
+// ValidateDispatchPolicy 检查分发策略是否合法，为空时表示不使用dispatcher
+func ValidateDispatchPolicy(policy string) error {
+	switch policy {
+	case "", iface.DispatchPolicyRoundRobin, iface.DispatchPolicyWeighted, iface.DispatchPolicyLeastInFlight:
+		return nil
+	}
+
+	return e.ErrDispatchPolicyInValid
+}
+
+// dispatchConsumer dispatcher中的一个客户端
+type dispatchConsumer struct {
+	id       uint64
+	consumer iface.IConsumer
+	msgChan  chan iface.IMessage // 分发给这个客户端的消息，容量为1
+	current  int                 // 平滑加权轮询中的当前权重
+}
+
+// ready 客户端是否还可以接收消息，failover模式下备用的客户端不接收消息
+func (c *dispatchConsumer) ready(channel *Channel) bool {
+	return channel.IsActiveClient(c.id) && len(c.msgChan) < cap(c.msgChan) && c.consumer.RecvCapacity() > int64(len(c.msgChan))
+}
+
+type consumerDispatcher struct {
+	sync.Mutex
+	stage
+
+	policy    string
+	consumers []*dispatchConsumer // 按照客户端ID排序
+	next      int                 // 轮询分发的下一个位置
+
+	updateChan chan struct{} // 客户端可以接收消息或者客户端变化的通知
+
+	pending iface.IMessage // 已经取出等待分发的消息，只在loop中访问
+	held    atomic.Int64   // pending中的消息数量
+}
+
+func newConsumerDispatcher(channel *Channel, policy string) *consumerDispatcher {
+	d := &consumerDispatcher{
+		stage:      newStage(channel, "consumer dispatcher"),
+		policy:     policy,
+		updateChan: make(chan struct{}, 1),
+	}
+	d.start(d.loop)
+
+	return d
+}
+
+func (d *consumerDispatcher) setPolicy(policy string) {
+	d.Lock()
+	d.policy = policy
+	d.Unlock()
+}
+
+// notify 通知dispatcher重新检查客户端是否可以接收消息
+func (d *consumerDispatcher) notify() {
+	select {
+	case d.updateChan <- struct{}{}:
+	default:
+	}
+}
+
+func (d *consumerDispatcher) addConsumer(clientID uint64, consumer iface.IConsumer) {
+	d.Lock()
+	for _, c := range d.consumers {
+		if c.id == clientID {
+			d.Unlock()
+			return
+		}
+	}
+	d.consumers = append(d.consumers, &dispatchConsumer{
+		id:       clientID,
+		consumer: consumer,
+		msgChan:  make(chan iface.IMessage, 1),
+	})
+	sort.Slice(d.consumers, func(i, j int) bool {
+		return d.consumers[i].id < d.consumers[j].id
+	})
+	d.Unlock()
+
+	d.notify()
+}
+
+// removeConsumer 移除客户端，已经分发给这个客户端但还没有被读取的消息重新入队
+func (d *consumerDispatcher) removeConsumer(clientID uint64) {
+	var removed *dispatchConsumer
+	d.Lock()
+	for i, c := range d.consumers {
+		if c.id == clientID {
+			removed = c
+			d.consumers = append(d.consumers[:i], d.consumers[i+1:]...)
+			break
+		}
+	}
+	d.Unlock()
+
+	if removed != nil {
+		d.requeueConsumer(removed)
+	}
+}
+
+func (d *consumerDispatcher) requeueConsumer(c *dispatchConsumer) {
+	for {
+		select {
+		case msg := <-c.msgChan:
+			_ = d.channel.requeue(msg)
+		default:
+			return
+		}
+	}
+}
+
+// getMsgChan 获取分发给客户端的消息chan，客户端不存在时返回nil
+func (d *consumerDispatcher) getMsgChan(clientID uint64) chan iface.IMessage {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, c := range d.consumers {
+		if c.id == clientID {
+			return c.msgChan
+		}
+	}
+
+	return nil
+}
+
+// hasReady 是否有可以接收消息的客户端
+func (d *consumerDispatcher) hasReady() bool {
+	if d.channel.IsPausing() {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, c := range d.consumers {
+		if c.ready(d.channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliver 按照分发策略选出一个客户端并分发消息，没有可以接收消息的客户端时返回false
+func (d *consumerDispatcher) deliver(msg iface.IMessage) bool {
+	if d.channel.IsPausing() {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	var chosen *dispatchConsumer
+	switch d.policy {
+	case iface.DispatchPolicyWeighted:
+		chosen = d.pickWeighted()
+	case iface.DispatchPolicyLeastInFlight:
+		chosen = d.pickLeastInFlight()
+	default:
+		chosen = d.pickRoundRobin()
+	}
+	if chosen == nil {
+		return false
+	}
+
+	// 只有dispatcher会向msgChan中放入消息，ready时msgChan一定有空位
+	select {
+	case chosen.msgChan <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *consumerDispatcher) pickRoundRobin() *dispatchConsumer {
+	n := len(d.consumers)
+	for i := 0; i < n; i++ {
+		c := d.consumers[(d.next+i)%n]
+		if c.ready(d.channel) {
+			d.next = (d.next + i + 1) % n
+			return c
+		}
+	}
+
+	return nil
+}
+
+// pickWeighted 平滑加权轮询，只在可以接收消息的客户端之间分配
+func (d *consumerDispatcher) pickWeighted() *dispatchConsumer {
+	var chosen *dispatchConsumer
+	total := 0
+	for _, c := range d.consumers {
+		if !c.ready(d.channel) {
+			continue
+		}
+
+		weight := c.consumer.GetWeight()
+		total += weight
+		c.current += weight
+		if chosen == nil || c.current > chosen.current {
+			chosen = c
+		}
+	}
+
+	if chosen != nil {
+		chosen.current -= total
+	}
+
+	return chosen
+}
+
+// pickLeastInFlight 选出in-flight消息最少的客户端，数量相同时轮流分发
+func (d *consumerDispatcher) pickLeastInFlight() *dispatchConsumer {
+	var chosen *dispatchConsumer
+	var least int64
+	n := len(d.consumers)
+	for i := 0; i < n; i++ {
+		c := d.consumers[(d.next+i)%n]
+		if !c.ready(d.channel) {
+			continue
+		}
+
+		inFlight := c.consumer.GetInFlightCount() + int64(len(c.msgChan))
+		if chosen == nil || inFlight < least {
+			chosen, least = c, inFlight
+			d.next = (d.next + i + 1) % n
+		}
+	}
+
+	return chosen
+}
+
+func (d *consumerDispatcher) loop() {
+	for {
+		if d.pending == nil {
+			// 有客户端可以接收消息时才从上一个阶段中读取
+			if !d.hasReady() {
+				if !d.wait() {
+					goto exit
+				}
+				continue
+			}
+
+			memoryMsgChan, backendMsgChan := d.channel.sourceChans()
+			select {
+			case msg := <-memoryMsgChan:
+				d.pending = msg
+			case data := <-backendMsgChan:
+				if d.pending = d.convert(data); d.pending == nil {
+					continue
+				}
+			case <-d.updateChan:
+				continue
+			case <-d.emptyChan:
+				d.discard()
+				continue
+			case req := <-d.exportChan:
+				req.done <- d.exportHeld(req.fn)
+				continue
+			case <-d.exitChan:
+				goto exit
+			}
+			d.held.Store(1)
+		}
+
+		if d.deliver(d.pending) {
+			d.pending = nil
+			d.held.Store(0)
+			continue
+		}
+
+		if !d.wait() {
+			goto exit
+		}
+	}
+
+exit:
+	// 还没有被客户端读取的消息重新入队
+	if d.pending != nil {
+		_ = d.channel.requeue(d.pending)
+		d.pending = nil
+		d.held.Store(0)
+	}
+
+	d.Lock()
+	consumers := d.consumers
+	d.consumers = nil
+	d.Unlock()
+	for _, c := range consumers {
+		d.requeueConsumer(c)
+	}
+}
+
+// wait 等待客户端可以接收消息的通知，退出时返回false
+func (d *consumerDispatcher) wait() bool {
+	select {
+	case <-d.updateChan:
+	case <-d.emptyChan:
+		d.discard()
+	case req := <-d.exportChan:
+		req.done <- d.exportHeld(req.fn)
+	case <-d.exitChan:
+		return false
+	}
+
+	return true
+}
+
+// discard 丢弃等待分发以及还没有被客户端读取的消息，只在loop中调用
+func (d *consumerDispatcher) discard() {
+	d.pending = nil
+	d.held.Store(0)
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, c := range d.consumers {
+		select {
+		case <-c.msgChan:
+		default:
+		}
+	}
+}
+
+// exportHeld 导出已经分发给客户端但还没有被读取的消息和等待分发的消息，之后导出上一个阶段，只在loop中调用
+func (d *consumerDispatcher) exportHeld(fn func(msg iface.IMessage) error) error {
+	var err error
+	d.Lock()
+	for _, c := range d.consumers {
+		select {
+		case msg := <-c.msgChan:
+			if err == nil {
+				err = fn(msg)
+			}
+			c.msgChan <- msg
+		default:
+		}
+	}
+	d.Unlock()
+
+	if err == nil && d.pending != nil {
+		err = fn(d.pending)
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.channel.exportSource(fn)
+}
+
+// depth 已经取出但还没有被客户端读取的消息数量
+func (d *consumerDispatcher) depth() int64 {
+	depth := d.held.Load()
+
+	d.Lock()
+	for _, c := range d.consumers {
+		depth += int64(len(c.msgChan))
+	}
+	d.Unlock()
+
+	return depth
+}
@@ -18,9 +18,6 @@ func newInFlightPriQueue(capacity int) *inFlightPriQueue {
 }
 
 func (inflight *inFlightPriQueue) Push(msg iface.IMessage) {
-	n := inflight.internal.Len()
-	msg.SetIndex(n)
-
 	heap.Push(&inflight.internal, msg)
 }
 
@@ -65,16 +62,22 @@ func (queue internalInFlightPriQueue) Len() int {
 	return len(queue)
 }
 
+// Less 超时时间最早的消息在堆顶
 func (queue internalInFlightPriQueue) Less(i, j int) bool {
-	return queue[i].GetPriority() >= queue[j].GetPriority()
+	return queue[i].GetPriority() < queue[j].GetPriority()
 }
 
+// Swap 交换时同时更新消息在堆中的下标，Remove依赖这个下标
 func (queue internalInFlightPriQueue) Swap(i, j int) {
 	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].SetIndex(i)
+	queue[j].SetIndex(j)
 }
 
 func (queue *internalInFlightPriQueue) Push(x any) {
-	*queue = append(*queue, x.(iface.IMessage))
+	msg := x.(iface.IMessage)
+	msg.SetIndex(len(*queue))
+	*queue = append(*queue, msg)
 }
 
 func (queue *internalInFlightPriQueue) Pop() any {
@@ -0,0 +1,467 @@
+package channel
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/backendqueue"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+	按照ordering key顺序投递消息
+	ordered模式下由dispatcher作为流水线的排序阶段，统一从内存队列和磁盘队列中按照发布的顺序读取消息，下一个阶段只从dispatcher中获取消息
+	相同key的消息同一时间只有一个在投递中，上一个消息FIN之后才会投递下一个消息
+	REQ或者超时的消息会在相同key的其他消息之前重新投递，没有ordering key的消息不保证顺序
+	每个key在内存中等待上一个消息FIN的消息（backlog）最多maxHeld个，超出的消息写入单独的spill队列，不会阻塞其他key的消息，
+	key在spill队列中还有消息时，这个key之后的消息也写入spill队列。backlog有空位时读取spill队列，
+	没有轮到的消息（相同key更早的消息还在spill队列中，或者backlog已满）重新写回spill队列的末尾，每个key在spill队列中消息的顺序单独记录，
+	重新写回不会改变这个顺序
+	退出时ready和pending中的消息写入spill队列，排在相同key在spill队列中的消息之前，每个key的顺序写入单独的文件，
+	启动时遍历spill队列并按照这个文件恢复顺序，没有这个文件时（例如进程崩溃）按照消息在spill队列中的位置恢复
+*/
+
+type orderedEvent struct {
+	msg      iface.IMessage
+	finished bool // 为true时表示消息已经FIN，否则表示消息需要重新投递
+}
+
+type orderedDispatcher struct {
+	stage
+
+	msgChan   chan iface.IMessage // 下一个阶段从这个chan中读取可以投递的消息
+	eventChan chan *orderedEvent  // 消息FIN、REQ、超时的通知
+
+	ready   []iface.IMessage            // 可以投递的消息
+	pending map[string][]iface.IMessage // 等待相同key的上一个消息FIN的消息
+	busy    map[string]struct{}         // 有消息正在投递的key
+	held    atomic.Int64                // ready和pending中的消息数量
+	maxHeld int64                       // ready中最多保存的消息数量，也是每个key在pending中最多保存的消息数量
+
+	spillQueue backendqueue.BackendQueue    // 保存超出backlog的消息
+	spilled    map[string][]iface.MessageID // 每个key在spill队列中的消息，按照发布的顺序
+	spilledIDs map[iface.MessageID]struct{} // 在spill队列中的消息
+	spillReady map[string]struct{}          // 在spill队列中有消息，并且backlog有空位的key
+}
+
+func newOrderedDispatcher(channel *Channel) *orderedDispatcher {
+	maxHeld := int64(channel.GetOptions().MemQueueSize)
+	if maxHeld <= 0 {
+		maxHeld = 1
+	}
+
+	d := &orderedDispatcher{
+		stage:      newStage(channel, "ordered dispatcher"),
+		msgChan:    make(chan iface.IMessage),
+		eventChan:  make(chan *orderedEvent),
+		pending:    make(map[string][]iface.IMessage),
+		busy:       make(map[string]struct{}),
+		maxHeld:    maxHeld,
+		spillQueue: channel.newStageBackendQueue(OrderedSpillQueueName(channel.topicName, channel.name)),
+		spilled:    make(map[string][]iface.MessageID),
+		spilledIDs: make(map[iface.MessageID]struct{}),
+		spillReady: make(map[string]struct{}),
+	}
+	d.restore()
+	d.start(d.loop)
+
+	return d
+}
+
+// OrderedSpillQueueName ordered模式下channel的spill队列名称
+func OrderedSpillQueueName(topicName, name string) string {
+	return BackendQueueName(topicName, name) + ".spill"
+}
+
+// orderFilename 退出时保存每个key在spill队列中消息顺序的文件
+func (d *orderedDispatcher) orderFilename() string {
+	return path.Join(config.GetLmqdConfig().DataRootPath, OrderedSpillQueueName(d.channel.topicName, d.channel.name)+".order.dat")
+}
+
+func (d *orderedDispatcher) loop() {
+	for {
+		var sendChan chan iface.IMessage
+		var next iface.IMessage
+		if len(d.ready) > 0 {
+			sendChan = d.msgChan
+			next = d.ready[0]
+		}
+
+		// 可以投递的消息没有达到上限时读取队列，等待上一个消息FIN的消息不会占用名额
+		var memoryMsgChan chan iface.IMessage
+		var backendMsgChan <-chan []byte
+		var spillMsgChan <-chan []byte
+		if int64(len(d.ready)) < d.maxHeld {
+			memoryMsgChan = d.channel.memoryMsgChan
+			if len(memoryMsgChan) == 0 {
+				// 内存队列为空时才读取磁盘队列，保证按照发布的顺序读取
+				backendMsgChan = d.channel.backendQueue.ReadChan()
+			}
+			if len(d.spillReady) > 0 {
+				// 有key的backlog出现空位时才读取spill队列
+				spillMsgChan = d.spillQueue.ReadChan()
+			}
+		}
+
+		select {
+		case sendChan <- next:
+			d.ready = d.ready[1:]
+			d.held.Add(-1)
+		case msg := <-memoryMsgChan:
+			d.dispatch(msg)
+		case data := <-backendMsgChan:
+			if msg := d.convert(data); msg != nil {
+				d.dispatch(msg)
+			}
+		case data := <-spillMsgChan:
+			if msg := d.convert(data); msg != nil {
+				d.dispatchSpilled(msg)
+			}
+		case event := <-d.eventChan:
+			if event.finished {
+				d.finish(event.msg)
+			} else {
+				d.requeue(event.msg)
+			}
+		case <-d.emptyChan:
+			d.ready = nil
+			d.pending = make(map[string][]iface.IMessage)
+			d.busy = make(map[string]struct{})
+			d.held.Store(0)
+			_ = d.spillQueue.Empty()
+			d.spilled = make(map[string][]iface.MessageID)
+			d.spilledIDs = make(map[iface.MessageID]struct{})
+			d.spillReady = make(map[string]struct{})
+			_ = os.Remove(d.orderFilename())
+		case req := <-d.exportChan:
+			req.done <- d.exportHeld(req.fn)
+		case <-d.exitChan:
+			d.persist()
+			return
+		}
+	}
+}
+
+// dispatch 处理从channel队列中读取的消息，key在spill队列中有消息或者backlog已满时写入spill队列
+func (d *orderedDispatcher) dispatch(msg iface.IMessage) {
+	key := msg.GetHeader(iface.OrderingKeyHeader)
+	if key == "" {
+		d.held.Add(1)
+		d.ready = append(d.ready, msg)
+		return
+	}
+
+	if len(d.spilled[key]) > 0 || !d.hasRoom(key) {
+		d.spill(key, msg)
+		return
+	}
+
+	d.enqueue(key, msg)
+}
+
+// dispatchSpilled 处理从spill队列中读取的消息，没有轮到或者backlog已满时重新写回spill队列的末尾
+func (d *orderedDispatcher) dispatchSpilled(msg iface.IMessage) {
+	key := msg.GetHeader(iface.OrderingKeyHeader)
+	id := msg.GetID()
+	if _, ok := d.spilledIDs[id]; !ok {
+		// 没有记录顺序的消息，当作新的消息处理
+		d.dispatch(msg)
+		return
+	}
+
+	if d.spilled[key][0] != id || !d.hasRoom(key) {
+		d.respill(key, msg)
+		return
+	}
+
+	d.unspill(key, id)
+	d.enqueue(key, msg)
+}
+
+// hasRoom key的backlog是否还有空位
+func (d *orderedDispatcher) hasRoom(key string) bool {
+	return int64(len(d.pending[key])) < d.maxHeld
+}
+
+// enqueue key没有消息在投递时可以投递，否则放入backlog等待
+func (d *orderedDispatcher) enqueue(key string, msg iface.IMessage) {
+	d.held.Add(1)
+	if _, ok := d.busy[key]; ok {
+		d.pending[key] = append(d.pending[key], msg)
+		d.updateSpillReady(key)
+		return
+	}
+
+	d.busy[key] = struct{}{}
+	d.ready = append(d.ready, msg)
+}
+
+// spill 将消息写入spill队列的末尾，临时channel直接丢弃
+func (d *orderedDispatcher) spill(key string, msg iface.IMessage) {
+	data, err := message.ConvertMessageToBytes(msg)
+	if err == nil {
+		err = d.spillQueue.Put(data)
+	}
+	if err != nil || d.channel.isTemporary {
+		d.channel.dropMessage(msg)
+		return
+	}
+
+	d.spilled[key] = append(d.spilled[key], msg.GetID())
+	d.spilledIDs[msg.GetID()] = struct{}{}
+	d.updateSpillReady(key)
+}
+
+// respill 没有轮到的消息重新写回spill队列的末尾，这个key的消息顺序不变
+func (d *orderedDispatcher) respill(key string, msg iface.IMessage) {
+	data, err := message.ConvertMessageToBytes(msg)
+	if err == nil {
+		err = d.spillQueue.Put(data)
+	}
+	if err != nil {
+		d.unspill(key, msg.GetID())
+		d.channel.dropMessage(msg)
+	}
+}
+
+// unspill 消息已经从spill队列中读出
+func (d *orderedDispatcher) unspill(key string, id iface.MessageID) {
+	delete(d.spilledIDs, id)
+
+	ids := d.spilled[key]
+	for i := range ids {
+		if ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(d.spilled, key)
+	} else {
+		d.spilled[key] = ids
+	}
+	d.updateSpillReady(key)
+}
+
+// updateSpillReady key的backlog或者spill队列变化之后，更新是否可以从spill队列中读取这个key的消息
+func (d *orderedDispatcher) updateSpillReady(key string) {
+	if len(d.spilled[key]) > 0 && d.hasRoom(key) {
+		d.spillReady[key] = struct{}{}
+	} else {
+		delete(d.spillReady, key)
+	}
+}
+
+// finish 消息FIN之后，投递相同key的下一个消息
+func (d *orderedDispatcher) finish(msg iface.IMessage) {
+	key := msg.GetHeader(iface.OrderingKeyHeader)
+	if key == "" {
+		return
+	}
+
+	queue := d.pending[key]
+	if len(queue) == 0 {
+		delete(d.busy, key)
+		return
+	}
+
+	d.ready = append(d.ready, queue[0])
+	if len(queue) == 1 {
+		delete(d.pending, key)
+	} else {
+		d.pending[key] = queue[1:]
+	}
+	d.updateSpillReady(key)
+}
+
+// requeue 重新投递的消息放在最前面，key仍然处于投递中
+func (d *orderedDispatcher) requeue(msg iface.IMessage) {
+	d.held.Add(1)
+	d.ready = append([]iface.IMessage{msg}, d.ready...)
+}
+
+// pendingKeys 按照key排序，导出和持久化时保持确定的顺序
+func (d *orderedDispatcher) pendingKeys() []string {
+	keys := make([]string, 0, len(d.pending))
+	for key := range d.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// exportHeld 按照投递的顺序导出ready、pending、spill队列中的消息，之后导出channel的队列，只在loop中调用
+func (d *orderedDispatcher) exportHeld(fn func(msg iface.IMessage) error) error {
+	for _, msg := range d.ready {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range d.pendingKeys() {
+		for _, msg := range d.pending[key] {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := d.channel.exportQueue(nil, d.spillQueue, fn); err != nil {
+		return err
+	}
+
+	return d.channel.exportQueue(d.channel.memoryMsgChan, d.channel.backendQueue, fn)
+}
+
+// persist 退出时将还没有投递的消息写入spill队列，排在相同key在spill队列中的消息之前，并保存每个key的顺序
+// 没有ordering key的消息不保证顺序，写入channel的磁盘队列
+func (d *orderedDispatcher) persist() {
+	defer func() {
+		d.ready = nil
+		d.pending = nil
+		d.held.Store(0)
+	}()
+
+	if d.channel.isTemporary {
+		return
+	}
+
+	// 每个key在ready中最多有一个消息，比pending中的消息更早
+	held := make(map[string][]iface.IMessage)
+	for _, msg := range d.ready {
+		key := msg.GetHeader(iface.OrderingKeyHeader)
+		if key == "" {
+			if data, err := message.ConvertMessageToBytes(msg); err == nil {
+				_ = d.channel.backendQueue.Put(data)
+			}
+			continue
+		}
+		held[key] = append(held[key], msg)
+	}
+	for _, key := range d.pendingKeys() {
+		held[key] = append(held[key], d.pending[key]...)
+	}
+
+	for key, msgs := range held {
+		ids := make([]iface.MessageID, 0, len(msgs)+len(d.spilled[key]))
+		for _, msg := range msgs {
+			data, err := message.ConvertMessageToBytes(msg)
+			if err == nil {
+				err = d.spillQueue.Put(data)
+			}
+			if err != nil {
+				continue
+			}
+			ids = append(ids, msg.GetID())
+		}
+		d.spilled[key] = append(ids, d.spilled[key]...)
+	}
+
+	if err := d.saveOrder(); err != nil {
+		logger.Errorf("topic(%s) channel(%s) save ordered spill order failed, err: %s", d.channel.topicName, d.channel.name, err.Error())
+	}
+}
+
+// saveOrder 将每个key在spill队列中消息的顺序写入文件，先写入临时文件再重命名
+func (d *orderedDispatcher) saveOrder() error {
+	data, err := json.Marshal(d.spilled)
+	if err != nil {
+		return err
+	}
+
+	filename := d.orderFilename()
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", filename, rand.Int())
+	if err = os.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
+
+// restore 启动时遍历spill队列，恢复每个key在spill队列中消息的顺序
+// 有上一次退出时保存的顺序时按照保存的顺序，其余的消息按照在spill队列中的位置排在后面
+func (d *orderedDispatcher) restore() {
+	if d.channel.isTemporary {
+		return
+	}
+
+	filename := d.orderFilename()
+	saved := make(map[string][]iface.MessageID)
+	if data, err := os.ReadFile(filename); err == nil {
+		if err = json.Unmarshal(data, &saved); err != nil {
+			logger.Errorf("topic(%s) channel(%s) load ordered spill order failed, err: %s", d.channel.topicName, d.channel.name, err.Error())
+		}
+	}
+
+	queued := make(map[string][]iface.MessageID)
+	err := d.spillQueue.Scan(func(data []byte) error {
+		if msg := d.convert(data); msg != nil {
+			key := msg.GetHeader(iface.OrderingKeyHeader)
+			queued[key] = append(queued[key], msg.GetID())
+			d.spilledIDs[msg.GetID()] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("topic(%s) channel(%s) scan ordered spill queue failed, err: %s", d.channel.topicName, d.channel.name, err.Error())
+	}
+
+	for key, ids := range queued {
+		restored := make([]iface.MessageID, 0, len(ids))
+		seen := make(map[iface.MessageID]struct{}, len(ids))
+		for _, id := range saved[key] {
+			if _, ok := d.spilledIDs[id]; ok {
+				restored = append(restored, id)
+				seen[id] = struct{}{}
+			}
+		}
+		for _, id := range ids {
+			if _, ok := seen[id]; !ok {
+				restored = append(restored, id)
+			}
+		}
+		d.spilled[key] = restored
+		d.updateSpillReady(key)
+	}
+
+	// 顺序只在这一次启动时使用，之后写入spill队列的消息在退出时重新保存
+	_ = os.Remove(filename)
+}
+
+// depth ready、pending以及spill队列中的消息数量
+func (d *orderedDispatcher) depth() int64 {
+	return d.held.Load() + d.spillQueue.Depth()
+}
+
+func (d *orderedDispatcher) sendEvent(event *orderedEvent) error {
+	select {
+	case d.eventChan <- event:
+		return nil
+	case <-d.doneChan:
+		return e.ErrChannelIsExiting
+	}
+}
+
+// close 停止dispatcher，还没有投递的消息写入磁盘队列，关闭spill队列
+func (d *orderedDispatcher) close() error {
+	d.stop()
+
+	return d.spillQueue.Close()
+}
+
+// delete 停止dispatcher，删除spill队列
+func (d *orderedDispatcher) delete() error {
+	d.stop()
+	_ = os.Remove(d.orderFilename())
+
+	return d.spillQueue.Delete()
+}
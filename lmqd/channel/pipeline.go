@@ -0,0 +1,198 @@
+package channel
+
+import (
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/backendqueue"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"sync"
+)
+
+/*
+	channel中消息的分发流水线：
+	channel的队列（内存队列+磁盘队列） -> 排序阶段（ordered或者优先级队列，可选） -> 分发阶段（按照分发策略分发，可选） -> 客户端
+	每个阶段由一个goroutine从上一个阶段的chan中读取消息，交给下一个阶段，没有消息或者下一个阶段不能接收消息时阻塞在select上等待通知，
+	清空和退出也由stage统一处理。客户端总是从最后一个阶段读取消息
+	导出时从最后一个阶段开始，每个阶段在自己的goroutine中导出已经取出的消息，再交给上一个阶段，
+	导出期间阶段不会再取出消息，所以导出的消息不会重复也不会遗漏，并且保持投递的顺序
+*/
+
+// exportRequest 导出请求，由阶段在自己的goroutine中处理
+type exportRequest struct {
+	fn   func(msg iface.IMessage) error
+	done chan error
+}
+
+// stage 分发流水线中的一个阶段
+type stage struct {
+	channel *Channel
+	name    string
+
+	emptyChan  chan struct{}       // 清空阶段中消息的通知
+	exportChan chan *exportRequest // 导出消息的请求
+	exitChan   chan struct{}
+	doneChan   chan struct{}
+	exitOnce   sync.Once
+}
+
+func newStage(channel *Channel, name string) stage {
+	return stage{
+		channel:    channel,
+		name:       name,
+		emptyChan:  make(chan struct{}),
+		exportChan: make(chan *exportRequest),
+		exitChan:   make(chan struct{}),
+		doneChan:   make(chan struct{}),
+	}
+}
+
+// start 启动阶段的goroutine，loop返回之后阶段结束
+func (s *stage) start(loop func()) {
+	go func() {
+		defer close(s.doneChan)
+		loop()
+	}()
+}
+
+// convert 将从磁盘队列中读取的数据转换为消息，失败时返回nil
+func (s *stage) convert(data []byte) iface.IMessage {
+	msg, err := message.ConvertBytesToMessage(data)
+	if err != nil {
+		logger.Errorf("topic(%s) channel(%s) convert bytes to message failed in %s, err:%s", s.channel.topicName, s.channel.name, s.name, err.Error())
+		return nil
+	}
+
+	return msg
+}
+
+// empty 通知阶段丢弃已经取出的消息，阶段已经结束时直接返回
+func (s *stage) empty() {
+	select {
+	case s.emptyChan <- struct{}{}:
+	case <-s.doneChan:
+	}
+}
+
+// export 请求阶段导出消息，阶段在处理请求期间不会取出新的消息
+func (s *stage) export(fn func(msg iface.IMessage) error) error {
+	req := &exportRequest{fn: fn, done: make(chan error, 1)}
+	select {
+	case s.exportChan <- req:
+	case <-s.doneChan:
+		return e.ErrChannelIsExiting
+	}
+
+	return <-req.done
+}
+
+func (s *stage) stop() {
+	s.exitOnce.Do(func() {
+		close(s.exitChan)
+	})
+	<-s.doneChan
+}
+
+// sourceChans 分发阶段的输入：开启排序阶段之后从排序阶段读取，否则直接读取channel的内存队列和磁盘队列
+func (channel *Channel) sourceChans() (chan iface.IMessage, <-chan []byte) {
+	if d := channel.ordered.Load(); d != nil {
+		return d.msgChan, nil
+	}
+
+	if d := channel.priority.Load(); d != nil {
+		return d.msgChan, nil
+	}
+
+	return channel.memoryMsgChan, channel.backendQueue.ReadChan()
+}
+
+// exportSource 导出排序阶段中的消息，以及channel队列中的消息
+func (channel *Channel) exportSource(fn func(msg iface.IMessage) error) error {
+	if d := channel.ordered.Load(); d != nil {
+		return d.export(fn)
+	}
+
+	if d := channel.priority.Load(); d != nil {
+		return d.export(fn)
+	}
+
+	return channel.exportQueue(channel.memoryMsgChan, channel.backendQueue, fn)
+}
+
+// exportQueue 按顺序导出内存队列和磁盘队列中的消息，不会消费这些消息
+func (channel *Channel) exportQueue(memoryMsgChan chan iface.IMessage, backendQueue backendqueue.BackendQueue, fn func(msg iface.IMessage) error) error {
+	// 取出内存队列中的消息，导出之后按照原来的顺序放回
+	// 导出期间不会有新的消息放入内存chan，放回时不会超出容量，也不会排在新的消息之后
+	channel.putLock.Lock()
+	var memoryMsgs []iface.IMessage
+	for i := len(memoryMsgChan); i > 0; i-- {
+		select {
+		case msg := <-memoryMsgChan:
+			memoryMsgs = append(memoryMsgs, msg)
+		default:
+		}
+	}
+
+	var err error
+	for _, msg := range memoryMsgs {
+		if err == nil {
+			err = fn(msg)
+		}
+		memoryMsgChan <- msg
+	}
+	channel.putLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 导出磁盘队列中的消息
+	return backendQueue.Scan(func(data []byte) error {
+		msg, err := message.ConvertBytesToMessage(data)
+		if err != nil {
+			return err
+		}
+
+		return fn(msg)
+	})
+}
+
+// newStageBackendQueue 创建阶段使用的磁盘队列，临时channel使用丢弃消息的队列
+func (channel *Channel) newStageBackendQueue(name string) backendqueue.BackendQueue {
+	if channel.isTemporary {
+		return backendqueue.NewDummyBackendQueue(&channel.droppedCount)
+	}
+
+	opts := channel.GetOptions()
+	lmqdConfig := config.GetLmqdConfig()
+	minMsgSize := message.MinPersistLength(lmqdConfig.MinMessageSize)
+	maxMsgSize := message.MaxPersistLength(lmqdConfig.MaxMessageSize)
+	return backendqueue.NewDiskBackendQueue(name,
+		lmqdConfig.DataRootPath, opts.MaxBytesPerFile, minMsgSize, maxMsgSize,
+		opts.SyncEvery, opts.SyncTimeout,
+	)
+}
+
+// GetClientMsgChans 客户端从流水线的最后一个阶段读取消息，设置分发策略之后只读取分发给这个客户端的消息
+func (channel *Channel) GetClientMsgChans(clientID uint64) (chan iface.IMessage, <-chan []byte) {
+	if d := channel.dispatch.Load(); d != nil {
+		return d.getMsgChan(clientID), nil
+	}
+
+	return channel.sourceChans()
+}
+
+// wakeupClients 流水线的阶段变化之后，唤醒dispatcher和客户端从新的chan中读取消息
+func (channel *Channel) wakeupClients() {
+	channel.RLock()
+	clients := make([]iface.IConsumer, 0, len(channel.clients))
+	for _, c := range channel.clients {
+		clients = append(clients, c)
+	}
+	channel.RUnlock()
+
+	for _, c := range clients {
+		c.Wakeup()
+	}
+	channel.NotifyReady()
+}
@@ -0,0 +1,369 @@
+package channel
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/backendqueue"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"sync/atomic"
+)
+
+/*
+	按照优先级投递消息
+	channel收到第一个带有优先级的消息时开启优先级队列，优先级大于0的消息进入high lane，小于0的消息进入low lane，
+	其余消息仍然使用channel原有的内存队列和磁盘队列（normal lane），每个lane都有自己的内存队列和磁盘队列
+	由dispatcher作为流水线的排序阶段，按照high、normal、low的顺序取出消息交给下一个阶段，已经取出等待投递的消息会让位于之后到达的更高优先级的消息，
+	低优先级的lane中有消息等待时，最多连续投递maxPriorityStreak个高优先级的消息，之后优先投递低优先级的消息，防止饥饿
+*/
+
+const (
+	highPriorityLane = "high"
+	lowPriorityLane  = "low"
+
+	maxPriorityStreak = 10 // 低优先级有消息等待时，最多连续投递的高优先级消息数量
+)
+
+// priorityLane 一个优先级的消息队列
+type priorityLane struct {
+	channel *Channel
+
+	memoryMsgChan chan iface.IMessage
+	backendQueue  backendqueue.BackendQueue
+
+	front iface.IMessage // 让位于更高优先级的消息，下一次最先取出，只在dispatcher中访问
+	held  atomic.Int64   // front中的消息数量
+}
+
+func newPriorityLane(channel *Channel, name string) *priorityLane {
+	return &priorityLane{
+		channel:       channel,
+		memoryMsgChan: make(chan iface.IMessage, channel.GetOptions().MemQueueSize),
+		backendQueue:  channel.newStageBackendQueue(PriorityBackendQueueName(channel.topicName, channel.name, name)),
+	}
+}
+
+// PriorityBackendQueueName channel中优先级队列对应的磁盘队列名称
+func PriorityBackendQueueName(topicName, name, lane string) string {
+	return BackendQueueName(topicName, name) + "." + lane
+}
+
+func (lane *priorityLane) put(msg iface.IMessage) error {
+	lane.channel.putLock.RLock()
+	select {
+	case lane.memoryMsgChan <- msg:
+		lane.channel.putLock.RUnlock()
+		return nil
+	default:
+		lane.channel.putLock.RUnlock()
+	}
+
+	// 内存chan已经满了，放入backend queue中
+	data, err := message.ConvertMessageToBytes(msg)
+	if err != nil {
+		return err
+	}
+
+	if err = lane.backendQueue.Put(data); err != nil {
+		return err
+	}
+	if lane.channel.isTemporary {
+		// 临时channel丢弃了消息
+		lane.channel.dropMessage(msg)
+	}
+
+	return nil
+}
+
+// tryRead 不阻塞地取出一个消息，没有消息时返回nil
+func (lane *priorityLane) tryRead(d *priorityDispatcher) iface.IMessage {
+	if msg := lane.front; msg != nil {
+		lane.front = nil
+		lane.held.Store(0)
+		return msg
+	}
+
+	select {
+	case msg := <-lane.memoryMsgChan:
+		return msg
+	default:
+	}
+
+	select {
+	case data := <-lane.backendQueue.ReadChan():
+		return d.convert(data)
+	default:
+	}
+
+	return nil
+}
+
+func (lane *priorityLane) depth() int64 {
+	return lane.held.Load() + int64(len(lane.memoryMsgChan)) + lane.backendQueue.Depth()
+}
+
+func (lane *priorityLane) empty() error {
+	for {
+		select {
+		case <-lane.memoryMsgChan:
+		default:
+			return lane.backendQueue.Empty()
+		}
+	}
+}
+
+// close 将内存队列中的消息持久化到磁盘中，并关闭磁盘队列
+func (lane *priorityLane) close() error {
+	for {
+		select {
+		case msg := <-lane.memoryMsgChan:
+			data, err := message.ConvertMessageToBytes(msg)
+			if err != nil {
+				continue
+			}
+			_ = lane.backendQueue.Put(data)
+		default:
+			return lane.backendQueue.Close()
+		}
+	}
+}
+
+type priorityDispatcher struct {
+	stage
+	lanes []*priorityLane // 按照high、normal、low的顺序
+
+	msgChan chan iface.IMessage // 下一个阶段从这个chan中读取消息
+
+	pending     iface.IMessage // 已经取出等待投递的消息
+	held        atomic.Int64   // pending中的消息数量
+	pendingLane int            // pending所在lane的下标，为-1时不会让位于更高优先级的消息
+	streak      int            // 低优先级有消息等待时，连续投递的高优先级消息数量
+}
+
+func newPriorityDispatcher(channel *Channel) *priorityDispatcher {
+	d := &priorityDispatcher{
+		stage: newStage(channel, "priority dispatcher"),
+		lanes: []*priorityLane{
+			newPriorityLane(channel, highPriorityLane),
+			{channel: channel, memoryMsgChan: channel.memoryMsgChan, backendQueue: channel.backendQueue},
+			newPriorityLane(channel, lowPriorityLane),
+		},
+		msgChan: make(chan iface.IMessage),
+	}
+	d.start(d.loop)
+
+	return d
+}
+
+// lane 根据消息的优先级获取lane，normal lane返回nil
+func (d *priorityDispatcher) lane(msg iface.IMessage) *priorityLane {
+	priority := message.Priority(msg)
+	if priority > 0 {
+		return d.lanes[0]
+	} else if priority < 0 {
+		return d.lanes[2]
+	}
+
+	return nil
+}
+
+// next 按照优先级不阻塞地取出下一个消息，返回消息所在lane的下标
+func (d *priorityDispatcher) next() (iface.IMessage, int) {
+	// 防止饥饿，从最低优先级开始取，取出的消息不会让位于更高优先级的消息
+	if d.streak >= maxPriorityStreak {
+		d.streak = 0
+		for i := len(d.lanes) - 1; i >= 0; i-- {
+			if msg := d.lanes[i].tryRead(d); msg != nil {
+				return msg, -1
+			}
+		}
+		return nil, -1
+	}
+
+	for i, lane := range d.lanes {
+		msg := lane.tryRead(d)
+		if msg == nil {
+			continue
+		}
+
+		// 更低优先级的lane中有消息在等待时累计连续投递的数量
+		lowerWaiting := false
+		for _, lower := range d.lanes[i+1:] {
+			if lower.depth() > 0 {
+				lowerWaiting = true
+				break
+			}
+		}
+		if lowerWaiting {
+			d.streak++
+		} else {
+			d.streak = 0
+		}
+		return msg, i
+	}
+
+	return nil, -1
+}
+
+func (d *priorityDispatcher) loop() {
+	high, normal, low := d.lanes[0], d.lanes[1], d.lanes[2]
+	for {
+		if d.pending == nil {
+			d.setPending(d.next())
+		}
+
+		// 已经有等待投递的消息时，只读取更高优先级的内存队列
+		var sendChan chan iface.IMessage
+		var highMsgChan, normalMsgChan, lowMsgChan chan iface.IMessage
+		var highBackendChan, normalBackendChan, lowBackendChan <-chan []byte
+		if d.pending != nil {
+			sendChan = d.msgChan
+			if d.pendingLane > 0 {
+				highMsgChan = high.memoryMsgChan
+			}
+			if d.pendingLane > 1 {
+				normalMsgChan = normal.memoryMsgChan
+			}
+		} else {
+			highMsgChan, highBackendChan = high.memoryMsgChan, high.backendQueue.ReadChan()
+			normalMsgChan, normalBackendChan = normal.memoryMsgChan, normal.backendQueue.ReadChan()
+			lowMsgChan, lowBackendChan = low.memoryMsgChan, low.backendQueue.ReadChan()
+		}
+
+		var data []byte
+		var msg iface.IMessage
+		var laneIndex int
+		select {
+		case sendChan <- d.pending:
+			d.setPending(nil, -1)
+			continue
+		case msg = <-highMsgChan:
+			laneIndex = 0
+		case msg = <-normalMsgChan:
+			laneIndex = 1
+		case msg = <-lowMsgChan:
+			laneIndex = 2
+		case data = <-highBackendChan:
+			laneIndex = 0
+		case data = <-normalBackendChan:
+			laneIndex = 1
+		case data = <-lowBackendChan:
+			laneIndex = 2
+		case <-d.emptyChan:
+			d.setPending(nil, -1)
+			for _, lane := range d.lanes {
+				lane.front = nil
+				lane.held.Store(0)
+			}
+			continue
+		case req := <-d.exportChan:
+			req.done <- d.exportHeld(req.fn)
+			continue
+		case <-d.exitChan:
+			// 等待投递的消息放回对应的lane
+			if d.pending != nil {
+				_ = d.put(d.pending)
+			}
+			for _, lane := range d.lanes {
+				if lane.front != nil {
+					_ = d.put(lane.front)
+					lane.front = nil
+					lane.held.Store(0)
+				}
+			}
+			return
+		}
+
+		if msg == nil {
+			if msg = d.convert(data); msg == nil {
+				continue
+			}
+		}
+
+		if d.pending != nil {
+			// 更高优先级的消息到达，原来等待投递的消息放回lane的最前面
+			d.lanes[d.pendingLane].front = d.pending
+			d.lanes[d.pendingLane].held.Store(1)
+		}
+		d.setPending(msg, laneIndex)
+	}
+}
+
+// setPending 设置等待投递的消息，只在loop中调用
+func (d *priorityDispatcher) setPending(msg iface.IMessage, laneIndex int) {
+	d.pending, d.pendingLane = msg, laneIndex
+	if msg != nil {
+		d.held.Store(1)
+	} else {
+		d.held.Store(0)
+	}
+}
+
+// exportHeld 按照投递的顺序导出等待投递的消息和每个lane中的消息，只在loop中调用
+func (d *priorityDispatcher) exportHeld(fn func(msg iface.IMessage) error) error {
+	if d.pending != nil {
+		if err := fn(d.pending); err != nil {
+			return err
+		}
+	}
+
+	for _, lane := range d.lanes {
+		if lane.front != nil {
+			if err := fn(lane.front); err != nil {
+				return err
+			}
+		}
+		if err := d.channel.exportQueue(lane.memoryMsgChan, lane.backendQueue, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// put 将消息放入对应优先级的lane中
+func (d *priorityDispatcher) put(msg iface.IMessage) error {
+	if lane := d.lane(msg); lane != nil {
+		return lane.put(msg)
+	}
+
+	return d.channel.putNormal(msg)
+}
+
+// depth 等待投递的消息、high lane和low lane中的消息数量，以及normal lane中让位于更高优先级的消息
+func (d *priorityDispatcher) depth() int64 {
+	return d.held.Load() + d.lanes[0].depth() + d.lanes[1].held.Load() + d.lanes[2].depth()
+}
+
+func (d *priorityDispatcher) empty() error {
+	d.stage.empty()
+
+	err := d.lanes[0].empty()
+	if lowErr := d.lanes[2].empty(); err == nil {
+		err = lowErr
+	}
+
+	return err
+}
+
+// close 停止dispatcher，关闭high lane和low lane
+func (d *priorityDispatcher) close() error {
+	d.stop()
+
+	err := d.lanes[0].close()
+	if lowErr := d.lanes[2].close(); err == nil {
+		err = lowErr
+	}
+
+	return err
+}
+
+// delete 停止dispatcher，删除high lane和low lane的磁盘队列
+func (d *priorityDispatcher) delete() error {
+	d.stop()
+
+	err := d.lanes[0].backendQueue.Delete()
+	if lowErr := d.lanes[2].backendQueue.Delete(); err == nil {
+		err = lowErr
+	}
+
+	return err
+}
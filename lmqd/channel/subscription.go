@@ -0,0 +1,139 @@
+package channel
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+)
+
+/*
+	channel的订阅模式
+	shared：所有客户端共同消费channel中的消息
+	exclusive：channel只允许一个客户端订阅，其他客户端订阅时返回错误
+	failover：允许多个客户端订阅，但只有最早订阅的客户端（活跃的客户端）接收消息，其他客户端作为备用，
+	活跃的客户端断开连接或者心跳超时之后，下一个备用客户端接替接收消息
+*/
+
+// ValidateSubscriptionMode 检查订阅模式是否合法，为空时表示shared模式
+func ValidateSubscriptionMode(mode string) error {
+	switch mode {
+	case "", iface.SubscriptionModeShared, iface.SubscriptionModeExclusive, iface.SubscriptionModeFailover:
+		return nil
+	}
+
+	return e.ErrSubscriptionModeInValid
+}
+
+// setSubscriptionMode 设置订阅模式，已经有多个客户端订阅时不能切换到exclusive模式，切换到failover模式时最早订阅的客户端成为活跃的客户端
+func (channel *Channel) setSubscriptionMode(mode string) error {
+	exclusive := mode == iface.SubscriptionModeExclusive
+	channel.Lock()
+	if exclusive && !channel.exclusive && len(channel.clients) > 1 {
+		channel.Unlock()
+		return e.ErrChannelHasConsumers
+	}
+	channel.exclusive = exclusive
+	channel.Unlock()
+
+	failover := mode == iface.SubscriptionModeFailover
+	if channel.failover.Swap(failover) == failover {
+		return nil
+	}
+
+	channel.Lock()
+	channel.updateActiveClient()
+	clients := make([]iface.IConsumer, 0, len(channel.clients))
+	for _, c := range channel.clients {
+		clients = append(clients, c)
+	}
+	channel.Unlock()
+
+	// 备用客户端需要停止或者开始接收消息
+	for _, c := range clients {
+		c.Wakeup()
+	}
+
+	return nil
+}
+
+// updateActiveClient 更新failover模式下活跃的客户端，返回活跃的客户端是否变化，调用时需要持有锁
+func (channel *Channel) updateActiveClient() bool {
+	var active uint64
+	if channel.failover.Load() && len(channel.clientOrder) > 0 {
+		active = channel.clientOrder[0]
+	}
+
+	return channel.activeClient.Swap(active) != active
+}
+
+// IsActiveClient 客户端是否可以从channel中接收消息，只有failover模式下的备用客户端返回false
+func (channel *Channel) IsActiveClient(clientID uint64) bool {
+	if !channel.failover.Load() {
+		return true
+	}
+
+	return channel.activeClient.Load() == clientID
+}
+
+// wakeupActiveClient 唤醒活跃的客户端开始接收消息
+func (channel *Channel) wakeupActiveClient() {
+	channel.RLock()
+	client, ok := channel.clients[channel.activeClient.Load()]
+	channel.RUnlock()
+
+	if ok {
+		client.Wakeup()
+	}
+	channel.NotifyReady()
+}
+
+// checkFailover failover模式下活跃的客户端心跳超时之后，将其移动到备用客户端的最后，由下一个心跳正常的备用客户端接替
+func (channel *Channel) checkFailover() {
+	if !channel.failover.Load() {
+		return
+	}
+
+	channel.Lock()
+	if len(channel.clientOrder) < 2 {
+		channel.Unlock()
+		return
+	}
+
+	activeID := channel.clientOrder[0]
+	active, ok := channel.clients[activeID]
+	if !ok || active.IsAlive() {
+		channel.Unlock()
+		return
+	}
+
+	// 由第一个心跳没有超时的备用客户端接替，没有时保持不变
+	next := 0
+	for i := 1; i < len(channel.clientOrder); i++ {
+		if c, ok := channel.clients[channel.clientOrder[i]]; ok && c.IsAlive() {
+			next = i
+			break
+		}
+	}
+	if next == 0 {
+		channel.Unlock()
+		return
+	}
+
+	// 心跳超时的客户端按照原来的顺序移动到最后
+	order := make([]uint64, 0, len(channel.clientOrder))
+	order = append(order, channel.clientOrder[next:]...)
+	channel.clientOrder = append(order, channel.clientOrder[:next]...)
+	channel.updateActiveClient()
+	channel.Unlock()
+
+	logger.Infof("topic(%s) channel(%s) active client(%d) missed heartbeats, failover to client(%d)", channel.topicName, channel.name, activeID, channel.activeClient.Load())
+
+	// 已经分发给原来活跃客户端但还没有被读取的消息重新入队
+	if d := channel.dispatch.Load(); d != nil {
+		d.removeConsumer(activeID)
+		d.addConsumer(activeID, active)
+	}
+
+	active.Wakeup()
+	channel.wakeupActiveClient()
+}
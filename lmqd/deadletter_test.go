@@ -0,0 +1,99 @@
+package lmqd
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"testing"
+	"time"
+)
+
+// restartTestLmqd 关闭lmqd，使用相同的数据目录重新创建lmqd并加载元数据
+func restartTestLmqd(t *testing.T, lmqd *LmqDaemon) *LmqDaemon {
+	t.Helper()
+
+	lmqd.Exit()
+
+	daemon, err := NewLmqDaemon()
+	if err != nil {
+		t.Fatalf("new lmqd err: %s", err)
+	}
+	restarted := daemon.(*LmqDaemon)
+	restarted.lookupManager.Start()
+	t.Cleanup(restarted.Exit)
+
+	if err = restarted.LoadMetaData(); err != nil {
+		t.Fatalf("load metadata err: %s", err)
+	}
+
+	return restarted
+}
+
+func TestDeadLetter(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test")
+	channel, _ := topic.GetChannel("ch")
+	if err := channel.SetSettings(iface.ChannelSettings{DeadLetter: &iface.DeadLetter{TopicName: "dead", MaxAttempts: 2}}); err != nil {
+		t.Fatalf("set settings err: %s", err)
+	}
+
+	msg := message.NewMessage(lmqd.GetGUIDFactory().NewMessageID(), []byte("dead letter"))
+	for attempts := 1; attempts <= 2; attempts++ {
+		// 模拟客户端接收消息之后重新入队
+		msg.AddAttempts(1)
+		if err := channel.StartInFlightTimeout(msg, 1, time.Minute); err != nil {
+			t.Fatalf("start in-flight err: %s", err)
+		}
+		if err := channel.RequeueMessage(1, msg.GetID()); err != nil {
+			t.Fatalf("requeue err: %s", err)
+		}
+
+		if attempts < 2 {
+			// 还没有达到尝试次数的上限，重新入队
+			if depth := channel.Depth(); depth != 1 {
+				t.Fatalf("depth %d after %d attempts, want 1", depth, attempts)
+			}
+			msg = <-channel.GetMemoryMsgChan()
+		}
+	}
+
+	stats := channel.GetStats()
+	if stats.Depth != 0 || stats.DeadCount != 1 {
+		t.Fatalf("depth = %d, dead = %d, want 0 and 1", stats.Depth, stats.DeadCount)
+	}
+	dead, err := lmqd.GetExistingTopic("dead")
+	if err != nil {
+		t.Fatalf("dead letter topic not created, err: %s", err)
+	}
+	if depth := dead.GetStats().Depth; depth != 1 {
+		t.Fatalf("dead letter topic depth %d, want 1", depth)
+	}
+}
+
+func TestDeadLetterPersisted(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test")
+	channel, _ := topic.GetChannel("ch")
+	if err := channel.SetSettings(iface.ChannelSettings{DeadLetter: &iface.DeadLetter{TopicName: "dead", MaxAttempts: 3}}); err != nil {
+		t.Fatalf("set settings err: %s", err)
+	}
+	if err := lmqd.PersistMetaData(); err != nil {
+		t.Fatalf("persist metadata err: %s", err)
+	}
+
+	lmqd = restartTestLmqd(t, lmqd)
+
+	topic, err := lmqd.GetExistingTopic("test")
+	if err != nil {
+		t.Fatalf("topic not restored, err: %s", err)
+	}
+	channel, err = topic.GetExistingChannel("ch")
+	if err != nil {
+		t.Fatalf("channel not restored, err: %s", err)
+	}
+	deadLetter := channel.GetSettings().DeadLetter
+	if deadLetter == nil || deadLetter.TopicName != "dead" || deadLetter.MaxAttempts != 3 {
+		t.Fatalf("dead letter not restored, got %+v", deadLetter)
+	}
+}
@@ -0,0 +1,434 @@
+package dedup
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+/*
+	dedup cache 记录topic在去重窗口内已经接受的消息的dedup key，用于丢弃生产者重试产生的重复消息
+	key按照接受的顺序保存在链表中，过期或者超过最大数量时从链表头部淘汰
+	Add之后key等待确认，消息发布成功之后Commit，发布失败之后Remove，相同key的消息在此期间等待之前的消息发布的结果
+	Commit和Remove立即追加写入journal文件，进程崩溃时不会丢失已经确认的key，journal定期fsync，
+	journal中的记录过多时重写为只包含没有过期的key的新文件，写入journal不会持有Add使用的锁
+*/
+
+const (
+	syncInterval      = time.Second
+	compactMinRecords = 1024 // journal中的记录超过这个数量，并且超过key数量的两倍时压缩
+)
+
+var ErrCacheClosed = errors.New("dedup cache is closed")
+
+type entry struct {
+	key       string
+	expireAt  int64         // 过期时间（单位纳秒）
+	committed bool          // 消息是否已经发布成功
+	done      chan struct{} // Commit或者Remove之后关闭
+}
+
+// record journal中的一条记录
+type record struct {
+	Key      string `json:"key"`
+	ExpireAt int64  `json:"expire_at,omitempty"`
+	Removed  bool   `json:"removed,omitempty"`
+}
+
+// Cache 一个topic的dedup cache
+type Cache struct {
+	sync.Mutex
+
+	name     string        // topic名称
+	dataPath string        // 数据路径
+	window   time.Duration // 去重窗口
+	maxKeys  int           // 最多保存的key数量
+
+	entries  *list.List               // 按照接受的顺序保存的key
+	keys     map[string]*list.Element // key -> 链表中的元素
+	isClosed bool
+
+	journalLock sync.Mutex
+	journal     *os.File // 追加写入的journal文件，关闭之后为nil
+	records     int      // journal中的记录数量
+	needSync    bool     // journal是否有还未fsync的记录
+
+	exitChan chan struct{}
+	doneChan chan struct{}
+}
+
+func NewCache(name string, dataPath string, window time.Duration, maxKeys int) (*Cache, error) {
+	cache := &Cache{
+		name:     name,
+		dataPath: dataPath,
+		window:   window,
+		maxKeys:  maxKeys,
+		entries:  list.New(),
+		keys:     make(map[string]*list.Element),
+		exitChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+
+	// 恢复持久化的key，之后重写journal
+	err := cache.load()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.journalLock.Lock()
+	err = cache.compact()
+	cache.journalLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	go cache.syncLoop()
+
+	return cache, nil
+}
+
+func (cache *Cache) filename() string {
+	return path.Join(cache.dataPath, fmt.Sprintf("%s.dedup.dat", cache.name))
+}
+
+// SetWindow 修改去重窗口，只影响之后接受的key
+func (cache *Cache) SetWindow(window time.Duration) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.window = window
+}
+
+// Add 记录一个key，key在去重窗口内已经发布成功时返回false
+// 相同key的消息还在发布时等待它的结果，发布失败之后重新记录这个key
+func (cache *Cache) Add(key string) (bool, error) {
+	for {
+		cache.Lock()
+		if cache.isClosed {
+			cache.Unlock()
+			return false, ErrCacheClosed
+		}
+
+		now := time.Now().UnixNano()
+		cache.evict(now)
+
+		elem, ok := cache.keys[key]
+		if !ok {
+			cache.keys[key] = cache.entries.PushBack(&entry{key: key, expireAt: now + int64(cache.window), done: make(chan struct{})})
+
+			// 超过最大数量时淘汰最早的key
+			for cache.maxKeys > 0 && cache.entries.Len() > cache.maxKeys {
+				cache.remove(cache.entries.Front())
+			}
+			cache.Unlock()
+
+			return true, nil
+		}
+
+		ent := elem.Value.(*entry)
+		committed, done := ent.committed, ent.done
+		cache.Unlock()
+		if committed {
+			return false, nil
+		}
+
+		select {
+		case <-done:
+		case <-cache.exitChan:
+			return false, ErrCacheClosed
+		}
+	}
+}
+
+// Commit 消息发布成功，确认key并写入journal
+func (cache *Cache) Commit(key string) error {
+	cache.Lock()
+	elem, ok := cache.keys[key]
+	if !ok || elem.Value.(*entry).committed {
+		// 已经被淘汰
+		cache.Unlock()
+		return nil
+	}
+	ent := elem.Value.(*entry)
+	ent.committed = true
+	close(ent.done)
+	rec := &record{Key: key, ExpireAt: ent.expireAt}
+	cache.Unlock()
+
+	return cache.appendRecord(rec)
+}
+
+// Remove 删除一个key，用于消息发布失败之后允许生产者重试
+func (cache *Cache) Remove(key string) error {
+	cache.Lock()
+	elem, ok := cache.keys[key]
+	if !ok {
+		cache.Unlock()
+		return nil
+	}
+	committed := elem.Value.(*entry).committed
+	cache.remove(elem)
+	cache.Unlock()
+
+	if !committed {
+		// 还没有写入journal
+		return nil
+	}
+
+	return cache.appendRecord(&record{Key: key, Removed: true})
+}
+
+// Len 去重窗口内key的数量
+func (cache *Cache) Len() int {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.evict(time.Now().UnixNano())
+
+	return cache.entries.Len()
+}
+
+// evict 从链表头部淘汰过期的key，去重窗口修改之后过期时间可能不是递增的，遇到没有过期的key就停止
+func (cache *Cache) evict(now int64) {
+	for elem := cache.entries.Front(); elem != nil; elem = cache.entries.Front() {
+		if elem.Value.(*entry).expireAt > now {
+			return
+		}
+		cache.remove(elem)
+	}
+}
+
+// remove 删除链表中的key，还在等待结果的消息不会再等待
+func (cache *Cache) remove(elem *list.Element) {
+	ent := elem.Value.(*entry)
+	delete(cache.keys, ent.key)
+	cache.entries.Remove(elem)
+	if !ent.committed {
+		close(ent.done)
+	}
+}
+
+func (cache *Cache) syncLoop() {
+	defer close(cache.doneChan)
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cache.journalLock.Lock()
+			_ = cache.sync()
+			if cache.records > compactMinRecords && cache.records > 2*cache.Len() {
+				_ = cache.compact()
+			}
+			cache.journalLock.Unlock()
+		case <-cache.exitChan:
+			return
+		}
+	}
+}
+
+// appendRecord 追加写入journal
+func (cache *Cache) appendRecord(rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	cache.journalLock.Lock()
+	defer cache.journalLock.Unlock()
+
+	if cache.journal == nil {
+		return ErrCacheClosed
+	}
+
+	_, err = cache.journal.Write(data)
+	if err != nil {
+		return err
+	}
+	cache.records++
+	cache.needSync = true
+
+	return nil
+}
+
+// sync fsync journal，调用时需要持有journalLock
+func (cache *Cache) sync() error {
+	if cache.journal == nil || !cache.needSync {
+		return nil
+	}
+
+	err := cache.journal.Sync()
+	if err != nil {
+		return err
+	}
+	cache.needSync = false
+
+	return nil
+}
+
+// load 从journal中恢复没有过期的key，最后一条记录不完整时忽略
+func (cache *Cache) load() error {
+	file, err := os.Open(cache.filename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		rec := &record{}
+		if err = json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			break
+		}
+
+		if elem, ok := cache.keys[rec.Key]; ok {
+			delete(cache.keys, rec.Key)
+			cache.entries.Remove(elem)
+		}
+		if !rec.Removed {
+			cache.keys[rec.Key] = cache.entries.PushBack(&entry{key: rec.Key, expireAt: rec.ExpireAt, committed: true})
+		}
+	}
+
+	cache.evict(time.Now().UnixNano())
+	for cache.maxKeys > 0 && cache.entries.Len() > cache.maxKeys {
+		cache.remove(cache.entries.Front())
+	}
+
+	return nil
+}
+
+// compact 将没有过期并且已经确认的key写入新的journal，替换原来的journal，调用时需要持有journalLock
+// 先持有journalLock再读取key，读取之后确认的key会追加写入新的journal
+func (cache *Cache) compact() error {
+	cache.Lock()
+	cache.evict(time.Now().UnixNano())
+	records := make([]*record, 0, cache.entries.Len())
+	for elem := cache.entries.Front(); elem != nil; elem = elem.Next() {
+		if ent := elem.Value.(*entry); ent.committed {
+			records = append(records, &record{Key: ent.key, ExpireAt: ent.expireAt})
+		}
+	}
+	cache.Unlock()
+
+	// 先写入临时文件，再重命名，防止写入过程中崩溃导致文件损坏
+	filename := cache.filename()
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", filename, rand.Int())
+	file, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, rec := range records {
+		if err = encoder.Encode(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	_ = file.Close()
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+
+	if err = os.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+
+	journal, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if cache.journal != nil {
+		_ = cache.journal.Close()
+	}
+	cache.journal = journal
+	cache.records = len(records)
+	cache.needSync = false
+
+	return nil
+}
+
+// Close 压缩并关闭journal
+func (cache *Cache) Close() error {
+	if !cache.exit() {
+		return ErrCacheClosed
+	}
+
+	cache.journalLock.Lock()
+	defer cache.journalLock.Unlock()
+
+	err := cache.compact()
+	if err != nil {
+		_ = cache.sync()
+	}
+	if cache.journal != nil {
+		_ = cache.journal.Close()
+		cache.journal = nil
+	}
+
+	return err
+}
+
+// Delete 关闭cache并删除journal
+func (cache *Cache) Delete() error {
+	if !cache.exit() {
+		return ErrCacheClosed
+	}
+
+	cache.journalLock.Lock()
+	defer cache.journalLock.Unlock()
+
+	if cache.journal != nil {
+		_ = cache.journal.Close()
+		cache.journal = nil
+	}
+
+	cache.Lock()
+	cache.entries.Init()
+	cache.keys = make(map[string]*list.Element)
+	cache.Unlock()
+
+	err := os.Remove(cache.filename())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (cache *Cache) exit() bool {
+	cache.Lock()
+	if cache.isClosed {
+		cache.Unlock()
+		return false
+	}
+	cache.isClosed = true
+	cache.Unlock()
+
+	close(cache.exitChan)
+	<-cache.doneChan
+
+	return true
+}
@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func mustAdd(t *testing.T, cache *Cache, key string, want bool) {
+	t.Helper()
+
+	accepted, err := cache.Add(key)
+	if err != nil {
+		t.Fatalf("add %s err: %s", key, err)
+	}
+	if accepted != want {
+		t.Fatalf("add %s accepted %v, want %v", key, accepted, want)
+	}
+}
+
+func TestCacheWindow(t *testing.T) {
+	cache, err := NewCache("window", t.TempDir(), 50*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("new cache err: %s", err)
+	}
+	defer cache.Close()
+
+	mustAdd(t, cache, "a", true)
+	_ = cache.Commit("a")
+	mustAdd(t, cache, "a", false)
+
+	// 发布失败之后允许重试
+	mustAdd(t, cache, "b", true)
+	_ = cache.Remove("b")
+	mustAdd(t, cache, "b", true)
+
+	time.Sleep(80 * time.Millisecond)
+	if n := cache.Len(); n != 0 {
+		t.Fatalf("len %d after window, want 0", n)
+	}
+	mustAdd(t, cache, "a", true)
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache, err := NewCache("eviction", t.TempDir(), time.Minute, 2)
+	if err != nil {
+		t.Fatalf("new cache err: %s", err)
+	}
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		mustAdd(t, cache, key, true)
+		_ = cache.Commit(key)
+	}
+
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("len %d, want 2", n)
+	}
+	mustAdd(t, cache, "c", false)
+	mustAdd(t, cache, "a", true)
+}
+
+// TestCacheWaitPending 相同key的消息在发布时，重复的消息等待它的结果
+func TestCacheWaitPending(t *testing.T) {
+	cache, err := NewCache("pending", t.TempDir(), time.Minute, 0)
+	if err != nil {
+		t.Fatalf("new cache err: %s", err)
+	}
+	defer cache.Close()
+
+	mustAdd(t, cache, "a", true)
+
+	result := make(chan bool)
+	go func() {
+		accepted, _ := cache.Add("a")
+		result <- accepted
+	}()
+
+	select {
+	case <-result:
+		t.Fatalf("duplicate returned before the first message finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 发布失败之后，等待的消息重新记录这个key
+	_ = cache.Remove("a")
+	if accepted := <-result; !accepted {
+		t.Fatalf("duplicate not accepted after the first message failed")
+	}
+
+	go func() {
+		accepted, _ := cache.Add("a")
+		result <- accepted
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = cache.Commit("a")
+	if accepted := <-result; accepted {
+		t.Fatalf("duplicate accepted after the first message committed")
+	}
+}
+
+func TestCacheReload(t *testing.T) {
+	dataPath := t.TempDir()
+	cache, err := NewCache("reload", dataPath, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("new cache err: %s", err)
+	}
+
+	for _, key := range []string{"a", "b", "c", "pending"} {
+		mustAdd(t, cache, key, true)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		_ = cache.Commit(key)
+	}
+	_ = cache.Remove("b")
+
+	// 模拟崩溃：不关闭cache，最后一条记录只写入了一半
+	file, err := os.OpenFile(cache.filename(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("open journal err: %s", err)
+	}
+	_, _ = file.WriteString(`{"key":"d","exp`)
+	_ = file.Close()
+
+	reloaded, err := NewCache("reload", dataPath, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("reload cache err: %s", err)
+	}
+	defer reloaded.Close()
+
+	if n := reloaded.Len(); n != 2 {
+		t.Fatalf("len %d after reload, want 2", n)
+	}
+	mustAdd(t, reloaded, "a", false)
+	mustAdd(t, reloaded, "c", false)
+	mustAdd(t, reloaded, "b", true)
+	mustAdd(t, reloaded, "pending", true)
+	mustAdd(t, reloaded, "d", true)
+}
@@ -0,0 +1,160 @@
+package lmqd
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/logger"
+	"time"
+)
+
+/*
+	下线lmqd：
+	1. 在所有的lookup中tombstone本节点的所有topic，消费者和生产者不再发现本节点
+	2. 拒绝新的发布请求，并提示可用的其他节点
+	3. 继续投递消息，直到所有topic、channel的内存和磁盘队列为空，并且没有正在投递中的消息
+	4. 报告可以停止lmqd
+	下线期间可以取消，取消之后在所有的lookup中取消tombstone，重新接受发布请求
+	其他可用的节点在drainLoop中定期刷新，拒绝发布请求时不再访问lookup
+*/
+
+const (
+	drainCheckInterval     = time.Second
+	drainTombstoneInterval = 10 * time.Second // lookup中的tombstone会过期，下线期间需要定期重新tombstone
+)
+
+// Drain 开始下线lmqd
+func (lmqd *LmqDaemon) Drain() {
+	lmqd.drainLock.Lock()
+	defer lmqd.drainLock.Unlock()
+
+	if lmqd.isDraining.Load() {
+		// 已经在下线了
+		return
+	}
+	lmqd.drainStartedAt.Store(time.Now().UnixNano())
+	lmqd.drainExitChan = make(chan struct{})
+	lmqd.drainDoneChan = make(chan struct{})
+	lmqd.isDraining.Store(true)
+	logger.Info("lmqd is draining")
+
+	go lmqd.drainLoop(lmqd.drainExitChan, lmqd.drainDoneChan)
+}
+
+// CancelDrain 取消下线，在lookup中取消tombstone，重新接受发布请求
+func (lmqd *LmqDaemon) CancelDrain() {
+	lmqd.drainLock.Lock()
+	defer lmqd.drainLock.Unlock()
+
+	if !lmqd.isDraining.Load() {
+		return
+	}
+
+	// 等待drainLoop退出，之后不会再进行tombstone
+	close(lmqd.drainExitChan)
+	<-lmqd.drainDoneChan
+
+	lmqd.lookupManager.UnTombstoneTopics(lmqd.getTopicNames())
+	lmqd.drainTargets.Store([]string{})
+	lmqd.drainStartedAt.Store(0)
+	lmqd.isDraining.Store(false)
+	logger.Info("lmqd drain is cancelled")
+}
+
+// IsDraining 是否正在下线
+func (lmqd *LmqDaemon) IsDraining() bool {
+	return lmqd.isDraining.Load()
+}
+
+// GetDrainTargets 下线期间可以接受发布请求的其他节点
+func (lmqd *LmqDaemon) GetDrainTargets() []string {
+	targets, _ := lmqd.drainTargets.Load().([]string)
+	return targets
+}
+
+// GetDrainStatus 获取下线的进度
+func (lmqd *LmqDaemon) GetDrainStatus() *iface.DrainStatus {
+	status := &iface.DrainStatus{
+		Draining:  lmqd.isDraining.Load(),
+		StartedAt: lmqd.drainStartedAt.Load(),
+	}
+
+	for _, t := range lmqd.GetTopics() {
+		status.Topics++
+		status.Depth += t.Depth()
+
+		for _, channelName := range t.GetChannelNames() {
+			c, err := t.GetExistingChannel(channelName)
+			if err != nil {
+				continue
+			}
+
+			status.Depth += c.Depth()
+			status.InFlight += int64(c.InFlightCount())
+		}
+	}
+
+	status.ReadyToStop = status.Draining && status.Depth == 0 && status.InFlight == 0
+
+	return status
+}
+
+func (lmqd *LmqDaemon) getTopicNames() []string {
+	topics := lmqd.GetTopics()
+	topicNames := make([]string, 0, len(topics))
+	for _, t := range topics {
+		topicNames = append(topicNames, t.GetName())
+	}
+
+	return topicNames
+}
+
+// refreshDrainTargets 从lookup中获取其他可用的节点
+func (lmqd *LmqDaemon) refreshDrainTargets() {
+	self, nodes, err := lmqd.lookupManager.GetLookupNodes()
+	if err != nil {
+		logger.Warnf("lmqd get nodes from lookup when draining failed, err: %s", err.Error())
+		return
+	}
+
+	others := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node != self {
+			others = append(others, node)
+		}
+	}
+	lmqd.drainTargets.Store(others)
+}
+
+func (lmqd *LmqDaemon) drainLoop(exitChan, doneChan chan struct{}) {
+	defer close(doneChan)
+
+	lmqd.lookupManager.TombstoneTopics(lmqd.getTopicNames())
+	lmqd.refreshDrainTargets()
+
+	checkTicker := time.NewTicker(drainCheckInterval)
+	defer checkTicker.Stop()
+	tombstoneTicker := time.NewTicker(drainTombstoneInterval)
+	defer tombstoneTicker.Stop()
+
+	reported := false
+	for {
+		select {
+		case <-checkTicker.C:
+			if lmqd.status.Load() == exited {
+				return
+			}
+
+			status := lmqd.GetDrainStatus()
+			if status.ReadyToStop && !reported {
+				reported = true
+				logger.Info("lmqd is drained, ready to stop")
+			} else if !status.ReadyToStop {
+				reported = false
+			}
+		case <-tombstoneTicker.C:
+			lmqd.lookupManager.TombstoneTopics(lmqd.getTopicNames())
+			lmqd.refreshDrainTargets()
+		case <-exitChan:
+			return
+		}
+	}
+}
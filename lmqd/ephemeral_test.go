@@ -0,0 +1,213 @@
+package lmqd
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConsumer 只用于订阅channel的客户端
+type testConsumer struct{}
+
+func (c *testConsumer) Pause()                               {}
+func (c *testConsumer) UnPause()                             {}
+func (c *testConsumer) Close() error                         { return nil }
+func (c *testConsumer) Empty(inFlight int64)                 {}
+func (c *testConsumer) TimeoutMessage()                      {}
+func (c *testConsumer) RemoveChannel(channel iface.IChannel) {}
+func (c *testConsumer) RecvCapacity() int64                  { return 0 }
+func (c *testConsumer) GetInFlightCount() int64              { return 0 }
+func (c *testConsumer) GetWeight() int                       { return 1 }
+func (c *testConsumer) Wakeup()                              {}
+func (c *testConsumer) IsAlive() bool                        { return true }
+
+// newTestLmqd 创建一个使用临时数据目录的lmqd，内存队列长度为memQueueSize
+func newTestLmqd(t *testing.T, memQueueSize int, gracePeriod time.Duration) *LmqDaemon {
+	t.Helper()
+
+	lmqdConfig := *config.GetLmqdConfig()
+	lmqdConfig.DataRootPath = t.TempDir()
+	lmqdConfig.MemQueueSize = memQueueSize
+	lmqdConfig.EphemeralGracePeriod = gracePeriod
+	config.SetLmqdConfig(&lmqdConfig)
+
+	daemon, err := NewLmqDaemon()
+	if err != nil {
+		t.Fatalf("new lmqd err: %s", err)
+	}
+	lmqd := daemon.(*LmqDaemon)
+	// 启动lookup manager接收新建topic/channel的通知
+	lmqd.lookupManager.Start()
+	t.Cleanup(lmqd.Exit)
+
+	return lmqd
+}
+
+func putMessages(t *testing.T, lmqd *LmqDaemon, put func(msg iface.IMessage) error, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		msg := message.NewMessage(lmqd.GetGUIDFactory().NewMessageID(), []byte("ephemeral"))
+		if err := put(msg); err != nil {
+			t.Fatalf("put message err: %s", err)
+		}
+	}
+}
+
+// waitFor 等待cond满足，超时返回false
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return cond()
+}
+
+func TestEphemeralChannelMemoryOnly(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test")
+	channel, err := topic.GetChannel("ch#tmp")
+	if err != nil {
+		t.Fatalf("get channel err: %s", err)
+	}
+	if !channel.IsEphemeral() {
+		t.Fatal("channel ch#tmp should be ephemeral")
+	}
+
+	// 内存队列满了之后的消息被丢弃
+	putMessages(t, lmqd, channel.PutMessage, 6)
+	stats := channel.GetStats()
+	if stats.Depth != 4 || stats.DroppedCount != 2 {
+		t.Fatalf("depth = %d, dropped = %d, want 4 and 2", stats.Depth, stats.DroppedCount)
+	}
+
+	// 临时channel不写磁盘
+	entries, _ := os.ReadDir(config.GetLmqdConfig().DataRootPath)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "#tmp") {
+			t.Fatalf("ephemeral channel should not write disk, found %s", entry.Name())
+		}
+	}
+}
+
+func TestEphemeralTopicMemoryOnly(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test#tmp")
+	if !topic.IsEphemeral() {
+		t.Fatal("topic test#tmp should be ephemeral")
+	}
+
+	// 没有channel时消息留在内存队列中，内存队列满了之后的消息被丢弃
+	putMessages(t, lmqd, topic.PutMessage, 7)
+	stats := topic.GetStats()
+	if stats.Depth != 4 || stats.DroppedCount != 3 {
+		t.Fatalf("depth = %d, dropped = %d, want 4 and 3", stats.Depth, stats.DroppedCount)
+	}
+}
+
+func TestEphemeralExpiry(t *testing.T) {
+	grace := 50 * time.Millisecond
+	lmqd := newTestLmqd(t, 4, grace)
+
+	topic, _ := lmqd.GetTopic("test#tmp")
+	channel, _ := topic.GetChannel("ch#tmp")
+	_ = channel.AddClient(1, &testConsumer{})
+
+	// 有客户端订阅时不删除
+	time.Sleep(3 * grace)
+	if _, err := topic.GetExistingChannel("ch#tmp"); err != nil {
+		t.Fatalf("channel with client should not expire, err: %s", err)
+	}
+
+	// 宽限期内重新订阅时不删除
+	channel.RemoveClient(1)
+	_ = channel.AddClient(2, &testConsumer{})
+	time.Sleep(3 * grace)
+	if _, err := topic.GetExistingChannel("ch#tmp"); err != nil {
+		t.Fatalf("channel resubscribed in grace period should not expire, err: %s", err)
+	}
+
+	// 最后一个客户端离开之后，channel和topic依次删除
+	channel.RemoveClient(2)
+	if !waitFor(func() bool {
+		_, err := topic.GetExistingChannel("ch#tmp")
+		return errors.Is(err, e.ErrChannelNotFound)
+	}) {
+		t.Fatal("channel should expire after last client leaves")
+	}
+	if !waitFor(func() bool {
+		_, err := lmqd.GetExistingTopic("test#tmp")
+		return errors.Is(err, e.ErrTopicNotFound)
+	}) {
+		t.Fatal("topic should expire after last channel is deleted")
+	}
+}
+
+func TestEphemeralIdleExpiry(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, 50*time.Millisecond)
+
+	// 一直没有消费者的临时topic/channel也会被删除
+	topic, _ := lmqd.GetTopic("test")
+	_, _ = topic.GetChannel("ch#tmp")
+	_, _ = lmqd.GetTopic("idle#tmp")
+
+	if !waitFor(func() bool {
+		_, err := topic.GetExistingChannel("ch#tmp")
+		return errors.Is(err, e.ErrChannelNotFound)
+	}) {
+		t.Fatal("idle ephemeral channel should expire")
+	}
+	if !waitFor(func() bool {
+		_, err := lmqd.GetExistingTopic("idle#tmp")
+		return errors.Is(err, e.ErrTopicNotFound)
+	}) {
+		t.Fatal("idle ephemeral topic should expire")
+	}
+
+	// 普通的topic不会被删除
+	if _, err := lmqd.GetExistingTopic("test"); err != nil {
+		t.Fatalf("durable topic should not expire, err: %s", err)
+	}
+}
+
+func TestEphemeralNotPersisted(t *testing.T) {
+	lmqd := newTestLmqd(t, 4, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test")
+	_, _ = topic.GetChannel("ch")
+	_, _ = topic.GetChannel("ch#tmp")
+	_, _ = lmqd.GetTopic("test#tmp")
+
+	if err := lmqd.PersistMetaData(); err != nil {
+		t.Fatalf("persist metadata err: %s", err)
+	}
+
+	data, err := os.ReadFile(lmqd.metaFilename())
+	if err != nil {
+		t.Fatalf("read metadata err: %s", err)
+	}
+	var metaData MetaData
+	if err = json.Unmarshal(data, &metaData); err != nil {
+		t.Fatalf("unmarshal metadata err: %s", err)
+	}
+
+	if len(metaData.Topics) != 1 || metaData.Topics[0].Name != "test" {
+		t.Fatalf("only durable topic should be persisted, got %s", data)
+	}
+	if channels := metaData.Topics[0].Channels; len(channels) != 1 || channels[0].Name != "ch" {
+		t.Fatalf("only durable channel should be persisted, got %s", data)
+	}
+}
@@ -1,25 +1,30 @@
 package lmqd
 
 import (
-	"errors"
+	"fmt"
 	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
 	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/dirlock"
 	"github.com/dawnzzz/lmq/internel/utils"
 	"github.com/dawnzzz/lmq/lmqd/lookup"
+	"github.com/dawnzzz/lmq/lmqd/replication"
 	"github.com/dawnzzz/lmq/lmqd/tcp"
 	"github.com/dawnzzz/lmq/lmqd/topic"
 	"github.com/dawnzzz/lmq/logger"
 	"github.com/dawnzzz/lmq/pkg/e"
+	"math/rand"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// maxNodeID 节点ID的取值范围为[0, maxNodeID)
+const maxNodeID = 1024
+
 const (
 	starting = uint32(iota)
 	running
@@ -33,12 +38,28 @@ type LmqDaemon struct {
 	clientIDLock     sync.RWMutex
 	isLoading        atomic.Bool
 
-	lookupManager iface.ILookupManager
+	lookupManager      iface.ILookupManager
+	replicationManager iface.IReplicationManager
 
 	status     atomic.Uint32           // 当前运行状态：starting、running、closing
 	topics     map[string]iface.ITopic // 保存所有的topic字典
 	topicsLock sync.RWMutex            // 控制对topic字典的互斥访问
 
+	topicWatchers        map[uint64]*topicWatcher // 监听新建topic的回调函数，用于通配符订阅
+	topicWatcherSequence uint64
+	topicWatchersLock    sync.RWMutex
+
+	isDraining     atomic.Bool  // 是否正在下线
+	drainStartedAt atomic.Int64 // 开始下线的时间
+	drainTargets   atomic.Value // 下线期间可以接受发布请求的其他节点
+	drainExitChan  chan struct{}
+	drainDoneChan  chan struct{}
+	drainLock      sync.Mutex
+
+	nodeID      atomic.Int64       // 节点ID，配置文件中没有指定时使用持久化在元数据中的节点ID
+	guidFactory iface.IGUIDFactory // message id 生成器，使用节点ID生成集群中唯一的message id
+	startTime   time.Time          // 启动时间
+
 	tcpServer *tcp.TcpServer
 
 	waitGroup utils.WaitGroupWrapper
@@ -51,17 +72,29 @@ func NewLmqDaemon() (iface.ILmqDaemon, error) {
 	lmqd := &LmqDaemon{
 		clientIDMap: make(map[serveriface.IConnection]uint64),
 
-		topics: map[string]iface.ITopic{},
+		topics:        map[string]iface.ITopic{},
+		topicWatchers: map[uint64]*topicWatcher{},
 
 		exitChan: make(chan struct{}, 1),
+
+		startTime: time.Now(),
+	}
+	if err := logger.SetLevel(config.GetLmqdConfig().LogLevel); err != nil {
+		return nil, err
+	}
+
+	// 先随机生成节点ID，加载元数据时替换为持久化的节点ID
+	if err := lmqd.initNodeID(randomNodeID()); err != nil {
+		return nil, err
 	}
 	lmqd.tcpServer = tcp.NewTcpServer(lmqd)
-	lmqd.lookupManager = lookup.NewManager(lmqd, config.GlobalLmqdConfig.LookupAddresses)
+	lmqd.lookupManager = lookup.NewManager(lmqd, config.GetLmqdConfig().LookupAddresses)
+	lmqd.replicationManager = replication.NewManager(lmqd)
 	lmqd.status.Store(starting)
-	lmqd.dirLock = dirlock.NewDirLock(config.GlobalLmqdConfig.DataRootPath)
-	if success := lmqd.dirLock.TryLock(); !success { // 尝试对文件夹上锁
+	lmqd.dirLock = dirlock.NewDirLock(config.GetLmqdConfig().DataRootPath)
+	if err := lmqd.dirLock.TryLock(); err != nil { // 尝试对文件夹上锁
 		// 如果上锁失败，则返回错误
-		return nil, errors.New("please change your DataRootPath, another lmqd is using this dir as DataRootPath")
+		return nil, fmt.Errorf("please change your DataRootPath, another lmqd is using this dir as DataRootPath: %w", err)
 	}
 
 	return lmqd, nil
@@ -70,16 +103,23 @@ func NewLmqDaemon() (iface.ILmqDaemon, error) {
 func (lmqd *LmqDaemon) Main() {
 	go lmqd.tcpServer.Start()  // 开启TCP服务器
 	lmqd.lookupManager.Start() // 开启lookup manager
+	if config.GetLmqdConfig().ReplicationFactor > 0 {
+		lmqd.replicationManager.Start() // 开启副本管理器
+	}
 	lmqd.status.Store(running)
 	logger.Info("lmqd is running")
 
-	signalChan := make(chan os.Signal)
-	signal.Notify(signalChan)
+	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
-	// 开启一个协程监听退出信号
+	// 开启一个协程监听信号
 	go func() {
-		select {
-		case <-signalChan:
+		for sig := range signalChan {
+			if sig == syscall.SIGHUP {
+				// 收到SIGHUP，重新加载配置文件
+				_, _ = lmqd.Reload()
+				continue
+			}
+
 			// 收到退出信号，关闭lmqd
 			lmqd.Exit()
 			return
@@ -103,6 +143,9 @@ func (lmqd *LmqDaemon) Exit() {
 	// 关闭tcp服务器
 	lmqd.tcpServer.Stop()
 
+	// 关闭副本管理器
+	lmqd.replicationManager.Close()
+
 	// 关闭lookup manager
 	lmqd.lookupManager.Close()
 
@@ -141,6 +184,11 @@ func (lmqd *LmqDaemon) GetTopics() []iface.ITopic {
 
 // GetTopic 根据名字获取一个topic，如果不存在则新建一个topic
 func (lmqd *LmqDaemon) GetTopic(name string) (iface.ITopic, error) {
+	return lmqd.GetTopicWithSettings(name, iface.TopicSettings{})
+}
+
+// GetTopicWithSettings 根据名字获取一个topic，如果不存在则按照settings新建一个topic，topic已经存在时settings不生效
+func (lmqd *LmqDaemon) GetTopicWithSettings(name string, settings iface.TopicSettings) (iface.ITopic, error) {
 	// 检查名字是否合法
 	if !utils.TopicOrChannelNameIsValid(name) {
 		return nil, e.ErrTopicNameInValid
@@ -167,14 +215,14 @@ func (lmqd *LmqDaemon) GetTopic(name string) (iface.ITopic, error) {
 	deleteCallback := func(t iface.ITopic) {
 		_ = lmqd.DeleteExistingTopic(t.GetName())
 	}
-	t := topic.NewTopic(lmqd, name, deleteCallback)
+	t := topic.NewTopic(lmqd, name, settings, deleteCallback)
 	lmqd.topics[name] = t
 
 	// 在lmq look查询channels
 	lmqd.waitGroup.Wrap(func() {
 		channelNames := lmqd.lookupManager.GetLookupTopicChannels(name)
 		for _, channelName := range channelNames {
-			if strings.HasSuffix(channelName, "#tmp") {
+			if utils.IsEphemeralName(channelName) {
 				// 在没有消费者时不创建临时channel
 				continue
 			}
@@ -188,9 +236,66 @@ func (lmqd *LmqDaemon) GetTopic(name string) (iface.ITopic, error) {
 
 	t.Start()
 
+	// 通知监听新建topic的订阅者
+	lmqd.waitGroup.Wrap(func() {
+		lmqd.notifyTopicWatchers(t)
+	})
+
 	return t, nil
 }
 
+// topicWatcher 监听新建topic的回调函数，回调执行期间持有读锁，取消监听时等待正在执行的回调结束
+type topicWatcher struct {
+	sync.RWMutex
+	fn      func(topic iface.ITopic)
+	removed bool
+}
+
+// WatchTopics 监听新建的topic，返回监听的ID
+func (lmqd *LmqDaemon) WatchTopics(fn func(topic iface.ITopic)) uint64 {
+	lmqd.topicWatchersLock.Lock()
+	defer lmqd.topicWatchersLock.Unlock()
+
+	lmqd.topicWatcherSequence++
+	lmqd.topicWatchers[lmqd.topicWatcherSequence] = &topicWatcher{fn: fn}
+
+	return lmqd.topicWatcherSequence
+}
+
+// UnWatchTopics 取消监听新建的topic，返回之后回调函数不会再被调用
+func (lmqd *LmqDaemon) UnWatchTopics(id uint64) {
+	lmqd.topicWatchersLock.Lock()
+	watcher, ok := lmqd.topicWatchers[id]
+	delete(lmqd.topicWatchers, id)
+	lmqd.topicWatchersLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// 等待正在执行的回调结束
+	watcher.Lock()
+	watcher.removed = true
+	watcher.Unlock()
+}
+
+func (lmqd *LmqDaemon) notifyTopicWatchers(t iface.ITopic) {
+	lmqd.topicWatchersLock.RLock()
+	watchers := make([]*topicWatcher, 0, len(lmqd.topicWatchers))
+	for _, watcher := range lmqd.topicWatchers {
+		watchers = append(watchers, watcher)
+	}
+	lmqd.topicWatchersLock.RUnlock()
+
+	for _, watcher := range watchers {
+		watcher.RLock()
+		if !watcher.removed {
+			watcher.fn(t)
+		}
+		watcher.RUnlock()
+	}
+}
+
 // GetExistingTopic 获取一个已经存在的topic
 func (lmqd *LmqDaemon) GetExistingTopic(topicName string) (iface.ITopic, error) {
 	lmqd.topicsLock.RLock()
@@ -243,6 +348,57 @@ func (lmqd *LmqDaemon) GenerateClientID(conn serveriface.IConnection) uint64 {
 	return lmqd.clientIDSequence
 }
 
+// GetLookupManager 获取lookup manager
+func (lmqd *LmqDaemon) GetLookupManager() iface.ILookupManager {
+	return lmqd.lookupManager
+}
+
+// GetReplicationManager 获取副本管理器
+func (lmqd *LmqDaemon) GetReplicationManager() iface.IReplicationManager {
+	return lmqd.replicationManager
+}
+
+// GetNodeID 获取节点ID
+func (lmqd *LmqDaemon) GetNodeID() int64 {
+	return lmqd.nodeID.Load()
+}
+
+// GetStartTime 获取启动时间
+func (lmqd *LmqDaemon) GetStartTime() time.Time {
+	return lmqd.startTime
+}
+
+// GetGUIDFactory 获取message id生成器
+func (lmqd *LmqDaemon) GetGUIDFactory() iface.IGUIDFactory {
+	return lmqd.guidFactory
+}
+
+// initNodeID 初始化节点ID以及message id生成器，优先使用配置文件中的节点ID，其次使用持久化的节点ID
+func (lmqd *LmqDaemon) initNodeID(persisted int64) error {
+	nodeID := persisted
+	if configured := config.GetLmqdConfig().NodeID; configured >= 0 {
+		nodeID = configured
+	}
+	if nodeID < 0 || nodeID >= maxNodeID {
+		return fmt.Errorf("lmqd node id %d is invalid, it must be in [0, %d)", nodeID, maxNodeID)
+	}
+
+	guidFactory, err := topic.NewGUIDFactory(nodeID)
+	if err != nil {
+		return err
+	}
+
+	lmqd.nodeID.Store(nodeID)
+	lmqd.guidFactory = guidFactory
+
+	return nil
+}
+
+// randomNodeID 随机生成一个节点ID
+func randomNodeID() int64 {
+	return rand.Int63n(maxNodeID)
+}
+
 // Notify 通知lmqd进行持久化，通知lookup
 func (lmqd *LmqDaemon) Notify(v interface{}, persist bool) {
 	isLoading := lmqd.isLoading.Load()
@@ -1,14 +1,21 @@
 package lookup
 
 import (
+	"errors"
+	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/logger"
+	"net"
+	"strconv"
+	"sync"
 	"sync/atomic"
 )
 
 type Manager struct {
 	lmqd        iface.ILmqDaemon
-	lookupPeers []*lookupPeer
+	lookupPeers []*lookupPeer // 更新lookup地址时整体替换，不在原来的切片上修改
+	peersLock   sync.RWMutex
 	notifyChan  chan interface{} // 用于通知lookup本节点的topic或者channel更新了
 	isExiting   atomic.Bool
 	exitChan    chan struct{}
@@ -33,7 +40,7 @@ func NewManager(lmqd iface.ILmqDaemon, lookupAddresses []string) iface.ILookupMa
 }
 
 func (m *Manager) Start() {
-	for _, peer := range m.lookupPeers {
+	for _, peer := range m.getLookupPeers() {
 		if peer != nil {
 			peer.start()
 		}
@@ -50,6 +57,8 @@ func (m *Manager) Close() {
 	// 等待所有请求发送完成
 	m.Wait()
 	// 关闭所有lookup peer
+	m.peersLock.Lock()
+	defer m.peersLock.Unlock()
 	for _, peer := range m.lookupPeers {
 		if peer != nil {
 			peer.close()
@@ -61,6 +70,56 @@ func (m *Manager) GetNotifyChan() chan interface{} {
 	return m.notifyChan
 }
 
+// getLookupPeers 获取所有的lookup peer
+func (m *Manager) getLookupPeers() []*lookupPeer {
+	m.peersLock.RLock()
+	defer m.peersLock.RUnlock()
+
+	return m.lookupPeers
+}
+
+// UpdateLookupAddresses 更新lmq lookup的地址，连接新增的lookup并注册本节点的topic和channel，断开已经删除的lookup
+func (m *Manager) UpdateLookupAddresses(addresses []string) {
+	m.peersLock.Lock()
+	defer m.peersLock.Unlock()
+
+	if m.isExiting.Load() {
+		return
+	}
+
+	existing := make(map[string]*lookupPeer, len(m.lookupPeers))
+	for _, peer := range m.lookupPeers {
+		if peer != nil {
+			existing[peer.address] = peer
+		}
+	}
+
+	lookupPeers := make([]*lookupPeer, 0, len(addresses))
+	for _, address := range utils.Uniq(addresses) {
+		if peer, ok := existing[address]; ok {
+			delete(existing, address)
+			lookupPeers = append(lookupPeers, peer)
+			continue
+		}
+
+		peer, err := newLookupPeer(m.lmqd, address)
+		if err != nil {
+			logger.Errorf("lmq lookup address(%s) is invalid, err: %s", address, err.Error())
+			continue
+		}
+		logger.Infof("add lmq lookup(%s)", address)
+		peer.startAndRegister()
+		lookupPeers = append(lookupPeers, peer)
+	}
+
+	for address, peer := range existing {
+		logger.Infof("remove lmq lookup(%s)", address)
+		peer.close()
+	}
+
+	m.lookupPeers = lookupPeers
+}
+
 func (m *Manager) lookupLoop() {
 	for {
 		if m.isExiting.Load() {
@@ -98,7 +157,8 @@ func (m *Manager) lookupLoop() {
 				}
 			}
 
-			for _, peer := range m.lookupPeers {
+			for _, peer := range m.getLookupPeers() {
+				peer := peer
 				m.Wrap(func() {
 					if peer != nil {
 						_ = peer.sendRegistration(unRegister, topicName, channelName)
@@ -111,9 +171,41 @@ func (m *Manager) lookupLoop() {
 exit:
 }
 
+// TombstoneTopics 在所有的lookup中tombstone本节点的topic
+func (m *Manager) TombstoneTopics(topicNames []string) {
+	for _, peer := range m.getLookupPeers() {
+		if peer == nil {
+			continue
+		}
+
+		for _, topicName := range topicNames {
+			if err := peer.sendTombstone(false, topicName); err != nil {
+				logger.Errorf("tombstone topic(%s) in lmq lookup(%s:%d) failed, err: %s", topicName, peer.host, peer.port, err.Error())
+				break
+			}
+		}
+	}
+}
+
+// UnTombstoneTopics 在所有的lookup中取消本节点topic的tombstone
+func (m *Manager) UnTombstoneTopics(topicNames []string) {
+	for _, peer := range m.getLookupPeers() {
+		if peer == nil {
+			continue
+		}
+
+		for _, topicName := range topicNames {
+			if err := peer.sendTombstone(true, topicName); err != nil {
+				logger.Errorf("untombstone topic(%s) in lmq lookup(%s:%d) failed, err: %s", topicName, peer.host, peer.port, err.Error())
+				break
+			}
+		}
+	}
+}
+
 func (m *Manager) GetLookupTopicChannels(topicName string) []string {
 	channels := make([]string, 0, 10)
-	for _, peer := range m.lookupPeers {
+	for _, peer := range m.getLookupPeers() {
 		if peer != nil {
 			c := peer.getTopicChannels(topicName)
 			channels = append(channels, c...)
@@ -124,3 +216,41 @@ func (m *Manager) GetLookupTopicChannels(topicName string) []string {
 	channels = utils.Uniq(channels)
 	return channels
 }
+
+// GetLookupNodes 获取所有lookup中存活的lmqd节点地址（host:port），以及本节点的地址
+func (m *Manager) GetLookupNodes() (string, []string, error) {
+	var self string
+	var nodes []string
+	var err error
+	ok := false
+	for _, peer := range m.getLookupPeers() {
+		if peer == nil {
+			continue
+		}
+
+		peerNodes, peerErr := peer.getNodes()
+		if peerErr != nil {
+			err = peerErr
+			continue
+		}
+		ok = true
+
+		if localHost := peer.getLocalHost(); self == "" && localHost != "" {
+			self = net.JoinHostPort(localHost, strconv.Itoa(config.GetLmqdConfig().TcpPort))
+		}
+		for _, node := range peerNodes {
+			nodes = append(nodes, net.JoinHostPort(node.Hostname, strconv.Itoa(node.TCPPort)))
+		}
+	}
+
+	if !ok {
+		if err == nil {
+			err = errors.New("no lmq lookup is available")
+		}
+		return "", nil, err
+	}
+
+	// 去重
+	nodes = utils.Uniq(nodes)
+	return self, nodes, nil
+}
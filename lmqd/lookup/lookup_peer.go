@@ -8,8 +8,10 @@ import (
 	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/version"
 	"github.com/dawnzzz/lmq/logger"
 	"net"
+	"os"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -33,6 +35,7 @@ var (
 
 type lookupPeer struct {
 	lmqd         iface.ILmqDaemon
+	address      string              // 配置中lmq lookup的地址（host:port）
 	host         string              // lmq lookup的地址
 	port         int                 // lmq lookup的端口
 	client       serveriface.IClient // 记录与lmq lookup连接的客户端
@@ -42,6 +45,9 @@ type lookupPeer struct {
 
 	channelsRequestChan  chan *channelsReq // sendTopicChannels的响应信息
 	channelsResponseChan chan *channelsReq // sendTopicChannels的返回信息
+	nodesRequestChan     chan *nodesReq    // sendNodes的响应信息
+	nodesResponseChan    chan *nodesReq    // sendNodes的返回信息
+	localHost            atomic.Value      // 本节点在lookup中的hostname（string），连接时写入，其他协程并发读取
 	reconnectChan        chan struct{}     // 当这个chan中有一个消息时，表示需要与lookup进行连接了
 	isClosing            atomic.Bool
 	exitChan             chan struct{}
@@ -80,6 +86,49 @@ func (h *channelsRecvHandler) Handle(request serveriface.IRequest) {
 	}
 }
 
+type nodesReq struct {
+	sendAt time.Time        // 请求发送时间
+	data   []*protocol.Node // 返回的数据
+}
+
+type nodesRecvHandler struct {
+	hamble.BaseHandler
+	peer *lookupPeer
+}
+
+func (h *nodesRecvHandler) Handle(request serveriface.IRequest) {
+	// 收到了nodes消息
+	nodesRequestChan := h.peer.nodesRequestChan
+	nodesResponseChan := h.peer.nodesResponseChan
+	select {
+	case req := <-nodesRequestChan:
+		// 反序列化消息
+		resp := protocol.ResponseBody{}
+		err := json.Unmarshal(request.GetData(), &resp)
+		if err == nil {
+			req.data = resp.Nodes
+		}
+		nodesResponseChan <- req
+	case <-h.peer.exitChan:
+	default:
+	}
+}
+
+// identityRecvHandler 处理identify的响应，lookup拒绝时记录错误
+type identityRecvHandler struct {
+	hamble.BaseHandler
+	peer *lookupPeer
+}
+
+func (h *identityRecvHandler) Handle(request serveriface.IRequest) {
+	resp := protocol.ResponseBody{}
+	if err := json.Unmarshal(request.GetData(), &resp); err != nil || !resp.IsError {
+		return
+	}
+
+	logger.Errorf("lookup(%s:%d) rejects identify of lmqd, err: %s", h.peer.host, h.peer.port, resp.StatusMsg)
+}
+
 func newLookupPeer(lmqd iface.ILmqDaemon, lookupAddress string) (*lookupPeer, error) {
 	host, portStr, err := net.SplitHostPort(lookupAddress)
 	if err != nil {
@@ -89,13 +138,16 @@ func newLookupPeer(lmqd iface.ILmqDaemon, lookupAddress string) (*lookupPeer, er
 
 	peer := &lookupPeer{
 		lmqd:     lmqd,
+		address:  lookupAddress,
 		host:     host,
 		port:     port,
-		interval: config.GlobalLmqdConfig.HeartBeatInterval,
+		interval: config.GetLmqdConfig().HeartBeatInterval,
 
 		cond:                 sync.NewCond(&sync.Mutex{}),
 		channelsRequestChan:  make(chan *channelsReq, defaultChanSize),
 		channelsResponseChan: make(chan *channelsReq, defaultChanSize),
+		nodesRequestChan:     make(chan *nodesReq, defaultChanSize),
+		nodesResponseChan:    make(chan *nodesReq, defaultChanSize),
 		reconnectChan:        make(chan struct{}, 1),
 		exitChan:             make(chan struct{}),
 	}
@@ -110,6 +162,12 @@ func (peer *lookupPeer) start() {
 	go peer.loop()
 }
 
+// startAndRegister 运行过程中新增的lookup peer，在loop中连接lookup并注册本节点所有的topic和channel
+func (peer *lookupPeer) startAndRegister() {
+	peer.reconnectChan <- struct{}{}
+	go peer.loop()
+}
+
 // 负责定期发送心跳，以及重连
 func (peer *lookupPeer) loop() {
 	ticker := time.NewTicker(peer.interval)
@@ -132,6 +190,11 @@ func (peer *lookupPeer) loop() {
 			// 停止
 			goto exit
 		case <-ticker.C:
+			// 重新加载配置之后心跳间隔可能发生变化
+			if interval := config.GetLmqdConfig().HeartBeatInterval; interval > 0 && interval != peer.interval {
+				peer.interval = interval
+				ticker.Reset(interval)
+			}
 			// 发送心跳消息
 			_ = peer.sendHeartbeatPing()
 		}
@@ -150,6 +213,14 @@ func (peer *lookupPeer) close() {
 
 	// 关闭exit通道
 	close(peer.exitChan)
+
+	// 断开与lmq lookup的连接，唤醒等待连接的协程
+	peer.cond.L.Lock()
+	if peer.client != nil {
+		peer.client.GetConnection().Stop()
+	}
+	peer.cond.Broadcast()
+	peer.cond.L.Unlock()
 }
 
 // 连接
@@ -186,6 +257,8 @@ func (peer *lookupPeer) connect() (err error) {
 		go func() {
 			handler.peer = peer
 			peer.client.RegisterHandler(protocol.ChannelsID, handler)
+			peer.client.RegisterHandler(protocol.NodesID, &nodesRecvHandler{peer: peer})
+			peer.client.RegisterHandler(protocol.IdentityID, &identityRecvHandler{peer: peer})
 			peer.client.Start()
 			// 关闭连接进行重连
 			select {
@@ -201,14 +274,7 @@ func (peer *lookupPeer) connect() (err error) {
 	}
 
 	// 发送identify消息
-	address := peer.client.GetConnection().GetConn().LocalAddr().String()
-	host, _, _ := net.SplitHostPort(address)
-	requestBody := protocol.RequestBody{
-		RemoteAddress: peer.client.GetConnection().GetConn().LocalAddr().String(),
-		Hostname:      host,
-		TcpPort:       config.GlobalLmqdConfig.TcpPort,
-	}
-	data, err := json.Marshal(requestBody)
+	data, err := json.Marshal(peer.identityBody())
 	if err != nil {
 		return
 	}
@@ -222,6 +288,11 @@ func (peer *lookupPeer) connect() (err error) {
 }
 
 func (peer *lookupPeer) reconnect() (err error) {
+	if peer.isClosing.Load() {
+		// 已经关闭，不再重连
+		return errors.New("lmq lookup peer is closed")
+	}
+
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
 			peer.client = nil
@@ -239,6 +310,8 @@ func (peer *lookupPeer) reconnect() (err error) {
 
 	peer.channelsRequestChan = make(chan *channelsReq, defaultChanSize)  // 连接时清空队列
 	peer.channelsResponseChan = make(chan *channelsReq, defaultChanSize) // 连接时清空队列
+	peer.nodesRequestChan = make(chan *nodesReq, defaultChanSize)        // 连接时清空队列
+	peer.nodesResponseChan = make(chan *nodesReq, defaultChanSize)       // 连接时清空队列
 
 	defer func() {
 		if err != nil { // 连接时发生了错误
@@ -258,6 +331,8 @@ func (peer *lookupPeer) reconnect() (err error) {
 			go func() {
 				handler.peer = peer
 				peer.client.RegisterHandler(protocol.ChannelsID, handler)
+				peer.client.RegisterHandler(protocol.NodesID, &nodesRecvHandler{peer: peer})
+				peer.client.RegisterHandler(protocol.IdentityID, &identityRecvHandler{peer: peer})
 				peer.client.Start()
 				// 关闭连接进行重连
 				select {
@@ -277,14 +352,7 @@ func (peer *lookupPeer) reconnect() (err error) {
 	}
 
 	// 发送identify消息
-	address := peer.client.GetConnection().GetConn().LocalAddr().String()
-	host, _, _ := net.SplitHostPort(address)
-	requestBody := protocol.RequestBody{
-		RemoteAddress: peer.client.GetConnection().GetConn().LocalAddr().String(),
-		Hostname:      host,
-		TcpPort:       config.GlobalLmqdConfig.TcpPort,
-	}
-	data, err := json.Marshal(requestBody)
+	data, err := json.Marshal(peer.identityBody())
 	if err != nil {
 		return
 	}
@@ -295,6 +363,7 @@ func (peer *lookupPeer) reconnect() (err error) {
 	}
 
 	// 注册所有的topic和channel
+	var requestBody protocol.RequestBody
 	for _, t := range peer.lmqd.GetTopics() {
 		// 注册topic
 		requestBody = protocol.RequestBody{
@@ -351,6 +420,11 @@ func (peer *lookupPeer) sendHeartbeatPing() (err error) {
 func (peer *lookupPeer) sendRegistration(unRegister bool, topicName, channelName string) (err error) {
 	peer.cond.L.Lock()
 	if peer.client == nil {
+		if peer.isClosing.Load() {
+			// 已经关闭，不会再建立连接
+			peer.cond.L.Unlock()
+			return errors.New("lmq lookup peer is closed")
+		}
 		// 没有连接lookup
 		select {
 		case peer.reconnectChan <- struct{}{}:
@@ -386,6 +460,67 @@ func (peer *lookupPeer) sendRegistration(unRegister bool, topicName, channelName
 	return peer.doSendWithLook(protocol.RegisterID, data)
 }
 
+// getLocalHost 本节点在lookup中的hostname，还没有连接时为空
+func (peer *lookupPeer) getLocalHost() string {
+	host, _ := peer.localHost.Load().(string)
+	return host
+}
+
+// identityBody 构造identify消息，记录本节点的身份信息
+func (peer *lookupPeer) identityBody() *protocol.RequestBody {
+	address := peer.client.GetConnection().GetConn().LocalAddr().String()
+	host, _, _ := net.SplitHostPort(address)
+	peer.localHost.Store(host)
+
+	// 没有配置广播地址时，使用本机的hostname
+	lmqdConfig := config.GetLmqdConfig()
+	broadcastAddress := lmqdConfig.BroadcastAddress
+	if broadcastAddress == "" {
+		broadcastAddress, _ = os.Hostname()
+	}
+
+	return &protocol.RequestBody{
+		RemoteAddress:    address,
+		Hostname:         host,
+		TcpPort:          lmqdConfig.TcpPort,
+		NodeID:           peer.lmqd.GetNodeID(),
+		Version:          version.Version,
+		HttpPort:         lmqdConfig.HttpPort,
+		BroadcastAddress: broadcastAddress,
+		StartTime:        peer.lmqd.GetStartTime().UnixNano(),
+	}
+}
+
+// sendTombstone 在lookup中tombstone或者取消tombstone本节点的topic
+func (peer *lookupPeer) sendTombstone(unTombstone bool, topicName string) (err error) {
+	peer.cond.L.Lock()
+	defer peer.cond.L.Unlock()
+	if peer.client == nil {
+		select {
+		case peer.reconnectChan <- struct{}{}:
+		default:
+		}
+		return errors.New("lmq lookup server is not connected")
+	}
+
+	requestBody := &protocol.RequestBody{
+		TopicName:     topicName,
+		RemoteAddress: peer.client.GetConnection().GetConn().LocalAddr().String(),
+		Hostname:      peer.getLocalHost(),
+		TcpPort:       config.GetLmqdConfig().TcpPort,
+	}
+	data, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	if unTombstone {
+		return peer.doSendWithLook(protocol.UnTombstoneTopicID, data)
+	}
+
+	return peer.doSendWithLook(protocol.TombstoneTopicID, data)
+}
+
 func (peer *lookupPeer) getTopicChannels(topicName string) []string {
 	err := peer.sendTopicChannels(topicName)
 	if err != nil {
@@ -457,8 +592,63 @@ func (peer *lookupPeer) sendTopicChannels(topicName string) error {
 	return nil
 }
 
+// getNodes 获取lookup中所有存活的lmqd节点
+func (peer *lookupPeer) getNodes() ([]*protocol.Node, error) {
+	err := peer.sendNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(defaultTimeout)
+	defer timer.Stop()
+
+	select {
+	case request := <-peer.nodesResponseChan:
+		if time.Now().Sub(request.sendAt) > defaultTimeout { // 超时，直接丢弃消息
+			return nil, errors.New("lmq lookup nodes response timeout")
+		}
+
+		return request.data, nil
+	case <-peer.exitChan: // 已经关闭
+		return nil, errors.New("lmq lookup peer is closed")
+	case <-timer.C:
+		return nil, errors.New("lmq lookup nodes response timeout")
+	}
+}
+
+func (peer *lookupPeer) sendNodes() error {
+	peer.cond.L.Lock()
+	defer peer.cond.L.Unlock()
+	if peer.client == nil {
+		// 没有连接lookup，不等待连接
+		select {
+		case peer.reconnectChan <- struct{}{}:
+		default:
+		}
+		return errors.New("lmq lookup server is not connected")
+	}
+
+	// 发送消息
+	now := time.Now()
+	err := peer.doSendWithLook(protocol.NodesID, []byte("{}"))
+	if err != nil {
+		return err
+	}
+
+	peer.nodesRequestChan <- &nodesReq{
+		sendAt: now,
+	}
+
+	return nil
+}
+
 // 向lookup服务器发送消息，调用此函数时已经加锁了
 func (peer *lookupPeer) doSendWithLook(id uint32, data []byte) (err error) {
+	if peer.isClosing.Load() {
+		// 已经关闭的连接不能再发送消息
+		return errors.New("lmq lookup peer is closed")
+	}
+
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
 			peer.client = nil
@@ -0,0 +1,70 @@
+package message
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"strings"
+)
+
+/*
+	按照消息头部过滤消息，用于channel的过滤条件以及topic的路由规则
+	每一条规则检查消息头部中的一个字段，消息需要满足所有的规则
+*/
+
+// ValidateFilter 检查过滤规则是否合法
+func ValidateFilter(filter []*iface.FilterRule) error {
+	for _, rule := range filter {
+		if rule == nil || rule.Header == "" {
+			return e.ErrFilterInValid
+		}
+
+		switch rule.Op {
+		case iface.FilterOpEq, iface.FilterOpPrefix:
+			if len(rule.Values) != 1 {
+				return e.ErrFilterInValid
+			}
+		case iface.FilterOpIn:
+			if len(rule.Values) == 0 {
+				return e.ErrFilterInValid
+			}
+		default:
+			return e.ErrFilterInValid
+		}
+	}
+
+	return nil
+}
+
+// MatchFilter 消息是否满足所有的过滤规则，没有规则时总是满足
+func MatchFilter(filter []*iface.FilterRule, msg iface.IMessage) bool {
+	for _, rule := range filter {
+		value, ok := msg.GetHeaders()[rule.Header]
+		if !ok {
+			return false
+		}
+
+		switch rule.Op {
+		case iface.FilterOpEq:
+			if value != rule.Values[0] {
+				return false
+			}
+		case iface.FilterOpPrefix:
+			if !strings.HasPrefix(value, rule.Values[0]) {
+				return false
+			}
+		case iface.FilterOpIn:
+			found := false
+			for _, v := range rule.Values {
+				if value == v {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -2,14 +2,16 @@ package message
 
 import (
 	"github.com/dawnzzz/lmq/iface"
+	"strconv"
 	"time"
 )
 
 type Message struct {
-	ID        iface.MessageID `json:"ID"`
-	Data      []byte          `json:"Data"`
-	Timestamp int64           `json:"Timestamp"`
-	Attempts  uint16          `json:"Attempts"`
+	ID        iface.MessageID   `json:"ID"`
+	Data      []byte            `json:"Data"`
+	Timestamp int64             `json:"Timestamp"`
+	Attempts  uint16            `json:"Attempts"`
+	Headers   map[string]string `json:"Headers,omitempty"`
 
 	// 优先队列中使用到的数据结构
 	clientID uint64
@@ -27,6 +29,16 @@ func NewMessage(id iface.MessageID, data []byte) iface.IMessage {
 	return msg
 }
 
+// CopyMessage 复制一个消息，用于topic向多个channel投递消息
+func CopyMessage(msg iface.IMessage) iface.IMessage {
+	c := NewMessage(msg.GetID(), msg.GetData())
+	c.SetTimestamp(msg.GetTimestamp())
+	c.SetAttempts(msg.GetAttempts())
+	c.SetHeaders(msg.GetHeaders())
+
+	return c
+}
+
 func (msg *Message) GetID() iface.MessageID {
 	return msg.ID
 }
@@ -44,8 +56,10 @@ func (msg *Message) GetLength() int32 {
 	dataLen := len(msg.Data)
 	timestampLen := 8
 	attemptsLen := 2
+	versionLen := 1
+	headersLen := 2 + headersLength(msg.Headers)
 
-	return int32(idLen + dataLen + timestampLen + attemptsLen)
+	return int32(idLen + dataLen + timestampLen + attemptsLen + versionLen + headersLen)
 }
 
 func (msg *Message) GetTimestamp() int64 {
@@ -68,6 +82,18 @@ func (msg *Message) AddAttempts(delta uint16) {
 	msg.Attempts += delta
 }
 
+func (msg *Message) GetHeaders() map[string]string {
+	return msg.Headers
+}
+
+func (msg *Message) SetHeaders(headers map[string]string) {
+	msg.Headers = headers
+}
+
+func (msg *Message) GetHeader(key string) string {
+	return msg.Headers[key]
+}
+
 func (msg *Message) GetPriority() int64 {
 	return msg.pri
 }
@@ -91,3 +117,19 @@ func (msg *Message) GetIndex() int {
 func (msg *Message) SetIndex(index int) {
 	msg.index = index
 }
+
+// Priority 获取消息头部中的优先级，没有设置时为0
+func Priority(msg iface.IMessage) int {
+	priority, _ := strconv.Atoi(msg.GetHeader(iface.PriorityHeader))
+	return priority
+}
+
+// SetPriority 在消息头部中设置优先级
+func SetPriority(msg iface.IMessage, priority int) {
+	headers := make(map[string]string, len(msg.GetHeaders())+1)
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	headers[iface.PriorityHeader] = strconv.Itoa(priority)
+	msg.SetHeaders(headers)
+}
@@ -5,10 +5,62 @@ import (
 	"encoding/binary"
 	"errors"
 	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/pkg/e"
 	"io"
+	"sort"
 	"sync"
 )
 
+/*
+	消息持久化的格式
+	旧格式（没有头部）：ID + 时间戳 + attempts + 数据
+	新格式：ID + 时间戳 + attempts|recordFormatFlag + 格式版本(1) + 头部长度(2) + 头部 + 数据
+	attempts的最高位标记是否为新格式，升级之前写入磁盘队列的旧格式消息仍然可以读取
+*/
+
+const (
+	recordFormatFlag    uint16 = 1 << 15              // attempts的最高位，为1时表示attempts之后是格式版本
+	maxRecordAttempts          = recordFormatFlag - 1 // 持久化时attempts的最大值，超过时按照最大值保存
+	recordFormatVersion byte   = 1                    // 当前的格式版本
+)
+
+// 旧格式除数据部分之外的固定长度：ID + 时间戳 + attempts
+const legacyFixedLength = iface.MsgIDLength + 8 + 2
+
+// 消息持久化时除数据部分之外的固定长度：ID + 时间戳 + attempts + 格式版本 + 头部长度
+const fixedLength = legacyFixedLength + 1 + 2
+
+var errConvertFailed = errors.New("convert bytes to message err")
+
+// MinPersistLength 根据消息数据的最小长度，计算消息持久化后的最小长度，按照旧格式计算
+func MinPersistLength(minDataSize int32) int32 {
+	return minDataSize + legacyFixedLength
+}
+
+// MaxPersistLength 根据消息数据的最大长度，计算消息持久化后的最大长度
+func MaxPersistLength(maxDataSize int32) int32 {
+	return maxDataSize + fixedLength + iface.MsgHeadersMaxLength
+}
+
+// CheckHeaders 检查消息头部序列化后的长度是否合法
+func CheckHeaders(headers map[string]string) error {
+	if headersLength(headers) > iface.MsgHeadersMaxLength {
+		return e.ErrMessageHeadersTooLong
+	}
+
+	return nil
+}
+
+// headersLength 头部序列化后的长度，每一项为：key长度(2) + key + value长度(2) + value
+func headersLength(headers map[string]string) int {
+	length := 0
+	for k, v := range headers {
+		length += 2 + len(k) + 2 + len(v)
+	}
+
+	return length
+}
+
 var bp sync.Pool
 
 func init() {
@@ -47,8 +99,22 @@ func ConvertMessageToBytes(message iface.IMessage) ([]byte, error) {
 		return nil, err
 	}
 
-	// 写入attempts
-	err = binary.Write(buffer, binary.BigEndian, message.GetAttempts())
+	// 写入attempts和格式版本
+	attempts := message.GetAttempts()
+	if attempts > maxRecordAttempts {
+		attempts = maxRecordAttempts
+	}
+	err = binary.Write(buffer, binary.BigEndian, attempts|recordFormatFlag)
+	if err != nil {
+		return nil, err
+	}
+	err = buffer.WriteByte(recordFormatVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// 写入头部
+	err = writeHeaders(buffer, message.GetHeaders())
 	if err != nil {
 		return nil, err
 	}
@@ -59,10 +125,12 @@ func ConvertMessageToBytes(message iface.IMessage) ([]byte, error) {
 		return nil, err
 	}
 
-	return buffer.Bytes(), nil
-}
+	// buffer会放回对象池中，需要复制一份
+	data := make([]byte, buffer.Len())
+	copy(data, buffer.Bytes())
 
-var errConvertFailed = errors.New("convert bytes to message err")
+	return data, nil
+}
 
 func ConvertBytesToMessage(data []byte) (iface.IMessage, error) {
 	reader := bytes.NewReader(data)
@@ -95,17 +163,36 @@ func ConvertBytesToMessage(data []byte) (iface.IMessage, error) {
 		return nil, err
 	}
 
+	// 新格式读取格式版本和头部，旧格式没有头部
+	var headers map[string]string
+	if attempts&recordFormatFlag != 0 {
+		attempts &^= recordFormatFlag
+
+		version, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if version != recordFormatVersion {
+			return nil, errConvertFailed
+		}
+
+		headers, err = readHeaders(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 读取message数据
-	buffer := bufferPoolGet()
-	defer bufferPoolPut(buffer)
-	_, err = buffer.ReadFrom(reader)
-	if err != nil && err != io.EOF {
+	body := make([]byte, reader.Len())
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
 		return nil, err
 	}
 
-	msg := NewMessage(*msgID, buffer.Bytes())
+	msg := NewMessage(*msgID, body)
 	msg.SetTimestamp(timestamp)
 	msg.SetAttempts(attempts)
+	msg.SetHeaders(headers)
 
 	return msg, nil
 }
@@ -122,3 +209,83 @@ func sliceToMessageID(slice []byte) (*iface.MessageID, error) {
 
 	return &msgID, nil
 }
+
+// writeHeaders 写入消息头部，格式为：头部总长度(2) + 每一项[key长度(2) + key + value长度(2) + value]
+func writeHeaders(buffer *bytes.Buffer, headers map[string]string) error {
+	length := headersLength(headers)
+	if length > iface.MsgHeadersMaxLength {
+		return e.ErrMessageHeadersTooLong
+	}
+
+	err := binary.Write(buffer, binary.BigEndian, uint16(length))
+	if err != nil {
+		return err
+	}
+
+	// 按照key排序，保证序列化结果一致
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, s := range []string{k, headers[k]} {
+			err = binary.Write(buffer, binary.BigEndian, uint16(len(s)))
+			if err != nil {
+				return err
+			}
+			_, err = buffer.WriteString(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readHeaders 读取消息头部
+func readHeaders(reader *bytes.Reader) (map[string]string, error) {
+	var length uint16
+	err := binary.Read(reader, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+
+	if length == 0 {
+		return nil, nil
+	}
+
+	if int(length) > reader.Len() {
+		return nil, errConvertFailed
+	}
+
+	headersBytes := make([]byte, length)
+	_, err = io.ReadFull(reader, headersBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string)
+	headersReader := bytes.NewReader(headersBytes)
+	for headersReader.Len() > 0 {
+		var kv [2]string
+		for i := range kv {
+			var n uint16
+			err = binary.Read(headersReader, binary.BigEndian, &n)
+			if err != nil {
+				return nil, errConvertFailed
+			}
+			if int(n) > headersReader.Len() {
+				return nil, errConvertFailed
+			}
+			b := make([]byte, n)
+			_, _ = io.ReadFull(headersReader, b)
+			kv[i] = string(b)
+		}
+		headers[kv[0]] = kv[1]
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,84 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dawnzzz/lmq/iface"
+	"reflect"
+	"testing"
+)
+
+func TestConvertMessageRoundTrip(t *testing.T) {
+	id := iface.MessageID{1, 2, 3, 4, 5, 6, 7, 8}
+	msg := NewMessage(id, []byte("hello"))
+	msg.SetTimestamp(1234567890)
+	msg.SetAttempts(3)
+	msg.SetHeaders(map[string]string{"region": "cn", "type": "order"})
+
+	data, err := ConvertMessageToBytes(msg)
+	if err != nil {
+		t.Fatalf("convert message to bytes err: %s", err)
+	}
+	if int32(len(data)) != msg.GetLength() {
+		t.Fatalf("persist length %d, want %d", len(data), msg.GetLength())
+	}
+
+	got, err := ConvertBytesToMessage(data)
+	if err != nil {
+		t.Fatalf("convert bytes to message err: %s", err)
+	}
+	if got.GetID() != id || got.GetTimestamp() != 1234567890 || got.GetAttempts() != 3 || string(got.GetData()) != "hello" {
+		t.Fatalf("unexpected message %+v", got)
+	}
+	if !reflect.DeepEqual(got.GetHeaders(), msg.GetHeaders()) {
+		t.Fatalf("headers %v, want %v", got.GetHeaders(), msg.GetHeaders())
+	}
+}
+
+// TestConvertLegacyMessage 升级之前写入磁盘队列的消息：ID + 时间戳 + attempts + 数据，没有头部
+func TestConvertLegacyMessage(t *testing.T) {
+	id := iface.MessageID{8, 7, 6, 5, 4, 3, 2, 1}
+	buffer := &bytes.Buffer{}
+	buffer.Write(id.Bytes())
+	_ = binary.Write(buffer, binary.BigEndian, int64(987654321))
+	_ = binary.Write(buffer, binary.BigEndian, uint16(2))
+	// 数据的前两个字节在新格式中会被当作头部长度
+	buffer.Write([]byte{0x00, 0x10, 'l', 'e', 'g', 'a', 'c', 'y'})
+	data := buffer.Bytes()
+
+	if int32(len(data)) < MinPersistLength(0) {
+		t.Fatalf("legacy record length %d is less than min persist length %d", len(data), MinPersistLength(0))
+	}
+
+	got, err := ConvertBytesToMessage(data)
+	if err != nil {
+		t.Fatalf("convert legacy bytes to message err: %s", err)
+	}
+	if got.GetID() != id || got.GetTimestamp() != 987654321 || got.GetAttempts() != 2 {
+		t.Fatalf("unexpected message %+v", got)
+	}
+	if !bytes.Equal(got.GetData(), []byte{0x00, 0x10, 'l', 'e', 'g', 'a', 'c', 'y'}) {
+		t.Fatalf("data %v is not preserved", got.GetData())
+	}
+	if len(got.GetHeaders()) != 0 {
+		t.Fatalf("legacy message should have no headers, got %v", got.GetHeaders())
+	}
+}
+
+func TestConvertMessageAttemptsOverflow(t *testing.T) {
+	msg := NewMessage(iface.MessageID{}, []byte("x"))
+	msg.SetAttempts(0xffff)
+
+	data, err := ConvertMessageToBytes(msg)
+	if err != nil {
+		t.Fatalf("convert message to bytes err: %s", err)
+	}
+
+	got, err := ConvertBytesToMessage(data)
+	if err != nil {
+		t.Fatalf("convert bytes to message err: %s", err)
+	}
+	if got.GetAttempts() != maxRecordAttempts || string(got.GetData()) != "x" {
+		t.Fatalf("unexpected message %+v", got)
+	}
+}
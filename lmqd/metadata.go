@@ -2,32 +2,135 @@ package lmqd
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/utils"
 	"github.com/dawnzzz/lmq/logger"
 	"io"
+	"math/rand"
 	"os"
 	"path"
+	"time"
 )
 
-// MetaData 元数据，记录了topic channel信息
+// MetaDataVersion 当前元数据的版本，元数据结构发生不兼容的变化时需要增加版本，并在metaDataMigrations中添加迁移函数
+const MetaDataVersion = 2
+
+// MetaData 元数据，记录了节点ID、topic channel信息
 type MetaData struct {
-	Topics []*TopicMetaData `json:"topics"`
+	Version int              `json:"version"`
+	NodeID  int64            `json:"node_id"`
+	Topics  []*TopicMetaData `json:"topics"`
 }
 
 type TopicMetaData struct {
-	Name      string             `json:"name,required"`
-	IsPausing bool               `json:"is_pausing"`
-	Channels  []*ChannelMetaData `json:"channels,omitempty"`
+	Name      string `json:"name,required"`
+	IsPausing bool   `json:"is_pausing"`
+	Ephemeral bool   `json:"ephemeral"`
+	CreatedAt int64  `json:"created_at"`
+	iface.TopicSettings
+	Channels []*ChannelMetaData `json:"channels,omitempty"`
 }
 
 type ChannelMetaData struct {
 	Name      string `json:"name,required"`
 	IsPausing bool   `json:"is_pausing"`
+	Ephemeral bool   `json:"ephemeral"`
+	CreatedAt int64  `json:"created_at"`
+	iface.ChannelSettings
+}
+
+// metaDataMigration 将反序列化后的元数据从第i个版本迁移到第i+1个版本
+type metaDataMigration func(raw map[string]interface{}) error
+
+// metaDataMigrations 第i个迁移函数将元数据从版本i迁移到版本i+1
+var metaDataMigrations = []metaDataMigration{
+	migrateMetaDataV0ToV1,
+	migrateMetaDataV1ToV2,
+}
+
+// migrateMetaDataV0ToV1 版本0的元数据没有版本号，topic/channel只有name和is_pausing，补充ephemeral和created_at
+func migrateMetaDataV0ToV1(raw map[string]interface{}) error {
+	now := time.Now().UnixNano()
+	fill := func(obj map[string]interface{}) {
+		name, _ := obj["name"].(string)
+		obj["ephemeral"] = utils.IsEphemeralName(name)
+		obj["created_at"] = now
+	}
+
+	topics, _ := raw["topics"].([]interface{})
+	for _, rawTopic := range topics {
+		topic, ok := rawTopic.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fill(topic)
+
+		channels, _ := topic["channels"].([]interface{})
+		for _, rawChannel := range channels {
+			channel, ok := rawChannel.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fill(channel)
+		}
+	}
+
+	return nil
+}
+
+// migrateMetaDataV1ToV2 版本1的元数据没有节点ID，随机生成一个
+func migrateMetaDataV1ToV2(raw map[string]interface{}) error {
+	raw["node_id"] = randomNodeID()
+
+	return nil
+}
+
+// migrateMetaData 将元数据迁移到当前版本
+func migrateMetaData(data []byte) (*MetaData, error) {
+	raw := map[string]interface{}{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if v, ok := raw["version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > MetaDataVersion {
+		return nil, fmt.Errorf("metadata version %d is newer than supported version %d", version, MetaDataVersion)
+	}
+
+	// 依次执行迁移函数
+	for ; version < MetaDataVersion; version++ {
+		logger.Infof("migrating lmqd metadata from version %d to %d", version, version+1)
+		err = metaDataMigrations[version](raw)
+		if err != nil {
+			return nil, fmt.Errorf("migrate metadata from version %d failed: %w", version, err)
+		}
+	}
+	raw["version"] = MetaDataVersion
+
+	// 转为当前版本的结构体
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var metaData MetaData
+	err = json.Unmarshal(data, &metaData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metaData, nil
 }
 
 func (lmqd *LmqDaemon) metaFilename() string {
-	return path.Join(config.GlobalLmqdConfig.DataRootPath, "[lmqd].meta.dat")
+	return path.Join(config.GetLmqdConfig().DataRootPath, "[lmqd].meta.dat")
 }
 
 // LoadMetaData 加载元数据信息
@@ -43,6 +146,7 @@ func (lmqd *LmqDaemon) LoadMetaData() error {
 	metaFile, err := os.OpenFile(metaFilename, os.O_RDONLY, 0600)
 	if err != nil {
 		if os.IsNotExist(err) {
+			// 首次启动，使用创建lmqd时生成的节点ID
 			return nil
 		}
 
@@ -56,26 +160,37 @@ func (lmqd *LmqDaemon) LoadMetaData() error {
 		return err
 	}
 
-	// 反序列化，转为结构体
-	var metaData MetaData
-	err = json.Unmarshal(data, &metaData)
+	// 反序列化并迁移到当前版本，转为结构体
+	metaData, err := migrateMetaData(data)
 	if err != nil {
 		return err
 	}
 
+	// 加载节点ID
+	if err = lmqd.initNodeID(metaData.NodeID); err != nil {
+		return err
+	}
+
 	// 加载元数据信息
 	for _, topicMetaData := range metaData.Topics {
 		if !utils.TopicOrChannelNameIsValid(topicMetaData.Name) { // topic名字不合法，直接跳过
 			continue
 		}
+		if topicMetaData.Ephemeral { // 临时topic不恢复
+			continue
+		}
 
 		// 加载topic
-		t, err := lmqd.GetTopic(topicMetaData.Name)
+		// 按照保存的配置创建队列
+		t, err := lmqd.GetTopicWithSettings(topicMetaData.Name, topicMetaData.TopicSettings)
 		if err != nil {
 			logger.Errorf("get topic(%s) err when retrieveMetaData, err: %s", topicMetaData.Name, err.Error())
 			continue
 		}
 
+		t.SetCreatedAt(time.Unix(0, topicMetaData.CreatedAt))
+		t.SetSettings(topicMetaData.TopicSettings)
+
 		t.Start()
 
 		if topicMetaData.IsPausing {
@@ -87,14 +202,20 @@ func (lmqd *LmqDaemon) LoadMetaData() error {
 			if !utils.TopicOrChannelNameIsValid(channelMetaData.Name) { // channel名字不合法，直接跳过
 				continue
 			}
+			if channelMetaData.Ephemeral { // 临时channel不恢复
+				continue
+			}
 
 			// 加载channel
-			c, err := t.GetChannel(channelMetaData.Name)
+			c, err := t.GetChannelWithSettings(channelMetaData.Name, channelMetaData.ChannelSettings)
 			if err != nil {
 				logger.Errorf("get topic(%s) channel(%s) err when retrieveMetaData, err: %s", topicMetaData.Name, channelMetaData.Name, err.Error())
 				continue
 			}
 
+			c.SetCreatedAt(time.Unix(0, channelMetaData.CreatedAt))
+			_ = c.SetSettings(channelMetaData.ChannelSettings)
+
 			if channelMetaData.IsPausing {
 				_ = c.Pause()
 			}
@@ -109,30 +230,40 @@ func (lmqd *LmqDaemon) PersistMetaData() error {
 	lmqd.topicsLock.Lock()
 	defer lmqd.topicsLock.Unlock()
 
-	if len(lmqd.topics) <= 0 {
-		return nil
+	metaData := MetaData{
+		Version: MetaDataVersion,
+		NodeID:  lmqd.GetNodeID(),
+		Topics:  []*TopicMetaData{},
 	}
 
-	var metaData MetaData
-
 	// 持久化操作
 	for _, t := range lmqd.topics {
+		if t.IsEphemeral() { // 临时topic只保存在内存中，不持久化
+			continue
+		}
+
 		topicMetaData := TopicMetaData{
-			Name:      t.GetName(),
-			IsPausing: t.IsPausing(),
+			Name:          t.GetName(),
+			IsPausing:     t.IsPausing(),
+			Ephemeral:     t.IsEphemeral(),
+			CreatedAt:     t.GetCreatedAt().UnixNano(),
+			TopicSettings: t.GetSettings(),
 		}
 
 		// 获取所有的channel name
 		channelNames := t.GetChannelNames()
 		for _, channelName := range channelNames {
 			c, err := t.GetExistingChannel(channelName)
-			if err != nil {
+			if err != nil || c.IsEphemeral() { // 临时channel不持久化
 				continue
 			}
 
 			topicMetaData.Channels = append(topicMetaData.Channels, &ChannelMetaData{
-				Name:      c.GetName(),
-				IsPausing: c.IsPausing(),
+				Name:            c.GetName(),
+				IsPausing:       c.IsPausing(),
+				Ephemeral:       c.IsEphemeral(),
+				CreatedAt:       c.GetCreatedAt().UnixNano(),
+				ChannelSettings: c.GetSettings(),
 			})
 		}
 
@@ -145,18 +276,23 @@ func (lmqd *LmqDaemon) PersistMetaData() error {
 		return err
 	}
 
-	// 打开元数据文件，并写入元数据
+	// 先写入临时文件，再重命名为元数据文件，防止写入过程中崩溃导致元数据文件损坏
 	metaFilename := lmqd.metaFilename()
-	metaFile, err := os.OpenFile(metaFilename, os.O_WRONLY|os.O_CREATE, 0600)
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", metaFilename, rand.Int())
+	metaFile, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	defer metaFile.Close()
 
 	_, err = metaFile.Write(data)
+	if err == nil {
+		err = metaFile.Sync()
+	}
+	_ = metaFile.Close()
 	if err != nil {
+		_ = os.Remove(tmpFilename)
 		return err
 	}
 
-	return nil
+	return os.Rename(tmpFilename, metaFilename)
 }
@@ -0,0 +1,102 @@
+package options
+
+import (
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/pkg/e"
+)
+
+/*
+	topic/channel级别的配置覆盖lmqd的全局配置
+	channel的配置按照channel、topic、lmqd全局配置的顺序取值，topic的配置按照topic、lmqd全局配置的顺序取值
+	MessageTimeout、MinMessageSize、MaxMessageSize修改之后立即生效，
+	MemQueueSize、SyncEvery、SyncTimeout、MaxBytesPerFile只在创建队列时生效（新建或者lmqd重启之后）
+*/
+
+// Global lmqd全局配置对应的Options
+func Global() *iface.Options {
+	lmqdConfig := config.GetLmqdConfig()
+	return &iface.Options{
+		MemQueueSize:    lmqdConfig.MemQueueSize,
+		MinMessageSize:  lmqdConfig.MinMessageSize,
+		MaxMessageSize:  lmqdConfig.MaxMessageSize,
+		SyncEvery:       lmqdConfig.SyncEvery,
+		SyncTimeout:     lmqdConfig.SyncTimeout,
+		MaxBytesPerFile: lmqdConfig.MaxBytesPerFile,
+		MessageTimeout:  lmqdConfig.MessageTimeout,
+	}
+}
+
+// Resolve 从全局配置开始依次应用overrides，后面的overrides优先级更高，为nil的overrides跳过
+func Resolve(overrides ...*iface.Overrides) *iface.Options {
+	opts := Global()
+
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		if o.MemQueueSize != nil {
+			opts.MemQueueSize = *o.MemQueueSize
+		}
+		if o.MinMessageSize != nil {
+			opts.MinMessageSize = *o.MinMessageSize
+		}
+		if o.MaxMessageSize != nil {
+			opts.MaxMessageSize = *o.MaxMessageSize
+		}
+		if o.SyncEvery != nil {
+			opts.SyncEvery = *o.SyncEvery
+		}
+		if o.SyncTimeout != nil {
+			opts.SyncTimeout = *o.SyncTimeout
+		}
+		if o.MaxBytesPerFile != nil {
+			opts.MaxBytesPerFile = *o.MaxBytesPerFile
+		}
+		if o.MessageTimeout != nil {
+			opts.MessageTimeout = *o.MessageTimeout
+		}
+	}
+
+	return opts
+}
+
+// Validate 检查overrides是否合法，overrides按照Resolve的顺序传入，检查应用之后的配置
+func Validate(overrides ...*iface.Overrides) error {
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		if o.MemQueueSize != nil && *o.MemQueueSize < 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.MinMessageSize != nil && *o.MinMessageSize < 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.MaxMessageSize != nil && *o.MaxMessageSize <= 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.SyncEvery != nil && *o.SyncEvery <= 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.SyncTimeout != nil && *o.SyncTimeout <= 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.MaxBytesPerFile != nil && *o.MaxBytesPerFile <= 0 {
+			return e.ErrOverridesInValid
+		}
+		if o.MessageTimeout != nil && *o.MessageTimeout <= 0 {
+			return e.ErrOverridesInValid
+		}
+	}
+
+	// 磁盘队列和tcp server按照全局配置限制消息长度，不能超过全局配置
+	opts := Resolve(overrides...)
+	lmqdConfig := config.GetLmqdConfig()
+	if opts.MinMessageSize < lmqdConfig.MinMessageSize || opts.MaxMessageSize > lmqdConfig.MaxMessageSize ||
+		opts.MinMessageSize > opts.MaxMessageSize {
+		return e.ErrOverridesInValid
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package lmqd
+
+import (
+	"fmt"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"testing"
+	"time"
+)
+
+func putOrdered(t *testing.T, lmqd *LmqDaemon, channel iface.IChannel, key string, seq int) {
+	t.Helper()
+
+	msg := message.NewMessage(lmqd.GetGUIDFactory().NewMessageID(), []byte(fmt.Sprintf("%s%d", key, seq)))
+	msg.SetHeaders(map[string]string{iface.OrderingKeyHeader: key})
+	if err := channel.PutMessage(msg); err != nil {
+		t.Fatalf("put message err: %s", err)
+	}
+}
+
+// recvOrdered 作为客户端从channel中接收一个消息，finish为true时立即FIN
+func recvOrdered(t *testing.T, channel iface.IChannel, finish bool) iface.IMessage {
+	t.Helper()
+
+	msgChan, _ := channel.GetClientMsgChans(1)
+	select {
+	case msg := <-msgChan:
+		if err := channel.StartInFlightTimeout(msg, 1, time.Minute); err != nil {
+			t.Fatalf("start in-flight err: %s", err)
+		}
+		if finish {
+			if err := channel.FinishMessage(1, msg.GetID()); err != nil {
+				t.Fatalf("finish err: %s", err)
+			}
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message to receive")
+	}
+
+	return nil
+}
+
+// TestOrderedRestart 重启之后，等待投递的消息和spill队列中的消息仍然按照每个key发布的顺序投递
+func TestOrderedRestart(t *testing.T) {
+	lmqd := newTestLmqd(t, 2, time.Minute)
+
+	topic, _ := lmqd.GetTopic("test")
+	channel, _ := topic.GetChannel("ch")
+	if err := channel.SetSettings(iface.ChannelSettings{Ordered: true}); err != nil {
+		t.Fatalf("set settings err: %s", err)
+	}
+
+	for seq := 1; seq <= 8; seq++ {
+		putOrdered(t, lmqd, channel, "a", seq)
+		putOrdered(t, lmqd, channel, "b", seq)
+	}
+
+	// a1、b1投递中，每个key有2个消息在backlog中，其余的消息写入spill队列
+	a1 := recvOrdered(t, channel, false)
+	b1 := recvOrdered(t, channel, false)
+	if !waitFor(func() bool {
+		return len(channel.GetMemoryMsgChan()) == 0 && channel.GetBackendQueue().Depth() == 0
+	}) {
+		t.Fatal("channel queue is not drained into the ordered stage")
+	}
+
+	// b的backlog出现空位时读取spill队列，没有轮到的a的消息被写回spill队列的末尾
+	_ = channel.FinishMessage(1, a1.GetID())
+	_ = channel.FinishMessage(1, b1.GetID())
+	for _, want := range []string{"a2", "b2"} {
+		if msg := recvOrdered(t, channel, true); string(msg.GetData()) != want {
+			t.Fatalf("received %s, want %s", msg.GetData(), want)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	lmqd = restartTestLmqd(t, lmqd)
+	topic, _ = lmqd.GetExistingTopic("test")
+	channel, _ = topic.GetExistingChannel("ch")
+	if !channel.IsOrdered() {
+		t.Fatal("channel is not ordered after restart")
+	}
+	putOrdered(t, lmqd, channel, "a", 9)
+	putOrdered(t, lmqd, channel, "b", 9)
+
+	next := map[string]int{"a": 3, "b": 3}
+	for count := 0; count < 14; count++ {
+		msg := recvOrdered(t, channel, true)
+		key := msg.GetHeader(iface.OrderingKeyHeader)
+		if want := fmt.Sprintf("%s%d", key, next[key]); string(msg.GetData()) != want {
+			t.Fatalf("received %s after restart, want %s", msg.GetData(), want)
+		}
+		next[key]++
+	}
+}
@@ -0,0 +1,31 @@
+package lmqd
+
+import (
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/logger"
+)
+
+// Reload 重新加载配置文件，可以安全修改的配置立即生效，返回发生变化的配置以及需要重启才能生效的配置
+func (lmqd *LmqDaemon) Reload() (*config.ReloadResult, error) {
+	result, err := config.ReloadLmqdConfig()
+	if err != nil {
+		logger.Errorf("reload config failed, err: %s", err.Error())
+		return nil, err
+	}
+
+	// 日志级别
+	lmqdConfig := config.GetLmqdConfig()
+	_ = logger.SetLevel(lmqdConfig.LogLevel)
+
+	// 连接新增的lookup，断开已经删除的lookup
+	lmqd.lookupManager.UpdateLookupAddresses(lmqdConfig.LookupAddresses)
+
+	// 重新应用topic和channel的配置，使用新的全局配置作为默认值
+	for _, t := range lmqd.GetTopics() {
+		t.SetSettings(t.GetSettings())
+	}
+
+	logger.Infof("reload config, changed: %v, restart required: %v", result.Changed, result.RestartRequired)
+
+	return result, nil
+}
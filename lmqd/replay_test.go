@@ -0,0 +1,55 @@
+package lmqd
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/tcp"
+	"testing"
+	"time"
+)
+
+// TestReplayChannelWithClient 重放有客户端订阅的channel，客户端丢弃in-flight消息之后可以继续接收消息
+func TestReplayChannelWithClient(t *testing.T) {
+	lmqd := newTestLmqd(t, 16, time.Minute)
+
+	topic, err := lmqd.GetTopicWithSettings("test", iface.TopicSettings{Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("get topic err: %s", err)
+	}
+	channel, _ := topic.GetChannel("ch")
+	client := tcp.NewTcpClient(1, nil)
+	if err = channel.AddClient(client.ID, client); err != nil {
+		t.Fatalf("add client err: %s", err)
+	}
+	topic.Start()
+
+	putMessages(t, lmqd, topic.PutMessage, 3)
+	if !waitFor(func() bool { return channel.Depth() == 3 }) {
+		t.Fatalf("depth %d, want 3", channel.Depth())
+	}
+
+	// 客户端接收了一个消息，还没有确认
+	msg := <-channel.GetMemoryMsgChan()
+	msg.AddAttempts(1)
+	if err = channel.StartInFlightTimeout(msg, client.ID, time.Minute); err != nil {
+		t.Fatalf("start in-flight err: %s", err)
+	}
+	client.InFlightCount.Add(1)
+
+	count, err := topic.ReplayChannel("ch", 0, iface.MessageID{})
+	if err != nil {
+		t.Fatalf("replay err: %s", err)
+	}
+	if count != 3 {
+		t.Fatalf("replayed %d messages, want 3", count)
+	}
+
+	if depth := channel.Depth(); depth != 3 {
+		t.Fatalf("depth %d after replay, want 3", depth)
+	}
+	if n := channel.InFlightCount(); n != 0 {
+		t.Fatalf("channel in-flight %d after replay, want 0", n)
+	}
+	if n := client.InFlightCount.Load(); n != 0 {
+		t.Fatalf("client in-flight %d after replay, want 0", n)
+	}
+}
@@ -0,0 +1,142 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dawnzzz/hamble-tcp-server/hamble"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var errReplicaClosed = errors.New("replica connection is closed")
+
+// replicaClient 与一个副本节点之间的连接
+// 同一个连接上同一种任务的响应是按照请求的顺序返回的，所以用一个FIFO队列记录等待响应的请求
+type replicaClient struct {
+	sync.Mutex
+
+	address  string
+	client   serveriface.IClient
+	waiters  []chan error // 等待REPLICATE响应的请求
+	isClosed bool
+}
+
+type replicateRecvHandler struct {
+	hamble.BaseHandler
+	rc *replicaClient
+}
+
+func (h *replicateRecvHandler) Handle(request serveriface.IRequest) {
+	resp := protocol.ResponseBody{}
+	err := json.Unmarshal(request.GetData(), &resp)
+	if err == nil && resp.IsError {
+		err = errors.New(resp.StatusMsg)
+	}
+
+	h.rc.Lock()
+	defer h.rc.Unlock()
+	if len(h.rc.waiters) == 0 {
+		return
+	}
+
+	waiter := h.rc.waiters[0]
+	h.rc.waiters = h.rc.waiters[1:]
+	waiter <- err
+}
+
+// ignoreRecvHandler 忽略响应
+type ignoreRecvHandler struct {
+	hamble.BaseHandler
+}
+
+func (h *ignoreRecvHandler) Handle(request serveriface.IRequest) {}
+
+func newReplicaClient(address string) (*replicaClient, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := hamble.NewClient("tcp", host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &replicaClient{
+		address: address,
+		client:  client,
+	}
+	client.RegisterHandler(protocol.ReplicateID, &replicateRecvHandler{rc: rc})
+	client.RegisterHandler(protocol.ReplicaFinID, &ignoreRecvHandler{})
+
+	go func() {
+		client.Start()
+		// 连接断开
+		rc.close()
+	}()
+
+	return rc, nil
+}
+
+// replicate 发送REPLICATE请求，返回用于等待响应的chan
+func (rc *replicaClient) replicate(data []byte) (chan error, error) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if rc.isClosed {
+		return nil, errReplicaClosed
+	}
+
+	waiter := make(chan error, 1)
+	err := rc.client.GetConnection().SendBufMsg(protocol.ReplicateID, data)
+	if err != nil {
+		return nil, err
+	}
+	rc.waiters = append(rc.waiters, waiter)
+
+	return waiter, nil
+}
+
+// send 发送请求，不等待响应
+func (rc *replicaClient) send(id uint32, data []byte) error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if rc.isClosed {
+		return errReplicaClosed
+	}
+
+	return rc.client.GetConnection().SendBufMsg(id, data)
+}
+
+func (rc *replicaClient) closed() bool {
+	rc.Lock()
+	defer rc.Unlock()
+
+	return rc.isClosed
+}
+
+func (rc *replicaClient) close() {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if rc.isClosed {
+		return
+	}
+	rc.isClosed = true
+
+	// 唤醒所有等待的请求
+	for _, waiter := range rc.waiters {
+		waiter <- errReplicaClosed
+	}
+	rc.waiters = nil
+
+	rc.client.Stop()
+}
@@ -0,0 +1,495 @@
+package replication
+
+import (
+	"encoding/json"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	副本机制：
+	主节点在发布消息时，将消息复制到replication_factor个其他的lmqd节点（通过lmqlookup发现）中，等待replication_acks个副本确认之后才返回
+	消息在主节点的所有channel中都完成之后，通知副本节点删除该消息
+	副本节点发现主节点在lmqlookup中消失，并且直接连接主节点也失败之后，由第一个存活的副本节点接管主节点，将副本中的消息发布到本节点的topic中
+	每个消息发布成功之后才从副本中删除，发布失败时剩下的消息留在副本中，下一次检查时继续接管
+	接管之后主节点如果恢复，磁盘队列中的消息会被再次投递，即至少投递一次
+*/
+
+const (
+	checkInterval    = 5 * time.Second // 刷新lmqd节点、检查主节点是否存活的时间间隔
+	finFlushInterval = time.Second     // 批量发送REPLICA_FIN的时间间隔
+	maxMisses        = 2               // 连续多少次没有在lookup中发现主节点，才进行接管
+	probeTimeout     = 3 * time.Second // 接管之前直接连接主节点的超时时间
+	maxPending       = 1 << 20         // 最多记录多少条等待完成的消息
+
+	maxFinBatchSize = 256
+)
+
+// pendingMessage 主节点中已经复制、还未完成的消息
+type pendingMessage struct {
+	replicas []string            // 复制到的副本节点
+	channels map[string]struct{} // 还没有完成的channel，nil表示还没有投递到channel中
+}
+
+type finKey struct {
+	replica   string
+	topicName string
+}
+
+type Manager struct {
+	lmqd iface.ILmqDaemon
+
+	self     string   // 本节点地址
+	nodes    []string // lookup中存活的其他lmqd节点
+	alive    map[string]bool
+	nodeLock sync.RWMutex
+
+	clients     map[string]*replicaClient // 副本节点地址 -> 连接
+	clientsLock sync.Mutex
+
+	pending     map[string]map[iface.MessageID]*pendingMessage // topic name -> 等待完成的消息
+	pendingSize int
+	pendingLock sync.Mutex
+
+	fins     map[finKey][]iface.MessageID // 等待发送的REPLICA_FIN
+	finsLock sync.Mutex
+
+	store *replicaStore
+
+	isExiting atomic.Bool
+	exitChan  chan struct{}
+}
+
+func NewManager(lmqd iface.ILmqDaemon) iface.IReplicationManager {
+	return &Manager{
+		lmqd:     lmqd,
+		alive:    map[string]bool{},
+		clients:  make(map[string]*replicaClient),
+		pending:  make(map[string]map[iface.MessageID]*pendingMessage),
+		fins:     make(map[finKey][]iface.MessageID),
+		store:    newReplicaStore(),
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (m *Manager) Start() {
+	go m.loop()
+}
+
+func (m *Manager) Close() {
+	if !m.isExiting.CompareAndSwap(false, true) {
+		return
+	}
+
+	close(m.exitChan)
+
+	m.clientsLock.Lock()
+	for _, rc := range m.clients {
+		rc.close()
+	}
+	m.clientsLock.Unlock()
+}
+
+func (m *Manager) loop() {
+	checkTicker := time.NewTicker(checkInterval)
+	finTicker := time.NewTicker(finFlushInterval)
+	defer checkTicker.Stop()
+	defer finTicker.Stop()
+
+	m.refreshNodes()
+
+	for {
+		select {
+		case <-checkTicker.C:
+			if !m.refreshNodes() {
+				continue
+			}
+			m.checkPrimaries()
+		case <-finTicker.C:
+			m.flushFins()
+		case <-m.exitChan:
+			return
+		}
+	}
+}
+
+// refreshNodes 从lookup中获取存活的lmqd节点，返回是否获取成功
+func (m *Manager) refreshNodes() bool {
+	self, nodes, err := m.lmqd.GetLookupManager().GetLookupNodes()
+	if err != nil {
+		logger.Warnf("replication get lmqd nodes from lookup failed, err: %s", err.Error())
+		return false
+	}
+
+	alive := make(map[string]bool, len(nodes))
+	others := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		alive[node] = true
+		if node != self {
+			others = append(others, node)
+		}
+	}
+
+	m.nodeLock.Lock()
+	m.self = self
+	m.nodes = others
+	m.alive = alive
+	m.nodeLock.Unlock()
+
+	return true
+}
+
+// checkPrimaries 检查副本对应的主节点是否存活，接管已经不可用的主节点
+func (m *Manager) checkPrimaries() {
+	m.nodeLock.RLock()
+	self, alive := m.self, m.alive
+	m.nodeLock.RUnlock()
+
+	if self == "" {
+		return
+	}
+
+	for _, primary := range m.store.check(self, alive, maxMisses) {
+		// lookup中没有主节点不代表主节点已经不可用（例如和lookup之间的网络抖动），还能连接到主节点时不接管
+		if probe(primary) {
+			logger.Warnf("primary(%s) is missing in lookup but still reachable, skip taking over", primary)
+			m.store.resetMisses(primary)
+			continue
+		}
+
+		count, err := m.TakeOver(primary)
+		if err != nil {
+			logger.Errorf("replica take over primary(%s) failed, err: %s", primary, err.Error())
+			continue
+		}
+		logger.Infof("replica take over primary(%s), %d messages are published", primary, count)
+	}
+}
+
+// selectReplicas 为topic选出副本节点，使用rendezvous hash，同一个topic在节点不变时总是选出相同的副本节点
+func (m *Manager) selectReplicas(topicName string) (string, []string) {
+	m.nodeLock.RLock()
+	self := m.self
+	nodes := make([]string, len(m.nodes))
+	copy(nodes, m.nodes)
+	m.nodeLock.RUnlock()
+
+	weight := func(node string) uint64 {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(topicName))
+		_, _ = h.Write([]byte(node))
+		return h.Sum64()
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return weight(nodes[i]) > weight(nodes[j])
+	})
+
+	if factor := config.GetLmqdConfig().ReplicationFactor; len(nodes) > factor {
+		nodes = nodes[:factor]
+	}
+
+	return self, nodes
+}
+
+func (m *Manager) getClient(address string) (*replicaClient, error) {
+	m.clientsLock.Lock()
+	defer m.clientsLock.Unlock()
+
+	if rc, ok := m.clients[address]; ok && !rc.closed() {
+		return rc, nil
+	}
+
+	rc, err := newReplicaClient(address)
+	if err != nil {
+		return nil, err
+	}
+	m.clients[address] = rc
+
+	return rc, nil
+}
+
+// Replicate 将消息复制到副本节点，等待足够数量的副本确认
+func (m *Manager) Replicate(topicName string, msg iface.IMessage) (err error) {
+	factor := config.GetLmqdConfig().ReplicationFactor
+	if factor <= 0 || m.isExiting.Load() || utils.IsEphemeralName(topicName) {
+		// 临时topic只保存在内存中，不复制
+		return nil
+	}
+
+	acks := config.GetLmqdConfig().ReplicationAcks
+	if acks > factor {
+		acks = factor
+	}
+
+	self, replicas := m.selectReplicas(topicName)
+	if self == "" || len(replicas) < acks {
+		return e.ErrNotEnoughReplicas
+	}
+
+	data, err := message.ConvertMessageToBytes(msg)
+	if err != nil {
+		return err
+	}
+	requestData, err := json.Marshal(&protocol.RequestBody{
+		TopicName:   topicName,
+		MessageData: data,
+		Primary:     self,
+		Replicas:    replicas,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 记录等待完成的消息，之后通知副本节点删除，记录已满时拒绝发布，避免副本节点中的消息永远不会被删除
+	m.pendingLock.Lock()
+	if m.pendingSize >= maxPending {
+		m.pendingLock.Unlock()
+		return e.ErrTooManyPendingReplicas
+	}
+	if _, ok := m.pending[topicName]; !ok {
+		m.pending[topicName] = make(map[iface.MessageID]*pendingMessage)
+	}
+	m.pending[topicName][msg.GetID()] = &pendingMessage{
+		replicas: replicas,
+	}
+	m.pendingSize++
+	m.pendingLock.Unlock()
+
+	defer func() {
+		if err != nil {
+			// 复制失败，消息不会被发布，通知已经保存的副本节点删除
+			m.Abort(topicName, msg.GetID())
+		}
+	}()
+
+	// 发送到所有的副本节点
+	results := make(chan error, len(replicas))
+	for _, replica := range replicas {
+		rc, err := m.getClient(replica)
+		if err != nil {
+			results <- err
+			continue
+		}
+
+		waiter, err := rc.replicate(requestData)
+		if err != nil {
+			results <- err
+			continue
+		}
+
+		go func(waiter chan error) {
+			results <- <-waiter
+		}(waiter)
+	}
+
+	// 等待副本确认
+	if acks <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(config.GetLmqdConfig().ReplicationTimeout)
+	defer timer.Stop()
+
+	confirmed, failed := 0, 0
+	for confirmed < acks {
+		select {
+		case resultErr := <-results:
+			if resultErr != nil {
+				logger.Warnf("replicate message of topic(%s) failed, err: %s", topicName, resultErr.Error())
+				failed++
+				if len(replicas)-failed < acks {
+					return e.ErrNotEnoughReplicas
+				}
+				continue
+			}
+			confirmed++
+		case <-timer.C:
+			return e.ErrNotEnoughReplicas
+		case <-m.exitChan:
+			return e.ErrNotEnoughReplicas
+		}
+	}
+
+	return nil
+}
+
+// Track 记录消息被投递到了哪些channel中
+func (m *Manager) Track(topicName string, msgID iface.MessageID, channelNames []string) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	p, ok := m.pending[topicName][msgID]
+	if !ok || p.channels != nil {
+		return
+	}
+	if len(channelNames) == 0 {
+		m.removePending(topicName, msgID, p)
+		return
+	}
+	p.channels = make(map[string]struct{}, len(channelNames))
+	for _, channelName := range channelNames {
+		p.channels[channelName] = struct{}{}
+	}
+}
+
+// Finish 某个channel完成了消息，所有channel都完成之后通知副本节点删除
+func (m *Manager) Finish(topicName, channelName string, msgID iface.MessageID) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	p, ok := m.pending[topicName][msgID]
+	if !ok || p.channels == nil {
+		return
+	}
+	delete(p.channels, channelName)
+	if len(p.channels) == 0 {
+		m.removePending(topicName, msgID, p)
+	}
+}
+
+// Abort 消息复制之后发布失败，通知副本节点删除
+func (m *Manager) Abort(topicName string, msgID iface.MessageID) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	if p, ok := m.pending[topicName][msgID]; ok {
+		m.removePending(topicName, msgID, p)
+	}
+}
+
+// Drop 消息被清空时调用，channelName为空时表示topic中还没有投递到channel的消息，否则表示该channel中的消息
+func (m *Manager) Drop(topicName, channelName string) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	for msgID, p := range m.pending[topicName] {
+		if channelName == "" {
+			if p.channels == nil {
+				m.removePending(topicName, msgID, p)
+			}
+			continue
+		}
+
+		if _, ok := p.channels[channelName]; !ok {
+			continue
+		}
+		delete(p.channels, channelName)
+		if len(p.channels) == 0 {
+			m.removePending(topicName, msgID, p)
+		}
+	}
+}
+
+// removePending 删除等待完成的消息，并加入到REPLICA_FIN的队列中，调用时已经加锁
+func (m *Manager) removePending(topicName string, msgID iface.MessageID, p *pendingMessage) {
+	delete(m.pending[topicName], msgID)
+	if len(m.pending[topicName]) == 0 {
+		delete(m.pending, topicName)
+	}
+	m.pendingSize--
+
+	m.finsLock.Lock()
+	for _, replica := range p.replicas {
+		key := finKey{replica: replica, topicName: topicName}
+		m.fins[key] = append(m.fins[key], msgID)
+	}
+	m.finsLock.Unlock()
+}
+
+// flushFins 批量发送REPLICA_FIN
+func (m *Manager) flushFins() {
+	m.finsLock.Lock()
+	fins := m.fins
+	m.fins = make(map[finKey][]iface.MessageID)
+	m.finsLock.Unlock()
+
+	m.nodeLock.RLock()
+	self := m.self
+	m.nodeLock.RUnlock()
+
+	for key, msgIDs := range fins {
+		rc, err := m.getClient(key.replica)
+		if err != nil {
+			continue
+		}
+
+		for start := 0; start < len(msgIDs); start += maxFinBatchSize {
+			end := start + maxFinBatchSize
+			if end > len(msgIDs) {
+				end = len(msgIDs)
+			}
+
+			data, err := json.Marshal(&protocol.RequestBody{
+				TopicName:  key.topicName,
+				Primary:    self,
+				MessageIDs: msgIDs[start:end],
+			})
+			if err != nil {
+				continue
+			}
+
+			if err = rc.send(protocol.ReplicaFinID, data); err != nil {
+				logger.Warnf("send replica fin to %s failed, err: %s", key.replica, err.Error())
+				break
+			}
+		}
+	}
+}
+
+// StoreReplica 保存主节点复制过来的消息
+func (m *Manager) StoreReplica(primary string, replicas []string, topicName string, msg iface.IMessage) {
+	m.store.put(primary, replicas, topicName, msg)
+}
+
+// FinishReplica 删除主节点已经完成的消息
+func (m *Manager) FinishReplica(primary string, topicName string, msgIDs []iface.MessageID) {
+	m.store.finish(primary, topicName, msgIDs)
+}
+
+// TakeOver 接管主节点，将副本中的消息按照顺序发布到本节点的topic中
+// 消息发布成功之后才从副本中删除，发布失败时还没有发布的消息留在副本中，之后可以再次接管
+func (m *Manager) TakeOver(primary string) (int, error) {
+	count := 0
+	for topicName, messages := range m.store.messages(primary) {
+		topic, err := m.lmqd.GetTopic(topicName)
+		if err != nil {
+			return count, err
+		}
+
+		for _, msg := range messages {
+			err = topic.PutMessage(msg)
+			if err != nil {
+				return count, err
+			}
+			m.store.finish(primary, topicName, []iface.MessageID{msg.GetID()})
+			count++
+		}
+	}
+
+	// 所有的消息都已经发布，不再记录这个主节点
+	m.store.removeIfEmpty(primary)
+
+	return count, nil
+}
+
+// probe 直接连接主节点，检查主节点是否存活
+func probe(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+
+	return true
+}
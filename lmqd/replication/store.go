@@ -0,0 +1,181 @@
+package replication
+
+import (
+	"container/list"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/logger"
+	"sync"
+)
+
+/*
+	副本节点保存主节点复制过来的消息，副本只保存在内存中
+	每个主节点的每个topic都有一个按照发布顺序排列的消息队列，超过maxReplicaMessages时丢弃最早的消息
+*/
+
+const maxReplicaMessages = 1 << 20
+
+// topicReplica 一个主节点中一个topic的副本
+type topicReplica struct {
+	messages *list.List                        // 按照复制顺序排列的消息
+	index    map[iface.MessageID]*list.Element // 消息ID到消息的映射
+}
+
+// primaryReplica 一个主节点的副本
+type primaryReplica struct {
+	replicas []string                 // 保存该主节点消息的副本节点（按照优先级排列），用于选出接管主节点的副本节点
+	topics   map[string]*topicReplica // topic name -> topic副本
+	misses   int                      // 连续多少次没有在lookup中发现主节点
+}
+
+type replicaStore struct {
+	sync.Mutex
+	primaries map[string]*primaryReplica // 主节点地址 -> 主节点的副本
+}
+
+func newReplicaStore() *replicaStore {
+	return &replicaStore{
+		primaries: make(map[string]*primaryReplica),
+	}
+}
+
+// put 保存一条消息
+func (store *replicaStore) put(primary string, replicas []string, topicName string, msg iface.IMessage) {
+	store.Lock()
+	defer store.Unlock()
+
+	p, ok := store.primaries[primary]
+	if !ok {
+		p = &primaryReplica{
+			topics: make(map[string]*topicReplica),
+		}
+		store.primaries[primary] = p
+	}
+	p.replicas = replicas
+	p.misses = 0
+
+	t, ok := p.topics[topicName]
+	if !ok {
+		t = &topicReplica{
+			messages: list.New(),
+			index:    make(map[iface.MessageID]*list.Element),
+		}
+		p.topics[topicName] = t
+	}
+
+	if _, exist := t.index[msg.GetID()]; exist {
+		// 重复复制
+		return
+	}
+	t.index[msg.GetID()] = t.messages.PushBack(msg)
+
+	// 超过上限，丢弃最早的消息
+	if t.messages.Len() > maxReplicaMessages {
+		front := t.messages.Front()
+		t.messages.Remove(front)
+		delete(t.index, front.Value.(iface.IMessage).GetID())
+		logger.Warnf("replica of topic(%s) from primary(%s) is full, drop the oldest message", topicName, primary)
+	}
+}
+
+// finish 删除已经完成的消息
+func (store *replicaStore) finish(primary string, topicName string, msgIDs []iface.MessageID) {
+	store.Lock()
+	defer store.Unlock()
+
+	p, ok := store.primaries[primary]
+	if !ok {
+		return
+	}
+	t, ok := p.topics[topicName]
+	if !ok {
+		return
+	}
+
+	for _, msgID := range msgIDs {
+		if e, exist := t.index[msgID]; exist {
+			t.messages.Remove(e)
+			delete(t.index, msgID)
+		}
+	}
+
+	if t.messages.Len() == 0 {
+		delete(p.topics, topicName)
+	}
+}
+
+// messages 按照复制的顺序返回一个主节点中每个topic的副本消息，不会删除这些消息
+func (store *replicaStore) messages(primary string) map[string][]iface.IMessage {
+	store.Lock()
+	defer store.Unlock()
+
+	p, ok := store.primaries[primary]
+	if !ok {
+		return nil
+	}
+
+	messages := make(map[string][]iface.IMessage, len(p.topics))
+	for topicName, t := range p.topics {
+		msgs := make([]iface.IMessage, 0, t.messages.Len())
+		for elem := t.messages.Front(); elem != nil; elem = elem.Next() {
+			msgs = append(msgs, elem.Value.(iface.IMessage))
+		}
+		messages[topicName] = msgs
+	}
+
+	return messages
+}
+
+// removeIfEmpty 主节点的副本中已经没有消息时，删除这个主节点
+func (store *replicaStore) removeIfEmpty(primary string) {
+	store.Lock()
+	defer store.Unlock()
+
+	if p, ok := store.primaries[primary]; ok && len(p.topics) == 0 {
+		delete(store.primaries, primary)
+	}
+}
+
+// resetMisses 主节点仍然存活，重新计算没有在lookup中发现主节点的次数
+func (store *replicaStore) resetMisses(primary string) {
+	store.Lock()
+	defer store.Unlock()
+
+	if p, ok := store.primaries[primary]; ok {
+		p.misses = 0
+	}
+}
+
+// check 根据lookup中存活的节点，返回需要本节点接管的主节点，并删除交由其他副本节点接管的主节点
+func (store *replicaStore) check(self string, alive map[string]bool, maxMisses int) []string {
+	store.Lock()
+	defer store.Unlock()
+
+	var takeovers []string
+	for primary, p := range store.primaries {
+		if alive[primary] {
+			p.misses = 0
+			continue
+		}
+
+		p.misses++
+		if p.misses < maxMisses {
+			continue
+		}
+
+		// 主节点不可用了，由第一个存活的副本节点接管
+		for _, replica := range p.replicas {
+			if replica == self {
+				takeovers = append(takeovers, primary)
+				break
+			}
+			if alive[replica] {
+				// 由其他的副本节点接管
+				logger.Infof("primary(%s) is gone, replica(%s) will take over it", primary, replica)
+				delete(store.primaries, primary)
+				break
+			}
+		}
+	}
+
+	return takeovers
+}
@@ -0,0 +1,418 @@
+package retention
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	retention log 保留topic中发布过的消息，用于从某个时间点或者某个消息ID开始重放消息
+	由多个segment文件组成，每个segment文件中的每一条记录为：消息长度(4) + 消息
+	segment文件写满或者写入的时间超过保留时长的1/10（最少1秒，最多1分钟）之后切换到下一个segment
+	segment文件的最后修改时间超过保留时长之后，整个segment文件会被删除（包括正在写入的segment）
+*/
+
+const (
+	minCleanInterval = time.Second
+	maxCleanInterval = time.Minute
+)
+
+var ErrLogClosed = errors.New("retention log is closed")
+
+type segment struct {
+	index     int64
+	size      int64
+	createdAt time.Time // 开始写入的时间，用于按照时间切换segment
+	modTime   time.Time
+	filename  string
+}
+
+// Log 一个topic的retention log
+type Log struct {
+	sync.RWMutex
+
+	name            string        // topic名称
+	dataPath        string        // 数据路径
+	window          time.Duration // 消息保留时长
+	maxBytesPerFile int64         // 每一个segment文件的最大长度
+	minMsgSize      int32
+	maxMsgSize      int32
+
+	segments  []*segment // 按照index从小到大排列
+	writeFile *os.File
+	writeBuf  bytes.Buffer
+	isClosed  bool
+
+	exitChan chan struct{}
+}
+
+func NewLog(name string, dataPath string, window time.Duration, maxBytesPerFile int64, minMsgSize int32, maxMsgSize int32) (*Log, error) {
+	log := &Log{
+		name:            name,
+		dataPath:        dataPath,
+		window:          window,
+		maxBytesPerFile: maxBytesPerFile,
+		minMsgSize:      minMsgSize,
+		maxMsgSize:      maxMsgSize,
+		exitChan:        make(chan struct{}),
+	}
+
+	// 检索已经存在的segment文件
+	err := log.retrieveSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	go log.cleanLoop()
+
+	return log, nil
+}
+
+// SetWindow 修改消息保留时长
+func (log *Log) SetWindow(window time.Duration) {
+	log.Lock()
+	defer log.Unlock()
+
+	log.window = window
+}
+
+// Append 在log的末尾追加一条消息
+func (log *Log) Append(msg iface.IMessage) error {
+	data, err := message.ConvertMessageToBytes(msg)
+	if err != nil {
+		return err
+	}
+
+	log.Lock()
+	defer log.Unlock()
+
+	if log.isClosed {
+		return ErrLogClosed
+	}
+
+	totalBytes := int64(4 + len(data))
+
+	// 当前segment写满了，或者写入的时间太长，切换到下一个segment
+	last := log.lastSegment()
+	if last == nil || (last.size > 0 && (last.size+totalBytes > log.maxBytesPerFile ||
+		time.Since(last.createdAt) >= segmentInterval(log.window))) {
+		err = log.rotate()
+		if err != nil {
+			return err
+		}
+		last = log.lastSegment()
+	}
+
+	if log.writeFile == nil {
+		log.writeFile, err = os.OpenFile(last.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.writeBuf.Reset()
+	_ = binary.Write(&log.writeBuf, binary.BigEndian, int32(len(data)))
+	_, _ = log.writeBuf.Write(data)
+	_, err = log.writeFile.Write(log.writeBuf.Bytes())
+	if err != nil {
+		_ = log.writeFile.Close()
+		log.writeFile = nil
+		return err
+	}
+
+	last.size += totalBytes
+	last.modTime = time.Now()
+
+	return nil
+}
+
+// Replay 从时间戳不小于fromTimestamp（单位纳秒）、或者ID不小于fromID的第一条消息开始，按顺序遍历保留的消息
+// fromID为空时使用fromTimestamp
+func (log *Log) Replay(fromTimestamp int64, fromID iface.MessageID, fn func(msg iface.IMessage) error) error {
+	log.RLock()
+	if log.isClosed {
+		log.RUnlock()
+		return ErrLogClosed
+	}
+	// 复制一份segment信息，遍历时不持有锁
+	segments := make([]segment, len(log.segments))
+	for i, s := range log.segments {
+		segments[i] = *s
+	}
+	log.RUnlock()
+
+	started := false
+	isStart := func(msg iface.IMessage) bool {
+		if fromID != (iface.MessageID{}) {
+			// message ID是按照时间递增的，可以直接比较大小
+			return bytes.Compare(msg.GetID().Bytes(), fromID.Bytes()) >= 0
+		}
+
+		return msg.GetTimestamp() >= fromTimestamp
+	}
+
+	for _, s := range segments {
+		if !started && fromID == (iface.MessageID{}) && s.modTime.UnixNano() < fromTimestamp {
+			// 整个segment中的消息都早于fromTimestamp，跳过
+			continue
+		}
+
+		err := log.scanSegment(s, func(msg iface.IMessage) error {
+			if !started && !isStart(msg) {
+				return nil
+			}
+			started = true
+
+			return fn(msg)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close 关闭log
+func (log *Log) Close() error {
+	return log.exit(false)
+}
+
+// Delete 关闭log，并删除所有的segment文件
+func (log *Log) Delete() error {
+	return log.exit(true)
+}
+
+func (log *Log) exit(deleted bool) error {
+	log.Lock()
+	defer log.Unlock()
+
+	if log.isClosed {
+		return ErrLogClosed
+	}
+	log.isClosed = true
+	close(log.exitChan)
+
+	var err error
+	if log.writeFile != nil {
+		err = log.writeFile.Sync()
+		_ = log.writeFile.Close()
+		log.writeFile = nil
+	}
+
+	if deleted {
+		for _, s := range log.segments {
+			innerErr := os.Remove(s.filename)
+			if innerErr != nil && !os.IsNotExist(innerErr) {
+				logger.Errorf("RetentionLog(%s) failed to remove segment - %s", log.name, innerErr)
+				err = innerErr
+			}
+		}
+		log.segments = nil
+	}
+
+	return err
+}
+
+func (log *Log) segmentFileName(index int64) string {
+	return path.Join(log.dataPath, fmt.Sprintf("%s.retention.%06d.dat", log.name, index))
+}
+
+// retrieveSegments 检索数据路径下已经存在的segment文件
+func (log *Log) retrieveSegments() error {
+	prefix := log.name + ".retention."
+	filenames, err := filepath.Glob(path.Join(log.dataPath, prefix+"*.dat"))
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range filenames {
+		indexStr := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(filename), prefix), ".dat")
+		index, err := strconv.ParseInt(indexStr, 10, 64)
+		if err != nil {
+			// 不是这个topic的segment文件
+			continue
+		}
+
+		stat, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+
+		log.segments = append(log.segments, &segment{
+			index:     index,
+			size:      stat.Size(),
+			createdAt: stat.ModTime(),
+			modTime:   stat.ModTime(),
+			filename:  filename,
+		})
+	}
+
+	sort.Slice(log.segments, func(i, j int) bool {
+		return log.segments[i].index < log.segments[j].index
+	})
+
+	return nil
+}
+
+func (log *Log) lastSegment() *segment {
+	if len(log.segments) == 0 {
+		return nil
+	}
+
+	return log.segments[len(log.segments)-1]
+}
+
+// rotate 创建一个新的segment用于写入，调用时已经加锁
+func (log *Log) rotate() error {
+	if log.writeFile != nil {
+		_ = log.writeFile.Sync()
+		_ = log.writeFile.Close()
+		log.writeFile = nil
+	}
+
+	var index int64
+	if last := log.lastSegment(); last != nil {
+		index = last.index + 1
+	}
+
+	now := time.Now()
+	log.segments = append(log.segments, &segment{
+		index:     index,
+		createdAt: now,
+		modTime:   now,
+		filename:  log.segmentFileName(index),
+	})
+
+	return nil
+}
+
+// scanSegment 遍历一个segment中的所有消息
+func (log *Log) scanSegment(s segment, fn func(msg iface.IMessage) error) error {
+	f, err := os.OpenFile(s.filename, os.O_RDONLY, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 已经被清理掉了
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(io.LimitReader(f, s.size))
+	for {
+		var msgSize int32
+		err = binary.Read(reader, binary.BigEndian, &msgSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msgSize < log.minMsgSize || msgSize > log.maxMsgSize {
+			return fmt.Errorf("invalid message read size (%d)", msgSize)
+		}
+
+		data := make([]byte, msgSize)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return err
+		}
+
+		msg, err := message.ConvertBytesToMessage(data)
+		if err != nil {
+			return err
+		}
+
+		err = fn(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanLoop 定期删除超过保留时长的segment
+func (log *Log) cleanLoop() {
+	ticker := time.NewTicker(log.cleanInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			log.clean()
+			ticker.Reset(log.cleanInterval())
+		case <-log.exitChan:
+			return
+		}
+	}
+}
+
+func (log *Log) cleanInterval() time.Duration {
+	log.RLock()
+	defer log.RUnlock()
+
+	return segmentInterval(log.window)
+}
+
+// segmentInterval 保留时长的1/10，限制在[minCleanInterval, maxCleanInterval]之间
+// 用作清理的间隔以及一个segment最长的写入时间
+func segmentInterval(window time.Duration) time.Duration {
+	interval := window / 10
+	if interval < minCleanInterval {
+		return minCleanInterval
+	}
+	if interval > maxCleanInterval {
+		return maxCleanInterval
+	}
+
+	return interval
+}
+
+// clean 删除最后修改时间超过保留时长的segment
+// 正在写入的segment超过保留时长没有写入时也会被删除，下一次写入时创建新的segment
+func (log *Log) clean() {
+	log.Lock()
+	defer log.Unlock()
+
+	if log.isClosed {
+		return
+	}
+
+	deadline := time.Now().Add(-log.window)
+	i := 0
+	for ; i < len(log.segments); i++ {
+		s := log.segments[i]
+		if s.modTime.After(deadline) {
+			break
+		}
+
+		if i == len(log.segments)-1 && log.writeFile != nil {
+			_ = log.writeFile.Close()
+			log.writeFile = nil
+		}
+
+		err := os.Remove(s.filename)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Errorf("RetentionLog(%s) failed to remove segment %s - %s", log.name, s.filename, err)
+			break
+		}
+	}
+
+	log.segments = log.segments[i:]
+}
@@ -0,0 +1,142 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/backup"
+)
+
+/*
+	关于导出、导入topic/channel的handler，文件路径为lmqd配置的备份目录（backup_path）中的相对路径
+*/
+
+// ExportTopicHandler 导出topic中还未投递的消息
+type ExportTopicHandler struct {
+	BaseHandler
+}
+
+func (handler *ExportTopicHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、file path
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	filePath, err := backup.ResolvePath(config.GetLmqdConfig().BackupPath, requestBody.FilePath)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 导出
+	header := backup.NewFileHeader(topic.GetName(), "")
+	count, err := backup.ExportToFile(filePath, header, topic.Export)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, map[string]interface{}{
+		"count": count,
+	})
+}
+
+// ExportChannelHandler 导出channel中还未投递的消息
+type ExportChannelHandler struct {
+	BaseHandler
+}
+
+func (handler *ExportChannelHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、channel name、file path
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	filePath, err := backup.ResolvePath(config.GetLmqdConfig().BackupPath, requestBody.FilePath)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(requestBody.ChannelName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 导出
+	header := backup.NewFileHeader(topic.GetName(), channel.GetName())
+	count, err := backup.ExportToFile(filePath, header, channel.Export)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, map[string]interface{}{
+		"count": count,
+	})
+}
+
+// ImportTopicHandler 将备份文件中的消息发布到topic中，消息使用新的ID，保留时间戳、尝试次数以及头部
+type ImportTopicHandler struct {
+	BaseHandler
+}
+
+func (handler *ImportTopicHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、file path
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	filePath, err := backup.ResolvePath(config.GetLmqdConfig().BackupPath, requestBody.FilePath)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 正在下线，拒绝导入
+	if err = drainingError(handler.LmqDaemon); err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 获取topic，不存在就新建一个
+	topic, err := handler.LmqDaemon.GetTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 导入
+	guidFactory := handler.LmqDaemon.GetGUIDFactory()
+	_, count, err := backup.ImportFromFile(filePath, func(msg iface.IMessage) error {
+		return topic.PutMessage(backup.Renew(msg, guidFactory.NewMessageID()))
+	})
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, map[string]interface{}{
+		"count": count,
+	})
+}
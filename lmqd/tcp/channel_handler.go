@@ -2,7 +2,12 @@ package tcp
 
 import (
 	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
+	channelpkg "github.com/dawnzzz/lmq/lmqd/channel"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/lmqd/options"
+	"github.com/dawnzzz/lmq/pkg/e"
 )
 
 /*
@@ -22,18 +27,111 @@ func (handler *CreateChannelHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
+	// 检查过滤条件、采样率、分发策略、订阅模式和死信配置
+	err = message.ValidateFilter(requestBody.Filter)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+	if requestBody.SampleRate != nil && (*requestBody.SampleRate < 0 || *requestBody.SampleRate > 100) {
+		_ = handler.SendErrResponse(request, e.ErrSampleRateInValid)
+		return
+	}
+	if requestBody.DispatchPolicy != nil {
+		err = channelpkg.ValidateDispatchPolicy(*requestBody.DispatchPolicy)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	}
+	if requestBody.SubscriptionMode != nil {
+		err = channelpkg.ValidateSubscriptionMode(*requestBody.SubscriptionMode)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	}
+	err = channelpkg.ValidateDeadLetter(requestBody.TopicName, requestBody.DeadLetter)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
 	// 创建新的channel
 	topic, err := handler.BaseHandler.LmqDaemon.GetTopic(requestBody.TopicName)
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
-	_, err = topic.GetChannel(requestBody.ChannelName)
+	err = options.Validate(topic.GetSettings().Overrides, requestBody.Overrides)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+	channel, err := topic.GetChannelWithSettings(requestBody.ChannelName, iface.ChannelSettings{Overrides: requestBody.Overrides})
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
 
+	// 设置过滤条件和采样率，只接收满足条件并且被采样的消息，设置分发策略、订阅模式、死信配置和overrides
+	if requestBody.Filter != nil || requestBody.SampleRate != nil || requestBody.DispatchPolicy != nil || requestBody.SubscriptionMode != nil || requestBody.DeadLetter != nil || requestBody.Overrides != nil {
+		settings := channel.GetSettings()
+		if requestBody.Overrides != nil {
+			settings.Overrides = requestBody.Overrides
+		}
+		if requestBody.Filter != nil {
+			settings.Filter = requestBody.Filter
+		}
+		if requestBody.SampleRate != nil {
+			settings.SampleRate = *requestBody.SampleRate
+		}
+		if requestBody.DispatchPolicy != nil {
+			settings.DispatchPolicy = *requestBody.DispatchPolicy
+		}
+		if requestBody.SubscriptionMode != nil {
+			settings.SubscriptionMode = *requestBody.SubscriptionMode
+		}
+		if requestBody.DeadLetter != nil {
+			settings.DeadLetter = requestBody.DeadLetter
+		}
+		err = channel.SetSettings(settings)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+
+		// 持久化元数据
+		err = handler.LmqDaemon.PersistMetaData()
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	}
+
+	// 开启ordered模式，相同ordering key的消息按顺序投递
+	if requestBody.Ordered && !channel.IsOrdered() {
+		settings := channel.GetSettings()
+		if settings.Priority {
+			// 已经开启优先级队列的channel不能开启ordered模式
+			_ = handler.SendErrResponse(request, e.ErrChannelIsPriority)
+			return
+		}
+		settings.Ordered = true
+		err = channel.SetSettings(settings)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+
+		// 持久化元数据
+		err = handler.LmqDaemon.PersistMetaData()
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	}
+
 	_ = handler.SendOkResponse(request)
 }
 
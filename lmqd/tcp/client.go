@@ -7,8 +7,12 @@ import (
 	"github.com/dawnzzz/lmq/internel/protocol"
 	"github.com/dawnzzz/lmq/lmqd/message"
 	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"reflect"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -24,15 +28,25 @@ type TcpClient struct {
 	Status    atomic.Uint32 // 客户端当前状态
 	IsPausing atomic.Bool   // 标记是否暂停
 
-	channel iface.IChannel // 订阅的通道
+	channels     map[string]iface.IChannel // 订阅的通道，topic name -> channel
+	channelsLock sync.RWMutex
+	generation   uint64 // 取消订阅之后加一，连接断开之后执行的回调不会再订阅channel，由channelsLock保护
+	pattern      string // 通配符订阅时的topic通配符，匹配的新建topic也会被订阅
+	watcherID    uint64 // 通配符订阅时监听新建topic的ID
+	weight       int    // 订阅时指定的权重，用于channel的weighted分发策略
 
 	ReadyCount    atomic.Int64 // 准备好接收的message数量
 	InFlightCount atomic.Int64 // in-flight消息数量
 	RequeueCount  atomic.Int64 // requeue消息数量
 	MessageCount  atomic.Int64 // 发布消息的数量
 
+	lastActiveAt atomic.Int64 // 最后一次收到客户端心跳、RDY、FIN或者REQ的时间（单位纳秒）
+
 	updateReadyChan chan struct{}
-	closingChan     chan struct{}
+	closingChan     chan struct{} // 关闭客户端时close
+
+	pumpLock     sync.Mutex
+	pumpDoneChan chan struct{} // message pump退出时close，没有开启message pump时为nil
 }
 
 // 对象池
@@ -46,25 +60,44 @@ func NewTcpClient(id uint64, conn serveriface.IConnection) *TcpClient {
 	client.ID = id
 	client.connection = conn
 	client.Status.Store(statusInit)
+	client.channels = make(map[string]iface.IChannel)
 	client.closingChan = make(chan struct{})
 	client.updateReadyChan = make(chan struct{}, 1)
+	client.lastActiveAt.Store(time.Now().UnixNano())
 
 	return client
 }
 
+// DestroyTcpClient 等待message pump退出之后恢复client的状态，放回对象池，调用之前需要先取消订阅
 func DestroyTcpClient(client *TcpClient) {
+	_ = client.Close()
+
+	client.pumpLock.Lock()
+	pumpDoneChan := client.pumpDoneChan
+	client.pumpDoneChan = nil
+	client.pumpLock.Unlock()
+	if pumpDoneChan != nil {
+		<-pumpDoneChan
+	}
+
 	// 恢复client的状态
 	client.ID = 0
 	client.connection = nil
 	client.Status.Store(statusClosing)
 	client.IsPausing.Store(false)
 
-	client.channel = nil
+	client.channelsLock.Lock()
+	client.channels = nil
+	client.channelsLock.Unlock()
+	client.pattern = ""
+	client.watcherID = 0
+	client.weight = 0
 
 	client.ReadyCount.Store(0)
 	client.InFlightCount.Store(0)
 	client.RequeueCount.Store(0)
 	client.MessageCount.Store(0)
+	client.lastActiveAt.Store(0)
 
 	client.closingChan = nil
 	client.updateReadyChan = nil
@@ -72,28 +105,32 @@ func DestroyTcpClient(client *TcpClient) {
 	clientPool.Put(client)
 }
 
+// Pause channel暂停时调用，message pump不再从暂停的channel中读取消息
 func (tcpClient *TcpClient) Pause() {
-	tcpClient.IsPausing.Store(true)
+	tcpClient.tryUpdateReady()
 }
 
 func (tcpClient *TcpClient) UnPause() {
-	tcpClient.IsPausing.Store(false)
+	tcpClient.tryUpdateReady()
 }
 
 func (tcpClient *TcpClient) Close() error {
-	select {
-	case tcpClient.closingChan <- struct{}{}:
-	default:
+	if tcpClient.Status.Swap(statusClosing) != statusClosing {
+		// 通知message pump退出
+		close(tcpClient.closingChan)
 	}
 
-	tcpClient.Status.Store(statusClosing)
-
 	return nil
 }
 
-func (tcpClient *TcpClient) Empty() {
-	//TODO implement me
-	panic("implement me")
+// Empty channel清空时调用，channel中的in-flight消息已经被丢弃，不再计入in-flight数量，客户端可以重新接收消息
+func (tcpClient *TcpClient) Empty(inFlight int64) {
+	if inFlight <= 0 {
+		return
+	}
+
+	tcpClient.InFlightCount.Add(-inFlight)
+	tcpClient.tryUpdateReady()
 }
 
 func (tcpClient *TcpClient) tryUpdateReady() {
@@ -102,6 +139,16 @@ func (tcpClient *TcpClient) tryUpdateReady() {
 	default:
 
 	}
+
+	// 设置了分发策略的channel需要重新检查客户端是否可以接收消息
+	for _, channel := range tcpClient.getChannels() {
+		channel.NotifyReady()
+	}
+}
+
+// Wakeup channel的分发方式变化时调用，唤醒message pump从新的chan中读取消息
+func (tcpClient *TcpClient) Wakeup() {
+	tcpClient.tryUpdateReady()
 }
 
 func (tcpClient *TcpClient) UpdateReady(readyCount int64) {
@@ -113,10 +160,6 @@ func (tcpClient *TcpClient) UpdateReady(readyCount int64) {
 
 // IsReadyRecv 客户端是否已经可以接收消息
 func (tcpClient *TcpClient) IsReadyRecv() bool {
-	if tcpClient.channel == nil {
-		return false
-	}
-
 	if tcpClient.Status.Load() == statusClosing {
 		return false
 	}
@@ -133,6 +176,43 @@ func (tcpClient *TcpClient) IsReadyRecv() bool {
 	return true
 }
 
+// RecvCapacity 还可以接收的消息数量
+func (tcpClient *TcpClient) RecvCapacity() int64 {
+	if !tcpClient.IsReadyRecv() {
+		return 0
+	}
+
+	return tcpClient.ReadyCount.Load() - tcpClient.InFlightCount.Load()
+}
+
+func (tcpClient *TcpClient) GetInFlightCount() int64 {
+	return tcpClient.InFlightCount.Load()
+}
+
+// GetWeight 订阅时指定的权重，没有指定时为1
+func (tcpClient *TcpClient) GetWeight() int {
+	if tcpClient.weight <= 0 {
+		return 1
+	}
+
+	return tcpClient.weight
+}
+
+// touch 收到客户端的心跳、RDY、FIN或者REQ时更新活跃时间
+func (tcpClient *TcpClient) touch() {
+	tcpClient.lastActiveAt.Store(time.Now().UnixNano())
+}
+
+// IsAlive 客户端的心跳是否超时
+func (tcpClient *TcpClient) IsAlive() bool {
+	timeout := config.GetLmqdConfig().ClientHeartbeatTimeout
+	if timeout <= 0 {
+		return true
+	}
+
+	return time.Since(time.Unix(0, tcpClient.lastActiveAt.Load())) < timeout
+}
+
 // IsReadyPub 客户端是否已经可以发布消息
 func (tcpClient *TcpClient) IsReadyPub() bool {
 	if tcpClient.Status.Load() == statusClosing {
@@ -159,6 +239,113 @@ func (tcpClient *TcpClient) IsReadySub() bool {
 	return true
 }
 
+// getGeneration 获取当前的订阅代数，订阅channel时传入
+func (tcpClient *TcpClient) getGeneration() uint64 {
+	tcpClient.channelsLock.RLock()
+	defer tcpClient.channelsLock.RUnlock()
+
+	return tcpClient.generation
+}
+
+// addChannel 订阅一个channel，每个topic只会订阅一个channel，客户端已经关闭或者取消订阅时返回错误
+func (tcpClient *TcpClient) addChannel(channel iface.IChannel, generation uint64) error {
+	tcpClient.channelsLock.Lock()
+	if tcpClient.Status.Load() == statusClosing || tcpClient.channels == nil || tcpClient.generation != generation {
+		tcpClient.channelsLock.Unlock()
+		return e.ErrClientIsClosing
+	}
+	if _, ok := tcpClient.channels[channel.GetTopicName()]; ok {
+		tcpClient.channelsLock.Unlock()
+		return nil
+	}
+
+	err := channel.AddClient(tcpClient.ID, tcpClient)
+	if err != nil {
+		tcpClient.channelsLock.Unlock()
+		return err
+	}
+	tcpClient.channels[channel.GetTopicName()] = channel
+	tcpClient.channelsLock.Unlock()
+
+	// 通知message pump读取新的channel
+	tcpClient.tryUpdateReady()
+
+	return nil
+}
+
+// RemoveChannel channel退出时调用，不再从这个channel中接收消息，不是通配符订阅并且没有订阅其他channel时关闭客户端
+func (tcpClient *TcpClient) RemoveChannel(channel iface.IChannel) {
+	tcpClient.channelsLock.Lock()
+	if c, ok := tcpClient.channels[channel.GetTopicName()]; ok && c == channel {
+		delete(tcpClient.channels, channel.GetTopicName())
+	}
+	count := len(tcpClient.channels)
+	tcpClient.channelsLock.Unlock()
+
+	if tcpClient.pattern == "" && count == 0 {
+		_ = tcpClient.Close()
+		return
+	}
+
+	tcpClient.tryUpdateReady()
+}
+
+// getChannels 获取所有订阅的channel，按照topic name排序
+func (tcpClient *TcpClient) getChannels() []iface.IChannel {
+	tcpClient.channelsLock.RLock()
+	defer tcpClient.channelsLock.RUnlock()
+
+	channels := make([]iface.IChannel, 0, len(tcpClient.channels))
+	for _, channel := range tcpClient.channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].GetTopicName() < channels[j].GetTopicName()
+	})
+
+	return channels
+}
+
+// unsubscribe 连接断开时取消监听新建的topic，并从所有订阅的channel中移除
+func (tcpClient *TcpClient) unsubscribe(lmqd iface.ILmqDaemon) {
+	if tcpClient.watcherID != 0 {
+		lmqd.UnWatchTopics(tcpClient.watcherID)
+	}
+
+	// 之后不会再订阅新的channel
+	tcpClient.channelsLock.Lock()
+	tcpClient.generation++
+	tcpClient.channelsLock.Unlock()
+
+	for _, channel := range tcpClient.getChannels() {
+		channel.RemoveClient(tcpClient.ID)
+	}
+}
+
+// finishMessage 在订阅的channel中结束消息的投递
+func (tcpClient *TcpClient) finishMessage(messageID iface.MessageID) error {
+	err := e.ErrMessageIDIsNotInFlight
+	for _, channel := range tcpClient.getChannels() {
+		if err = channel.FinishMessage(tcpClient.ID, messageID); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// requeueMessage 在订阅的channel中将消息重新入队
+func (tcpClient *TcpClient) requeueMessage(messageID iface.MessageID) error {
+	err := e.ErrMessageIDIsNotInFlight
+	for _, channel := range tcpClient.getChannels() {
+		if err = channel.RequeueMessage(tcpClient.ID, messageID); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
 func (tcpClient *TcpClient) TimeoutMessage() {
 	tcpClient.InFlightCount.Add(-1)
 	tcpClient.tryUpdateReady()
@@ -177,47 +364,118 @@ func (tcpClient *TcpClient) sendMessage(message iface.IMessage) error {
 	return nil
 }
 
-func (tcpClient *TcpClient) messagePump() {
-	var memoryMsgChan chan iface.IMessage
-	var backendMsgChan <-chan []byte
-	var msg iface.IMessage
-	var subChannel iface.IChannel
+// startMessagePump 订阅之后开启message pump，客户端已经关闭时返回错误
+func (tcpClient *TcpClient) startMessagePump() error {
+	tcpClient.pumpLock.Lock()
+	defer tcpClient.pumpLock.Unlock()
+
+	if !tcpClient.Status.CompareAndSwap(statusInit, statusSubscribed) {
+		return e.ErrClientIsClosing
+	}
+
+	pumpDoneChan := make(chan struct{})
+	tcpClient.pumpDoneChan = pumpDoneChan
+	go func() {
+		defer close(pumpDoneChan)
+		tcpClient.messagePump()
+	}()
 
+	return nil
+}
+
+// messagePump 从所有订阅的channel中读取消息发送给客户端
+// 只订阅了一个channel时使用静态的select，通配符订阅或者订阅了多个channel时channel的数量不固定，使用reflect.Select
+func (tcpClient *TcpClient) messagePump() {
 	for {
-		if !tcpClient.IsReadyRecv() {
-			memoryMsgChan = nil
-			backendMsgChan = nil
+		var channels []iface.IChannel
+		if tcpClient.IsReadyRecv() {
+			for _, channel := range tcpClient.getChannels() {
+				if channel.IsPausing() || channel.IsExiting() || !channel.IsActiveClient(tcpClient.ID) {
+					continue
+				}
+				channels = append(channels, channel)
+			}
+		}
+
+		var subChannel iface.IChannel
+		var value interface{}
+		if tcpClient.pattern == "" && len(channels) <= 1 {
+			// 从channel分发流水线的最后一个阶段读取消息
+			var memoryMsgChan chan iface.IMessage
+			var backendMsgChan <-chan []byte
+			if len(channels) == 1 {
+				subChannel = channels[0]
+				memoryMsgChan, backendMsgChan = subChannel.GetClientMsgChans(tcpClient.ID)
+			}
+
+			select {
+			case <-tcpClient.closingChan:
+				goto Exit
+			case <-tcpClient.updateReadyChan:
+				continue
+			case msg, ok := <-memoryMsgChan:
+				if !ok {
+					continue
+				}
+				value = msg
+			case data, ok := <-backendMsgChan:
+				if !ok {
+					continue
+				}
+				value = data
+			}
 		} else {
-			memoryMsgChan = tcpClient.channel.GetMemoryMsgChan()
-			backendMsgChan = tcpClient.channel.GetBackendQueue().ReadChan()
-			subChannel = tcpClient.channel
+			// 前两个case为退出和更新的通知，之后每个channel对应两个case
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(tcpClient.closingChan)},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(tcpClient.updateReadyChan)},
+			}
+			for _, channel := range channels {
+				memoryMsgChan, backendMsgChan := channel.GetClientMsgChans(tcpClient.ID)
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(memoryMsgChan)},
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(backendMsgChan)},
+				)
+			}
+
+			chosen, v, ok := reflect.Select(cases)
+			switch {
+			case chosen == 0:
+				goto Exit
+			case chosen == 1 || !ok:
+				continue
+			}
+			subChannel = channels[(chosen-2)/2]
+			value = v.Interface()
 		}
 
-		select {
-		case <-tcpClient.closingChan:
-			goto Exit
-		case msg = <-memoryMsgChan:
-		// 从内存队列中取出消息
-		case data := <-backendMsgChan: // 从磁盘中取出消息
+		var msg iface.IMessage
+		switch v := value.(type) {
+		case iface.IMessage: // 从内存队列中取出消息
+			msg = v
+		case []byte: // 从磁盘中取出消息
 			var err error
-			msg, err = message.ConvertBytesToMessage(data)
+			msg, err = message.ConvertBytesToMessage(v)
 			if err != nil {
-				logger.Errorf("topic(%s) channel(%s) convert bytes to message failed in tcp client message pump, err:%s", tcpClient.channel.GetTopicName(), tcpClient.channel.GetName(), err.Error())
+				logger.Errorf("topic(%s) channel(%s) convert bytes to message failed in tcp client message pump, err:%s", subChannel.GetTopicName(), subChannel.GetName(), err.Error())
 				continue
 			}
-		case <-tcpClient.updateReadyChan:
+		default:
 			continue
 		}
 
 		// 向客户端发送消息
 		msg.AddAttempts(1)
 
-		_ = subChannel.StartInFlightTimeout(msg, tcpClient.ID, config.GlobalLmqdConfig.MessageTimeout)
+		_ = subChannel.StartInFlightTimeout(msg, tcpClient.ID, subChannel.GetOptions().MessageTimeout)
 		err := tcpClient.sendMessage(msg)
 		if err != nil {
-			logger.Errorf("topic(%s) channel(%s) send message failed in tcp client message pump, err:%s", tcpClient.channel.GetTopicName(), tcpClient.channel.GetName(), err.Error())
+			logger.Errorf("topic(%s) channel(%s) send message failed in tcp client message pump, err:%s", subChannel.GetTopicName(), subChannel.GetName(), err.Error())
 			goto Exit
 		}
+
+		// 客户端取走了分发给自己的消息，通知dispatcher重新检查
+		subChannel.NotifyReady()
 	}
 
 Exit:
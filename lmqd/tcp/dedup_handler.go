@@ -0,0 +1,43 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+)
+
+/*
+	关于消息去重的handler
+*/
+
+// SetTopicDedupHandler 设置topic的去重窗口，为0时使用全局配置，小于0时不去重
+type SetTopicDedupHandler struct {
+	BaseHandler
+}
+
+func (handler *SetTopicDedupHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、dedup window
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	settings := topic.GetSettings()
+	settings.DedupWindow = requestBody.DedupWindow
+	topic.SetSettings(settings)
+
+	// 持久化元数据
+	err = handler.LmqDaemon.PersistMetaData()
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendOkResponse(request)
+}
@@ -0,0 +1,55 @@
+package tcp
+
+import (
+	"fmt"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"strings"
+)
+
+// DrainHandler 开始下线lmqd，返回下线的进度
+type DrainHandler struct {
+	BaseHandler
+}
+
+func (handler *DrainHandler) Handle(request serveriface.IRequest) {
+	handler.LmqDaemon.Drain()
+
+	_ = handler.SendDataResponse(request, handler.LmqDaemon.GetDrainStatus())
+}
+
+// CancelDrainHandler 取消下线，返回下线的进度
+type CancelDrainHandler struct {
+	BaseHandler
+}
+
+func (handler *CancelDrainHandler) Handle(request serveriface.IRequest) {
+	handler.LmqDaemon.CancelDrain()
+
+	_ = handler.SendDataResponse(request, handler.LmqDaemon.GetDrainStatus())
+}
+
+// DrainStatusHandler 查询下线的进度
+type DrainStatusHandler struct {
+	BaseHandler
+}
+
+func (handler *DrainStatusHandler) Handle(request serveriface.IRequest) {
+	_ = handler.SendDataResponse(request, handler.LmqDaemon.GetDrainStatus())
+}
+
+// drainingError lmqd正在下线时拒绝发布消息，并提示其他可用的节点
+func drainingError(lmqd iface.ILmqDaemon) error {
+	if !lmqd.IsDraining() {
+		return nil
+	}
+
+	// 使用下线期间定期刷新的节点列表，不访问lookup
+	others := lmqd.GetDrainTargets()
+	if len(others) == 0 {
+		return e.ErrLmqdDraining
+	}
+
+	return fmt.Errorf("%w, please publish to: %s", e.ErrLmqdDraining, strings.Join(others, ","))
+}
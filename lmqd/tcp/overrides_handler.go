@@ -0,0 +1,95 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/options"
+)
+
+/*
+	关于topic/channel级别配置的handler
+*/
+
+// SetOverridesHandler 设置topic或者channel的overrides，覆盖原有的overrides，为空时使用上一级的配置，返回生效的配置
+// channel name为空时设置topic的overrides
+type SetOverridesHandler struct {
+	BaseHandler
+}
+
+func (handler *SetOverridesHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、channel name、overrides
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	if requestBody.ChannelName == "" {
+		// channel继承topic的overrides，检查topic以及所有channel生效的配置
+		err = options.Validate(requestBody.Overrides)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+		for _, channelName := range topic.GetChannelNames() {
+			c, err := topic.GetExistingChannel(channelName)
+			if err != nil {
+				continue
+			}
+			err = options.Validate(requestBody.Overrides, c.GetSettings().Overrides)
+			if err != nil {
+				_ = handler.SendErrResponse(request, err)
+				return
+			}
+		}
+
+		settings := topic.GetSettings()
+		settings.Overrides = requestBody.Overrides
+		topic.SetSettings(settings)
+
+		// 持久化元数据
+		err = handler.LmqDaemon.PersistMetaData()
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+
+		_ = handler.SendDataResponse(request, topic.GetOptions())
+		return
+	}
+
+	channel, err := topic.GetExistingChannel(requestBody.ChannelName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	err = options.Validate(topic.GetSettings().Overrides, requestBody.Overrides)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	settings := channel.GetSettings()
+	settings.Overrides = requestBody.Overrides
+	err = channel.SetSettings(settings)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 持久化元数据
+	err = handler.LmqDaemon.PersistMetaData()
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, channel.GetOptions())
+}
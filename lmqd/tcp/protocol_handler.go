@@ -24,6 +24,7 @@ func (handler *RydHandler) Handle(request serveriface.IRequest) {
 		_ = handler.SendErrResponse(request, errors.New("server internal error"))
 		return
 	}
+	client.touch()
 
 	count := requestBody.Count
 	client.UpdateReady(count)
@@ -49,20 +50,15 @@ func (handler *FinHandler) Handle(request serveriface.IRequest) {
 		_ = handler.SendErrResponse(request, errors.New("server internal error"))
 		return
 	}
+	client.touch()
 
-	rawID := request.GetConnection().GetProperty("clientID")
-	clientID, ok := rawID.(uint64)
-	if !ok {
-		_ = handler.SendErrResponse(request, errors.New("server internal error"))
-		return
-	}
-
-	err = client.channel.FinishMessage(clientID, requestBody.MessageID)
+	err = client.finishMessage(requestBody.MessageID)
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
 	client.InFlightCount.Add(-1)
+	client.tryUpdateReady()
 
 	_ = handler.SendOkResponse(request)
 }
@@ -85,15 +81,9 @@ func (handler *ReqHandler) Handle(request serveriface.IRequest) {
 		_ = handler.SendErrResponse(request, errors.New("server internal error"))
 		return
 	}
+	client.touch()
 
-	rawID := request.GetConnection().GetProperty("clientID")
-	clientID, ok := rawID.(uint64)
-	if !ok {
-		_ = handler.SendErrResponse(request, errors.New("server internal error"))
-		return
-	}
-
-	err = client.channel.RequeueMessage(clientID, requestBody.MessageID)
+	err = client.requeueMessage(requestBody.MessageID)
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
@@ -102,3 +92,20 @@ func (handler *ReqHandler) Handle(request serveriface.IRequest) {
 
 	_ = handler.SendOkResponse(request)
 }
+
+// PingHandler 客户端心跳，failover模式下活跃的客户端需要定期发送心跳
+type PingHandler struct {
+	BaseHandler
+}
+
+func (handler *PingHandler) Handle(request serveriface.IRequest) {
+	raw := request.GetConnection().GetProperty("client")
+	client, ok := raw.(*TcpClient)
+	if !ok {
+		_ = handler.SendErrResponse(request, errors.New("server internal error"))
+		return
+	}
+	client.touch()
+
+	_ = handler.SendOkResponse(request)
+}
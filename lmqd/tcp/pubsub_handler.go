@@ -3,8 +3,12 @@ package tcp
 import (
 	"errors"
 	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/utils"
 	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
 )
 
 /*
@@ -30,6 +34,12 @@ func (handler *PubHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
+	// 正在下线，拒绝发布
+	if err = drainingError(handler.LmqDaemon); err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
 	// 反序列化，获取topic name
 	requestBody, err := protocol.GetRequestBody(request)
 	if err != nil {
@@ -46,13 +56,39 @@ func (handler *PubHandler) Handle(request serveriface.IRequest) {
 
 	// 新建消息
 	msg := message.NewMessage(topic.GenerateGUID(), requestBody.MessageData)
+	msg.SetHeaders(requestBody.Headers)
+	if requestBody.Priority != 0 {
+		message.SetPriority(msg, requestBody.Priority)
+	}
+
+	// 去重窗口内已经发布过相同dedup key的消息，直接确认，不再重复发布；相同key的消息正在发布时等待它的结果
+	accepted, err := topic.AcceptDedupKey(msg)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+	if !accepted {
+		_ = handler.SendOkResponse(request)
+		return
+	}
+
+	// 复制到副本节点，等待足够数量的副本确认
+	err = handler.LmqDaemon.GetReplicationManager().Replicate(topic.GetName(), msg)
+	if err != nil {
+		topic.ForgetDedupKey(msg)
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
 
 	// 发布消息
 	err = topic.PutMessage(msg)
 	if err != nil {
+		handler.LmqDaemon.GetReplicationManager().Abort(topic.GetName(), msg.GetID())
+		topic.ForgetDedupKey(msg)
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
+	topic.CommitDedupKey(msg)
 
 	client.MessageCount.Add(1)
 	_ = handler.SendOkResponse(request)
@@ -66,7 +102,7 @@ type SubHandler struct {
 
 func (handler *SubHandler) Handle(request serveriface.IRequest) {
 	// 获取client
-	client, clientID, err := getClient(handler.tcpServer, request)
+	client, _, err := getClient(handler.tcpServer, request)
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
@@ -84,32 +120,82 @@ func (handler *SubHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 获取topic
-	topic, err := handler.LmqDaemon.GetTopic(requestBody.TopicName)
-	if err != nil {
-		_ = handler.SendErrResponse(request, err)
-		return
+	// 订阅时指定的权重，在加入channel之前设置
+	client.weight = requestBody.Weight
+
+	if utils.IsTopicPattern(requestBody.TopicName) {
+		// 通配符订阅
+		err = handler.subscribePattern(client, requestBody.TopicName, requestBody.ChannelName)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	} else {
+		// 获取topic
+		topic, err := handler.LmqDaemon.GetTopic(requestBody.TopicName)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+
+		// 获取channel
+		c, err := topic.GetChannel(requestBody.ChannelName)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+
+		// 将用户添加到channel的用户组中
+		err = client.addChannel(c, client.getGeneration())
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
 	}
 
-	// 获取channel
-	c, err := topic.GetChannel(requestBody.ChannelName)
+	// 开启message pump，客户端已经关闭时不再开启
+	err = client.startMessagePump()
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
 
-	// 将用户添加到channel的用户组中
-	err = c.AddClient(clientID, client)
-	if err != nil {
-		_ = handler.SendErrResponse(request, err)
-		return
+	_ = handler.SendOkResponse(request)
+}
+
+// subscribePattern 订阅所有匹配通配符的topic中的channel，之后新建的匹配的topic也会被订阅
+func (handler *SubHandler) subscribePattern(client *TcpClient, pattern, channelName string) error {
+	if !utils.TopicPatternIsValid(pattern) {
+		return e.ErrTopicNameInValid
+	}
+	if !utils.TopicOrChannelNameIsValid(channelName) {
+		return e.ErrChannelNameInValid
 	}
 
-	client.channel = c
-	client.Status.Store(statusSubscribed)
-	go client.messagePump()
+	// 回调可能在连接断开之后执行，只订阅到同一个连接的客户端中
+	generation := client.getGeneration()
+	subscribe := func(topic iface.ITopic) {
+		if !utils.MatchTopicPattern(pattern, topic.GetName()) {
+			return
+		}
+
+		c, err := topic.GetChannel(channelName)
+		if err == nil {
+			err = client.addChannel(c, generation)
+		}
+		if err != nil {
+			logger.Errorf("subscribe topic(%s) channel(%s) by pattern(%s) failed, err: %s", topic.GetName(), channelName, pattern, err.Error())
+		}
+	}
 
-	_ = handler.SendOkResponse(request)
+	// 先监听新建的topic，再订阅已经存在的topic，避免遗漏
+	client.pattern = pattern
+	client.watcherID = handler.LmqDaemon.WatchTopics(subscribe)
+	for _, topic := range handler.LmqDaemon.GetTopics() {
+		subscribe(topic)
+	}
+
+	return nil
 }
 
 func getClient(tcpServer *TcpServer, request serveriface.IRequest) (*TcpClient, uint64, error) {
@@ -121,10 +207,10 @@ func getClient(tcpServer *TcpServer, request serveriface.IRequest) (*TcpClient,
 
 	tcpServer.clientMapLock.RLock()
 	client, ok := tcpServer.clientMap[clientID]
+	tcpServer.clientMapLock.RUnlock()
 	if !ok {
 		return nil, 0, errors.New("server internal error")
 	}
-	tcpServer.clientMapLock.RUnlock()
 
 	return client, clientID, nil
 }
@@ -0,0 +1,24 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+)
+
+/*
+	关于重新加载配置的handler
+*/
+
+// ReloadHandler 重新加载lmqd的配置文件，与SIGHUP相同，返回发生变化的配置以及需要重启才能生效的配置
+type ReloadHandler struct {
+	BaseHandler
+}
+
+func (handler *ReloadHandler) Handle(request serveriface.IRequest) {
+	result, err := handler.LmqDaemon.Reload()
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, result)
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+)
+
+/*
+	关于消息保留、重放channel的handler
+*/
+
+// SetTopicRetentionHandler 设置topic消息的保留时长，为0时使用全局配置，小于0时不保留
+type SetTopicRetentionHandler struct {
+	BaseHandler
+}
+
+func (handler *SetTopicRetentionHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、retention
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	settings := topic.GetSettings()
+	settings.Retention = requestBody.Retention
+	topic.SetSettings(settings)
+
+	// 持久化元数据
+	err = handler.LmqDaemon.PersistMetaData()
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendOkResponse(request)
+}
+
+// ReplayChannelHandler 从某个时间点或者某个消息ID开始，将topic保留的消息重新投递到channel中，channel不存在时会新建
+type ReplayChannelHandler struct {
+	BaseHandler
+}
+
+func (handler *ReplayChannelHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、channel name、timestamp、message id
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 重放
+	count, err := topic.ReplayChannel(requestBody.ChannelName, requestBody.Timestamp, requestBody.MessageID)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, map[string]interface{}{
+		"count": count,
+	})
+}
@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"errors"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/message"
+)
+
+/*
+	关于副本的handler，由主节点发送给副本节点
+*/
+
+var errPrimaryEmpty = errors.New("primary can not be empty")
+
+// ReplicateHandler 保存主节点复制过来的消息
+type ReplicateHandler struct {
+	BaseHandler
+}
+
+func (handler *ReplicateHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、primary、replicas、message data
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	if requestBody.Primary == "" {
+		_ = handler.SendErrResponse(request, errPrimaryEmpty)
+		return
+	}
+
+	msg, err := message.ConvertBytesToMessage(requestBody.MessageData)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	handler.LmqDaemon.GetReplicationManager().StoreReplica(requestBody.Primary, requestBody.Replicas, requestBody.TopicName, msg)
+
+	_ = handler.SendOkResponse(request)
+}
+
+// ReplicaFinHandler 删除主节点已经完成的消息
+type ReplicaFinHandler struct {
+	BaseHandler
+}
+
+func (handler *ReplicaFinHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、primary、message ids
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	handler.LmqDaemon.GetReplicationManager().FinishReplica(requestBody.Primary, requestBody.TopicName, requestBody.MessageIDs)
+
+	_ = handler.SendOkResponse(request)
+}
+
+// TakeOverHandler 手动接管一个主节点，将副本中的消息发布到本节点的topic中
+type TakeOverHandler struct {
+	BaseHandler
+}
+
+func (handler *TakeOverHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取primary
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	if requestBody.Primary == "" {
+		_ = handler.SendErrResponse(request, errPrimaryEmpty)
+		return
+	}
+
+	count, err := handler.LmqDaemon.GetReplicationManager().TakeOver(requestBody.Primary)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendDataResponse(request, map[string]interface{}{
+		"count": count,
+	})
+}
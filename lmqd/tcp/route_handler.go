@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/topic"
+)
+
+/*
+	关于topic路由规则的handler
+*/
+
+// SetTopicRoutesHandler 设置topic的路由规则，覆盖原有的规则，规则为空时不再转发
+type SetTopicRoutesHandler struct {
+	BaseHandler
+}
+
+func (handler *SetTopicRoutesHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、routes
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 检查路由规则
+	err = topic.ValidateRoutes(requestBody.TopicName, requestBody.Routes)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	t, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	settings := t.GetSettings()
+	settings.Routes = requestBody.Routes
+	t.SetSettings(settings)
+
+	// 持久化元数据
+	err = handler.LmqDaemon.PersistMetaData()
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_ = handler.SendOkResponse(request)
+}
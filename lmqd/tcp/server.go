@@ -8,8 +8,10 @@ import (
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
 	"github.com/dawnzzz/lmq/logger"
+	"reflect"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 )
 
 type TcpServer struct {
@@ -25,16 +27,17 @@ type TcpServer struct {
 
 func NewTcpServer(lmqDaemon iface.ILmqDaemon) *TcpServer {
 
+	lmqdConfig := config.GetLmqdConfig()
 	server := hamble.NewServerWithOption(&conf.Profile{
 		Name:             "LMQD TCP Server",
-		Host:             config.GlobalLmqdConfig.TcpHost,
-		Port:             config.GlobalLmqdConfig.TcpPort,
+		Host:             lmqdConfig.TcpHost,
+		Port:             lmqdConfig.TcpPort,
 		TcpVersion:       "tcp4",
-		MaxConn:          config.GlobalLmqdConfig.TcpServerMaxConn,
-		MaxPacketSize:    uint32(config.GlobalLmqdConfig.MaxMessageSize + 8),
-		WorkerPoolSize:   config.GlobalLmqdConfig.TcpServerWorkerPoolSize,
-		MaxWorkerTaskLen: config.GlobalLmqdConfig.TcpServerMaxWorkerTaskLen,
-		MaxMsgChanLen:    config.GlobalLmqdConfig.TcpServerMaxMsgChanLen,
+		MaxConn:          lmqdConfig.TcpServerMaxConn,
+		MaxPacketSize:    uint32(lmqdConfig.MaxMessageSize + 8),
+		WorkerPoolSize:   lmqdConfig.TcpServerWorkerPoolSize,
+		MaxWorkerTaskLen: lmqdConfig.TcpServerMaxWorkerTaskLen,
+		MaxMsgChanLen:    lmqdConfig.TcpServerMaxMsgChanLen,
 		LogFileName:      "",
 		MaxHeartbeatTime: 0,
 		CrtFileName:      "",
@@ -77,33 +80,50 @@ func NewTcpServer(lmqDaemon iface.ILmqDaemon) *TcpServer {
 		raw := conn.GetProperty("clientID")
 		clientID, _ := raw.(uint64)
 
-		// 获取TcpClient对象
+		// 获取TcpClient对象，并从clientMap中删除
+		tcpServer.clientMapLock.Lock()
 		client, ok := tcpServer.clientMap[clientID]
+		delete(tcpServer.clientMap, clientID)
+		tcpServer.clientMapLock.Unlock()
 		if !ok {
 			return
 		}
 
 		_ = client.Close()
 		// 从订阅的channel中移除该对象
-		if client.channel != nil {
-			client.channel.RemoveClient(clientID)
-		}
+		client.unsubscribe(lmqDaemon)
 
-		// 销毁对象
+		// 销毁对象，等待message pump退出之后放回对象池
 		DestroyTcpClient(client)
 	})
 
 	return tcpServer
 }
 
+// Start 开启tcp服务器，阻塞直到服务器退出
+// hamble的Start会监听SIGHUP等信号并在收到信号之后停止服务器，这里只开启工作池和服务，信号统一由lmqd处理
+// 服务器由lmqd调用Stop停止
 func (tcpServer *TcpServer) Start() {
-	tcpServer.server.Start()
+	logger.Infof("lmqd tcp server start")
+	tcpServer.server.GetRouter().StartWorkerPool()
+	serve(tcpServer.server)
+	logger.Infof("lmqd tcp server exited")
+}
 
-	tcpServer.server.Stop()
+// serve 调用hamble的Serve，Serve退出时会对hamble的Start中Add的WaitGroup调用Done，
+// 这里没有经过hamble的Start，所以在Serve之前对这个WaitGroup调用Add，Serve退出时计数不会变为负数
+func serve(server serveriface.IServer) {
+	if s, ok := server.(*hamble.Server); ok {
+		if field := reflect.ValueOf(s).Elem().FieldByName("wg"); field.IsValid() && field.Type() == reflect.TypeOf(sync.WaitGroup{}) {
+			(*sync.WaitGroup)(unsafe.Pointer(field.UnsafeAddr())).Add(1)
+		}
+	}
+
+	server.Serve()
 }
 
 func (tcpServer *TcpServer) Stop() {
-	if tcpServer.IsClosing.Load() {
+	if !tcpServer.IsClosing.CompareAndSwap(false, true) {
 		return
 	}
 
@@ -140,10 +160,14 @@ func registerHandler(tcpServer *TcpServer, server serveriface.IServer, lmqDaemon
 		BaseHandler: RegisterBaseHandler(protocol.ReqID, lmqDaemon),
 	})
 
+	server.RegisterHandler(protocol.PingID, &PingHandler{
+		BaseHandler: RegisterBaseHandler(protocol.PingID, lmqDaemon),
+	})
+
 	/*
 		Topic Handler
 	*/
-	server.RegisterHandler(protocol.CreateTopicID, &CreateChannelHandler{
+	server.RegisterHandler(protocol.CreateTopicID, &CreateTopicHandler{
 		BaseHandler: RegisterBaseHandler(protocol.CreateTopicID, lmqDaemon),
 	})
 
@@ -185,4 +209,106 @@ func registerHandler(tcpServer *TcpServer, server serveriface.IServer, lmqDaemon
 	server.RegisterHandler(protocol.UnPauseChannelID, &UnPauseChannelHandler{
 		BaseHandler: RegisterBaseHandler(protocol.UnPauseChannelID, lmqDaemon),
 	})
+
+	/*
+		Export and Import Handler
+	*/
+	server.RegisterHandler(protocol.ExportTopicID, &ExportTopicHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ExportTopicID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.ExportChannelID, &ExportChannelHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ExportChannelID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.ImportTopicID, &ImportTopicHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ImportTopicID, lmqDaemon),
+	})
+
+	/*
+		Retention and Replay Handler
+	*/
+	server.RegisterHandler(protocol.SetTopicRetentionID, &SetTopicRetentionHandler{
+		BaseHandler: RegisterBaseHandler(protocol.SetTopicRetentionID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.ReplayChannelID, &ReplayChannelHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ReplayChannelID, lmqDaemon),
+	})
+
+	/*
+		Replication Handler
+	*/
+	server.RegisterHandler(protocol.ReplicateID, &ReplicateHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ReplicateID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.ReplicaFinID, &ReplicaFinHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ReplicaFinID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.TakeOverID, &TakeOverHandler{
+		BaseHandler: RegisterBaseHandler(protocol.TakeOverID, lmqDaemon),
+	})
+
+	/*
+		Drain Handler
+	*/
+	server.RegisterHandler(protocol.DrainID, &DrainHandler{
+		BaseHandler: RegisterBaseHandler(protocol.DrainID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.CancelDrainID, &CancelDrainHandler{
+		BaseHandler: RegisterBaseHandler(protocol.CancelDrainID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.DrainStatusID, &DrainStatusHandler{
+		BaseHandler: RegisterBaseHandler(protocol.DrainStatusID, lmqDaemon),
+	})
+
+	/*
+		Tombstone Handler
+	*/
+	server.RegisterHandler(protocol.TombstoneTopicID, &TombstoneTopicHandler{
+		BaseHandler: RegisterBaseHandler(protocol.TombstoneTopicID, lmqDaemon),
+	})
+
+	server.RegisterHandler(protocol.UnTombstoneTopicID, &UnTombstoneTopicHandler{
+		BaseHandler: RegisterBaseHandler(protocol.UnTombstoneTopicID, lmqDaemon),
+	})
+
+	/*
+		Dedup Handler
+	*/
+	server.RegisterHandler(protocol.SetTopicDedupID, &SetTopicDedupHandler{
+		BaseHandler: RegisterBaseHandler(protocol.SetTopicDedupID, lmqDaemon),
+	})
+
+	/*
+		Route Handler
+	*/
+	server.RegisterHandler(protocol.SetTopicRoutesID, &SetTopicRoutesHandler{
+		BaseHandler: RegisterBaseHandler(protocol.SetTopicRoutesID, lmqDaemon),
+	})
+
+	/*
+		Overrides Handler
+	*/
+	server.RegisterHandler(protocol.SetOverridesID, &SetOverridesHandler{
+		BaseHandler: RegisterBaseHandler(protocol.SetOverridesID, lmqDaemon),
+	})
+
+	/*
+		Reload Handler
+	*/
+	server.RegisterHandler(protocol.ReloadID, &ReloadHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ReloadID, lmqDaemon),
+	})
+
+	/*
+		Stats Handler
+	*/
+	server.RegisterHandler(protocol.StatsID, &StatsHandler{
+		BaseHandler: RegisterBaseHandler(protocol.StatsID, lmqDaemon),
+	})
 }
@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"sort"
+)
+
+/*
+	关于统计信息的handler
+*/
+
+// StatsHandler 获取topic和channel的统计信息，topic name为空时返回所有topic，channel name不为空时只返回这个channel
+type StatsHandler struct {
+	BaseHandler
+}
+
+func (handler *StatsHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name、channel name
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	var topics []iface.ITopic
+	if requestBody.TopicName == "" {
+		topics = handler.LmqDaemon.GetTopics()
+	} else {
+		topic, err := handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+		topics = []iface.ITopic{topic}
+	}
+
+	stats := make([]*iface.TopicStats, 0, len(topics))
+	for _, topic := range topics {
+		topicStats := topic.GetStats()
+		if requestBody.ChannelName != "" {
+			channels := make([]*iface.ChannelStats, 0, 1)
+			for _, channelStats := range topicStats.Channels {
+				if channelStats.Name == requestBody.ChannelName {
+					channels = append(channels, channelStats)
+				}
+			}
+			topicStats.Channels = channels
+		}
+		stats = append(stats, topicStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+
+	_ = handler.SendDataResponse(request, stats)
+}
@@ -2,7 +2,9 @@ package tcp
 
 import (
 	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqd/options"
 )
 
 /*
@@ -22,13 +24,34 @@ func (handler *CreateTopicHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 创建新的topic
-	_, err = handler.BaseHandler.LmqDaemon.GetTopic(requestBody.TopicName)
+	// 检查overrides
+	err = options.Validate(requestBody.Overrides)
 	if err != nil {
 		_ = handler.SendErrResponse(request, err)
 		return
 	}
 
+	// 创建新的topic，按照overrides创建队列
+	topic, err := handler.BaseHandler.LmqDaemon.GetTopicWithSettings(requestBody.TopicName, iface.TopicSettings{Overrides: requestBody.Overrides})
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	// 设置overrides，topic已经存在时只有部分配置立即生效
+	if requestBody.Overrides != nil {
+		settings := topic.GetSettings()
+		settings.Overrides = requestBody.Overrides
+		topic.SetSettings(settings)
+
+		// 持久化元数据
+		err = handler.LmqDaemon.PersistMetaData()
+		if err != nil {
+			_ = handler.SendErrResponse(request, err)
+			return
+		}
+	}
+
 	_ = handler.SendOkResponse(request)
 }
 
@@ -142,3 +165,51 @@ func (handler *UnPauseTopicHandler) Handle(request serveriface.IRequest) {
 
 	_ = handler.SendOkResponse(request)
 }
+
+// TombstoneTopicHandler 在lookup中tombstone本节点的topic，新的消费者不会再发现本节点
+type TombstoneTopicHandler struct {
+	BaseHandler
+}
+
+func (handler *TombstoneTopicHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_, err = handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	handler.LmqDaemon.GetLookupManager().TombstoneTopics([]string{requestBody.TopicName})
+
+	_ = handler.SendOkResponse(request)
+}
+
+// UnTombstoneTopicHandler 在lookup中取消本节点topic的tombstone
+type UnTombstoneTopicHandler struct {
+	BaseHandler
+}
+
+func (handler *UnTombstoneTopicHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，获取topic name
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	_, err = handler.LmqDaemon.GetExistingTopic(requestBody.TopicName)
+	if err != nil {
+		_ = handler.SendErrResponse(request, err)
+		return
+	}
+
+	handler.LmqDaemon.GetLookupManager().UnTombstoneTopics([]string{requestBody.TopicName})
+
+	_ = handler.SendOkResponse(request)
+}
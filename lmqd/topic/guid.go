@@ -1,21 +1,27 @@
 package topic
 
 import (
+	"fmt"
 	"github.com/bwmarrin/snowflake"
 	"github.com/dawnzzz/lmq/iface"
 )
 
 // GUIDFactory message id 生成器
+// 使用lmqd的节点ID作为snowflake的节点ID，同一个lmqd中的所有topic共用一个生成器，
+// 生成的message id在集群中唯一，并且按照时间递增
 type GUIDFactory struct {
 	node *snowflake.Node
 }
 
-func NewGUIDFactory(nodeID int64) *GUIDFactory {
-	node, _ := snowflake.NewNode(nodeID % 1024)
+func NewGUIDFactory(nodeID int64) (*GUIDFactory, error) {
+	node, err := snowflake.NewNode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("create guid factory with node id %d failed: %w", nodeID, err)
+	}
 
 	return &GUIDFactory{
 		node: node,
-	}
+	}, nil
 }
 
 func (factory *GUIDFactory) NewMessageID() iface.MessageID {
@@ -0,0 +1,91 @@
+package topic
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"strconv"
+)
+
+/*
+	topic之间的路由规则
+	messagePump将消息分发到channel的同时，按照路由规则将消息转发到其他topic中，相当于topic内部的一个路由channel
+	满足过滤条件的消息会被复制到所有的目的topic中，转发时可以设置或者删除头部
+	每转发一次route_hops头部加1，达到maxRouteHops的消息不再转发，防止路由规则形成环
+*/
+
+const maxRouteHops = 8
+
+// ValidateRoutes 检查路由规则是否合法
+func ValidateRoutes(topicName string, routes []*iface.RouteRule) error {
+	for _, rule := range routes {
+		if rule == nil || len(rule.Destinations) == 0 {
+			return e.ErrRouteInValid
+		}
+
+		for _, destination := range rule.Destinations {
+			if destination == topicName || !utils.TopicOrChannelNameIsValid(destination) {
+				return e.ErrRouteInValid
+			}
+		}
+
+		if err := message.ValidateFilter(rule.Filter); err != nil {
+			return err
+		}
+
+		if _, ok := rule.SetHeaders[iface.RouteHopsHeader]; ok {
+			return e.ErrRouteInValid
+		}
+	}
+
+	return nil
+}
+
+// routeHeaders 转发消息的头部
+func routeHeaders(rule *iface.RouteRule, msg iface.IMessage, hops int) map[string]string {
+	headers := make(map[string]string, len(msg.GetHeaders())+len(rule.SetHeaders)+1)
+	for k, v := range msg.GetHeaders() {
+		headers[k] = v
+	}
+	for _, k := range rule.RemoveHeaders {
+		delete(headers, k)
+	}
+	for k, v := range rule.SetHeaders {
+		headers[k] = v
+	}
+	headers[iface.RouteHopsHeader] = strconv.Itoa(hops)
+
+	return headers
+}
+
+// route 按照路由规则将消息转发到目的topic中
+func (topic *Topic) route(routes []*iface.RouteRule, msg iface.IMessage) {
+	hops, _ := strconv.Atoi(msg.GetHeader(iface.RouteHopsHeader))
+	if hops >= maxRouteHops {
+		logger.Warnf("topic(%s) drop a message when routing, route hops exceed %d", topic.name, maxRouteHops)
+		return
+	}
+
+	for _, rule := range routes {
+		if !message.MatchFilter(rule.Filter, msg) {
+			continue
+		}
+
+		headers := routeHeaders(rule, msg, hops+1)
+		for _, destination := range rule.Destinations {
+			t, err := topic.lmqd.GetTopic(destination)
+			if err != nil {
+				logger.Errorf("topic(%s) route message to topic(%s) failed, err: %s", topic.name, destination, err.Error())
+				continue
+			}
+
+			routed := message.NewMessage(t.GenerateGUID(), msg.GetData())
+			routed.SetHeaders(headers)
+			if err = t.PutMessage(routed); err != nil {
+				logger.Errorf("topic(%s) route message to topic(%s) failed, err: %s", topic.name, destination, err.Error())
+			}
+		}
+	}
+}
@@ -1,37 +1,53 @@
 package topic
 
 import (
-	"encoding/binary"
 	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/utils"
 	"github.com/dawnzzz/lmq/lmqd/backendqueue"
 	"github.com/dawnzzz/lmq/lmqd/channel"
+	"github.com/dawnzzz/lmq/lmqd/dedup"
 	"github.com/dawnzzz/lmq/lmqd/message"
+	"github.com/dawnzzz/lmq/lmqd/options"
+	"github.com/dawnzzz/lmq/lmqd/retention"
 	"github.com/dawnzzz/lmq/logger"
 	"github.com/dawnzzz/lmq/pkg/e"
-	"strings"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Topic struct {
 	lmqd iface.ILmqDaemon
 
 	name         string
-	isTemporary  bool                      // 标记是否是临时的topic
+	isTemporary  bool                      // 标记是否是临时的topic，临时topic只保存在内存中，不持久化
 	isPausing    atomic.Bool               // 标记是否已经暂停
 	isExiting    atomic.Bool               // 标记是否已经退出
+	createdAt    atomic.Int64              // 创建时间
 	channels     map[string]iface.IChannel // 保存所有的channel字典
 	channelsLock sync.RWMutex              // 控制对channel字典的互斥访问
 
-	guidFactory *GUIDFactory // message id 生成器
+	settings     iface.TopicSettings // 需要持久化的配置
+	settingsLock sync.RWMutex
+	options      atomic.Pointer[iface.Options] // 生效的配置
+
+	retentionLog  *retention.Log // 保留已经发布的消息，用于重放channel，为nil时不保留
+	retentionLock sync.RWMutex
+
+	dedupCache *dedup.Cache // 记录去重窗口内已经接受的dedup key，为nil时不去重
+	dedupLock  sync.RWMutex
+
+	guidFactory iface.IGUIDFactory // message id 生成器，由lmqd中的所有topic共用
 
 	memoryMsgChan chan iface.IMessage       // 内存chan
+	putLock       sync.RWMutex              // 向内存chan放入消息与导出的互斥，导出时内存chan中的消息保持原来的顺序
 	backendQueue  backendqueue.BackendQueue // 当内存chan满了之后，将消息存入到后端队列中（持久化保存）
 
 	deleteCallback func(topic iface.ITopic)
 	deleter        sync.Once
+	expiryTimer    *time.Timer // 临时topic没有channel之后，等待宽限期之后删除，由channelsLock保护
 
 	startChan   chan struct{}
 	updateChan  chan struct{}
@@ -41,14 +57,16 @@ type Topic struct {
 
 	messageCount atomic.Uint64
 	messageBytes atomic.Uint64
+	droppedCount atomic.Uint64 // 临时topic内存队列满了之后丢弃的消息数量
 }
 
-func NewTopic(lmqd iface.ILmqDaemon, name string, deleteCallback func(topic iface.ITopic)) iface.ITopic {
+func NewTopic(lmqd iface.ILmqDaemon, name string, settings iface.TopicSettings, deleteCallback func(topic iface.ITopic)) iface.ITopic {
 	topic := &Topic{
 		lmqd: lmqd,
 
 		name:     name,
 		channels: map[string]iface.IChannel{},
+		settings: settings,
 
 		deleteCallback: deleteCallback,
 
@@ -58,31 +76,47 @@ func NewTopic(lmqd iface.ILmqDaemon, name string, deleteCallback func(topic ifac
 		closingChan: make(chan struct{}, 1),
 		closedChan:  make(chan struct{}, 1),
 	}
+	topic.createdAt.Store(time.Now().UnixNano())
+
+	// 队列按照创建时生效的配置初始化
+	topic.options.Store(options.Resolve(settings.Overrides))
+	opts := topic.GetOptions()
 
 	// 内存级队列
-	if config.GlobalLmqdConfig.MemQueueSize > 0 {
-		if strings.HasSuffix(name, "#temp") { // 只有内存级队列长度大于0才允许内存级队列
-			topic.isTemporary = true
-			topic.backendQueue = backendqueue.NewDummyBackendQueue() // 临时队列超出长度会被丢弃
-		}
-		topic.memoryMsgChan = make(chan iface.IMessage, config.GlobalLmqdConfig.MemQueueSize)
+	if opts.MemQueueSize > 0 {
+		topic.memoryMsgChan = make(chan iface.IMessage, opts.MemQueueSize)
 	}
 
-	// 磁盘队列
-	if topic.backendQueue == nil {
-		minMsgSize := config.GlobalLmqdConfig.MinMessageSize + iface.MsgIDLength + 8 + 2
-		maxMsgSize := config.GlobalLmqdConfig.MaxMessageSize + iface.MsgIDLength + 8 + 2
+	if utils.IsEphemeralName(name) {
+		// 临时topic只使用内存队列，超出长度的消息会被丢弃
+		topic.isTemporary = true
+		topic.backendQueue = backendqueue.NewDummyBackendQueue(&topic.droppedCount)
+	} else {
+		// 磁盘队列
+		lmqdConfig := config.GetLmqdConfig()
+		minMsgSize := message.MinPersistLength(lmqdConfig.MinMessageSize)
+		maxMsgSize := message.MaxPersistLength(lmqdConfig.MaxMessageSize)
 		topic.backendQueue = backendqueue.NewDiskBackendQueue(topic.name,
-			config.GlobalLmqdConfig.DataRootPath, config.GlobalLmqdConfig.MaxBytesPerFile, minMsgSize, maxMsgSize,
-			config.GlobalLmqdConfig.SyncEvery, config.GlobalLmqdConfig.SyncTimeout,
+			lmqdConfig.DataRootPath, opts.MaxBytesPerFile, minMsgSize, maxMsgSize,
+			opts.SyncEvery, opts.SyncTimeout,
 		)
 	}
 
-	nodeID, _ := binary.Varint([]byte(topic.name))
-	topic.guidFactory = NewGUIDFactory(nodeID)
+	topic.guidFactory = lmqd.GetGUIDFactory()
+
+	// 消息保留
+	topic.applyRetention()
+
+	// 消息去重
+	topic.applyDedup()
 
 	go topic.messagePump()
 
+	// 临时topic一直没有channel时也会被删除
+	topic.channelsLock.Lock()
+	topic.scheduleExpiry()
+	topic.channelsLock.Unlock()
+
 	lmqd.Notify(topic, !topic.isTemporary)
 
 	return topic
@@ -156,6 +190,12 @@ func (topic *Topic) exit(deleted bool) error {
 		// 清空backend队列
 		err := topic.backendQueue.Delete()
 
+		// 删除保留的消息
+		topic.closeRetentionLog(true)
+
+		// 删除去重记录
+		topic.closeDedupCache(true)
+
 		topic.lmqd.Notify(topic, !topic.isTemporary)
 
 		return err
@@ -171,6 +211,10 @@ func (topic *Topic) exit(deleted bool) error {
 	// 在内存队列中的数据要进行持久化操作，否则会丢失
 	_ = topic.persistMemoryChan()
 
+	topic.closeRetentionLog(false)
+
+	topic.closeDedupCache(false)
+
 	return topic.backendQueue.Close()
 }
 
@@ -208,6 +252,9 @@ func (topic *Topic) Empty() error {
 	}
 
 finish:
+	// 清空的消息不会再投递到channel中，通知副本管理器
+	topic.lmqd.GetReplicationManager().Drop(topic.name, "")
+
 	// 清空backend队列
 	return topic.backendQueue.Empty()
 }
@@ -222,6 +269,248 @@ func (topic *Topic) IsExiting() bool {
 	return topic.isExiting.Load()
 }
 
+// IsEphemeral 返回是否是临时topic
+func (topic *Topic) IsEphemeral() bool {
+	return topic.isTemporary
+}
+
+// GetCreatedAt 获取创建时间
+func (topic *Topic) GetCreatedAt() time.Time {
+	return time.Unix(0, topic.createdAt.Load())
+}
+
+// SetCreatedAt 设置创建时间
+func (topic *Topic) SetCreatedAt(createdAt time.Time) {
+	topic.createdAt.Store(createdAt.UnixNano())
+}
+
+// GetSettings 获取需要持久化的配置
+func (topic *Topic) GetSettings() iface.TopicSettings {
+	topic.settingsLock.RLock()
+	defer topic.settingsLock.RUnlock()
+
+	return topic.settings
+}
+
+// SetSettings 设置需要持久化的配置
+func (topic *Topic) SetSettings(settings iface.TopicSettings) {
+	topic.settingsLock.Lock()
+	topic.settings = settings
+	topic.options.Store(options.Resolve(settings.Overrides))
+	topic.settingsLock.Unlock()
+
+	// channel的配置继承topic的overrides
+	topic.channelsLock.RLock()
+	for _, c := range topic.channels {
+		c.SetTopicOverrides(settings.Overrides)
+	}
+	topic.channelsLock.RUnlock()
+
+	topic.applyRetention()
+	topic.applyDedup()
+
+	// 路由规则可能发生变化，更新messagePump状态
+	select {
+	case topic.updateChan <- struct{}{}:
+	default:
+	}
+}
+
+// GetOptions 获取生效的配置
+func (topic *Topic) GetOptions() *iface.Options {
+	return topic.options.Load()
+}
+
+// retentionWindow 获取消息的保留时长，临时topic不保留消息
+func (topic *Topic) retentionWindow() time.Duration {
+	if topic.isTemporary {
+		return 0
+	}
+
+	window := topic.GetSettings().Retention
+	if window == 0 {
+		window = config.GetLmqdConfig().RetentionWindow
+	}
+	if window < 0 {
+		return 0
+	}
+
+	return window
+}
+
+// applyRetention 根据保留时长开启、关闭或者更新retention log
+func (topic *Topic) applyRetention() {
+	window := topic.retentionWindow()
+
+	topic.retentionLock.Lock()
+	defer topic.retentionLock.Unlock()
+
+	if topic.isExiting.Load() {
+		return
+	}
+
+	if window <= 0 {
+		// 不再保留消息，删除已经保留的消息
+		if topic.retentionLog != nil {
+			_ = topic.retentionLog.Delete()
+			topic.retentionLog = nil
+		}
+		return
+	}
+
+	if topic.retentionLog != nil {
+		topic.retentionLog.SetWindow(window)
+		return
+	}
+
+	lmqdConfig := config.GetLmqdConfig()
+	minMsgSize := message.MinPersistLength(lmqdConfig.MinMessageSize)
+	maxMsgSize := message.MaxPersistLength(lmqdConfig.MaxMessageSize)
+	log, err := retention.NewLog(topic.name, lmqdConfig.DataRootPath, window,
+		topic.GetOptions().MaxBytesPerFile, minMsgSize, maxMsgSize)
+	if err != nil {
+		logger.Errorf("topic(%s) open retention log failed, err: %s", topic.name, err.Error())
+		return
+	}
+	topic.retentionLog = log
+}
+
+// closeRetentionLog 关闭retention log，deleted为true时删除保留的消息
+func (topic *Topic) closeRetentionLog(deleted bool) {
+	topic.retentionLock.Lock()
+	defer topic.retentionLock.Unlock()
+
+	if topic.retentionLog == nil {
+		return
+	}
+
+	if deleted {
+		_ = topic.retentionLog.Delete()
+	} else {
+		_ = topic.retentionLog.Close()
+	}
+	topic.retentionLog = nil
+}
+
+// dedupWindow 获取去重窗口，临时topic不去重
+func (topic *Topic) dedupWindow() time.Duration {
+	if topic.isTemporary {
+		return 0
+	}
+
+	window := topic.GetSettings().DedupWindow
+	if window == 0 {
+		window = config.GetLmqdConfig().DedupWindow
+	}
+	if window < 0 {
+		return 0
+	}
+
+	return window
+}
+
+// applyDedup 根据去重窗口开启、关闭或者更新dedup cache
+func (topic *Topic) applyDedup() {
+	window := topic.dedupWindow()
+
+	topic.dedupLock.Lock()
+	defer topic.dedupLock.Unlock()
+
+	if topic.isExiting.Load() {
+		return
+	}
+
+	if window <= 0 {
+		// 不再去重，删除去重记录
+		if topic.dedupCache != nil {
+			_ = topic.dedupCache.Delete()
+			topic.dedupCache = nil
+		}
+		return
+	}
+
+	if topic.dedupCache != nil {
+		topic.dedupCache.SetWindow(window)
+		return
+	}
+
+	lmqdConfig := config.GetLmqdConfig()
+	cache, err := dedup.NewCache(topic.name, lmqdConfig.DataRootPath, window, lmqdConfig.DedupMaxKeys)
+	if err != nil {
+		logger.Errorf("topic(%s) open dedup cache failed, err: %s", topic.name, err.Error())
+		return
+	}
+	topic.dedupCache = cache
+}
+
+// closeDedupCache 关闭dedup cache，deleted为true时删除去重记录
+func (topic *Topic) closeDedupCache(deleted bool) {
+	topic.dedupLock.Lock()
+	defer topic.dedupLock.Unlock()
+
+	if topic.dedupCache == nil {
+		return
+	}
+
+	if deleted {
+		_ = topic.dedupCache.Delete()
+	} else {
+		_ = topic.dedupCache.Close()
+	}
+	topic.dedupCache = nil
+}
+
+// AcceptDedupKey 记录消息的dedup key，去重窗口内已经发布过相同key的消息时返回false，相同key的消息正在发布时等待它的结果
+func (topic *Topic) AcceptDedupKey(msg iface.IMessage) (bool, error) {
+	dedupKey := msg.GetHeader(iface.DedupKeyHeader)
+	if dedupKey == "" {
+		return true, nil
+	}
+
+	topic.dedupLock.RLock()
+	defer topic.dedupLock.RUnlock()
+
+	if topic.dedupCache == nil {
+		return true, nil
+	}
+
+	return topic.dedupCache.Add(dedupKey)
+}
+
+// ForgetDedupKey 消息发布失败时删除dedup key，允许生产者重试
+func (topic *Topic) ForgetDedupKey(msg iface.IMessage) {
+	dedupKey := msg.GetHeader(iface.DedupKeyHeader)
+	if dedupKey == "" {
+		return
+	}
+
+	topic.dedupLock.RLock()
+	defer topic.dedupLock.RUnlock()
+
+	if topic.dedupCache != nil {
+		_ = topic.dedupCache.Remove(dedupKey)
+	}
+}
+
+// CommitDedupKey 消息发布成功之后确认dedup key，之后相同key的消息才会被丢弃
+func (topic *Topic) CommitDedupKey(msg iface.IMessage) {
+	dedupKey := msg.GetHeader(iface.DedupKeyHeader)
+	if dedupKey == "" {
+		return
+	}
+
+	topic.dedupLock.RLock()
+	defer topic.dedupLock.RUnlock()
+
+	if topic.dedupCache == nil {
+		return
+	}
+
+	if err := topic.dedupCache.Commit(dedupKey); err != nil {
+		logger.Errorf("topic(%s) commit dedup key failed, err: %s", topic.name, err.Error())
+	}
+}
+
 // GenerateGUID 生成一个message ID
 func (topic *Topic) GenerateGUID() iface.MessageID {
 	return topic.guidFactory.NewMessageID()
@@ -232,6 +521,37 @@ func (topic *Topic) GetName() string {
 	return topic.name
 }
 
+// Depth 还未分发到channel的消息数量（内存和磁盘）
+func (topic *Topic) Depth() int64 {
+	return int64(len(topic.memoryMsgChan)) + topic.backendQueue.Depth()
+}
+
+// GetStats 获取topic以及所有channel的统计信息
+func (topic *Topic) GetStats() *iface.TopicStats {
+	stats := &iface.TopicStats{
+		Name:         topic.name,
+		Paused:       topic.IsPausing(),
+		Depth:        topic.Depth(),
+		MessageCount: topic.messageCount.Load(),
+		MessageBytes: topic.messageBytes.Load(),
+		DroppedCount: topic.droppedCount.Load(),
+		Overrides:    topic.GetSettings().Overrides,
+		Channels:     []*iface.ChannelStats{},
+	}
+
+	topic.channelsLock.RLock()
+	for _, channel := range topic.channels {
+		stats.Channels = append(stats.Channels, channel.GetStats())
+	}
+	topic.channelsLock.RUnlock()
+
+	sort.Slice(stats.Channels, func(i, j int) bool {
+		return stats.Channels[i].Name < stats.Channels[j].Name
+	})
+
+	return stats
+}
+
 // GetChannelNames 获取所有的channel名字
 func (topic *Topic) GetChannelNames() []string {
 	topic.channelsLock.RLock()
@@ -247,6 +567,11 @@ func (topic *Topic) GetChannelNames() []string {
 
 // GetChannel 获取一个channel，如果没有就新建一个
 func (topic *Topic) GetChannel(name string) (iface.IChannel, error) {
+	return topic.GetChannelWithSettings(name, iface.ChannelSettings{})
+}
+
+// GetChannelWithSettings 获取一个channel，如果没有就按照settings新建一个，channel已经存在时settings不生效
+func (topic *Topic) GetChannelWithSettings(name string, settings iface.ChannelSettings) (iface.IChannel, error) {
 	// 检查channel name是否合法
 	if !utils.TopicOrChannelNameIsValid(name) {
 		return nil, e.ErrChannelNameInValid
@@ -273,7 +598,7 @@ func (topic *Topic) GetChannel(name string) (iface.IChannel, error) {
 	deleteCallback := func(channel iface.IChannel) {
 		_ = topic.DeleteExistingChannel(channel.GetName())
 	}
-	c := channel.NewChannel(topic.lmqd, topic.name, name, deleteCallback)
+	c := channel.NewChannel(topic.lmqd, topic.name, name, topic.GetSettings().Overrides, settings, deleteCallback)
 	topic.channels[name] = c
 	topic.channelsLock.Unlock()
 	topic.updateChan <- struct{}{}
@@ -299,17 +624,20 @@ func (topic *Topic) DeleteExistingChannel(name string) error {
 	// 检查channel是否存在
 	topic.channelsLock.RLock()
 	channel, exist := topic.channels[name]
+	topic.channelsLock.RUnlock()
 	if !exist {
 		return e.ErrChannelNotFound
 	}
-	topic.channelsLock.RUnlock()
 
 	// 存在就删除这个channel
 	_ = channel.Delete()
 
 	topic.channelsLock.Lock()
 	delete(topic.channels, name)
-	numChannels := len(topic.channels)
+	if len(topic.channels) == 0 {
+		// 临时topic没有channel之后，等待宽限期之后删除
+		topic.scheduleExpiry()
+	}
 	topic.channelsLock.Unlock()
 
 	// 更新messagePump状态
@@ -318,14 +646,34 @@ func (topic *Topic) DeleteExistingChannel(name string) error {
 	case <-topic.closingChan:
 	}
 
-	// 临时topic若channel为空，则立即删除这个topic
-	if numChannels == 0 && topic.isTemporary {
-		go topic.deleter.Do(func() {
-			topic.deleteCallback(topic)
-		})
+	return nil
+}
+
+// scheduleExpiry 临时topic没有channel时，等待宽限期之后删除，调用时需要持有channelsLock
+func (topic *Topic) scheduleExpiry() {
+	if !topic.isTemporary {
+		return
 	}
 
-	return nil
+	if topic.expiryTimer != nil {
+		topic.expiryTimer.Stop()
+	}
+	topic.expiryTimer = time.AfterFunc(config.GetLmqdConfig().EphemeralGracePeriod, topic.expire)
+}
+
+// expire 宽限期结束之后仍然没有channel，删除临时topic
+func (topic *Topic) expire() {
+	topic.channelsLock.RLock()
+	numChannels := len(topic.channels)
+	topic.channelsLock.RUnlock()
+
+	if numChannels > 0 || topic.isExiting.Load() {
+		return
+	}
+
+	topic.deleter.Do(func() {
+		topic.deleteCallback(topic)
+	})
 }
 
 func (topic *Topic) PutMessage(msg iface.IMessage) error {
@@ -335,14 +683,50 @@ func (topic *Topic) PutMessage(msg iface.IMessage) error {
 		return e.ErrTopicIsExiting
 	}
 
-	if msg.GetDataLength() < config.GlobalLmqdConfig.MinMessageSize || msg.GetDataLength() > config.GlobalLmqdConfig.MaxMessageSize {
+	if opts := topic.GetOptions(); msg.GetDataLength() < opts.MinMessageSize || msg.GetDataLength() > opts.MaxMessageSize {
 		// 消息长度不合法
 		return e.ErrMessageLengthInvalid
 	}
 
+	if err := message.CheckHeaders(msg.GetHeaders()); err != nil {
+		// 消息头部不合法
+		return err
+	}
+
+	// 保留消息，用于重放channel
+	// 消息放入队列之后会被channel和客户端修改（尝试次数、优先级等），保留的是放入之前的副本
+	topic.retentionLock.RLock()
+	defer topic.retentionLock.RUnlock()
+	var retained iface.IMessage
+	if topic.retentionLog != nil {
+		retained = message.CopyMessage(msg)
+	}
+
+	err := topic.put(msg)
+	if err != nil {
+		return err
+	}
+
+	if retained != nil {
+		err = topic.retentionLog.Append(retained)
+		if err != nil {
+			logger.Errorf("topic(%s) append message to retention log failed, err: %s", topic.name, err.Error())
+		}
+	}
+
+	topic.messageCount.Add(1)
+	topic.messageBytes.Add(uint64(len(msg.GetData())))
+
+	return nil
+}
+
+func (topic *Topic) put(msg iface.IMessage) error {
+	topic.putLock.RLock()
 	select {
 	case topic.memoryMsgChan <- msg:
+		topic.putLock.RUnlock()
 	default:
+		topic.putLock.RUnlock()
 		// 存入backend queue
 
 		// 转为[]byte
@@ -360,17 +744,107 @@ func (topic *Topic) PutMessage(msg iface.IMessage) error {
 		}
 	}
 
-	topic.messageCount.Add(1)
-	topic.messageBytes.Add(uint64(len(msg.GetData())))
-
 	return nil
 }
 
+// Export 按顺序导出topic中还未投递的消息（内存队列+磁盘队列），不会消费这些消息
+func (topic *Topic) Export(fn func(msg iface.IMessage) error) error {
+	topic.channelsLock.RLock()
+	defer topic.channelsLock.RUnlock()
+	if topic.isExiting.Load() {
+		return e.ErrTopicIsExiting
+	}
+
+	// 取出内存队列中的消息，导出之后按照原来的顺序放回
+	// 导出期间不会有新的消息放入内存chan，放回时不会超出容量，也不会排在新的消息之后
+	topic.putLock.Lock()
+	var memoryMsgs []iface.IMessage
+	for i := len(topic.memoryMsgChan); i > 0; i-- {
+		select {
+		case msg := <-topic.memoryMsgChan:
+			memoryMsgs = append(memoryMsgs, msg)
+		default:
+		}
+	}
+
+	var err error
+	for _, msg := range memoryMsgs {
+		if err == nil {
+			err = fn(msg)
+		}
+		topic.memoryMsgChan <- msg
+	}
+	topic.putLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 导出磁盘队列中的消息
+	return topic.backendQueue.Scan(func(data []byte) error {
+		msg, err := message.ConvertBytesToMessage(data)
+		if err != nil {
+			return err
+		}
+
+		return fn(msg)
+	})
+}
+
+// ReplayChannel 清空channel（不存在则新建），然后从时间戳不小于fromTimestamp、或者ID不小于fromID的消息开始，将保留的消息重新投递到channel中
+// fromID为空时使用fromTimestamp，重放期间新发布的消息可能会被重复投递
+func (topic *Topic) ReplayChannel(channelName string, fromTimestamp int64, fromID iface.MessageID) (int, error) {
+	topic.retentionLock.RLock()
+	enabled := topic.retentionLog != nil
+	topic.retentionLock.RUnlock()
+	if !enabled {
+		return 0, e.ErrTopicRetentionDisabled
+	}
+
+	// 先获取channel再持有retentionLock，和PutMessage的加锁顺序（channelsLock、retentionLock）一致
+	channel, err := topic.GetChannel(channelName)
+	if err != nil {
+		return 0, err
+	}
+
+	topic.retentionLock.RLock()
+	defer topic.retentionLock.RUnlock()
+
+	if topic.retentionLog == nil {
+		return 0, e.ErrTopicRetentionDisabled
+	}
+
+	// 回退channel，丢弃还未投递的消息
+	err = channel.Empty()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = topic.retentionLog.Replay(fromTimestamp, fromID, func(msg iface.IMessage) error {
+		msg.SetAttempts(0)
+		if !channel.AcceptMessage(msg) {
+			return nil
+		}
+		err := channel.PutMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
 func (topic *Topic) messagePump() {
 	var memoryMsgChan chan iface.IMessage
 	var backendMsgChan <-chan []byte
 	var msg iface.IMessage
 	var channels []iface.IChannel
+	var receivers []iface.IChannel
+	var receiverNames []string
+	var routes []*iface.RouteRule
 
 	for {
 		select {
@@ -393,7 +867,8 @@ func (topic *Topic) messagePump() {
 		channels = append(channels, channel)
 	}
 	topic.channelsLock.RUnlock()
-	if len(channels) > 0 && !topic.isPausing.Load() {
+	routes = topic.GetSettings().Routes
+	if (len(channels) > 0 || len(routes) > 0) && !topic.isPausing.Load() {
 		memoryMsgChan = topic.memoryMsgChan
 		backendMsgChan = topic.backendQueue.ReadChan()
 	}
@@ -408,17 +883,20 @@ func (topic *Topic) messagePump() {
 				logger.Errorf("topic(%s) convert bytes to message failed when message pump, err:%s", topic.name, err.Error())
 				continue
 			}
-		case <-topic.updateChan: // 更新channels
+		case <-topic.updateChan: // 更新channels和路由规则
 			channels = channels[:0]
 			topic.channelsLock.RLock()
 			for _, channel := range topic.channels {
 				channels = append(channels, channel)
 			}
 			topic.channelsLock.RUnlock()
-			if len(channels) == 0 || topic.isPausing.Load() {
+			routes = topic.GetSettings().Routes
+			if (len(channels) == 0 && len(routes) == 0) || topic.isPausing.Load() {
 				memoryMsgChan = nil
+				backendMsgChan = nil
 			} else {
 				memoryMsgChan = topic.memoryMsgChan
+				backendMsgChan = topic.backendQueue.ReadChan()
 			}
 
 			continue
@@ -436,17 +914,35 @@ func (topic *Topic) messagePump() {
 			continue
 		}
 
-		// 向所有channel发送消息
+		// 按照过滤条件和采样率选出接收消息的channel
+		receivers, receiverNames = receivers[:0], receiverNames[:0]
+		for _, channel := range channels {
+			if channel.AcceptMessage(msg) {
+				receivers = append(receivers, channel)
+				receiverNames = append(receiverNames, channel.GetName())
+			}
+		}
+
+		// 向所有接收消息的channel发送消息
 		logger.Infof("topic(%s) is publishing a message", topic.name)
-		for i, channel := range channels {
+		topic.lmqd.GetReplicationManager().Track(topic.name, msg.GetID(), receiverNames)
+		for i, channel := range receivers {
 			var chanMsg iface.IMessage
 
 			if i > 0 {
-				chanMsg = message.NewMessage(msg.GetID(), msg.GetData())
+				chanMsg = message.CopyMessage(msg)
 			} else {
 				chanMsg = msg
 			}
-			_ = channel.PutMessage(chanMsg)
+			if err := channel.PutMessage(chanMsg); err != nil {
+				// 投递失败的channel不会完成这个消息
+				topic.lmqd.GetReplicationManager().Finish(topic.name, channel.GetName(), msg.GetID())
+			}
+		}
+
+		// 按照路由规则转发到其他topic
+		if len(routes) > 0 {
+			topic.route(routes, msg)
 		}
 	}
 
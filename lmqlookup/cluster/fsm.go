@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqlookup/topology"
+	"github.com/dawnzzz/lmq/pkg/partition"
+	"time"
+)
+
+// Apply 将修改操作应用到registration db中，所有的操作都是幂等的
+func Apply(db iface.IRegistrationDB, cmd *iface.ClusterCommand) {
+	switch cmd.Op {
+	case iface.CreateTopicOp:
+		db.AddRegistration(topology.MakeRegistration(iface.TopicCategory, cmd.TopicName, ""))
+
+	case iface.DeleteTopicOp:
+		// 删除topic下所有的channels
+		chanRegs := db.FindRegistrations(iface.ChannelCategory, cmd.TopicName, "*")
+		for i := 0; i < chanRegs.Len(); i++ {
+			db.RemoveRegistration(chanRegs.GetItem(i))
+		}
+
+		// 删除topic
+		topicRegs := db.FindRegistrations(iface.TopicCategory, cmd.TopicName, "")
+		for i := 0; i < topicRegs.Len(); i++ {
+			db.RemoveRegistration(topicRegs.GetItem(i))
+		}
+		db.RemovePartitions(cmd.TopicName)
+
+	case iface.CreateChannelOp:
+		db.AddRegistration(topology.MakeRegistration(iface.ChannelCategory, cmd.TopicName, cmd.ChannelName))
+		db.AddRegistration(topology.MakeRegistration(iface.TopicCategory, cmd.TopicName, ""))
+
+	case iface.DeleteChannelOp:
+		chanRegs := db.FindRegistrations(iface.ChannelCategory, cmd.TopicName, cmd.ChannelName)
+		for i := 0; i < chanRegs.Len(); i++ {
+			db.RemoveRegistration(chanRegs.GetItem(i))
+		}
+
+	case iface.TombstoneTopicOp:
+		tombstonedAt := time.Now()
+		if cmd.TombstonedAt != 0 {
+			tombstonedAt = time.Unix(0, cmd.TombstonedAt)
+		}
+		db.TombstoneProducers(cmd.TopicName, cmd.Hostname, cmd.TcpPort, tombstonedAt)
+
+	case iface.UnTombstoneTopicOp:
+		db.UnTombstoneProducers(cmd.TopicName, cmd.Hostname, cmd.TcpPort)
+
+	case iface.CreatePartitionedTopicOp:
+		db.AddRegistration(topology.MakeRegistration(iface.TopicCategory, cmd.TopicName, ""))
+		for i := range cmd.Partitions {
+			db.AddRegistration(topology.MakeRegistration(iface.TopicCategory, partition.TopicName(cmd.TopicName, i), ""))
+		}
+		db.SetPartitions(cmd.TopicName, cmd.Partitions)
+	}
+}
+
+// Standalone 没有开启集群模式时使用，直接应用修改操作
+type Standalone struct {
+	db    iface.IRegistrationDB
+	store iface.IRegistrationStore
+}
+
+func NewStandalone(db iface.IRegistrationDB, store iface.IRegistrationStore) iface.ILookupCluster {
+	return &Standalone{db: db, store: store}
+}
+
+func (s *Standalone) Start() {}
+
+func (s *Standalone) Stop() {}
+
+func (s *Standalone) Propose(cmd *iface.ClusterCommand) error {
+	Apply(s.db, cmd)
+	return s.store.Append(cmd, 0, 0)
+}
+
+func (s *Standalone) IsLeader() bool {
+	return true
+}
+
+func (s *Standalone) GetLeader() string {
+	return ""
+}
@@ -0,0 +1,884 @@
+package cluster
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	简化的raft协议，用于在lmq lookup集群中复制管理命令（创建、删除topic/channel，tombstone）
+	所有的修改操作都由leader追加到日志中，复制到多数节点之后提交，再由每个节点按照相同的顺序应用到registration db中
+	follower收到的修改操作会转发给leader，查询操作只读取本地的registration db
+	term、投票和日志在回复请求之前持久化，节点重启之后从持久化的状态恢复
+	已经应用的日志保存在registration store中，超过maxLogEntries条之后压缩日志，落后太多的节点由leader发送快照
+*/
+
+type state uint8
+
+const (
+	follower = state(iota)
+	candidate
+	leader
+)
+
+func (s state) String() string {
+	switch s {
+	case follower:
+		return "follower"
+	case candidate:
+		return "candidate"
+	case leader:
+		return "leader"
+	}
+
+	return "unknown"
+}
+
+const (
+	tickInterval       = 50 * time.Millisecond
+	heartbeatInterval  = 300 * time.Millisecond
+	minElectionTimeout = 1500 * time.Millisecond
+	maxElectionTimeout = 3000 * time.Millisecond
+	rpcTimeout         = time.Second
+	proposeTimeout     = 5 * time.Second
+
+	maxEntriesPerAppend = 512
+)
+
+var maxLogEntries uint64 = 1024 // 已经应用的日志超过多少条之后压缩
+
+// LogEntry 日志条目
+type LogEntry struct {
+	Term    uint64                `json:"term"`
+	Index   uint64                `json:"index"`
+	Command *iface.ClusterCommand `json:"command,omitempty"`
+}
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64     `json:"term"`
+	Leader       string     `json:"leader"`
+	PrevLogIndex uint64     `json:"prev_log_index"`
+	PrevLogTerm  uint64     `json:"prev_log_term"`
+	Entries      []LogEntry `json:"entries,omitempty"`
+	LeaderCommit uint64     `json:"leader_commit"`
+}
+
+type AppendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index"` // 失败时，leader下一次从这个位置开始发送
+}
+
+type InstallSnapshotRequest struct {
+	Term      uint64 `json:"term"`
+	Leader    string `json:"leader"`
+	LastIndex uint64 `json:"last_index"` // 快照包含的最后一条日志
+	LastTerm  uint64 `json:"last_term"`
+	Data      []byte `json:"data"`
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// Status 节点状态
+type Status struct {
+	Address     string `json:"address"`
+	State       string `json:"state"`
+	Term        uint64 `json:"term"`
+	Leader      string `json:"leader"`
+	LastIndex   uint64 `json:"last_index"`
+	CommitIndex uint64 `json:"commit_index"`
+	LastApplied uint64 `json:"last_applied"`
+}
+
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+// Raft lmq lookup集群中的一个节点
+type Raft struct {
+	sync.Mutex
+
+	address string   // 本节点地址
+	peers   []string // 其他节点地址
+	db      iface.IRegistrationDB
+	store   iface.IRegistrationStore // 持久化已经应用的修改操作，同时作为日志压缩的快照
+	storage *raftStorage             // 持久化term、投票和日志
+
+	state       state
+	currentTerm uint64
+	votedFor    string
+	leader      string
+	log         []LogEntry // log[0]为占位的空日志，index和term为日志压缩的位置
+	commitIndex uint64
+	lastApplied uint64
+	deadline    time.Time // 选举超时时间
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	sending    map[string]bool // 是否正在向某个节点发送AppendEntries
+
+	proposals map[uint64]*proposal // 日志index -> 等待提交的修改操作
+
+	transport transport
+
+	applyLock sync.Mutex // 应用日志和恢复快照互斥
+	applyChan chan struct{}
+	isExiting atomic.Bool
+	exitChan  chan struct{}
+}
+
+func NewRaft(address string, peers []string, dataPath string, db iface.IRegistrationDB, store iface.IRegistrationStore) (*Raft, error) {
+	r := &Raft{
+		address:   address,
+		peers:     peers,
+		db:        db,
+		store:     store,
+		storage:   newRaftStorage(dataPath),
+		proposals: make(map[uint64]*proposal),
+		transport: newTCPTransport(protocol.RequestVoteID, protocol.AppendEntriesID, protocol.ProposeID, protocol.InstallSnapshotID),
+		applyChan: make(chan struct{}, 1),
+		exitChan:  make(chan struct{}),
+	}
+
+	// 加载持久化的状态
+	state, entries, err := r.storage.load()
+	if err != nil {
+		return nil, err
+	}
+	r.currentTerm = state.Term
+	r.votedFor = state.VotedFor
+	r.log = append([]LogEntry{{Term: state.SnapshotTerm, Index: state.SnapshotIndex}}, entries...)
+	r.resetDeadline()
+
+	return r, nil
+}
+
+// Start 启动节点，调用之前registration store已经加载完成
+func (r *Raft) Start() {
+	r.Lock()
+	// 已经应用的日志都已经提交了
+	index, term := r.store.AppliedIndex()
+	if index < r.snapshotIndex() || index > r.lastIndex() || r.entry(index).Term != term {
+		// registration store与日志不一致，丢弃本地的日志，由leader重新同步
+		logger.Warnf("lookup cluster node(%s) applied index %d does not match raft log, drop local log", r.address, index)
+		r.log = []LogEntry{{Term: term, Index: index}}
+		if err := r.persistSnapshot(); err != nil {
+			logger.Errorf("lookup cluster node(%s) persist raft log failed, err: %s", r.address, err.Error())
+		}
+	}
+	r.commitIndex = index
+	r.lastApplied = index
+	r.Unlock()
+
+	go r.tickLoop()
+	go r.applyLoop()
+}
+
+func (r *Raft) Stop() {
+	if !r.isExiting.CompareAndSwap(false, true) {
+		return
+	}
+
+	close(r.exitChan)
+	r.transport.close()
+
+	r.Lock()
+	r.storage.close()
+	r.Unlock()
+}
+
+// IsLeader 本节点是否是leader
+func (r *Raft) IsLeader() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.state == leader
+}
+
+// GetLeader 获取leader的地址
+func (r *Raft) GetLeader() string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.leader
+}
+
+// GetStatus 获取节点状态
+func (r *Raft) GetStatus() *Status {
+	r.Lock()
+	defer r.Unlock()
+
+	return &Status{
+		Address:     r.address,
+		State:       r.state.String(),
+		Term:        r.currentTerm,
+		Leader:      r.leader,
+		LastIndex:   r.lastIndex(),
+		CommitIndex: r.commitIndex,
+		LastApplied: r.lastApplied,
+	}
+}
+
+// Propose 提交一个修改操作，follower会转发给leader，还不知道leader时等待leader确定，超过proposeTimeout返回错误
+func (r *Raft) Propose(cmd *iface.ClusterCommand) error {
+	deadline := time.Now().Add(proposeTimeout)
+	for {
+		r.Lock()
+		if r.state == leader {
+			break
+		}
+		leaderAddress := r.leader
+		r.Unlock()
+
+		if leaderAddress != "" {
+			// 转发给leader
+			return r.transport.call(leaderAddress, protocol.ProposeID, cmd, nil, time.Until(deadline))
+		}
+
+		// 正在选举，或者刚选出的leader还没有发送心跳
+		if !time.Now().Before(deadline) {
+			return e.ErrClusterNoLeader
+		}
+		select {
+		case <-time.After(tickInterval):
+		case <-r.exitChan:
+			return e.ErrClusterNoLeader
+		}
+	}
+
+	// 持久化之后追加到日志中
+	entry := LogEntry{
+		Term:    r.currentTerm,
+		Index:   r.lastIndex() + 1,
+		Command: cmd,
+	}
+	if err := r.storage.append([]LogEntry{entry}); err != nil {
+		r.Unlock()
+		logger.Errorf("lookup cluster node(%s) persist raft log failed, err: %s", r.address, err.Error())
+		return e.ErrClusterProposeFailed
+	}
+	r.log = append(r.log, entry)
+	p := &proposal{
+		term: entry.Term,
+		done: make(chan error, 1),
+	}
+	r.proposals[entry.Index] = p
+	r.Unlock()
+
+	// 立即复制到其他节点
+	r.broadcastAppendEntries()
+
+	timer := time.NewTimer(proposeTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-timer.C:
+		r.Lock()
+		delete(r.proposals, entry.Index)
+		r.Unlock()
+		return e.ErrClusterProposeFailed
+	case <-r.exitChan:
+		return e.ErrClusterProposeFailed
+	}
+}
+
+// HandleRequestVote 处理投票请求
+func (r *Raft) HandleRequestVote(req *VoteRequest) *VoteResponse {
+	r.Lock()
+	defer r.Unlock()
+
+	if req.Term > r.currentTerm {
+		r.becomeFollower(req.Term, "")
+	}
+
+	resp := &VoteResponse{Term: r.currentTerm}
+	if req.Term < r.currentTerm {
+		return resp
+	}
+
+	// 候选人的日志至少和自己一样新，才进行投票
+	lastTerm := r.entry(r.lastIndex()).Term
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= r.lastIndex())
+	if (r.votedFor == "" || r.votedFor == req.Candidate) && upToDate {
+		// 投票持久化之后才回复
+		r.votedFor = req.Candidate
+		if err := r.persistState(); err != nil {
+			logger.Errorf("lookup cluster node(%s) persist vote failed, err: %s", r.address, err.Error())
+			r.votedFor = ""
+			return resp
+		}
+		r.resetDeadline()
+		resp.VoteGranted = true
+	}
+
+	return resp
+}
+
+// HandleAppendEntries 处理leader发送的日志
+func (r *Raft) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	r.Lock()
+	defer r.Unlock()
+
+	resp := &AppendEntriesResponse{Term: r.currentTerm}
+	if req.Term < r.currentTerm {
+		return resp
+	}
+
+	if req.Term > r.currentTerm || r.state != follower {
+		r.becomeFollower(req.Term, req.Leader)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+	resp.Term = r.currentTerm
+
+	// 已经压缩的日志一定是匹配的
+	entries, lastNewIndex := req.Entries, req.PrevLogIndex+uint64(len(req.Entries))
+	if req.PrevLogIndex < r.snapshotIndex() {
+		skip := r.snapshotIndex() - req.PrevLogIndex
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		entries = entries[skip:]
+		req.PrevLogIndex += skip
+		if req.PrevLogIndex < r.snapshotIndex() {
+			resp.Success = true
+			return resp
+		}
+		req.PrevLogTerm = r.entry(req.PrevLogIndex).Term
+	}
+
+	// 检查前一条日志是否匹配
+	if req.PrevLogIndex > r.lastIndex() {
+		resp.ConflictIndex = r.lastIndex() + 1
+		return resp
+	}
+	if r.entry(req.PrevLogIndex).Term != req.PrevLogTerm {
+		// 跳过冲突的整个term
+		conflictTerm := r.entry(req.PrevLogIndex).Term
+		index := req.PrevLogIndex
+		for index > r.snapshotIndex()+1 && r.entry(index-1).Term == conflictTerm {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	// 跳过已经存在的日志，找到第一条冲突或者新的日志
+	truncate := false
+	for len(entries) > 0 {
+		index := entries[0].Index
+		if index > r.lastIndex() {
+			break
+		}
+		if r.entry(index).Term != entries[0].Term {
+			truncate = true
+			break
+		}
+		entries = entries[1:]
+	}
+
+	// 持久化之后再修改内存中的日志，删除冲突的日志
+	if len(entries) > 0 {
+		var err error
+		if truncate {
+			kept := r.log[1 : entries[0].Index-r.snapshotIndex()]
+			err = r.storage.rewrite(append(append([]LogEntry{}, kept...), entries...))
+		} else {
+			err = r.storage.append(entries)
+		}
+		if err != nil {
+			logger.Errorf("lookup cluster node(%s) persist raft log failed, err: %s", r.address, err.Error())
+			return resp
+		}
+		r.log = append(r.log[:entries[0].Index-r.snapshotIndex()], entries...)
+	}
+
+	// 更新commit index
+	if req.LeaderCommit > r.commitIndex {
+		if req.LeaderCommit < lastNewIndex {
+			r.commitIndex = req.LeaderCommit
+		} else {
+			r.commitIndex = lastNewIndex
+		}
+		r.notifyApply()
+	}
+
+	resp.Success = true
+	return resp
+}
+
+// HandleInstallSnapshot 处理leader发送的快照，本节点落后太多时，leader已经压缩了需要的日志
+func (r *Raft) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	r.Lock()
+	if req.Term < r.currentTerm {
+		defer r.Unlock()
+		return &InstallSnapshotResponse{Term: r.currentTerm}
+	}
+	if req.Term > r.currentTerm || r.state != follower {
+		r.becomeFollower(req.Term, req.Leader)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+	resp := &InstallSnapshotResponse{Term: r.currentTerm}
+	r.Unlock()
+
+	// 恢复快照时不能同时应用日志
+	r.applyLock.Lock()
+	defer r.applyLock.Unlock()
+
+	r.Lock()
+	applied := r.lastApplied
+	r.Unlock()
+	if req.LastIndex <= applied {
+		return resp
+	}
+
+	if err := r.store.Restore(r.db, req.Data); err != nil {
+		logger.Errorf("lookup cluster node(%s) restore snapshot failed, err: %s", r.address, err.Error())
+		return resp
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// 保留快照之后匹配的日志
+	if req.LastIndex < r.lastIndex() && r.entry(req.LastIndex).Term == req.LastTerm {
+		r.log = append([]LogEntry{{Term: req.LastTerm, Index: req.LastIndex}}, r.log[req.LastIndex-r.snapshotIndex()+1:]...)
+	} else {
+		r.log = []LogEntry{{Term: req.LastTerm, Index: req.LastIndex}}
+	}
+	if err := r.persistSnapshot(); err != nil {
+		logger.Errorf("lookup cluster node(%s) persist raft log failed, err: %s", r.address, err.Error())
+	}
+	if req.LastIndex > r.commitIndex {
+		r.commitIndex = req.LastIndex
+	}
+	r.lastApplied = req.LastIndex
+	logger.Infof("lookup cluster node(%s) installs snapshot at index %d", r.address, req.LastIndex)
+
+	return resp
+}
+
+// snapshotIndex 日志压缩的位置，调用时已经加锁
+func (r *Raft) snapshotIndex() uint64 {
+	return r.log[0].Index
+}
+
+// entry 获取index对应的日志，index不能小于snapshotIndex，调用时已经加锁
+func (r *Raft) entry(index uint64) LogEntry {
+	return r.log[index-r.snapshotIndex()]
+}
+
+func (r *Raft) lastIndex() uint64 {
+	return r.snapshotIndex() + uint64(len(r.log)-1)
+}
+
+// persistState 持久化term、投票和日志压缩的位置，调用时已经加锁
+func (r *Raft) persistState() error {
+	return r.storage.saveState(&hardState{
+		Term:          r.currentTerm,
+		VotedFor:      r.votedFor,
+		SnapshotIndex: r.snapshotIndex(),
+		SnapshotTerm:  r.log[0].Term,
+	})
+}
+
+// persistSnapshot 日志压缩之后持久化，先保存压缩的位置，再重新生成日志文件，调用时已经加锁
+func (r *Raft) persistSnapshot() error {
+	if err := r.persistState(); err != nil {
+		return err
+	}
+
+	return r.storage.rewrite(r.log[1:])
+}
+
+// compact 已经应用的日志超过maxLogEntries条时压缩日志，被压缩的日志已经保存在registration store中
+func (r *Raft) compact() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.lastApplied-r.snapshotIndex() < maxLogEntries {
+		return
+	}
+
+	last := r.entry(r.lastApplied)
+	r.log = append([]LogEntry{{Term: last.Term, Index: last.Index}}, r.log[last.Index-r.snapshotIndex()+1:]...)
+	if err := r.persistSnapshot(); err != nil {
+		logger.Errorf("lookup cluster node(%s) compact raft log failed, err: %s", r.address, err.Error())
+	}
+}
+
+func (r *Raft) resetDeadline() {
+	timeout := minElectionTimeout + time.Duration(rand.Int63n(int64(maxElectionTimeout-minElectionTimeout)))
+	r.deadline = time.Now().Add(timeout)
+}
+
+func (r *Raft) notifyApply() {
+	select {
+	case r.applyChan <- struct{}{}:
+	default:
+	}
+}
+
+// becomeFollower 调用时已经加锁
+func (r *Raft) becomeFollower(term uint64, leaderAddress string) {
+	if term > r.currentTerm {
+		r.currentTerm = term
+		r.votedFor = ""
+		if err := r.persistState(); err != nil {
+			logger.Errorf("lookup cluster node(%s) persist term failed, err: %s", r.address, err.Error())
+		}
+	}
+	if r.state != follower {
+		logger.Infof("lookup cluster node(%s) becomes follower at term %d", r.address, r.currentTerm)
+	}
+	r.state = follower
+	r.leader = leaderAddress
+	r.resetDeadline()
+}
+
+// becomeLeader 调用时已经加锁
+func (r *Raft) becomeLeader() {
+	logger.Infof("lookup cluster node(%s) becomes leader at term %d", r.address, r.currentTerm)
+	r.state = leader
+	r.leader = r.address
+	r.nextIndex = make(map[string]uint64, len(r.peers))
+	r.matchIndex = make(map[string]uint64, len(r.peers))
+	r.sending = make(map[string]bool, len(r.peers))
+	for _, peer := range r.peers {
+		r.nextIndex[peer] = r.lastIndex() + 1
+		r.matchIndex[peer] = 0
+	}
+
+	// 追加一条空日志，用于提交之前term的日志
+	entry := LogEntry{Term: r.currentTerm, Index: r.lastIndex() + 1}
+	if err := r.storage.append([]LogEntry{entry}); err != nil {
+		logger.Errorf("lookup cluster node(%s) persist raft log failed, err: %s", r.address, err.Error())
+		return
+	}
+	r.log = append(r.log, entry)
+	r.advanceCommitIndex()
+}
+
+func (r *Raft) quorum() int {
+	return (len(r.peers)+1)/2 + 1
+}
+
+func (r *Raft) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	lastHeartbeat := time.Time{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.exitChan:
+			return
+		}
+
+		r.Lock()
+		isLeader := r.state == leader
+		timeout := !isLeader && time.Now().After(r.deadline)
+		r.Unlock()
+
+		if isLeader {
+			if time.Since(lastHeartbeat) >= heartbeatInterval {
+				lastHeartbeat = time.Now()
+				r.broadcastAppendEntries()
+			}
+			continue
+		}
+
+		if timeout {
+			r.startElection()
+		}
+	}
+}
+
+// startElection 发起选举
+func (r *Raft) startElection() {
+	r.Lock()
+	r.state = candidate
+	r.currentTerm++
+	r.votedFor = r.address
+	r.leader = ""
+	r.resetDeadline()
+	if err := r.persistState(); err != nil {
+		r.Unlock()
+		logger.Errorf("lookup cluster node(%s) persist term failed, err: %s", r.address, err.Error())
+		return
+	}
+	term := r.currentTerm
+	req := &VoteRequest{
+		Term:         term,
+		Candidate:    r.address,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.entry(r.lastIndex()).Term,
+	}
+	votes := 1
+	if votes >= r.quorum() {
+		r.becomeLeader()
+		r.Unlock()
+		return
+	}
+	r.Unlock()
+
+	logger.Infof("lookup cluster node(%s) starts election at term %d", r.address, term)
+
+	for _, peer := range r.peers {
+		go func(peer string) {
+			resp := &VoteResponse{}
+			if err := r.transport.call(peer, protocol.RequestVoteID, req, resp, rpcTimeout); err != nil {
+				return
+			}
+
+			r.Lock()
+			defer r.Unlock()
+			if resp.Term > r.currentTerm {
+				r.becomeFollower(resp.Term, "")
+				return
+			}
+			if r.state != candidate || r.currentTerm != term || !resp.VoteGranted {
+				return
+			}
+
+			votes++
+			if votes >= r.quorum() {
+				r.becomeLeader()
+				go r.broadcastAppendEntries()
+			}
+		}(peer)
+	}
+}
+
+// broadcastAppendEntries 向所有节点发送日志（或者心跳）
+func (r *Raft) broadcastAppendEntries() {
+	for _, peer := range r.peers {
+		r.Lock()
+		if r.state != leader || r.sending[peer] {
+			r.Unlock()
+			continue
+		}
+		r.sending[peer] = true
+		r.Unlock()
+
+		go r.sendAppendEntries(peer)
+	}
+}
+
+func (r *Raft) sendAppendEntries(peer string) {
+	defer func() {
+		r.Lock()
+		if r.sending != nil {
+			r.sending[peer] = false
+		}
+		r.Unlock()
+	}()
+
+	r.Lock()
+	if r.state != leader {
+		r.Unlock()
+		return
+	}
+	next := r.nextIndex[peer]
+	if next < 1 {
+		next = 1
+	}
+	if next <= r.snapshotIndex() {
+		// 需要的日志已经被压缩了，发送快照
+		r.Unlock()
+		r.sendSnapshot(peer)
+		return
+	}
+	end := r.lastIndex() + 1
+	if end-next > maxEntriesPerAppend {
+		end = next + maxEntriesPerAppend
+	}
+	entries := make([]LogEntry, end-next)
+	copy(entries, r.log[next-r.snapshotIndex():end-r.snapshotIndex()])
+	req := &AppendEntriesRequest{
+		Term:         r.currentTerm,
+		Leader:       r.address,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  r.entry(next - 1).Term,
+		Entries:      entries,
+		LeaderCommit: r.commitIndex,
+	}
+	r.Unlock()
+
+	resp := &AppendEntriesResponse{}
+	if err := r.transport.call(peer, protocol.AppendEntriesID, req, resp, rpcTimeout); err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if resp.Term > r.currentTerm {
+		r.becomeFollower(resp.Term, "")
+		return
+	}
+	if r.state != leader || r.currentTerm != req.Term {
+		return
+	}
+
+	if resp.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > r.matchIndex[peer] {
+			r.matchIndex[peer] = match
+		}
+		r.nextIndex[peer] = match + 1
+		r.advanceCommitIndex()
+		return
+	}
+
+	if resp.ConflictIndex >= 1 && resp.ConflictIndex < r.nextIndex[peer] {
+		r.nextIndex[peer] = resp.ConflictIndex
+	} else if r.nextIndex[peer] > 1 {
+		r.nextIndex[peer]--
+	}
+}
+
+// sendSnapshot 向落后的节点发送registration store的快照
+func (r *Raft) sendSnapshot(peer string) {
+	data, index, term, err := r.store.Snapshot()
+	if err != nil {
+		logger.Errorf("lookup cluster node(%s) make snapshot failed, err: %s", r.address, err.Error())
+		return
+	}
+
+	r.Lock()
+	if r.state != leader {
+		r.Unlock()
+		return
+	}
+	req := &InstallSnapshotRequest{
+		Term:      r.currentTerm,
+		Leader:    r.address,
+		LastIndex: index,
+		LastTerm:  term,
+		Data:      data,
+	}
+	r.Unlock()
+
+	resp := &InstallSnapshotResponse{}
+	if err = r.transport.call(peer, protocol.InstallSnapshotID, req, resp, rpcTimeout); err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if resp.Term > r.currentTerm {
+		r.becomeFollower(resp.Term, "")
+		return
+	}
+	if r.state != leader || r.currentTerm != req.Term {
+		return
+	}
+
+	if index > r.matchIndex[peer] {
+		r.matchIndex[peer] = index
+	}
+	r.nextIndex[peer] = index + 1
+	r.advanceCommitIndex()
+}
+
+// advanceCommitIndex leader根据多数节点的复制情况更新commit index，调用时已经加锁
+func (r *Raft) advanceCommitIndex() {
+	for index := r.lastIndex(); index > r.commitIndex; index-- {
+		// 只能直接提交当前term的日志
+		if r.entry(index).Term != r.currentTerm {
+			break
+		}
+
+		count := 1
+		for _, match := range r.matchIndex {
+			if match >= index {
+				count++
+			}
+		}
+		if count >= r.quorum() {
+			r.commitIndex = index
+			r.notifyApply()
+			break
+		}
+	}
+}
+
+// applyLoop 按照顺序应用已经提交的日志
+func (r *Raft) applyLoop() {
+	for {
+		select {
+		case <-r.applyChan:
+		case <-r.exitChan:
+			return
+		}
+
+		for r.applyNext() {
+		}
+		r.compact()
+	}
+}
+
+// applyNext 应用下一条已经提交的日志，没有需要应用的日志时返回false
+func (r *Raft) applyNext() bool {
+	r.applyLock.Lock()
+	defer r.applyLock.Unlock()
+
+	r.Lock()
+	if r.lastApplied >= r.commitIndex {
+		r.Unlock()
+		return false
+	}
+	entry := r.entry(r.lastApplied + 1)
+	p, ok := r.proposals[entry.Index]
+	if ok {
+		delete(r.proposals, entry.Index)
+	}
+	r.Unlock()
+
+	if entry.Command != nil {
+		Apply(r.db, entry.Command)
+	}
+	// 空日志也记录位置，registration store中的状态与已经应用的日志一致
+	if err := r.store.Append(entry.Command, entry.Index, entry.Term); err != nil {
+		logger.Errorf("lookup cluster node(%s) persist log entry(%d) failed, err: %s", r.address, entry.Index, err.Error())
+	}
+
+	r.Lock()
+	r.lastApplied = entry.Index
+	r.Unlock()
+
+	if ok {
+		if p.term == entry.Term {
+			p.done <- nil
+		} else {
+			// 日志被新的leader覆盖了
+			p.done <- e.ErrClusterProposeFailed
+		}
+	}
+
+	return true
+}
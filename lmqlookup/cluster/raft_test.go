@@ -0,0 +1,376 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqlookup/store"
+	"github.com/dawnzzz/lmq/lmqlookup/topology"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errNodeDown = errors.New("node is down")
+
+// memNetwork 在内存中转发集群节点之间的请求，可以模拟节点宕机
+type memNetwork struct {
+	sync.Mutex
+	nodes map[string]*Raft
+	down  map[string]bool
+}
+
+type memTransport struct {
+	network *memNetwork
+	from    string
+}
+
+func (t *memTransport) call(address string, taskID uint32, args interface{}, reply interface{}, _ time.Duration) error {
+	t.network.Lock()
+	r := t.network.nodes[address]
+	down := t.network.down[address] || t.network.down[t.from]
+	t.network.Unlock()
+	if r == nil || down {
+		return errNodeDown
+	}
+
+	// 与tcp传输一样，请求和响应都经过序列化
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	var resp interface{}
+	switch taskID {
+	case protocol.RequestVoteID:
+		req := &VoteRequest{}
+		_ = json.Unmarshal(data, req)
+		resp = r.HandleRequestVote(req)
+	case protocol.AppendEntriesID:
+		req := &AppendEntriesRequest{}
+		_ = json.Unmarshal(data, req)
+		resp = r.HandleAppendEntries(req)
+	case protocol.InstallSnapshotID:
+		req := &InstallSnapshotRequest{}
+		_ = json.Unmarshal(data, req)
+		resp = r.HandleInstallSnapshot(req)
+	case protocol.ProposeID:
+		cmd := &iface.ClusterCommand{}
+		_ = json.Unmarshal(data, cmd)
+		if !r.IsLeader() {
+			return errors.New("not leader")
+		}
+		return r.Propose(cmd)
+	}
+
+	if reply == nil {
+		return nil
+	}
+	data, err = json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, reply)
+}
+
+func (t *memTransport) close() {}
+
+type testCluster struct {
+	t       *testing.T
+	network *memNetwork
+	addrs   []string
+	dirs    map[string]string
+	dbs     map[string]iface.IRegistrationDB
+	stores  map[string]iface.IRegistrationStore
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:       t,
+		network: &memNetwork{nodes: map[string]*Raft{}, down: map[string]bool{}},
+		dirs:    map[string]string{},
+		dbs:     map[string]iface.IRegistrationDB{},
+		stores:  map[string]iface.IRegistrationStore{},
+	}
+	for i := 0; i < size; i++ {
+		addr := fmt.Sprintf("lookup%d:6300", i)
+		c.addrs = append(c.addrs, addr)
+		c.dirs[addr] = t.TempDir()
+	}
+	for _, addr := range c.addrs {
+		c.start(addr)
+	}
+	t.Cleanup(func() {
+		for _, addr := range c.addrs {
+			c.stop(addr)
+		}
+	})
+
+	return c
+}
+
+// start 使用节点的数据目录启动节点，重启时从持久化的状态恢复
+func (c *testCluster) start(addr string) *Raft {
+	c.t.Helper()
+
+	var peers []string
+	for _, peer := range c.addrs {
+		if peer != addr {
+			peers = append(peers, peer)
+		}
+	}
+
+	db := topology.NewRegistrationDB()
+	s := store.NewRegistrationStore(c.dirs[addr])
+	if err := s.Load(db); err != nil {
+		c.t.Fatalf("load store err: %s", err)
+	}
+	r, err := NewRaft(addr, peers, c.dirs[addr], db, s)
+	if err != nil {
+		c.t.Fatalf("new raft err: %s", err)
+	}
+	r.transport = &memTransport{network: c.network, from: addr}
+
+	c.network.Lock()
+	c.network.nodes[addr] = r
+	c.network.down[addr] = false
+	c.network.Unlock()
+	c.dbs[addr] = db
+	c.stores[addr] = s
+	r.Start()
+
+	return r
+}
+
+func (c *testCluster) stop(addr string) {
+	c.network.Lock()
+	r := c.network.nodes[addr]
+	c.network.down[addr] = true
+	delete(c.network.nodes, addr)
+	c.network.Unlock()
+
+	if r != nil {
+		r.Stop()
+		_ = c.stores[addr].Close()
+	}
+}
+
+func (c *testCluster) node(addr string) *Raft {
+	c.network.Lock()
+	defer c.network.Unlock()
+
+	return c.network.nodes[addr]
+}
+
+// waitLeader 等待集群中存活的节点选出唯一的leader，并且所有存活的follower都已经知道这个leader
+func (c *testCluster) waitLeader() *Raft {
+	c.t.Helper()
+
+	var leaderNode *Raft
+	if !waitFor(10*time.Second, func() bool {
+		var nodes []*Raft
+		leaderNode = nil
+		count := 0
+		for _, addr := range c.addrs {
+			r := c.node(addr)
+			if r == nil {
+				continue
+			}
+			nodes = append(nodes, r)
+			if r.IsLeader() {
+				leaderNode = r
+				count++
+			}
+		}
+		if count != 1 {
+			return false
+		}
+		for _, r := range nodes {
+			if r.GetLeader() != leaderNode.address {
+				return false
+			}
+		}
+		return true
+	}) {
+		c.t.Fatal("cluster has no leader")
+	}
+
+	return leaderNode
+}
+
+func (c *testCluster) hasTopic(addr, topicName string) bool {
+	return c.dbs[addr].FindRegistrations(iface.TopicCategory, topicName, "").Len() > 0
+}
+
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return cond()
+}
+
+func createTopic(topicName string) *iface.ClusterCommand {
+	return &iface.ClusterCommand{Op: iface.CreateTopicOp, TopicName: topicName}
+}
+
+func TestRaftElection(t *testing.T) {
+	c := newTestCluster(t, 3)
+
+	first := c.waitLeader()
+	term := first.GetStatus().Term
+
+	// leader宕机之后，剩下的节点在更高的term选出新的leader
+	c.stop(first.address)
+	second := c.waitLeader()
+	if second.address == first.address || second.GetStatus().Term <= term {
+		t.Fatalf("new leader %s at term %d, old leader %s at term %d", second.address, second.GetStatus().Term, first.address, term)
+	}
+}
+
+func TestRaftReplication(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leaderNode := c.waitLeader()
+
+	// follower收到的修改操作转发给leader
+	var followerNode *Raft
+	for _, addr := range c.addrs {
+		if addr != leaderNode.address {
+			followerNode = c.node(addr)
+			break
+		}
+	}
+	if err := followerNode.Propose(createTopic("from_follower")); err != nil {
+		t.Fatalf("propose by follower err: %s", err)
+	}
+	if err := leaderNode.Propose(createTopic("from_leader")); err != nil {
+		t.Fatalf("propose by leader err: %s", err)
+	}
+
+	for _, addr := range c.addrs {
+		addr := addr
+		if !waitFor(5*time.Second, func() bool {
+			return c.hasTopic(addr, "from_follower") && c.hasTopic(addr, "from_leader")
+		}) {
+			t.Fatalf("topics are not replicated to %s", addr)
+		}
+	}
+}
+
+func TestRaftRestart(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leaderNode := c.waitLeader()
+	if err := leaderNode.Propose(createTopic("before")); err != nil {
+		t.Fatalf("propose err: %s", err)
+	}
+
+	// 重启一个follower，term、投票和日志从磁盘恢复
+	var addr string
+	for _, a := range c.addrs {
+		if a != leaderNode.address {
+			addr = a
+			break
+		}
+	}
+	if !waitFor(5*time.Second, func() bool { return c.hasTopic(addr, "before") }) {
+		t.Fatalf("topic is not replicated to %s", addr)
+	}
+	status := c.node(addr).GetStatus()
+	c.stop(addr)
+
+	if err := leaderNode.Propose(createTopic("while_down")); err != nil {
+		t.Fatalf("propose err: %s", err)
+	}
+
+	r := c.start(addr)
+	restarted := r.GetStatus()
+	if restarted.Term < status.Term || restarted.LastIndex < status.LastIndex || restarted.LastApplied < status.LastApplied {
+		t.Fatalf("restarted status %+v, before restart %+v", restarted, status)
+	}
+	if !c.hasTopic(addr, "before") {
+		t.Fatal("applied topic should be loaded from store after restart")
+	}
+	if !waitFor(5*time.Second, func() bool { return c.hasTopic(addr, "while_down") }) {
+		t.Fatal("restarted node should catch up with the leader")
+	}
+
+	// 整个集群重启之后，日志和状态都不会丢失
+	for _, a := range c.addrs {
+		c.stop(a)
+	}
+	for _, a := range c.addrs {
+		c.start(a)
+	}
+	leaderNode = c.waitLeader()
+	if err := leaderNode.Propose(createTopic("after")); err != nil {
+		t.Fatalf("propose after restart err: %s", err)
+	}
+	for _, a := range c.addrs {
+		a := a
+		if !waitFor(5*time.Second, func() bool {
+			return c.hasTopic(a, "before") && c.hasTopic(a, "while_down") && c.hasTopic(a, "after")
+		}) {
+			t.Fatalf("topics are lost on %s after cluster restart", a)
+		}
+	}
+}
+
+func TestRaftSnapshot(t *testing.T) {
+	old := maxLogEntries
+	maxLogEntries = 8
+	t.Cleanup(func() { maxLogEntries = old })
+
+	c := newTestCluster(t, 3)
+	leaderNode := c.waitLeader()
+
+	if err := leaderNode.Propose(createTopic("deleted")); err != nil {
+		t.Fatalf("propose err: %s", err)
+	}
+	var addr string
+	for _, a := range c.addrs {
+		if a != leaderNode.address {
+			addr = a
+			break
+		}
+	}
+	if !waitFor(5*time.Second, func() bool { return c.hasTopic(addr, "deleted") }) {
+		t.Fatalf("topic is not replicated to %s", addr)
+	}
+	c.stop(addr)
+
+	// 落后的节点需要的日志被压缩之后，通过快照追上leader，快照中已经删除的topic也会被删除
+	for i := 0; i < 20; i++ {
+		if err := leaderNode.Propose(createTopic(fmt.Sprintf("topic%d", i))); err != nil {
+			t.Fatalf("propose err: %s", err)
+		}
+	}
+	if err := leaderNode.Propose(&iface.ClusterCommand{Op: iface.DeleteTopicOp, TopicName: "deleted"}); err != nil {
+		t.Fatalf("propose err: %s", err)
+	}
+	if !waitFor(5*time.Second, func() bool {
+		leaderNode.Lock()
+		defer leaderNode.Unlock()
+		return leaderNode.snapshotIndex() > 0 && uint64(len(leaderNode.log)) <= maxLogEntries+1
+	}) {
+		t.Fatal("leader log should be compacted")
+	}
+
+	c.start(addr)
+	if !waitFor(5*time.Second, func() bool {
+		for i := 0; i < 20; i++ {
+			if !c.hasTopic(addr, fmt.Sprintf("topic%d", i)) {
+				return false
+			}
+		}
+		return !c.hasTopic(addr, "deleted")
+	}) {
+		t.Fatal("lagging node should catch up by snapshot")
+	}
+}
@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/dawnzzz/lmq/logger"
+	"math/rand"
+	"os"
+	"path"
+)
+
+/*
+	raft的持久化状态：
+	状态文件保存currentTerm、votedFor以及日志压缩的位置，每次修改都写入临时文件之后重命名
+	日志文件按顺序追加日志条目，每次追加之后fsync；删除冲突的日志、压缩日志时重新生成日志文件
+	回复RequestVote、AppendEntries之前，修改都已经持久化
+*/
+
+// hardState 需要持久化的raft状态
+type hardState struct {
+	Term          uint64 `json:"term"`
+	VotedFor      string `json:"voted_for"`
+	SnapshotIndex uint64 `json:"snapshot_index"` // 日志压缩到的位置，之前的日志已经保存在registration store的快照中
+	SnapshotTerm  uint64 `json:"snapshot_term"`
+}
+
+type raftStorage struct {
+	dataPath string
+	logFile  *os.File
+}
+
+func newRaftStorage(dataPath string) *raftStorage {
+	return &raftStorage{dataPath: dataPath}
+}
+
+func (s *raftStorage) stateFilename() string {
+	return path.Join(s.dataPath, "[lmqlookup].raft_state.dat")
+}
+
+func (s *raftStorage) logFilename() string {
+	return path.Join(s.dataPath, "[lmqlookup].raft_log.dat")
+}
+
+// load 加载持久化的状态和压缩位置之后的日志
+func (s *raftStorage) load() (*hardState, []LogEntry, error) {
+	if err := os.MkdirAll(s.dataPath, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	state := &hardState{}
+	data, err := os.ReadFile(s.stateFilename())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, state); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	entries, err := s.readLog(state.SnapshotIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 重新生成日志文件，去掉没有写完整的日志
+	if err = s.rewrite(entries); err != nil {
+		return nil, nil, err
+	}
+
+	return state, entries, nil
+}
+
+// readLog 读取日志文件中从snapshotIndex+1开始连续的日志条目
+func (s *raftStorage) readLog(snapshotIndex uint64) ([]LogEntry, error) {
+	logFile, err := os.Open(s.logFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer logFile.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 0, 4096), 16<<20)
+	for scanner.Scan() {
+		entry := LogEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// 最后一条日志可能没有写完整
+			logger.Warnf("skip broken raft log entry, err: %s", err.Error())
+			break
+		}
+		if entry.Index <= snapshotIndex {
+			// 已经压缩的日志
+			continue
+		}
+		if entry.Index != snapshotIndex+uint64(len(entries))+1 {
+			logger.Warnf("raft log entry(%d) is not continuous, drop the rest", entry.Index)
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// saveState 持久化term、投票和日志压缩的位置
+func (s *raftStorage) saveState(state *hardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileSync(s.stateFilename(), data)
+}
+
+// append 在日志文件末尾追加日志
+func (s *raftStorage) append(entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if s.logFile == nil {
+		return os.ErrClosed
+	}
+
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if _, err = s.logFile.Write(data); err != nil {
+		return err
+	}
+
+	return s.logFile.Sync()
+}
+
+// rewrite 使用entries重新生成日志文件
+func (s *raftStorage) rewrite(entries []LogEntry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(s.logFilename(), data); err != nil {
+		return err
+	}
+
+	if s.logFile != nil {
+		_ = s.logFile.Close()
+	}
+	s.logFile, err = os.OpenFile(s.logFilename(), os.O_WRONLY|os.O_APPEND, 0600)
+
+	return err
+}
+
+func (s *raftStorage) close() {
+	if s.logFile != nil {
+		_ = s.logFile.Close()
+		s.logFile = nil
+	}
+}
+
+func encodeEntries(entries []LogEntry) ([]byte, error) {
+	var data []byte
+	for i := range entries {
+		line, err := json.Marshal(&entries[i])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	return data, nil
+}
+
+// writeFileSync 先写入临时文件，fsync之后再重命名，防止写入过程中崩溃导致文件损坏
+func writeFileSync(filename string, data []byte) error {
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", filename, rand.Int())
+	f, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	_ = f.Close()
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
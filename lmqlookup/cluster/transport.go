@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dawnzzz/hamble-tcp-server/hamble"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errPeerClosed = errors.New("lookup peer connection is closed")
+	errRPCTimeout = errors.New("lookup peer rpc timeout")
+)
+
+// rpcClient 与集群中另一个lookup之间的连接
+// 同一个连接上同一种任务的响应是按照请求的顺序返回的，所以每种任务用一个FIFO队列记录等待响应的请求
+type rpcClient struct {
+	sync.Mutex
+
+	address  string
+	client   serveriface.IClient
+	waiters  map[uint32][]chan *protocol.ResponseBody
+	isClosed bool
+}
+
+type rpcRecvHandler struct {
+	hamble.BaseHandler
+	rc     *rpcClient
+	taskID uint32
+}
+
+func (h *rpcRecvHandler) Handle(request serveriface.IRequest) {
+	resp := &protocol.ResponseBody{}
+	if err := json.Unmarshal(request.GetData(), resp); err != nil {
+		resp.IsError = true
+		resp.StatusMsg = err.Error()
+	}
+
+	h.rc.Lock()
+	defer h.rc.Unlock()
+	waiters := h.rc.waiters[h.taskID]
+	if len(waiters) == 0 {
+		return
+	}
+
+	h.rc.waiters[h.taskID] = waiters[1:]
+	waiters[0] <- resp
+}
+
+func newRPCClient(address string, taskIDs ...uint32) (*rpcClient, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := hamble.NewClient("tcp", host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &rpcClient{
+		address: address,
+		client:  client,
+		waiters: make(map[uint32][]chan *protocol.ResponseBody),
+	}
+	for _, taskID := range taskIDs {
+		client.RegisterHandler(taskID, &rpcRecvHandler{rc: rc, taskID: taskID})
+	}
+
+	go func() {
+		client.Start()
+		// 连接断开
+		rc.close()
+	}()
+
+	return rc, nil
+}
+
+// Call 与指定地址建立一次性的连接，发送请求并等待响应，用于lookup主动向lmqd发送命令
+func Call(address string, taskID uint32, args interface{}, reply interface{}) error {
+	rc, err := newRPCClient(address, taskID)
+	if err != nil {
+		return err
+	}
+	defer rc.close()
+
+	return rc.call(taskID, args, reply, rpcTimeout)
+}
+
+// call 发送请求并等待响应，响应中的数据反序列化到reply中
+func (rc *rpcClient) call(taskID uint32, args interface{}, reply interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	waiter := make(chan *protocol.ResponseBody, 1)
+	rc.Lock()
+	if rc.isClosed {
+		rc.Unlock()
+		return errPeerClosed
+	}
+	err = rc.client.GetConnection().SendBufMsg(taskID, data)
+	if err != nil {
+		rc.Unlock()
+		return err
+	}
+	rc.waiters[taskID] = append(rc.waiters[taskID], waiter)
+	rc.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-waiter:
+		if resp == nil {
+			return errPeerClosed
+		}
+		if resp.IsError {
+			return errors.New(resp.StatusMsg)
+		}
+		if reply == nil {
+			return nil
+		}
+
+		// Data反序列化之后是map，需要再转换一次
+		data, err = json.Marshal(resp.Data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, reply)
+	case <-timer.C:
+		return errRPCTimeout
+	}
+}
+
+func (rc *rpcClient) closed() bool {
+	rc.Lock()
+	defer rc.Unlock()
+
+	return rc.isClosed
+}
+
+func (rc *rpcClient) close() {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if rc.isClosed {
+		return
+	}
+	rc.isClosed = true
+
+	// 唤醒所有等待的请求
+	for _, waiters := range rc.waiters {
+		for _, waiter := range waiters {
+			waiter <- nil
+		}
+	}
+	rc.waiters = nil
+
+	rc.client.Stop()
+}
+
+// transport 向集群中的其他节点发送请求
+type transport interface {
+	call(address string, taskID uint32, args interface{}, reply interface{}, timeout time.Duration) error
+	close()
+}
+
+// tcpTransport 通过tcp连接向其他节点发送请求，与每个节点之间复用一个连接
+type tcpTransport struct {
+	sync.Mutex
+
+	taskIDs []uint32
+	clients map[string]*rpcClient
+}
+
+func newTCPTransport(taskIDs ...uint32) *tcpTransport {
+	return &tcpTransport{
+		taskIDs: taskIDs,
+		clients: make(map[string]*rpcClient),
+	}
+}
+
+func (t *tcpTransport) call(address string, taskID uint32, args interface{}, reply interface{}, timeout time.Duration) error {
+	rc, err := t.getClient(address)
+	if err != nil {
+		return err
+	}
+
+	return rc.call(taskID, args, reply, timeout)
+}
+
+func (t *tcpTransport) getClient(address string) (*rpcClient, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if rc, ok := t.clients[address]; ok && !rc.closed() {
+		return rc, nil
+	}
+
+	rc, err := newRPCClient(address, t.taskIDs...)
+	if err != nil {
+		return nil, err
+	}
+	t.clients[address] = rc
+
+	return rc, nil
+}
+
+func (t *tcpTransport) close() {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, rc := range t.clients {
+		rc.close()
+	}
+}
@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"net"
+	nethttp "net/http"
+	"strconv"
+	"time"
+)
+
+/*
+	lmq lookup的http服务器，提供拓扑变化的订阅
+	GET /watch?topic=xxx&since=seq&timeout=30s     long-poll，返回序号大于since的事件，没有事件时等待直到超时
+	GET /watch/stream?topic=xxx                     server-sent events，不断推送事件，支持Last-Event-ID断点续传
+	topic为空时订阅整个集群的拓扑变化
+*/
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 5 * time.Minute
+	keepaliveInterval  = 15 * time.Second
+	shutdownTimeout    = 5 * time.Second
+)
+
+type HttpServer struct {
+	server         *nethttp.Server
+	registrationDB iface.IRegistrationDB
+
+	ctx    context.Context // 所有请求的context，关闭时取消，结束long-poll和server-sent events
+	cancel context.CancelFunc
+}
+
+func NewHttpServer(registrationDB iface.IRegistrationDB) *HttpServer {
+	httpServer := &HttpServer{
+		registrationDB: registrationDB,
+	}
+
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/watch", httpServer.watchHandler)
+	mux.HandleFunc("/watch/stream", httpServer.watchStreamHandler)
+
+	address := net.JoinHostPort(config.GlobalLmqLookupConfig.HttpHost, strconv.Itoa(config.GlobalLmqLookupConfig.HttpPort))
+	httpServer.ctx, httpServer.cancel = context.WithCancel(context.Background())
+	httpServer.server = &nethttp.Server{
+		Addr:    address,
+		Handler: mux,
+		BaseContext: func(net.Listener) context.Context {
+			return httpServer.ctx
+		},
+	}
+
+	return httpServer
+}
+
+func (httpServer *HttpServer) Start() {
+	logger.Infof("lmq lookup http server is listening on %s", httpServer.server.Addr)
+	err := httpServer.server.ListenAndServe()
+	if err != nil && err != nethttp.ErrServerClosed {
+		logger.Errorf("lmq lookup http server exit, err: %s", err.Error())
+	}
+}
+
+func (httpServer *HttpServer) Stop() {
+	// 先结束正在等待的长连接，再等待其他请求完成，超时之后直接关闭
+	httpServer.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.server.Shutdown(ctx); err != nil {
+		logger.Warnf("lmq lookup http server shutdown err: %s, close it", err.Error())
+		_ = httpServer.server.Close()
+	}
+}
+
+func (httpServer *HttpServer) getTopicName(r *nethttp.Request) (string, error) {
+	topicName := r.URL.Query().Get("topic")
+	if topicName != "" && !utils.TopicOrChannelNameIsValid(topicName) {
+		return "", e.ErrTopicNameInValid
+	}
+
+	return topicName, nil
+}
+
+func writeError(w nethttp.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// watchHandler long-poll
+func (httpServer *HttpServer) watchHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	topicName, err := httpServer.getTopicName(r)
+	if err != nil {
+		writeError(w, nethttp.StatusBadRequest, err)
+		return
+	}
+
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, nethttp.StatusBadRequest, err)
+			return
+		}
+	}
+
+	timeout := defaultPollTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			writeError(w, nethttp.StatusBadRequest, err)
+			return
+		}
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+
+	// 先订阅再查询历史事件，防止两次操作之间的事件丢失
+	id, events := httpServer.registrationDB.Watch(topicName)
+	defer httpServer.registrationDB.Unwatch(id)
+
+	results := httpServer.registrationDB.EventsSince(topicName, since)
+	if len(results) == 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case event, ok := <-events:
+			if ok && event.Seq > since {
+				results = append(results, event)
+			}
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if results == nil {
+		results = []*iface.TopologyEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+// watchStreamHandler server-sent events
+func (httpServer *HttpServer) watchStreamHandler(w nethttp.ResponseWriter, r *nethttp.Request) {
+	topicName, err := httpServer.getTopicName(r)
+	if err != nil {
+		writeError(w, nethttp.StatusBadRequest, err)
+		return
+	}
+
+	flusher, ok := w.(nethttp.Flusher)
+	if !ok {
+		writeError(w, nethttp.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	id, events := httpServer.registrationDB.Watch(topicName)
+	defer httpServer.registrationDB.Unwatch(id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(nethttp.StatusOK)
+
+	// 断线重连时，先补发错过的事件
+	var lastSeq uint64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if lastSeq, err = strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			for _, event := range httpServer.registrationDB.EventsSince(topicName, lastSeq) {
+				if writeEvent(w, event) != nil {
+					return
+				}
+				lastSeq = event.Seq
+			}
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// 客户端来不及处理事件
+				_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", e.ErrWatchOverflow.Error())
+				flusher.Flush()
+				return
+			}
+			if event.Seq <= lastSeq {
+				continue
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+			lastSeq = event.Seq
+		case <-ticker.C:
+			// 保持连接
+			if _, err = fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w nethttp.ResponseWriter, event *iface.TopologyEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+	return err
+}
@@ -1,23 +1,33 @@
 package lmqlookup
 
 import (
+	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqlookup/cluster"
+	"github.com/dawnzzz/lmq/lmqlookup/http"
+	"github.com/dawnzzz/lmq/lmqlookup/store"
 	"github.com/dawnzzz/lmq/lmqlookup/tcp"
 	"github.com/dawnzzz/lmq/lmqlookup/topology"
 	"github.com/dawnzzz/lmq/logger"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 type LmqLookup struct {
 	sync.RWMutex
 
-	tcpServer *tcp.TcpServer
+	tcpServer  *tcp.TcpServer
+	httpServer *http.HttpServer
 
 	registrationDB iface.IRegistrationDB // 用于存储拓扑结构
+	lookupCluster  iface.ILookupCluster  // lookup集群，管理命令通过集群复制到所有的lookup
+	store          iface.IRegistrationStore
 
 	isClosing atomic.Bool
 	exitChan  chan struct{}
@@ -26,26 +36,74 @@ type LmqLookup struct {
 func NewLmqLookUp() iface.ILmqLookup {
 	lmqLookup := &LmqLookup{
 		registrationDB: topology.NewRegistrationDB(),
+		store:          store.NewRegistrationStore(config.GlobalLmqLookupConfig.DataRootPath),
 
 		exitChan: make(chan struct{}),
 	}
 
-	lmqLookup.tcpServer = tcp.NewTcpServer(lmqLookup.registrationDB)
+	// 配置了集群中的其他节点时，开启集群模式
+	if len(config.GlobalLmqLookupConfig.ClusterPeers) > 0 {
+		raft, err := cluster.NewRaft(clusterAddress(), config.GlobalLmqLookupConfig.ClusterPeers, config.GlobalLmqLookupConfig.DataRootPath, lmqLookup.registrationDB, lmqLookup.store)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		lmqLookup.lookupCluster = raft
+	} else {
+		lmqLookup.lookupCluster = cluster.NewStandalone(lmqLookup.registrationDB, lmqLookup.store)
+	}
+
+	lmqLookup.tcpServer = tcp.NewTcpServer(lmqLookup.registrationDB, lmqLookup.lookupCluster)
+	if config.GlobalLmqLookupConfig.HttpPort > 0 {
+		lmqLookup.httpServer = http.NewHttpServer(lmqLookup.registrationDB)
+	}
 
 	return lmqLookup
 }
 
+// clusterAddress 本节点在集群中的地址，没有配置时使用hostname和tcp端口
+func clusterAddress() string {
+	if config.GlobalLmqLookupConfig.ClusterAddress != "" {
+		return config.GlobalLmqLookupConfig.ClusterAddress
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	return net.JoinHostPort(hostname, strconv.Itoa(config.GlobalLmqLookupConfig.TcpPort))
+}
+
 func (lmqLookup *LmqLookup) Stop() {
 	if !lmqLookup.isClosing.CompareAndSwap(false, true) {
 		// 已经关闭，直接退出
 		return
 	}
 
+	// 停止http服务器
+	if lmqLookup.httpServer != nil {
+		lmqLookup.httpServer.Stop()
+	}
+
+	// 生成最终的快照
+	if err := lmqLookup.store.Close(); err != nil {
+		logger.Errorf("close registration store failed, err: %s", err.Error())
+	}
+
 	close(lmqLookup.exitChan)
 }
 
+// LoadRegistrations 加载持久化的registration，启动之后立即可以查询到管理命令创建的topic和channel
+func (lmqLookup *LmqLookup) LoadRegistrations() error {
+	return lmqLookup.store.Load(lmqLookup.registrationDB)
+}
+
 func (lmqLookup *LmqLookup) Main() {
 	go lmqLookup.tcpServer.Start()
+	if lmqLookup.httpServer != nil {
+		go lmqLookup.httpServer.Start()
+	}
+	go lmqLookup.inactiveLoop()
 	logger.Info("lmq lookup is running")
 
 	signalChan := make(chan os.Signal)
@@ -65,3 +123,18 @@ func (lmqLookup *LmqLookup) Main() {
 	<-lmqLookup.exitChan
 	logger.Info("lmq lookup is exited")
 }
+
+// inactiveLoop 定期检查不活跃的lmqd，通知拓扑变化的订阅者
+func (lmqLookup *LmqLookup) inactiveLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lmqLookup.registrationDB.CheckInactiveProducers(config.GlobalLmqLookupConfig.InactiveProducerTimeout)
+		case <-lmqLookup.exitChan:
+			return
+		}
+	}
+}
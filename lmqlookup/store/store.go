@@ -0,0 +1,455 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqlookup/topology"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/partition"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+/*
+	持久化通过管理命令创建的topic、channel以及tombstone
+	快照文件保存某一时刻的全部状态，日志文件按顺序追加之后的每一条修改操作
+	启动时先加载快照，再重放日志；日志条目数量超过maxJournalEntries时重新生成快照并清空日志
+	集群模式下每一条修改操作都带有raft日志的index和term，快照即为raft日志压缩之后的状态，落后的节点可以直接恢复快照
+	lmqd注册的producer不进行持久化，lmqd重新连接之后会重新注册
+*/
+
+const maxJournalEntries = 1024
+
+var ErrStoreClosed = errors.New("registration store is closed")
+
+type TopicSnapshot struct {
+	Name     string   `json:"name"`
+	Channels []string `json:"channels"`
+}
+
+type TombstoneSnapshot struct {
+	TopicName    string `json:"topic_name"`
+	Hostname     string `json:"hostname"`
+	TcpPort      int    `json:"tcp_port"`
+	TombstonedAt int64  `json:"tombstoned_at"`
+}
+
+// Snapshot 快照
+type Snapshot struct {
+	Topics     []*TopicSnapshot     `json:"topics"`
+	Tombstones []*TombstoneSnapshot `json:"tombstones"`
+
+	Partitions map[string][]*iface.PartitionPlacement `json:"partitions,omitempty"`
+
+	Index uint64 `json:"index,omitempty"` // 快照包含的最后一条raft日志，单机模式下为0
+	Term  uint64 `json:"term,omitempty"`
+}
+
+// journalEntry 日志文件中的一条记录，没有修改操作时只记录raft日志的位置
+type journalEntry struct {
+	*iface.ClusterCommand
+	Index uint64 `json:"index,omitempty"`
+	Term  uint64 `json:"term,omitempty"`
+}
+
+type tombstoneKey struct {
+	topicName string
+	hostname  string
+	tcpPort   int
+}
+
+type RegistrationStore struct {
+	sync.Mutex
+
+	dataPath string
+
+	topics     map[string]map[string]struct{}         // topic name -> channel names
+	tombstones map[tombstoneKey]int64                 // tombstone -> tombstone时间
+	partitions map[string][]*iface.PartitionPlacement // 分区topic -> 每个分区所在的lmqd
+	index      uint64                                 // 已经应用的最后一条raft日志
+	term       uint64
+
+	journal        *os.File
+	journalEntries int
+	isClosed       bool
+}
+
+func NewRegistrationStore(dataPath string) iface.IRegistrationStore {
+	return &RegistrationStore{
+		dataPath:   dataPath,
+		topics:     make(map[string]map[string]struct{}),
+		tombstones: make(map[tombstoneKey]int64),
+		partitions: make(map[string][]*iface.PartitionPlacement),
+	}
+}
+
+func (s *RegistrationStore) snapshotFilename() string {
+	return path.Join(s.dataPath, "[lmqlookup].snapshot.dat")
+}
+
+func (s *RegistrationStore) journalFilename() string {
+	return path.Join(s.dataPath, "[lmqlookup].journal.dat")
+}
+
+// Load 加载快照和日志，恢复到registration db中
+func (s *RegistrationStore) Load(db iface.IRegistrationDB) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.MkdirAll(s.dataPath, 0755); err != nil {
+		return err
+	}
+
+	// 加载快照
+	data, err := os.ReadFile(s.snapshotFilename())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		snapshot := &Snapshot{}
+		if err = json.Unmarshal(data, snapshot); err != nil {
+			return err
+		}
+		s.load(snapshot)
+	}
+
+	// 重放日志
+	if err = s.replayJournal(); err != nil {
+		return err
+	}
+
+	// 恢复到registration db中
+	s.restore(db)
+
+	// 生成新的快照，打开日志文件
+	return s.compact()
+}
+
+func (s *RegistrationStore) replayJournal() error {
+	journal, err := os.Open(s.journalFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer journal.Close()
+
+	scanner := bufio.NewScanner(journal)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for scanner.Scan() {
+		entry := &journalEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// 最后一条日志可能没有写完整
+			logger.Warnf("skip broken registration journal entry, err: %s", err.Error())
+			continue
+		}
+		s.apply(entry.ClusterCommand, entry.Index, entry.Term)
+	}
+
+	return scanner.Err()
+}
+
+// Append 追加一条修改操作，index和term为对应的raft日志，cmd为nil时只记录raft日志的位置
+func (s *RegistrationStore) Append(cmd *iface.ClusterCommand, index, term uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.isClosed || s.journal == nil {
+		return ErrStoreClosed
+	}
+
+	s.apply(cmd, index, term)
+
+	data, err := json.Marshal(&journalEntry{ClusterCommand: cmd, Index: index, Term: term})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err = s.journal.Write(data); err != nil {
+		return err
+	}
+	if err = s.journal.Sync(); err != nil {
+		return err
+	}
+
+	s.journalEntries++
+	if s.journalEntries >= maxJournalEntries {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// AppliedIndex 已经持久化的最后一条raft日志
+func (s *RegistrationStore) AppliedIndex() (uint64, uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.index, s.term
+}
+
+// Snapshot 生成当前状态的快照，返回快照包含的最后一条raft日志
+func (s *RegistrationStore) Snapshot() ([]byte, uint64, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return data, s.index, s.term, nil
+}
+
+// Restore 使用其他节点的快照替换当前状态，同时更新registration db
+func (s *RegistrationStore) Restore(db iface.IRegistrationDB, data []byte) error {
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.isClosed || s.journal == nil {
+		return ErrStoreClosed
+	}
+
+	// 删除快照中不存在的topic、channel、tombstone和分区
+	topics := make(map[string]map[string]struct{}, len(snapshot.Topics))
+	for _, topic := range snapshot.Topics {
+		channels := make(map[string]struct{}, len(topic.Channels))
+		for _, channelName := range topic.Channels {
+			channels[channelName] = struct{}{}
+		}
+		topics[topic.Name] = channels
+	}
+	for topicName, channels := range s.topics {
+		newChannels, ok := topics[topicName]
+		for channelName := range channels {
+			if _, exist := newChannels[channelName]; !exist {
+				removeRegistrations(db, iface.ChannelCategory, topicName, channelName)
+			}
+		}
+		if !ok {
+			removeRegistrations(db, iface.TopicCategory, topicName, "")
+		}
+	}
+	for key := range s.tombstones {
+		db.UnTombstoneProducers(key.topicName, key.hostname, key.tcpPort)
+	}
+	for topicName := range s.partitions {
+		if _, ok := snapshot.Partitions[topicName]; !ok {
+			db.RemovePartitions(topicName)
+		}
+	}
+
+	s.topics = make(map[string]map[string]struct{})
+	s.tombstones = make(map[tombstoneKey]int64)
+	s.partitions = make(map[string][]*iface.PartitionPlacement)
+	s.load(snapshot)
+	s.restore(db)
+
+	return s.compact()
+}
+
+func removeRegistrations(db iface.IRegistrationDB, category iface.Category, key, subKey string) {
+	regs := db.FindRegistrations(category, key, subKey)
+	for i := 0; i < regs.Len(); i++ {
+		db.RemoveRegistration(regs.GetItem(i))
+	}
+}
+
+func (s *RegistrationStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.isClosed {
+		return nil
+	}
+	s.isClosed = true
+
+	if s.journal == nil {
+		return nil
+	}
+
+	// 生成最终的快照，同时关闭日志文件
+	return s.compact()
+}
+
+// load 加载快照中的状态，调用时已经加锁
+func (s *RegistrationStore) load(snapshot *Snapshot) {
+	for _, topic := range snapshot.Topics {
+		channels := make(map[string]struct{}, len(topic.Channels))
+		for _, channelName := range topic.Channels {
+			channels[channelName] = struct{}{}
+		}
+		s.topics[topic.Name] = channels
+	}
+	for _, tombstone := range snapshot.Tombstones {
+		s.tombstones[tombstoneKey{tombstone.TopicName, tombstone.Hostname, tombstone.TcpPort}] = tombstone.TombstonedAt
+	}
+	for topicName, placements := range snapshot.Partitions {
+		s.partitions[topicName] = placements
+	}
+	s.index, s.term = snapshot.Index, snapshot.Term
+}
+
+// restore 将内存状态恢复到registration db中，调用时已经加锁
+func (s *RegistrationStore) restore(db iface.IRegistrationDB) {
+	for topicName, channels := range s.topics {
+		db.AddRegistration(topology.MakeRegistration(iface.TopicCategory, topicName, ""))
+		for channelName := range channels {
+			db.AddRegistration(topology.MakeRegistration(iface.ChannelCategory, topicName, channelName))
+		}
+	}
+	for key, tombstonedAt := range s.tombstones {
+		db.TombstoneProducers(key.topicName, key.hostname, key.tcpPort, time.Unix(0, tombstonedAt))
+	}
+	for topicName, placements := range s.partitions {
+		db.SetPartitions(topicName, placements)
+	}
+}
+
+// apply 将修改操作应用到内存状态中，调用时已经加锁
+func (s *RegistrationStore) apply(cmd *iface.ClusterCommand, index, term uint64) {
+	if index > s.index {
+		s.index, s.term = index, term
+	}
+	if cmd == nil {
+		return
+	}
+
+	switch cmd.Op {
+	case iface.CreateTopicOp:
+		if _, ok := s.topics[cmd.TopicName]; !ok {
+			s.topics[cmd.TopicName] = make(map[string]struct{})
+		}
+
+	case iface.DeleteTopicOp:
+		delete(s.topics, cmd.TopicName)
+		delete(s.partitions, cmd.TopicName)
+
+	case iface.CreateChannelOp:
+		if _, ok := s.topics[cmd.TopicName]; !ok {
+			s.topics[cmd.TopicName] = make(map[string]struct{})
+		}
+		s.topics[cmd.TopicName][cmd.ChannelName] = struct{}{}
+
+	case iface.DeleteChannelOp:
+		if channels, ok := s.topics[cmd.TopicName]; ok {
+			delete(channels, cmd.ChannelName)
+		}
+
+	case iface.TombstoneTopicOp:
+		tombstonedAt := cmd.TombstonedAt
+		if tombstonedAt == 0 {
+			tombstonedAt = time.Now().UnixNano()
+		}
+		s.tombstones[tombstoneKey{cmd.TopicName, cmd.Hostname, cmd.TcpPort}] = tombstonedAt
+
+	case iface.UnTombstoneTopicOp:
+		delete(s.tombstones, tombstoneKey{cmd.TopicName, cmd.Hostname, cmd.TcpPort})
+
+	case iface.CreatePartitionedTopicOp:
+		topicNames := []string{cmd.TopicName}
+		for i := range cmd.Partitions {
+			topicNames = append(topicNames, partition.TopicName(cmd.TopicName, i))
+		}
+		for _, topicName := range topicNames {
+			if _, ok := s.topics[topicName]; !ok {
+				s.topics[topicName] = make(map[string]struct{})
+			}
+		}
+		s.partitions[cmd.TopicName] = cmd.Partitions
+	}
+}
+
+// snapshot 生成当前状态的快照，调用时已经加锁
+func (s *RegistrationStore) snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Topics:     []*TopicSnapshot{},
+		Tombstones: []*TombstoneSnapshot{},
+		Partitions: s.partitions,
+		Index:      s.index,
+		Term:       s.term,
+	}
+	for topicName, channels := range s.topics {
+		topic := &TopicSnapshot{Name: topicName, Channels: []string{}}
+		for channelName := range channels {
+			topic.Channels = append(topic.Channels, channelName)
+		}
+		snapshot.Topics = append(snapshot.Topics, topic)
+	}
+
+	// 过期的tombstone不再保存
+	lifetime := config.GlobalLmqLookupConfig.TombstoneLifetime
+	for key, tombstonedAt := range s.tombstones {
+		if time.Since(time.Unix(0, tombstonedAt)) >= lifetime {
+			delete(s.tombstones, key)
+			continue
+		}
+		snapshot.Tombstones = append(snapshot.Tombstones, &TombstoneSnapshot{
+			TopicName:    key.topicName,
+			Hostname:     key.hostname,
+			TcpPort:      key.tcpPort,
+			TombstonedAt: tombstonedAt,
+		})
+	}
+
+	return snapshot
+}
+
+// compact 生成快照并清空日志，调用时已经加锁
+func (s *RegistrationStore) compact() error {
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件，再重命名为快照文件，防止写入过程中崩溃导致快照文件损坏
+	snapshotFilename := s.snapshotFilename()
+	tmpFilename := fmt.Sprintf("%s.%d.tmp", snapshotFilename, rand.Int())
+	snapshotFile, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = snapshotFile.Write(data)
+	if err == nil {
+		err = snapshotFile.Sync()
+	}
+	_ = snapshotFile.Close()
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+
+	if err = os.Rename(tmpFilename, snapshotFilename); err != nil {
+		return err
+	}
+
+	// 快照已经包含了日志中的所有修改，清空日志
+	if s.journal != nil {
+		_ = s.journal.Close()
+		s.journal = nil
+	}
+	if s.isClosed {
+		return nil
+	}
+	s.journal, err = os.OpenFile(s.journalFilename(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	s.journalEntries = 0
+
+	return nil
+}
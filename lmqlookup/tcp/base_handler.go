@@ -8,12 +8,14 @@ import (
 type BaseHandler struct {
 	protocol.BaseHandler
 	registrationDB iface.IRegistrationDB
+	lookupCluster  iface.ILookupCluster // 管理命令通过集群提交
 }
 
-func RegisterBaseHandler(taskID uint32, registrationDB iface.IRegistrationDB) *BaseHandler {
+func RegisterBaseHandler(taskID uint32, registrationDB iface.IRegistrationDB, lookupCluster iface.ILookupCluster) *BaseHandler {
 	h := &BaseHandler{}
 	h.TaskID = taskID
 	h.registrationDB = registrationDB
+	h.lookupCluster = lookupCluster
 
 	return h
 }
@@ -6,7 +6,6 @@ import (
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
 	"github.com/dawnzzz/lmq/internel/utils"
-	"github.com/dawnzzz/lmq/lmqlookup/topology"
 	"github.com/dawnzzz/lmq/pkg/e"
 )
 
@@ -52,17 +51,24 @@ func (h *CreateChannel) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 验证channel name是否有效
-	if !utils.TopicOrChannelNameIsValid(requestBody.ChannelName) {
+	// 验证channel name是否有效，#pN后缀只用于分区topic
+	if !utils.TopicOrChannelNameIsValid(requestBody.ChannelName) || utils.IsPartitionName(requestBody.ChannelName) {
 		_ = h.SendErrResponse(request, e.ErrTopicNameInValid)
 		return
 	}
 
-	// 添加channel和topic
-	chanReg := topology.MakeRegistration(iface.ChannelCategory, requestBody.TopicName, requestBody.ChannelName)
-	h.registrationDB.AddRegistration(chanReg)
-	topicReg := topology.MakeRegistration(iface.TopicCategory, requestBody.TopicName, "")
-	h.registrationDB.AddRegistration(topicReg)
+	// 通过集群添加channel和topic，分区topic在所有分区上添加channel
+	for _, topicName := range h.expandPartitions(requestBody.TopicName) {
+		err = h.lookupCluster.Propose(&iface.ClusterCommand{
+			Op:          iface.CreateChannelOp,
+			TopicName:   topicName,
+			ChannelName: requestBody.ChannelName,
+		})
+		if err != nil {
+			_ = h.SendErrResponse(request, err)
+			return
+		}
+	}
 
 	_ = h.SendOkResponse(request)
 }
@@ -98,10 +104,17 @@ func (h *DeleteChannel) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 删除channel
-	for i := 0; i < chanRegs.Len(); i++ {
-		chanReg := chanRegs.GetItem(i)
-		h.registrationDB.RemoveRegistration(chanReg)
+	// 通过集群删除channel，分区topic在所有分区上删除channel
+	for _, topicName := range h.expandPartitions(requestBody.TopicName) {
+		err = h.lookupCluster.Propose(&iface.ClusterCommand{
+			Op:          iface.DeleteChannelOp,
+			TopicName:   topicName,
+			ChannelName: requestBody.ChannelName,
+		})
+		if err != nil {
+			_ = h.SendErrResponse(request, err)
+			return
+		}
 	}
 
 	_ = h.SendOkResponse(request)
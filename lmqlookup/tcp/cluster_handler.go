@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"encoding/json"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/lmqlookup/cluster"
+	"github.com/dawnzzz/lmq/pkg/e"
+)
+
+/*
+	lmq lookup集群之间的handler
+*/
+
+// RequestVoteHandler 处理候选人的投票请求
+type RequestVoteHandler struct {
+	*BaseHandler
+	raft *cluster.Raft
+}
+
+func (h *RequestVoteHandler) Handle(request serveriface.IRequest) {
+	req := &cluster.VoteRequest{}
+	if err := json.Unmarshal(request.GetData(), req); err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	_ = h.SendDataResponse(request, h.raft.HandleRequestVote(req))
+}
+
+// AppendEntriesHandler 处理leader发送的日志
+type AppendEntriesHandler struct {
+	*BaseHandler
+	raft *cluster.Raft
+}
+
+func (h *AppendEntriesHandler) Handle(request serveriface.IRequest) {
+	req := &cluster.AppendEntriesRequest{}
+	if err := json.Unmarshal(request.GetData(), req); err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	_ = h.SendDataResponse(request, h.raft.HandleAppendEntries(req))
+}
+
+// InstallSnapshotHandler 处理leader发送的快照
+type InstallSnapshotHandler struct {
+	*BaseHandler
+	raft *cluster.Raft
+}
+
+func (h *InstallSnapshotHandler) Handle(request serveriface.IRequest) {
+	req := &cluster.InstallSnapshotRequest{}
+	if err := json.Unmarshal(request.GetData(), req); err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	_ = h.SendDataResponse(request, h.raft.HandleInstallSnapshot(req))
+}
+
+// ProposeHandler 处理follower转发过来的修改操作
+type ProposeHandler struct {
+	*BaseHandler
+	raft *cluster.Raft
+}
+
+func (h *ProposeHandler) Handle(request serveriface.IRequest) {
+	cmd := &iface.ClusterCommand{}
+	if err := json.Unmarshal(request.GetData(), cmd); err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	if !h.raft.IsLeader() {
+		// 不再是leader了，不进行二次转发
+		_ = h.SendErrResponse(request, e.ErrClusterNotLeader)
+		return
+	}
+
+	if err := h.raft.Propose(cmd); err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	_ = h.SendOkResponse(request)
+}
+
+// ClusterStatusHandler 查询本节点在集群中的状态
+type ClusterStatusHandler struct {
+	*BaseHandler
+	raft *cluster.Raft
+}
+
+func (h *ClusterStatusHandler) Handle(request serveriface.IRequest) {
+	_ = h.SendDataResponse(request, h.raft.GetStatus())
+}
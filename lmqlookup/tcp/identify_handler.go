@@ -6,6 +6,9 @@ import (
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
 	"github.com/dawnzzz/lmq/lmqlookup/topology"
+	"github.com/dawnzzz/lmq/logger"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"time"
 )
 
 type IdentityHandler struct {
@@ -32,8 +35,25 @@ func (h *IdentityHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
+	// 检查节点ID是否与其他lmqd冲突，冲突时不同lmqd生成的message id可能重复，拒绝identify
+	producers := h.registrationDB.FindProducers(iface.LmqdCategory, "", "")
+	for i := 0; i < producers.Len(); i++ {
+		other := producers.GetItem(i).GetLmqdInfo()
+		if other.GetNodeID() == requestBody.NodeID && (other.GetHostName() != requestBody.Hostname || other.GetTcpPort() != requestBody.TcpPort) {
+			logger.Warnf("reject lmqd %s:%d, it has the same node id %d with lmqd %s:%d",
+				requestBody.Hostname, requestBody.TcpPort, requestBody.NodeID, other.GetHostName(), other.GetTcpPort())
+			_ = h.SendErrResponse(request, e.ErrNodeIDConflict)
+			return
+		}
+	}
+
 	// 生成lmqd info信息
 	info := topology.NewLmqdInfo(request.GetConnection().RemoteAddr(), requestBody.RemoteAddress, requestBody.Hostname, requestBody.TcpPort)
+	info.SetNodeID(requestBody.NodeID)
+	info.SetVersion(requestBody.Version)
+	info.SetHttpPort(requestBody.HttpPort)
+	info.SetBroadcastAddress(requestBody.BroadcastAddress)
+	info.SetStartTime(time.Unix(0, requestBody.StartTime))
 	// info存入上下文中，设置连接身份
 	producer := topology.NewLmqProducer(info)
 	h.registrationDB.AddProducer(topology.MakeRegistration(iface.LmqdCategory, "", ""), producer) // 存入db中
@@ -32,8 +32,24 @@ func (h *LookupHandler) Handle(request serveriface.IRequest) {
 	producers := h.registrationDB.FindProducers(iface.TopicCategory, requestBody.TopicName, "")
 	producers = producers.FilterByActive(config.GlobalLmqLookupConfig.InactiveProducerTimeout, config.GlobalLmqLookupConfig.TombstoneLifetime)
 
-	_ = h.SendDataResponse(request, map[string]interface{}{
+	// 活跃的producer都没有被tombstone
+	nodes := make([]*protocol.Node, producers.Len())
+	for i := 0; i < producers.Len(); i++ {
+		nodes[i] = makeNode(producers.GetItem(i).GetLmqdInfo())
+		nodes[i].Topics = []string{requestBody.TopicName}
+		nodes[i].Tombstones = []bool{false}
+	}
+
+	data := map[string]interface{}{
+		"version":   protocol.LookupResponseVersion,
 		"channels":  channels,
-		"producers": producers,
-	})
+		"producers": nodes,
+	}
+
+	// 分区topic返回所有的分区，生产者根据分区进行路由，消费者需要订阅所有的分区
+	if placements := h.registrationDB.GetPartitions(requestBody.TopicName); placements != nil {
+		data["partitions"] = h.makePartitions(requestBody.TopicName, placements)
+	}
+
+	_ = h.SendDataResponse(request, data)
 }
@@ -37,14 +37,26 @@ func (h *NodesHandler) Handle(request serveriface.IRequest) {
 			}
 		}
 
-		nodes[i] = &protocol.Node{
-			RemoteAddress: p.GetLmqdInfo().GetRemoteAddress(),
-			Hostname:      p.GetLmqdInfo().GetHostName(),
-			TCPPort:       p.GetLmqdInfo().GetTcpPort(),
-			Tombstones:    tombstones,
-			Topics:        topics,
-		}
+		nodes[i] = makeNode(p.GetLmqdInfo())
+		nodes[i].Tombstones = tombstones
+		nodes[i].Topics = topics
 	}
 
 	_ = h.SendNodesResponse(request, nodes)
 }
+
+// makeNode 根据lmqd的信息生成节点信息
+func makeNode(info iface.ILmqdInfo) *protocol.Node {
+	return &protocol.Node{
+		RemoteAddress:    info.GetRemoteAddress(),
+		Hostname:         info.GetHostName(),
+		BroadcastAddress: info.GetBroadcastAddress(),
+		TCPPort:          info.GetTcpPort(),
+		HttpPort:         info.GetHttpPort(),
+		NodeID:           info.GetNodeID(),
+		Version:          info.GetVersion(),
+		StartTime:        info.GetStartTime().UnixNano(),
+		Tombstones:       []bool{},
+		Topics:           []string{},
+	}
+}
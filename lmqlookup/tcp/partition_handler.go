@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"fmt"
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/config"
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/lmqlookup/cluster"
+	"github.com/dawnzzz/lmq/pkg/e"
+	"github.com/dawnzzz/lmq/pkg/partition"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// CreatePartitionedTopicHandler 创建分区topic，将分区分散到不同的lmqd上，并在lmqd上创建分区对应的topic
+// 分区topic已经存在时，只在lmqd上重新创建分区对应的topic
+type CreatePartitionedTopicHandler struct {
+	*BaseHandler
+}
+
+func (h *CreatePartitionedTopicHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，得到topic name和分区数量
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	// 验证topic name是否有效，分区对应的topic名称也需要有效
+	if !utils.TopicOrChannelNameIsValid(requestBody.TopicName) {
+		_ = h.SendErrResponse(request, e.ErrTopicNameInValid)
+		return
+	}
+	if utils.IsPartitionName(requestBody.TopicName) {
+		_ = h.SendErrResponse(request, e.ErrPartitionNameUsed)
+		return
+	}
+	if requestBody.Partitions <= 0 || !utils.TopicOrChannelNameIsValid(partition.TopicName(requestBody.TopicName, requestBody.Partitions-1)) {
+		_ = h.SendErrResponse(request, e.ErrPartitionsInValid)
+		return
+	}
+
+	placements := h.registrationDB.GetPartitions(requestBody.TopicName)
+	if placements != nil && len(placements) != requestBody.Partitions {
+		_ = h.SendErrResponse(request, e.ErrPartitionsChanged)
+		return
+	}
+
+	if placements == nil {
+		// 选择分区所在的lmqd，通过集群记录分区的分布
+		placements, err = h.placePartitions(requestBody.Partitions)
+		if err != nil {
+			_ = h.SendErrResponse(request, err)
+			return
+		}
+
+		err = h.lookupCluster.Propose(&iface.ClusterCommand{
+			Op:         iface.CreatePartitionedTopicOp,
+			TopicName:  requestBody.TopicName,
+			Partitions: placements,
+		})
+		if err != nil {
+			_ = h.SendErrResponse(request, err)
+			return
+		}
+	}
+
+	// 在lmqd上创建分区对应的topic
+	for i, placement := range placements {
+		address := net.JoinHostPort(placement.Hostname, strconv.Itoa(placement.TcpPort))
+		partitionName := partition.TopicName(requestBody.TopicName, i)
+		err = cluster.Call(address, protocol.CreateTopicID, &protocol.RequestBody{TopicName: partitionName}, nil)
+		if err != nil {
+			_ = h.SendErrResponse(request, fmt.Errorf("create partition %s in lmqd %s failed: %w", partitionName, address, err))
+			return
+		}
+	}
+
+	_ = h.SendDataResponse(request, h.makePartitions(requestBody.TopicName, placements))
+}
+
+// placePartitions 将分区依次分配给topic数量最少的lmqd
+func (h *BaseHandler) placePartitions(partitions int) ([]*iface.PartitionPlacement, error) {
+	producers := h.registrationDB.FindProducers(iface.LmqdCategory, "", "").FilterByActive(config.GlobalLmqLookupConfig.InactiveProducerTimeout, 0)
+	if producers.Len() == 0 {
+		return nil, e.ErrNoLmqdAvailable
+	}
+
+	type candidate struct {
+		info   iface.ILmqdInfo
+		topics int
+	}
+	candidates := make([]*candidate, producers.Len())
+	for i := 0; i < producers.Len(); i++ {
+		info := producers.GetItem(i).GetLmqdInfo()
+		topics := h.registrationDB.LookupRegistrations(info.GetID()).Filter(iface.TopicCategory, "*", "").Len()
+		candidates[i] = &candidate{info: info, topics: topics}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].topics != candidates[j].topics {
+			return candidates[i].topics < candidates[j].topics
+		}
+		if candidates[i].info.GetHostName() != candidates[j].info.GetHostName() {
+			return candidates[i].info.GetHostName() < candidates[j].info.GetHostName()
+		}
+		return candidates[i].info.GetTcpPort() < candidates[j].info.GetTcpPort()
+	})
+
+	placements := make([]*iface.PartitionPlacement, partitions)
+	for i := range placements {
+		info := candidates[i%len(candidates)].info
+		placements[i] = &iface.PartitionPlacement{
+			Hostname: info.GetHostName(),
+			TcpPort:  info.GetTcpPort(),
+		}
+	}
+
+	return placements, nil
+}
+
+// makePartitions 生成分区信息，分区所在的lmqd在线时返回lmqd的完整信息
+func (h *BaseHandler) makePartitions(topicName string, placements []*iface.PartitionPlacement) []*protocol.Partition {
+	partitions := make([]*protocol.Partition, len(placements))
+	for i, placement := range placements {
+		partitionName := partition.TopicName(topicName, i)
+		partitions[i] = &protocol.Partition{
+			Partition: i,
+			TopicName: partitionName,
+			Node: &protocol.Node{
+				Hostname:   placement.Hostname,
+				TCPPort:    placement.TcpPort,
+				Tombstones: []bool{},
+				Topics:     []string{},
+			},
+		}
+
+		producers := h.registrationDB.FindProducers(iface.TopicCategory, partitionName, "")
+		for j := 0; j < producers.Len(); j++ {
+			info := producers.GetItem(j).GetLmqdInfo()
+			if info.GetHostName() == placement.Hostname && info.GetTcpPort() == placement.TcpPort {
+				partitions[i].Node = makeNode(info)
+				break
+			}
+		}
+	}
+
+	return partitions
+}
+
+// expandPartitions 分区topic返回自身以及所有分区对应的topic，普通topic只返回自身
+func (h *BaseHandler) expandPartitions(topicName string) []string {
+	topicNames := []string{topicName}
+	for i := range h.registrationDB.GetPartitions(topicName) {
+		topicNames = append(topicNames, partition.TopicName(topicName, i))
+	}
+
+	return topicNames
+}
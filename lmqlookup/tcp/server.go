@@ -8,6 +8,7 @@ import (
 	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/lmqlookup/cluster"
 	"github.com/dawnzzz/lmq/logger"
 	"sync/atomic"
 	"time"
@@ -24,18 +25,20 @@ const (
 const (
 	statusPropertyKey   = "status"
 	producerPropertyKey = "producer"
+	watchPropertyKey    = "watch"
 )
 
 type TcpServer struct {
 	server serveriface.IServer
 
 	registrationDB iface.IRegistrationDB // lmq lookup中用于记录lmqd拓扑的结构
+	lookupCluster  iface.ILookupCluster  // lmq lookup集群
 
 	IsClosing atomic.Bool
 	ExitChan  chan struct{}
 }
 
-func NewTcpServer(registrationDB iface.IRegistrationDB) *TcpServer {
+func NewTcpServer(registrationDB iface.IRegistrationDB, lookupCluster iface.ILookupCluster) *TcpServer {
 	server := hamble.NewServerWithOption(&conf.Profile{
 		Name:             "LMQLookup TCP Server",
 		Host:             config.GlobalLmqLookupConfig.TcpHost,
@@ -53,10 +56,11 @@ func NewTcpServer(registrationDB iface.IRegistrationDB) *TcpServer {
 	})
 
 	// 注册路由
-	registerHandler(server, registrationDB)
+	registerHandler(server, registrationDB, lookupCluster)
 
 	tcpServer := &TcpServer{
 		registrationDB: registrationDB,
+		lookupCluster:  lookupCluster,
 		server:         server,
 		ExitChan:       make(chan struct{}),
 	}
@@ -79,6 +83,12 @@ func NewTcpServer(registrationDB iface.IRegistrationDB) *TcpServer {
 			// 从所有的registration中删除producer
 			registrationDB.RemoveProducerFromAllRegistrations(producer.GetLmqdInfo().GetID())
 		}
+
+		// 取消拓扑变化的订阅
+		if id, ok := conn.GetProperty(watchPropertyKey).(uint64); ok {
+			conn.RemoveProperty(watchPropertyKey)
+			registrationDB.Unwatch(id)
+		}
 	})
 
 	return tcpServer
@@ -94,10 +104,11 @@ func (tcpServer *TcpServer) Start() {
 		},
 		MsgID: protocol.PingID,
 		Handler: &pingHandler{
-			RegisterBaseHandler(protocol.PingID, tcpServer.registrationDB),
+			RegisterBaseHandler(protocol.PingID, tcpServer.registrationDB, tcpServer.lookupCluster),
 		},
 	})
 
+	tcpServer.lookupCluster.Start()
 	tcpServer.server.Start()
 }
 
@@ -127,70 +138,129 @@ func (tcpServer *TcpServer) Stop() {
 		return
 	}
 
+	// 停止集群
+	tcpServer.lookupCluster.Stop()
+
 	// 停止tcp服务器
 	tcpServer.server.Stop()
 
 	close(tcpServer.ExitChan)
 }
 
-func registerHandler(server serveriface.IServer, registrationDB iface.IRegistrationDB) {
+func registerHandler(server serveriface.IServer, registrationDB iface.IRegistrationDB, lookupCluster iface.ILookupCluster) {
 	// identity
 	server.RegisterHandler(protocol.IdentityID, &IdentityHandler{
-		RegisterBaseHandler(protocol.IdentityID, registrationDB),
+		RegisterBaseHandler(protocol.IdentityID, registrationDB, lookupCluster),
 	})
 
 	// register
 	server.RegisterHandler(protocol.RegisterID, &RegisterHandler{
-		RegisterBaseHandler(protocol.RegisterID, registrationDB),
+		RegisterBaseHandler(protocol.RegisterID, registrationDB, lookupCluster),
 	})
 
 	// unregister
 	server.RegisterHandler(protocol.UnRegisterID, &UnRegisterHandler{
-		RegisterBaseHandler(protocol.UnRegisterID, registrationDB),
+		RegisterBaseHandler(protocol.UnRegisterID, registrationDB, lookupCluster),
 	})
 
 	// topics
 	server.RegisterHandler(protocol.TopicsID, &TopicsHandler{
-		RegisterBaseHandler(protocol.TopicsID, registrationDB),
+		RegisterBaseHandler(protocol.TopicsID, registrationDB, lookupCluster),
 	})
 
 	// channels
 	server.RegisterHandler(protocol.ChannelsID, &ChannelsHandlers{
-		RegisterBaseHandler(protocol.ChannelsID, registrationDB),
+		RegisterBaseHandler(protocol.ChannelsID, registrationDB, lookupCluster),
 	})
 
 	// lookup
 	server.RegisterHandler(protocol.LookupID, &LookupHandler{
-		RegisterBaseHandler(protocol.LookupID, registrationDB),
+		RegisterBaseHandler(protocol.LookupID, registrationDB, lookupCluster),
 	})
 
 	// create topic
 	server.RegisterHandler(protocol.CreateTopicID, &CreateTopicHandler{
-		RegisterBaseHandler(protocol.CreateTopicID, registrationDB),
+		RegisterBaseHandler(protocol.CreateTopicID, registrationDB, lookupCluster),
+	})
+
+	// create partitioned topic
+	server.RegisterHandler(protocol.CreatePartitionedTopicID, &CreatePartitionedTopicHandler{
+		RegisterBaseHandler(protocol.CreatePartitionedTopicID, registrationDB, lookupCluster),
 	})
 
 	// delete topic
 	server.RegisterHandler(protocol.DeleteTopicID, &DeleteTopicHandler{
-		RegisterBaseHandler(protocol.DeleteTopicID, registrationDB),
+		RegisterBaseHandler(protocol.DeleteTopicID, registrationDB, lookupCluster),
 	})
 
 	// create channel
 	server.RegisterHandler(protocol.CreateChannelID, &CreateChannel{
-		RegisterBaseHandler(protocol.CreateChannelID, registrationDB),
+		RegisterBaseHandler(protocol.CreateChannelID, registrationDB, lookupCluster),
 	})
 
 	// delete channel
 	server.RegisterHandler(protocol.DeleteChannelID, &DeleteChannel{
-		RegisterBaseHandler(protocol.DeleteChannelID, registrationDB),
+		RegisterBaseHandler(protocol.DeleteChannelID, registrationDB, lookupCluster),
 	})
 
 	// tombstone topic
 	server.RegisterHandler(protocol.TombstoneTopicID, &TombstoneHandler{
-		RegisterBaseHandler(protocol.TombstoneTopicID, registrationDB),
+		RegisterBaseHandler(protocol.TombstoneTopicID, registrationDB, lookupCluster),
+	})
+
+	// untombstone topic
+	server.RegisterHandler(protocol.UnTombstoneTopicID, &UnTombstoneHandler{
+		RegisterBaseHandler(protocol.UnTombstoneTopicID, registrationDB, lookupCluster),
 	})
 
 	// nodes
 	server.RegisterHandler(protocol.NodesID, &NodesHandler{
-		RegisterBaseHandler(protocol.NodesID, registrationDB),
+		RegisterBaseHandler(protocol.NodesID, registrationDB, lookupCluster),
+	})
+
+	// watch
+	server.RegisterHandler(protocol.WatchID, &WatchHandler{
+		RegisterBaseHandler(protocol.WatchID, registrationDB, lookupCluster),
+	})
+
+	// unwatch
+	server.RegisterHandler(protocol.UnWatchID, &UnWatchHandler{
+		RegisterBaseHandler(protocol.UnWatchID, registrationDB, lookupCluster),
+	})
+
+	// 集群之间的命令，只有开启集群模式时才注册
+	raft, ok := lookupCluster.(*cluster.Raft)
+	if !ok {
+		return
+	}
+
+	// request vote
+	server.RegisterHandler(protocol.RequestVoteID, &RequestVoteHandler{
+		BaseHandler: RegisterBaseHandler(protocol.RequestVoteID, registrationDB, lookupCluster),
+		raft:        raft,
+	})
+
+	// append entries
+	server.RegisterHandler(protocol.AppendEntriesID, &AppendEntriesHandler{
+		BaseHandler: RegisterBaseHandler(protocol.AppendEntriesID, registrationDB, lookupCluster),
+		raft:        raft,
+	})
+
+	// install snapshot
+	server.RegisterHandler(protocol.InstallSnapshotID, &InstallSnapshotHandler{
+		BaseHandler: RegisterBaseHandler(protocol.InstallSnapshotID, registrationDB, lookupCluster),
+		raft:        raft,
+	})
+
+	// propose
+	server.RegisterHandler(protocol.ProposeID, &ProposeHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ProposeID, registrationDB, lookupCluster),
+		raft:        raft,
+	})
+
+	// cluster status
+	server.RegisterHandler(protocol.ClusterStatusID, &ClusterStatusHandler{
+		BaseHandler: RegisterBaseHandler(protocol.ClusterStatusID, registrationDB, lookupCluster),
+		raft:        raft,
 	})
 }
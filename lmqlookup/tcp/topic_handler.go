@@ -6,8 +6,8 @@ import (
 	"github.com/dawnzzz/lmq/iface"
 	"github.com/dawnzzz/lmq/internel/protocol"
 	"github.com/dawnzzz/lmq/internel/utils"
-	"github.com/dawnzzz/lmq/lmqlookup/topology"
 	"github.com/dawnzzz/lmq/pkg/e"
+	"time"
 )
 
 type TopicsHandler struct {
@@ -40,10 +40,21 @@ func (h *CreateTopicHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 创建topic registration
-	topicReg := topology.MakeRegistration(iface.TopicCategory, requestBody.TopicName, "")
-	// 添加topic
-	h.registrationDB.AddRegistration(topicReg)
+	// 分区对应的topic只能通过分区topic创建
+	if utils.IsPartitionName(requestBody.TopicName) {
+		_ = h.SendErrResponse(request, e.ErrPartitionNameUsed)
+		return
+	}
+
+	// 通过集群创建topic
+	err = h.lookupCluster.Propose(&iface.ClusterCommand{
+		Op:        iface.CreateTopicOp,
+		TopicName: requestBody.TopicName,
+	})
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
 
 	_ = h.SendOkResponse(request)
 }
@@ -66,18 +77,17 @@ func (h *DeleteTopicHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// 删除topic下所有的channels
-	chanRegs := h.registrationDB.FindRegistrations(iface.ChannelCategory, requestBody.TopicName, "*")
-	for i := 0; i < chanRegs.Len(); i++ {
-		chanReg := chanRegs.GetItem(i)
-		h.registrationDB.RemoveRegistration(chanReg)
-	}
-
-	// 删除topic
-	topicRegs := h.registrationDB.FindRegistrations(iface.TopicCategory, requestBody.TopicName, "")
-	for i := 0; i < topicRegs.Len(); i++ {
-		topicReg := topicRegs.GetItem(i)
-		h.registrationDB.RemoveRegistration(topicReg)
+	// 通过集群删除topic及其下所有的channels，分区topic先删除所有分区再删除自身
+	topicNames := h.expandPartitions(requestBody.TopicName)
+	for i := len(topicNames) - 1; i >= 0; i-- {
+		err = h.lookupCluster.Propose(&iface.ClusterCommand{
+			Op:        iface.DeleteTopicOp,
+			TopicName: topicNames[i],
+		})
+		if err != nil {
+			_ = h.SendErrResponse(request, err)
+			return
+		}
 	}
 
 	_ = h.SendOkResponse(request)
@@ -101,21 +111,80 @@ func (h *TombstoneHandler) Handle(request serveriface.IRequest) {
 		return
 	}
 
-	// remoteAddress hostname port为空
-	if requestBody.RemoteAddress == "" || requestBody.Hostname == "" || requestBody.TcpPort == 0 {
-		_ = h.SendErrResponse(request, errors.New("tombstone topic command args invalid"))
+	hostname, tcpPort, err := tombstoneTarget(request, requestBody)
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
 		return
 	}
 
-	// tombstone
-	producers := h.registrationDB.FindProducers(iface.TopicCategory, requestBody.TopicName, "")
-	for i := 0; i < producers.Len(); i++ {
-		p := producers.GetItem(i)
-		info := p.GetLmqdInfo()
-		if info.GetRemoteAddress() == requestBody.RemoteAddress && info.GetHostName() == requestBody.Hostname && info.GetTcpPort() == requestBody.TcpPort {
-			p.Tombstone()
-		}
+	// 通过集群tombstone
+	err = h.lookupCluster.Propose(&iface.ClusterCommand{
+		Op:           iface.TombstoneTopicOp,
+		TopicName:    requestBody.TopicName,
+		Hostname:     hostname,
+		TcpPort:      tcpPort,
+		TombstonedAt: time.Now().UnixNano(),
+	})
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	_ = h.SendOkResponse(request)
+}
+
+type UnTombstoneHandler struct {
+	*BaseHandler
+}
+
+func (h *UnTombstoneHandler) Handle(request serveriface.IRequest) {
+	// 反序列化，得到topic name, remoteAddress, hostname, port
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	// 验证topic name是否有效
+	if !utils.TopicOrChannelNameIsValid(requestBody.TopicName) {
+		_ = h.SendErrResponse(request, e.ErrTopicNameInValid)
+		return
+	}
+
+	hostname, tcpPort, err := tombstoneTarget(request, requestBody)
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	// 通过集群取消tombstone
+	err = h.lookupCluster.Propose(&iface.ClusterCommand{
+		Op:        iface.UnTombstoneTopicOp,
+		TopicName: requestBody.TopicName,
+		Hostname:  hostname,
+		TcpPort:   tcpPort,
+	})
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
 	}
 
 	_ = h.SendOkResponse(request)
 }
+
+// tombstoneTarget 获取需要tombstone的lmqd，lmqd发起时使用自身identity的信息，否则使用请求中的参数
+func tombstoneTarget(request serveriface.IRequest, requestBody *protocol.RequestBody) (string, int, error) {
+	if request.GetConnection().GetProperty(statusPropertyKey) == statusLmqd {
+		if producer, ok := request.GetConnection().GetProperty(producerPropertyKey).(iface.ILmqdProducer); ok {
+			info := producer.GetLmqdInfo()
+			return info.GetHostName(), info.GetTcpPort(), nil
+		}
+	}
+
+	// remoteAddress hostname port为空
+	if requestBody.RemoteAddress == "" || requestBody.Hostname == "" || requestBody.TcpPort == 0 {
+		return "", 0, errors.New("tombstone topic command args invalid")
+	}
+
+	return requestBody.Hostname, requestBody.TcpPort, nil
+}
@@ -0,0 +1,72 @@
+package tcp
+
+import (
+	serveriface "github.com/dawnzzz/hamble-tcp-server/iface"
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"github.com/dawnzzz/lmq/pkg/e"
+)
+
+// WatchHandler 订阅topic（topic name为空时订阅整个集群）的拓扑变化，之后的事件会不断推送给客户端
+// 每个连接只能有一个订阅，重复订阅时替换之前的订阅
+type WatchHandler struct {
+	*BaseHandler
+}
+
+func (h *WatchHandler) Handle(request serveriface.IRequest) {
+	// 反序列化
+	requestBody, err := protocol.GetRequestBody(request)
+	if err != nil {
+		_ = h.SendErrResponse(request, err)
+		return
+	}
+
+	// 验证topic name是否有效
+	if requestBody.TopicName != "" && !utils.TopicOrChannelNameIsValid(requestBody.TopicName) {
+		_ = h.SendErrResponse(request, e.ErrTopicNameInValid)
+		return
+	}
+
+	// 取消之前的订阅
+	conn := request.GetConnection()
+	if id, ok := conn.GetProperty(watchPropertyKey).(uint64); ok {
+		conn.RemoveProperty(watchPropertyKey)
+		h.registrationDB.Unwatch(id)
+	}
+
+	id, events := h.registrationDB.Watch(requestBody.TopicName)
+	conn.SetProperty(watchPropertyKey, id)
+
+	_ = h.SendOkResponse(request)
+
+	// 推送事件
+	go func() {
+		for event := range events {
+			if err := h.SendDataResponse(request, event); err != nil {
+				break
+			}
+		}
+
+		// 订阅没有被客户端取消，说明客户端来不及处理事件
+		if cur, ok := conn.GetProperty(watchPropertyKey).(uint64); ok && cur == id {
+			conn.RemoveProperty(watchPropertyKey)
+			h.registrationDB.Unwatch(id)
+			_ = h.SendErrResponse(request, e.ErrWatchOverflow)
+		}
+	}()
+}
+
+// UnWatchHandler 取消订阅
+type UnWatchHandler struct {
+	*BaseHandler
+}
+
+func (h *UnWatchHandler) Handle(request serveriface.IRequest) {
+	conn := request.GetConnection()
+	if id, ok := conn.GetProperty(watchPropertyKey).(uint64); ok {
+		conn.RemoveProperty(watchPropertyKey)
+		h.registrationDB.Unwatch(id)
+	}
+
+	_ = h.SendOkResponse(request)
+}
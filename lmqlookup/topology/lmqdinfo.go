@@ -13,6 +13,12 @@ type LmqdInfo struct {
 	RemoteAddress string
 	Hostname      string
 	TcpPort       int
+
+	NodeID           int64  // lmqd的节点ID
+	Version          string // lmqd的版本
+	HttpPort         int    // lmqd的http端口
+	BroadcastAddress string // lmqd对外广播的地址，客户端应该使用这个地址连接lmqd
+	StartTime        int64  // lmqd的启动时间
 }
 
 func NewLmqdInfo(id string, remoteAddress string, hostname string, tcpPort int) iface.ILmqdInfo {
@@ -68,6 +74,46 @@ func (info *LmqdInfo) SetTcpPort(tcpPort int) {
 	info.TcpPort = tcpPort
 }
 
+func (info *LmqdInfo) GetNodeID() int64 {
+	return info.NodeID
+}
+
+func (info *LmqdInfo) SetNodeID(nodeID int64) {
+	info.NodeID = nodeID
+}
+
+func (info *LmqdInfo) GetVersion() string {
+	return info.Version
+}
+
+func (info *LmqdInfo) SetVersion(version string) {
+	info.Version = version
+}
+
+func (info *LmqdInfo) GetHttpPort() int {
+	return info.HttpPort
+}
+
+func (info *LmqdInfo) SetHttpPort(httpPort int) {
+	info.HttpPort = httpPort
+}
+
+func (info *LmqdInfo) GetBroadcastAddress() string {
+	return info.BroadcastAddress
+}
+
+func (info *LmqdInfo) SetBroadcastAddress(broadcastAddress string) {
+	info.BroadcastAddress = broadcastAddress
+}
+
+func (info *LmqdInfo) GetStartTime() time.Time {
+	return time.Unix(0, info.StartTime)
+}
+
+func (info *LmqdInfo) SetStartTime(startTime time.Time) {
+	info.StartTime = startTime.UnixNano()
+}
+
 func (info *LmqdInfo) Equals(anotherInfo iface.ILmqdInfo) bool {
 	if info == anotherInfo {
 		return true
@@ -33,8 +33,17 @@ func (p *LmqdProducer) String() string {
 }
 
 func (p *LmqdProducer) Tombstone() {
+	p.TombstoneAt(time.Now())
+}
+
+func (p *LmqdProducer) TombstoneAt(tombstonedAt time.Time) {
 	p.tombstoned = true
-	p.tombstonedAt = time.Now()
+	p.tombstonedAt = tombstonedAt
+}
+
+func (p *LmqdProducer) UnTombstone() {
+	p.tombstoned = false
+	p.tombstonedAt = time.Time{}
 }
 
 func (p *LmqdProducer) IsTombstoned(lifetime time.Duration) bool {
@@ -1,18 +1,36 @@
 package topology
 
 import (
+	"github.com/dawnzzz/lmq/config"
 	"github.com/dawnzzz/lmq/iface"
 	"sync"
+	"time"
 )
 
+// tombstoneKey 用hostname和tcp port标识topic下的一个lmqd
+type tombstoneKey struct {
+	topicName string
+	hostname  string
+	tcpPort   int
+}
+
 type RegistrationDB struct {
 	sync.RWMutex
 	registrationMap map[iface.IRegistration]iface.ProducerMap
+	tombstones      map[tombstoneKey]time.Time             // 记录tombstone，lmqd重新注册topic时仍然处于tombstone状态
+	partitions      map[string][]*iface.PartitionPlacement // 分区topic中每个分区所在的lmqd
+
+	hub      *watchHub           // 拓扑变化的订阅者
+	inactive map[string]struct{} // 已经产生过inactive事件的lmqd id
 }
 
 func NewRegistrationDB() *RegistrationDB {
 	return &RegistrationDB{
 		registrationMap: make(map[iface.IRegistration]iface.ProducerMap),
+		tombstones:      make(map[tombstoneKey]time.Time),
+		partitions:      make(map[string][]*iface.PartitionPlacement),
+		hub:             newWatchHub(),
+		inactive:        make(map[string]struct{}),
 	}
 }
 
@@ -39,6 +57,20 @@ func (r *RegistrationDB) AddProducer(key iface.IRegistration, p iface.ILmqdProdu
 	_, found := producerMap[p.GetLmqdInfo().GetID()]
 	if !found {
 		producerMap[p.GetLmqdInfo().GetID()] = p
+		r.hub.publish(makeEvent(iface.ProducerRegisterEvent, key, p))
+
+		// 注册topic时，检查是否有未过期的tombstone
+		if key.GetCategory() == iface.TopicCategory {
+			info := p.GetLmqdInfo()
+			tk := tombstoneKey{key.GetKey(), info.GetHostName(), info.GetTcpPort()}
+			if tombstonedAt, ok := r.tombstones[tk]; ok {
+				if time.Since(tombstonedAt) < config.GlobalLmqLookupConfig.TombstoneLifetime {
+					p.TombstoneAt(tombstonedAt)
+				} else {
+					delete(r.tombstones, tk)
+				}
+			}
+		}
 	}
 
 	return !found
@@ -55,8 +87,9 @@ func (r *RegistrationDB) RemoveProducer(key iface.IRegistration, id string) (boo
 	}
 
 	var removed bool
-	if _, exists := producerMap[id]; exists {
+	if p, exists := producerMap[id]; exists {
 		removed = true
+		r.hub.publish(makeEvent(iface.ProducerUnregisterEvent, key, p))
 	}
 
 	delete(producerMap, id)
@@ -66,6 +99,10 @@ func (r *RegistrationDB) RemoveProducer(key iface.IRegistration, id string) (boo
 func (r *RegistrationDB) RemoveRegistration(key iface.IRegistration) {
 	r.Lock()
 	defer r.Unlock()
+
+	for _, p := range r.registrationMap[key] {
+		r.hub.publish(makeEvent(iface.ProducerUnregisterEvent, key, p))
+	}
 	delete(r.registrationMap, key)
 }
 
@@ -146,13 +183,123 @@ func (r *RegistrationDB) RemoveProducerFromAllRegistrations(id string) {
 	defer r.Unlock()
 
 	for registration, producerMap := range r.registrationMap {
-		if _, exists := producerMap[id]; exists {
+		if p, exists := producerMap[id]; exists {
 			_, ok := r.registrationMap[registration]
 			if !ok {
 				continue
 			}
 
 			delete(producerMap, id)
+			r.hub.publish(makeEvent(iface.ProducerUnregisterEvent, registration, p))
+		}
+	}
+	delete(r.inactive, id)
+}
+
+// TombstoneProducers 将topic下指定hostname和tcp port的lmqd标记为tombstone
+// 不同lookup中同一个lmqd的remote address不同，所以使用hostname和tcp port匹配lmqd
+func (r *RegistrationDB) TombstoneProducers(topicName string, hostname string, tcpPort int, tombstonedAt time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	// 清理过期的tombstone
+	lifetime := config.GlobalLmqLookupConfig.TombstoneLifetime
+	for tk, at := range r.tombstones {
+		if time.Since(at) >= lifetime {
+			delete(r.tombstones, tk)
+		}
+	}
+	if time.Since(tombstonedAt) >= lifetime {
+		return
+	}
+	r.tombstones[tombstoneKey{topicName, hostname, tcpPort}] = tombstonedAt
+
+	topicReg := MakeRegistration(iface.TopicCategory, topicName, "")
+	for _, p := range r.registrationMap[topicReg] {
+		info := p.GetLmqdInfo()
+		if info.GetHostName() == hostname && info.GetTcpPort() == tcpPort {
+			p.TombstoneAt(tombstonedAt)
+			r.hub.publish(makeEvent(iface.ProducerTombstoneEvent, topicReg, p))
+		}
+	}
+}
+
+// UnTombstoneProducers 取消topic下指定hostname和tcp port的lmqd的tombstone
+func (r *RegistrationDB) UnTombstoneProducers(topicName string, hostname string, tcpPort int) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.tombstones, tombstoneKey{topicName, hostname, tcpPort})
+
+	topicReg := MakeRegistration(iface.TopicCategory, topicName, "")
+	for _, p := range r.registrationMap[topicReg] {
+		info := p.GetLmqdInfo()
+		if info.GetHostName() == hostname && info.GetTcpPort() == tcpPort {
+			p.UnTombstone()
+			r.hub.publish(makeEvent(iface.ProducerUnTombstoneEvent, topicReg, p))
+		}
+	}
+}
+
+// SetPartitions 记录分区topic中每个分区所在的lmqd
+func (r *RegistrationDB) SetPartitions(topicName string, placements []*iface.PartitionPlacement) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.partitions[topicName] = placements
+}
+
+// GetPartitions 查询分区topic中每个分区所在的lmqd，不是分区topic时返回nil
+func (r *RegistrationDB) GetPartitions(topicName string) []*iface.PartitionPlacement {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.partitions[topicName]
+}
+
+func (r *RegistrationDB) RemovePartitions(topicName string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.partitions, topicName)
+}
+
+// CheckInactiveProducers 检查长时间没有心跳的lmqd，每个lmqd变为不活跃时只产生一次inactive事件
+func (r *RegistrationDB) CheckInactiveProducers(inactivityTimeout time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	becomeInactive := make(map[string]struct{})
+	for registration, producerMap := range r.registrationMap {
+		for id, p := range producerMap {
+			if now.Sub(p.GetLmqdInfo().GetLastUpdate()) <= inactivityTimeout {
+				// 重新变为活跃
+				delete(r.inactive, id)
+				continue
+			}
+
+			if _, notified := r.inactive[id]; notified {
+				continue
+			}
+			becomeInactive[id] = struct{}{}
+			r.hub.publish(makeEvent(iface.ProducerInactiveEvent, registration, p))
 		}
 	}
+
+	for id := range becomeInactive {
+		r.inactive[id] = struct{}{}
+	}
+}
+
+func (r *RegistrationDB) Watch(topicName string) (uint64, <-chan *iface.TopologyEvent) {
+	return r.hub.watch(topicName)
+}
+
+func (r *RegistrationDB) Unwatch(id uint64) {
+	r.hub.unwatch(id)
+}
+
+func (r *RegistrationDB) EventsSince(topicName string, seq uint64) []*iface.TopologyEvent {
+	return r.hub.eventsSince(topicName, seq)
 }
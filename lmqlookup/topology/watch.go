@@ -0,0 +1,125 @@
+package topology
+
+import (
+	"github.com/dawnzzz/lmq/iface"
+	"github.com/dawnzzz/lmq/logger"
+	"sync"
+	"time"
+)
+
+const (
+	maxEventHistory   = 1024 // 保存最近的事件，用于long-poll查询
+	watcherBufferSize = 256  // 每个订阅者的事件缓冲区大小
+)
+
+type watcher struct {
+	topicName string
+	events    chan *iface.TopologyEvent
+}
+
+func (w *watcher) match(event *iface.TopologyEvent) bool {
+	return w.topicName == "" || w.topicName == event.TopicName
+}
+
+// watchHub 管理拓扑变化的订阅者
+type watchHub struct {
+	sync.Mutex
+
+	seq      uint64
+	history  []*iface.TopologyEvent
+	watchers map[uint64]*watcher
+	nextID   uint64
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		watchers: make(map[uint64]*watcher),
+	}
+}
+
+// publish 发布一个事件，订阅者来不及处理时，关闭该订阅者
+func (hub *watchHub) publish(event *iface.TopologyEvent) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	hub.seq++
+	event.Seq = hub.seq
+	event.Timestamp = time.Now().UnixNano()
+
+	hub.history = append(hub.history, event)
+	if len(hub.history) > maxEventHistory {
+		hub.history = hub.history[len(hub.history)-maxEventHistory:]
+	}
+
+	for id, w := range hub.watchers {
+		if !w.match(event) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			logger.Warnf("topology watcher(%d) is too slow, close it", id)
+			delete(hub.watchers, id)
+			close(w.events)
+		}
+	}
+}
+
+func (hub *watchHub) watch(topicName string) (uint64, <-chan *iface.TopologyEvent) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	hub.nextID++
+	w := &watcher{
+		topicName: topicName,
+		events:    make(chan *iface.TopologyEvent, watcherBufferSize),
+	}
+	hub.watchers[hub.nextID] = w
+
+	return hub.nextID, w.events
+}
+
+func (hub *watchHub) unwatch(id uint64) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	w, ok := hub.watchers[id]
+	if !ok {
+		return
+	}
+	delete(hub.watchers, id)
+	close(w.events)
+}
+
+func (hub *watchHub) eventsSince(topicName string, seq uint64) []*iface.TopologyEvent {
+	hub.Lock()
+	defer hub.Unlock()
+
+	w := &watcher{topicName: topicName}
+	var events []*iface.TopologyEvent
+	for _, event := range hub.history {
+		if event.Seq > seq && w.match(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// makeEvent 根据registration和producer生成事件
+func makeEvent(eventType iface.TopologyEventType, registration iface.IRegistration, p iface.ILmqdProducer) *iface.TopologyEvent {
+	info := p.GetLmqdInfo()
+	event := &iface.TopologyEvent{
+		Type:          eventType,
+		RemoteAddress: info.GetRemoteAddress(),
+		Hostname:      info.GetHostName(),
+		TcpPort:       info.GetTcpPort(),
+	}
+	if registration.GetCategory() != iface.LmqdCategory {
+		event.TopicName = registration.GetKey()
+		event.ChannelName = registration.GetCSubKey()
+	}
+
+	return event
+}
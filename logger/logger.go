@@ -14,7 +14,7 @@ var (
 var entryFields logrus.Fields
 
 func Debug(args ...interface{}) {
-	entry.Info(args...)
+	entry.Debug(args...)
 }
 
 func Info(args ...interface{}) {
@@ -22,7 +22,7 @@ func Info(args ...interface{}) {
 }
 
 func Warn(args ...interface{}) {
-	entry.Info(args...)
+	entry.Warn(args...)
 }
 
 func Error(args ...interface{}) {
@@ -53,6 +53,23 @@ func Fatalf(format string, args ...interface{}) {
 	entry.Fatalf(format, args...)
 }
 
+// SetLevel 设置日志级别
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	logger.SetLevel(l)
+	return nil
+}
+
+// IsValidLevel 日志级别是否合法
+func IsValidLevel(level string) bool {
+	_, err := logrus.ParseLevel(level)
+	return err == nil
+}
+
 func WithFields(fields logrus.Fields) {
 	entryFields = fields
 	entry = logger.WithFields(fields)
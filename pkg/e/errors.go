@@ -7,15 +7,46 @@ import (
 )
 
 var (
+	ErrNotEnoughReplicas      = errors.New("not enough replicas confirmed the message")
+	ErrTooManyPendingReplicas = errors.New("too many replicated messages are waiting to finish")
+	ErrLmqdDraining           = errors.New("lmqd is draining")
+
+	ErrClusterNoLeader  = errors.New("lookup cluster has no leader")
+	ErrClusterNotLeader = errors.New("lookup node is not the cluster leader")
+
+	ErrNodeIDConflict = errors.New("node id conflicts with another lmqd, please set a unique node_id")
+
+	ErrWatchOverflow        = errors.New("topology watcher is too slow, events are dropped")
+	ErrClusterProposeFailed = errors.New("lookup cluster propose failed")
+
 	ErrTopicNameInValid = errors.New("topic name is invalid")
 	ErrTopicNotFound    = errors.New("topic is not found")
 	ErrTopicIsExiting   = errors.New("topic is exiting")
 
-	ErrChannelNameInValid = errors.New("channel name is invalid")
-	ErrChannelNotFound    = errors.New("channel is not found")
-	ErrChannelIsExiting   = errors.New("channel is exiting")
+	ErrTopicRetentionDisabled = errors.New("topic retention is disabled")
+	ErrRouteInValid           = errors.New("topic route is invalid")
+
+	ErrPartitionsInValid = errors.New("partitions of topic is invalid")
+	ErrPartitionsChanged = errors.New("partitions of topic can not be changed")
+	ErrPartitionNameUsed = errors.New("name with partition suffix #pN is reserved for partitioned topics")
+	ErrNoLmqdAvailable   = errors.New("no lmqd is available")
+
+	ErrChannelNameInValid      = errors.New("channel name is invalid")
+	ErrChannelNotFound         = errors.New("channel is not found")
+	ErrChannelIsExiting        = errors.New("channel is exiting")
+	ErrChannelIsPriority       = errors.New("channel with priority messages can not be ordered")
+	ErrFilterInValid           = errors.New("channel filter is invalid")
+	ErrSampleRateInValid       = errors.New("channel sample rate is invalid, should be in [0, 100]")
+	ErrDispatchPolicyInValid   = errors.New("channel dispatch policy is invalid")
+	ErrSubscriptionModeInValid = errors.New("channel subscription mode is invalid")
+	ErrChannelIsExclusive      = errors.New("channel is exclusive and already has a consumer")
+	ErrChannelHasConsumers     = errors.New("channel with more than one consumer can not be exclusive")
+	ErrDeadLetterInValid       = errors.New("channel dead letter is invalid")
+	ErrOverridesInValid        = errors.New("overrides are invalid")
 
 	ErrMessageIDIsNotInFlight = errors.New("message ID is not in flight")
 	ErrClientNotOwnTheMessage = errors.New("this client not own the message")
-	ErrMessageLengthInvalid   = fmt.Errorf("message length is in valid, length is limited (%v, %v)", config.GlobalLmqdConfig.MinMessageSize, config.GlobalLmqdConfig.MaxMessageSize)
+	ErrClientIsClosing        = errors.New("client is closing")
+	ErrMessageHeadersTooLong  = errors.New("message headers are too long")
+	ErrMessageLengthInvalid   = fmt.Errorf("message length is in valid, length is limited (%v, %v)", config.GetLmqdConfig().MinMessageSize, config.GetLmqdConfig().MaxMessageSize)
 )
@@ -0,0 +1,67 @@
+package partition
+
+import (
+	"github.com/dawnzzz/lmq/internel/protocol"
+	"github.com/dawnzzz/lmq/internel/utils"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+	分区topic由N个普通的topic组成，第i个分区对应的topic名称为topic#pi（临时topic为topic#pi#tmp），分区分布在不同的lmqd上
+	#p后缀保留给分区使用，普通的topic不能带有这个后缀，通配符订阅也不会匹配到分区
+	生产者通过Router选择分区，消费者在所有分区上订阅同一个channel
+*/
+
+// TopicName 分区topic中第i个分区对应的topic名称
+func TopicName(topicName string, partition int) string {
+	suffix := utils.PartitionSeparator + strconv.Itoa(partition)
+	if utils.IsEphemeralName(topicName) {
+		return strings.TrimSuffix(topicName, utils.EphemeralSuffix) + suffix + utils.EphemeralSuffix
+	}
+
+	return topicName + suffix
+}
+
+// Router 生产者按照key的哈希值或者轮询选择分区
+type Router struct {
+	partitions []*protocol.Partition
+	next       atomic.Uint64
+}
+
+// NewRouter 根据lookup返回的分区信息创建路由
+func NewRouter(partitions []*protocol.Partition) *Router {
+	sorted := make([]*protocol.Partition, len(partitions))
+	copy(sorted, partitions)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Partition < sorted[j].Partition
+	})
+
+	return &Router{
+		partitions: sorted,
+	}
+}
+
+// Route key不为空时按照key的哈希值选择分区，相同key的消息总是发送到同一个分区；key为空时轮询
+func (r *Router) Route(key []byte) *protocol.Partition {
+	if len(r.partitions) == 0 {
+		return nil
+	}
+
+	if len(key) == 0 {
+		index := (r.next.Add(1) - 1) % uint64(len(r.partitions))
+		return r.partitions[index]
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return r.partitions[h.Sum32()%uint32(len(r.partitions))]
+}
+
+// Partitions 获取所有的分区，消费者需要订阅所有的分区
+func (r *Router) Partitions() []*protocol.Partition {
+	return r.partitions
+}